@@ -2,12 +2,15 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"time"
+	// Schedules are evaluated in their timezones even without system tzdata.
+	_ "time/tzdata"
 
 	"github.com/gorilla/csrf"
 	"github.com/gorilla/mux"
@@ -16,12 +19,17 @@ import (
 	"github.com/shark-ci/shark-ci/internal/config"
 	"github.com/shark-ci/shark-ci/internal/messagequeue"
 	pb "github.com/shark-ci/shark-ci/internal/proto"
+	"github.com/shark-ci/shark-ci/internal/server/commitstatus"
+	"github.com/shark-ci/shark-ci/internal/server/encryption"
+	"github.com/shark-ci/shark-ci/internal/server/event"
 	ciserverGrpc "github.com/shark-ci/shark-ci/internal/server/grpc"
 	"github.com/shark-ci/shark-ci/internal/server/handler"
 	"github.com/shark-ci/shark-ci/internal/server/middleware"
+	"github.com/shark-ci/shark-ci/internal/server/schedule"
 	"github.com/shark-ci/shark-ci/internal/server/service"
 	"github.com/shark-ci/shark-ci/internal/server/session"
 	"github.com/shark-ci/shark-ci/internal/server/store"
+	"github.com/shark-ci/shark-ci/internal/server/webhookcheck"
 )
 
 func fatal(msg string, err error) {
@@ -29,6 +37,21 @@ func fatal(msg string, err error) {
 	os.Exit(1)
 }
 
+// encryptSecrets encrypts tokens and webhook secrets stored in plaintext by
+// older versions and re-encrypts ones encrypted with old keys after rotation.
+// It runs on every start, so upgrades and key rotations need no manual step.
+func encryptSecrets(pgStore *store.PostgresStore) {
+	tokens, err := pgStore.EncryptServiceUserTokens(context.TODO())
+	if err != nil {
+		fatal("Encrypting service user tokens failed.", err)
+	}
+	secrets, err := pgStore.EncryptRepoWebhookSecrets(context.TODO())
+	if err != nil {
+		fatal("Encrypting repository webhook secrets failed.", err)
+	}
+	slog.Info("Secrets encrypted.", "tokens", tokens, "webhookSecrets", secrets)
+}
+
 func main() {
 	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
 	slog.SetDefault(logger)
@@ -40,8 +63,13 @@ func main() {
 
 	session.InitSessionStore(config.ServerConf.SecretKey)
 
+	keyring, err := encryption.LoadKeyring(config.ServerConf.TokenEncryptionKeys, config.ServerConf.TokenEncryptionKeysFile, config.ServerConf.SecretKey)
+	if err != nil {
+		fatal("Loading token encryption keys failed.", err)
+	}
+
 	slog.Info("Connecting to PostgreSQL.")
-	pgStore, err := store.NewPostgresStore(context.TODO(), config.ServerConf.DB.URI)
+	pgStore, err := store.NewPostgresStore(context.TODO(), config.ServerConf.DB.URI, keyring)
 	if err != nil {
 		fatal("Connecting to PostgreSQL failed.", err)
 	}
@@ -52,6 +80,11 @@ func main() {
 		fatal("Pinging to PostgreSQL failed.", err)
 	}
 	slog.Info("PostgreSQL connected.")
+
+	encryptSecrets(pgStore)
+	if len(os.Args) > 1 && os.Args[1] == "encrypt-secrets" {
+		return
+	}
 	store.Cleaner(pgStore, 24*time.Hour)
 
 	slog.Info("Connecting to RabbitMQ.")
@@ -62,15 +95,39 @@ func main() {
 	defer rabbitMQ.Close(context.TODO())
 	slog.Info("RabbitMQ connected.")
 
-	services := service.InitServices(pgStore)
+	services, err := service.InitServices(pgStore)
+	if err != nil {
+		fatal("Initializing services failed.", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	slog.Info("Starting commit status reporter.")
+	statusReporter := commitstatus.NewReporter(pgStore, services)
+	go statusReporter.Run(ctx)
+
+	slog.Info("Starting webhook event processor.", "workers", config.ServerConf.EventWorkers)
+	eventProcessor := event.NewProcessor(pgStore, rabbitMQ, services, statusReporter, config.ServerConf.EventWorkers)
+	go eventProcessor.Run(ctx)
+
+	webhookChecker := webhookcheck.NewChecker(pgStore, services, time.Duration(config.ServerConf.WebhookCheckInterval)*time.Hour)
+	if config.ServerConf.WebhookCheckInterval > 0 {
+		slog.Info("Starting webhook checker.", "intervalHours", config.ServerConf.WebhookCheckInterval)
+		go webhookChecker.Run(ctx)
+	}
+
+	slog.Info("Starting scheduler.")
+	scheduler := schedule.NewScheduler(pgStore, eventProcessor)
+	go scheduler.Run(ctx)
 
 	slog.Info("Starting gRPC server.")
 	lis, err := net.Listen("tcp", ":"+config.ServerConf.GRPCPort)
 	if err != nil {
 		fatal("Failed to listen.", err)
 	}
-	s := grpc.NewServer()
-	grpcServer := ciserverGrpc.NewGRPCServer(pgStore, services)
+	grpcServer := ciserverGrpc.NewGRPCServer(pgStore, statusReporter, services)
+	s := grpc.NewServer(grpc.UnaryInterceptor(grpcServer.JobTokenInterceptor))
 	pb.RegisterPipelineReporterServer(s, grpcServer)
 	go s.Serve(lis)
 	slog.Info("gRPC server is running.", "port", config.ServerConf.GRPCPort)
@@ -78,10 +135,12 @@ func main() {
 	slog.Info("Starting HTTP server.")
 	CSRF := csrf.Protect([]byte(config.ServerConf.SecretKey), csrf.Path("/"))
 
-	indexHandler := handler.NewIndexHandler(pgStore)
-	eventHandler := handler.NewEventHandler(pgStore, rabbitMQ, services)
-	repoHandler := handler.NewRepoHandler(pgStore, services)
+	indexHandler := handler.NewIndexHandler(pgStore, services)
+	eventHandler := handler.NewEventHandler(pgStore, eventProcessor, services, webhookChecker)
+	repoHandler := handler.NewRepoHandler(pgStore, services, statusReporter)
+	pipelineHandler := handler.NewPipelineHandler(pgStore, eventProcessor, statusReporter)
 	authHandler := handler.NewAuthHandler(pgStore, services)
+	scheduleHandler := handler.NewScheduleHandler(pgStore, eventProcessor)
 
 	r := mux.NewRouter()
 	r.Use(middleware.LoggingMiddleware)
@@ -98,10 +157,50 @@ func main() {
 	repos := r.PathPrefix("/repositories").Subrouter()
 	repos.Use(CSRF)
 	repos.Use(middleware.AuthMiddleware(pgStore))
-	repos.HandleFunc("/{id}", repoHandler.HandleRepoPipelines).Methods(http.MethodGet)
+	repos.HandleFunc("/{id}/deliveries", eventHandler.HandleRepoDeliveries).Methods(http.MethodGet)
+	repos.HandleFunc("/{id}/webhook/check", eventHandler.HandleCheckWebhook).Methods(http.MethodPost)
+	repos.HandleFunc("/{id}/deliveries/{delivery_id}/replay", eventHandler.HandleReplayDelivery).Methods(http.MethodPost)
+	repos.HandleFunc("/{id}/approvals", pipelineHandler.HandleApprovals).Methods(http.MethodGet)
+	repos.HandleFunc("/{id}/pipelines/trigger", pipelineHandler.HandleTriggerPipeline).Methods(http.MethodPost)
+	repos.HandleFunc("/{id}/pipelines/{number}/approve", pipelineHandler.HandleApprovePipeline).Methods(http.MethodPost)
+	repos.HandleFunc("/{id}/pipelines/{number}/reject", pipelineHandler.HandleRejectPipeline).Methods(http.MethodPost)
+	repos.HandleFunc("/{id}/pipelines/{number}/rerun", pipelineHandler.HandleRerunPipeline).Methods(http.MethodPost)
+	repos.HandleFunc("/{id}/pipelines/{number}/rerun-failed", pipelineHandler.HandleRerunFailedPipeline).Methods(http.MethodPost)
+	repos.HandleFunc("/{id}/schedules", scheduleHandler.HandleSchedules).Methods(http.MethodGet)
+	repos.HandleFunc("/{id}/schedules", scheduleHandler.HandleCreateSchedule).Methods(http.MethodPost)
+	repos.HandleFunc("/{id}/schedules/sync", scheduleHandler.HandleSyncWorkflowSchedules).Methods(http.MethodPost)
+	repos.HandleFunc("/{id}/schedules/{schedule_id}/delete", scheduleHandler.HandleDeleteSchedule).Methods(http.MethodPost)
 	repos.HandleFunc("/register", repoHandler.HandleRegisterRepo).Methods(http.MethodPost)
+	repos.HandleFunc("/{id}", repoHandler.HandleDeleteRepo).Methods(http.MethodDelete)
+	repos.HandleFunc("/{id}/unregister", repoHandler.HandleDeleteRepo).Methods(http.MethodPost)
+	repos.HandleFunc("/{id}/webhook-secret/rotate", repoHandler.HandleRotateWebhookSecret).Methods(http.MethodPost)
 	repos.HandleFunc("/fetch-unregistered/{service}", repoHandler.FetchUnregistredRepos).Methods(http.MethodGet)
 
+	// Repository pages subrouter, pipeline URLs reported to services point
+	// here.
+	reposUI := r.PathPrefix("/repos").Subrouter()
+	reposUI.Use(CSRF)
+	reposUI.Use(middleware.AuthMiddleware(pgStore))
+	reposUI.HandleFunc("/{id}/pipelines", repoHandler.HandleRepoPipelines).Methods(http.MethodGet)
+	reposUI.HandleFunc("/{id}/pipelines/new", pipelineHandler.HandleTriggerForm).Methods(http.MethodGet)
+	reposUI.HandleFunc("/{id}/builds/{number}", pipelineHandler.HandlePipeline).Methods(http.MethodGet)
+	reposUI.HandleFunc("/{id}/builds/{number}/log", pipelineHandler.HandlePipelineLog).Methods(http.MethodGet)
+	// Links reported before pipelines were numbered contain pipeline ID.
+	reposUI.HandleFunc("/{id}/pipelines/{pipeline_id}", pipelineHandler.HandlePipelineByID).Methods(http.MethodGet)
+	reposUI.HandleFunc("/{id}/pipelines/{pipeline_id}/log", pipelineHandler.HandlePipelineByID).Methods(http.MethodGet)
+
+	// JSON API subrouter.
+	api := r.PathPrefix("/api").Subrouter()
+	api.Use(middleware.ContentTypeMiddleware)
+	api.Use(middleware.APIAuthMiddleware(pgStore))
+	api.HandleFunc("/repos/{id}/pipelines", repoHandler.HandleAPIRepoPipelines).Methods(http.MethodGet)
+	api.HandleFunc("/repos/{id}/pipelines/{number}", repoHandler.HandleAPIRepoPipeline).Methods(http.MethodGet)
+	// Session cookie authenticates API requests too, so changes require CSRF
+	// token in X-CSRF-Token header.
+	api.Handle("/repos/{id}/pipelines", CSRF(http.HandlerFunc(pipelineHandler.HandleAPITriggerPipeline))).Methods(http.MethodPost)
+	api.Handle("/repos/{id}/pipelines/{number}/rerun", CSRF(http.HandlerFunc(pipelineHandler.HandleAPIRerunPipeline))).Methods(http.MethodPost)
+	api.Handle("/repos/{id}/pipelines/{number}/rerun-failed", CSRF(http.HandlerFunc(pipelineHandler.HandleAPIRerunFailedPipeline))).Methods(http.MethodPost)
+
 	server := &http.Server{
 		Addr:         ":" + config.ServerConf.Port,
 		Handler:      r,
@@ -117,6 +216,17 @@ func main() {
 	}()
 	slog.Info("HTTP server is running.", "port", config.ServerConf.Port)
 
+	if config.ServerConf.MetricsAddr != "" {
+		metrics := http.NewServeMux()
+		metrics.Handle("/debug/vars", expvar.Handler())
+		go func() {
+			if err := http.ListenAndServe(config.ServerConf.MetricsAddr, metrics); err != nil {
+				fatal("Metrics server error.", err)
+			}
+		}()
+		slog.Info("Metrics server is running.", "addr", config.ServerConf.MetricsAddr)
+	}
+
 	signalCh := make(chan os.Signal, 1)
 	signal.Notify(signalCh, os.Interrupt)
 	<-signalCh
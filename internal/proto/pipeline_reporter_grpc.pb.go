@@ -19,9 +19,12 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	PipelineReporter_PipelineStarted_FullMethodName   = "/PipelineReporter/PipelineStarted"
-	PipelineReporter_PipelineFinnished_FullMethodName = "/PipelineReporter/PipelineFinnished"
-	PipelineReporter_CommandOutput_FullMethodName     = "/PipelineReporter/CommandOutput"
+	PipelineReporter_PipelineStarted_FullMethodName    = "/PipelineReporter/PipelineStarted"
+	PipelineReporter_PipelineFinnished_FullMethodName  = "/PipelineReporter/PipelineFinnished"
+	PipelineReporter_CommandOutput_FullMethodName      = "/PipelineReporter/CommandOutput"
+	PipelineReporter_WorkflowLoaded_FullMethodName     = "/PipelineReporter/WorkflowLoaded"
+	PipelineReporter_GetCloneCredential_FullMethodName = "/PipelineReporter/GetCloneCredential"
+	PipelineReporter_PipelineHeartbeat_FullMethodName  = "/PipelineReporter/PipelineHeartbeat"
 )
 
 // PipelineReporterClient is the client API for PipelineReporter service.
@@ -31,6 +34,9 @@ type PipelineReporterClient interface {
 	PipelineStarted(ctx context.Context, in *PipelineStartedRequest, opts ...grpc.CallOption) (*Empty, error)
 	PipelineFinnished(ctx context.Context, in *PipelineFinnishedRequest, opts ...grpc.CallOption) (*Empty, error)
 	CommandOutput(ctx context.Context, in *CommandOutputRequest, opts ...grpc.CallOption) (*Empty, error)
+	WorkflowLoaded(ctx context.Context, in *WorkflowLoadedRequest, opts ...grpc.CallOption) (*Empty, error)
+	GetCloneCredential(ctx context.Context, in *CloneCredentialRequest, opts ...grpc.CallOption) (*CloneCredential, error)
+	PipelineHeartbeat(ctx context.Context, in *PipelineHeartbeatRequest, opts ...grpc.CallOption) (*Empty, error)
 }
 
 type pipelineReporterClient struct {
@@ -68,6 +74,33 @@ func (c *pipelineReporterClient) CommandOutput(ctx context.Context, in *CommandO
 	return out, nil
 }
 
+func (c *pipelineReporterClient) WorkflowLoaded(ctx context.Context, in *WorkflowLoadedRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, PipelineReporter_WorkflowLoaded_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pipelineReporterClient) GetCloneCredential(ctx context.Context, in *CloneCredentialRequest, opts ...grpc.CallOption) (*CloneCredential, error) {
+	out := new(CloneCredential)
+	err := c.cc.Invoke(ctx, PipelineReporter_GetCloneCredential_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pipelineReporterClient) PipelineHeartbeat(ctx context.Context, in *PipelineHeartbeatRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, PipelineReporter_PipelineHeartbeat_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // PipelineReporterServer is the server API for PipelineReporter service.
 // All implementations must embed UnimplementedPipelineReporterServer
 // for forward compatibility
@@ -75,6 +108,9 @@ type PipelineReporterServer interface {
 	PipelineStarted(context.Context, *PipelineStartedRequest) (*Empty, error)
 	PipelineFinnished(context.Context, *PipelineFinnishedRequest) (*Empty, error)
 	CommandOutput(context.Context, *CommandOutputRequest) (*Empty, error)
+	WorkflowLoaded(context.Context, *WorkflowLoadedRequest) (*Empty, error)
+	GetCloneCredential(context.Context, *CloneCredentialRequest) (*CloneCredential, error)
+	PipelineHeartbeat(context.Context, *PipelineHeartbeatRequest) (*Empty, error)
 	mustEmbedUnimplementedPipelineReporterServer()
 }
 
@@ -91,6 +127,15 @@ func (UnimplementedPipelineReporterServer) PipelineFinnished(context.Context, *P
 func (UnimplementedPipelineReporterServer) CommandOutput(context.Context, *CommandOutputRequest) (*Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CommandOutput not implemented")
 }
+func (UnimplementedPipelineReporterServer) WorkflowLoaded(context.Context, *WorkflowLoadedRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WorkflowLoaded not implemented")
+}
+func (UnimplementedPipelineReporterServer) GetCloneCredential(context.Context, *CloneCredentialRequest) (*CloneCredential, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCloneCredential not implemented")
+}
+func (UnimplementedPipelineReporterServer) PipelineHeartbeat(context.Context, *PipelineHeartbeatRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PipelineHeartbeat not implemented")
+}
 func (UnimplementedPipelineReporterServer) mustEmbedUnimplementedPipelineReporterServer() {}
 
 // UnsafePipelineReporterServer may be embedded to opt out of forward compatibility for this service.
@@ -158,6 +203,60 @@ func _PipelineReporter_CommandOutput_Handler(srv interface{}, ctx context.Contex
 	return interceptor(ctx, in, info, handler)
 }
 
+func _PipelineReporter_WorkflowLoaded_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WorkflowLoadedRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PipelineReporterServer).WorkflowLoaded(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PipelineReporter_WorkflowLoaded_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PipelineReporterServer).WorkflowLoaded(ctx, req.(*WorkflowLoadedRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PipelineReporter_GetCloneCredential_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CloneCredentialRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PipelineReporterServer).GetCloneCredential(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PipelineReporter_GetCloneCredential_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PipelineReporterServer).GetCloneCredential(ctx, req.(*CloneCredentialRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PipelineReporter_PipelineHeartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PipelineHeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PipelineReporterServer).PipelineHeartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PipelineReporter_PipelineHeartbeat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PipelineReporterServer).PipelineHeartbeat(ctx, req.(*PipelineHeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // PipelineReporter_ServiceDesc is the grpc.ServiceDesc for PipelineReporter service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -177,6 +276,18 @@ var PipelineReporter_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "CommandOutput",
 			Handler:    _PipelineReporter_CommandOutput_Handler,
 		},
+		{
+			MethodName: "WorkflowLoaded",
+			Handler:    _PipelineReporter_WorkflowLoaded_Handler,
+		},
+		{
+			MethodName: "GetCloneCredential",
+			Handler:    _PipelineReporter_GetCloneCredential_Handler,
+		},
+		{
+			MethodName: "PipelineHeartbeat",
+			Handler:    _PipelineReporter_PipelineHeartbeat_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "internal/proto/pipeline_reporter.proto",
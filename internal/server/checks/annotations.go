@@ -0,0 +1,101 @@
+package checks
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/shark-ci/shark-ci/internal/server/service"
+)
+
+// workDir is directory where worker mounts the repository.
+const workDir = "/app/"
+
+var (
+	ansiRe = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+	// file.go:12:5: message (Go, GCC, Clang, golangci-lint, ESLint unix, ...)
+	colonRe = regexp.MustCompile(`^\s*([^\s:()'"]+\.[A-Za-z0-9]+):(\d+)(?::(\d+))?:\s*(.+)$`)
+	// file.ts(12,5): error TS2322: message (TypeScript, MSBuild, ...)
+	parenRe = regexp.MustCompile(`^\s*([^\s:()'"]+\.[A-Za-z0-9]+)\((\d+)(?:,(\d+))?\):\s*(.+)$`)
+)
+
+// ParseAnnotations finds compiler and linter messages pointing to files of
+// the repository in the command output.
+func ParseAnnotations(output string) []service.Annotation {
+	var annotations []service.Annotation
+	seen := map[service.Annotation]bool{}
+	for _, line := range strings.Split(StripANSI(output), "\n") {
+		a, ok := parseLine(strings.TrimRight(line, "\r"))
+		if !ok || seen[a] {
+			continue
+		}
+		seen[a] = true
+		annotations = append(annotations, a)
+	}
+	return annotations
+}
+
+// StripANSI removes terminal color codes from s.
+func StripANSI(s string) string {
+	return ansiRe.ReplaceAllString(s, "")
+}
+
+func parseLine(line string) (service.Annotation, bool) {
+	m := colonRe.FindStringSubmatch(line)
+	if m == nil {
+		m = parenRe.FindStringSubmatch(line)
+	}
+	if m == nil {
+		return service.Annotation{}, false
+	}
+
+	p := strings.TrimPrefix(m[1], workDir)
+	if path.IsAbs(p) {
+		// File outside of the repository, e.g. in standard library.
+		return service.Annotation{}, false
+	}
+	p = path.Clean(p)
+	if strings.HasPrefix(p, "../") {
+		return service.Annotation{}, false
+	}
+
+	lineNum, err := strconv.Atoi(m[2])
+	if err != nil || lineNum == 0 {
+		return service.Annotation{}, false
+	}
+	column, _ := strconv.Atoi(m[3])
+
+	level, msg := parseLevel(strings.TrimSpace(m[4]))
+	return service.Annotation{
+		Path:    p,
+		Line:    lineNum,
+		Column:  column,
+		Level:   level,
+		Message: msg,
+	}, true
+}
+
+func parseLevel(msg string) (service.AnnotationLevel, string) {
+	lower := strings.ToLower(msg)
+	for _, prefix := range []struct {
+		prefix string
+		level  service.AnnotationLevel
+	}{
+		{"error:", service.AnnotationFailure},
+		{"error ", service.AnnotationFailure},
+		{"fatal error:", service.AnnotationFailure},
+		{"warning:", service.AnnotationWarning},
+		{"warning ", service.AnnotationWarning},
+		{"note:", service.AnnotationNotice},
+		{"info:", service.AnnotationNotice},
+	} {
+		if strings.HasPrefix(lower, prefix.prefix) {
+			if strings.HasSuffix(prefix.prefix, ":") {
+				msg = strings.TrimSpace(msg[len(prefix.prefix):])
+			}
+			return prefix.level, msg
+		}
+	}
+	return service.AnnotationFailure, msg
+}
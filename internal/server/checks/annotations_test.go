@@ -0,0 +1,59 @@
+package checks
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/shark-ci/shark-ci/internal/server/service"
+)
+
+func TestParseAnnotations(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []service.Annotation
+	}{
+		{
+			name:   "go build",
+			output: "# example.com/app\n./main.go:12:5: undefined: foo\n",
+			want:   []service.Annotation{{Path: "main.go", Line: 12, Column: 5, Level: service.AnnotationFailure, Message: "undefined: foo"}},
+		},
+		{
+			name:   "gcc warning in work dir",
+			output: "/app/src/main.c:3:10: warning: unused variable 'x' [-Wunused-variable]",
+			want:   []service.Annotation{{Path: "src/main.c", Line: 3, Column: 10, Level: service.AnnotationWarning, Message: "unused variable 'x' [-Wunused-variable]"}},
+		},
+		{
+			name:   "typescript",
+			output: "src/index.ts(7,3): error TS2322: Type 'string' is not assignable to type 'number'.",
+			want:   []service.Annotation{{Path: "src/index.ts", Line: 7, Column: 3, Level: service.AnnotationFailure, Message: "error TS2322: Type 'string' is not assignable to type 'number'."}},
+		},
+		{
+			name:   "go test without column",
+			output: "--- FAIL: TestFoo (0.00s)\n    foo_test.go:21: got 1, want 2\n",
+			want:   []service.Annotation{{Path: "foo_test.go", Line: 21, Level: service.AnnotationFailure, Message: "got 1, want 2"}},
+		},
+		{
+			name:   "colors and duplicates",
+			output: "\x1b[31mmain.go:1:1: error: boom\x1b[0m\nmain.go:1:1: error: boom\n",
+			want:   []service.Annotation{{Path: "main.go", Line: 1, Column: 1, Level: service.AnnotationFailure, Message: "boom"}},
+		},
+		{
+			name:   "files outside of repository",
+			output: "/usr/local/go/src/fmt/print.go:10:2: oops\n../other/file.go:3:1: oops\n",
+		},
+		{
+			name:   "not a file reference",
+			output: "Get https://example.com:443/path: timeout\nok  \texample.com/app\t0.01s\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseAnnotations(tt.output)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseAnnotations() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+// Package checks builds detailed pipeline reports for services supporting
+// check runs.
+package checks
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	"github.com/shark-ci/shark-ci/internal/server/service"
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+const (
+	// logTailLines is how many last lines of the step output are shown.
+	logTailLines = 50
+	// maxTextLength keeps the text under GitHub limit of 65535 characters.
+	maxTextLength = 60000
+)
+
+// Build creates check run of the pipeline from its finished steps.
+func Build(name string, pipeline types.Pipeline, logs []types.PipelineLog) service.CheckRun {
+	run := service.CheckRun{
+		Name:       name,
+		ExternalID: strconv.FormatInt(pipeline.ID, 10),
+		State:      pipeline.Status,
+		DetailsURL: pipeline.URL,
+		Title:      title(pipeline.Status, len(logs)),
+		Summary:    summary(logs),
+		StartedAt:  pipeline.StartedAt,
+	}
+	if pipeline.Status.Terminal() {
+		run.CompletedAt = pipeline.FinishedAt
+	}
+
+	if step := reportedStep(logs); step != nil {
+		run.Text = text(*step)
+	}
+
+	for _, log := range logs {
+		run.Annotations = append(run.Annotations, ParseAnnotations(log.Output)...)
+	}
+
+	return run
+}
+
+func title(status types.PipelineStatus, steps int) string {
+	switch status {
+	case types.Queued:
+		return "Pipeline is queued"
+	case types.Running:
+		return fmt.Sprintf("Pipeline is running, %d steps finished", steps)
+	case types.Success:
+		return "Pipeline succeeded"
+	case types.Error:
+		return "Pipeline could not run"
+	case types.Cancelled:
+		return "Pipeline was cancelled"
+	case types.TimedOut:
+		return "Pipeline timed out"
+	case types.Skipped:
+		return "Pipeline was skipped"
+	default:
+		return "Pipeline failed"
+	}
+}
+
+func summary(logs []types.PipelineLog) string {
+	if len(logs) == 0 {
+		return "No steps finished yet."
+	}
+
+	var b strings.Builder
+	b.WriteString("| Step | Command | Exit code |\n| --- | --- | --- |\n")
+	for _, log := range logs {
+		result := "✅ 0"
+		if log.ExitCode != 0 {
+			result = fmt.Sprintf("❌ %d", log.ExitCode)
+		}
+		cmd := strings.ReplaceAll(html.EscapeString(log.Cmd), "|", "&#124;")
+		fmt.Fprintf(&b, "| %d | <code>%s</code> | %s |\n", log.Order, cmd, result)
+	}
+	return b.String()
+}
+
+// reportedStep returns the first failed step or the last step if none failed.
+func reportedStep(logs []types.PipelineLog) *types.PipelineLog {
+	for i := range logs {
+		if logs[i].ExitCode != 0 {
+			return &logs[i]
+		}
+	}
+	if len(logs) == 0 {
+		return nil
+	}
+	return &logs[len(logs)-1]
+}
+
+func text(step types.PipelineLog) string {
+	lines := strings.Split(strings.TrimRight(StripANSI(step.Output), "\n"), "\n")
+	if len(lines) > logTailLines {
+		lines = lines[len(lines)-logTailLines:]
+	}
+	output := strings.Join(lines, "\n")
+	if len(output) > maxTextLength {
+		output = output[len(output)-maxTextLength:]
+	}
+	output = strings.ReplaceAll(output, "```", "` ` `")
+
+	return fmt.Sprintf("### Output of step %d\n\n```\n%s\n```\n", step.Order, output)
+}
@@ -0,0 +1,360 @@
+package commitstatus
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/shark-ci/shark-ci/internal/server/checks"
+	"github.com/shark-ci/shark-ci/internal/server/retry"
+	"github.com/shark-ci/shark-ci/internal/server/service"
+	"github.com/shark-ci/shark-ci/internal/server/store"
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+const (
+	// Context under which are pipeline statuses reported.
+	Context = "Shark CI"
+	// PullRequestContext is used for pull request pipelines so they don't
+	// replace status of push pipeline of the same commit.
+	PullRequestContext = "Shark CI (pull request)"
+)
+
+// ContextFor returns status context of the pipeline.
+func ContextFor(prNumber *int32) string {
+	if prNumber != nil {
+		return PullRequestContext
+	}
+	return Context
+}
+
+const (
+	// maxAttempts is how many times is sending of status tried before it is
+	// given up.
+	maxAttempts  = 10
+	lease        = 2 * time.Minute
+	batchSize    = 10
+	pollInterval = 2 * time.Second
+	retryBase    = 10 * time.Second
+	retryMax     = 30 * time.Minute
+)
+
+var metrics = expvar.NewMap("commit_statuses")
+
+// Reporter sends commit statuses to services in the background. Statuses are
+// stored first so they survive restarts and service outages and only the
+// latest status for each commit and context is sent.
+type Reporter struct {
+	s        store.Storer
+	services service.Services
+	wake     chan struct{}
+
+	mu          sync.Mutex
+	pausedUntil map[types.Service]time.Time
+}
+
+func NewReporter(s store.Storer, services service.Services) *Reporter {
+	return &Reporter{
+		s:           s,
+		services:    services,
+		wake:        make(chan struct{}, 1),
+		pausedUntil: map[types.Service]time.Time{},
+	}
+}
+
+// Report queues status to be sent. Not yet sent status for the same commit and
+// context is replaced, unless it is later status of the same pipeline.
+func (r *Reporter) Report(ctx context.Context, status types.CommitStatus) error {
+	err := r.s.UpsertCommitStatus(ctx, status)
+	if err != nil {
+		return fmt.Errorf("store: cannot save commit status: %w", err)
+	}
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// SendNow sends status right away without queueing it, e.g. before the
+// repository is unregistered and statuses could not be sent anymore. Check runs
+// of the pipeline are updated too.
+func (r *Reporter) SendNow(ctx context.Context, status types.CommitStatus) error {
+	info, err := r.s.GetRepoStatusInfo(ctx, status.RepoID)
+	if err != nil {
+		return fmt.Errorf("store: cannot get repo status info: %w", err)
+	}
+	srv, ok := r.services[info.Service]
+	if !ok {
+		return fmt.Errorf("service %s is not configured", info.Service)
+	}
+
+	token, err := service.RepoToken(ctx, r.s, srv, info.ServiceUserID, info.Token, info.InstallationID, info.RepoServiceID)
+	if err != nil {
+		return err
+	}
+	info.Token = token
+
+	err = createStatus(ctx, srv, info, status)
+	if err != nil {
+		return err
+	}
+	if checkSrv, ok := srv.(service.CheckRunManager); ok && checkSrv.ChecksEnabled() && info.InstallationID != nil && status.PipelineID != nil {
+		err = r.syncCheckRuns(ctx, checkSrv, info, status)
+		if err != nil {
+			return fmt.Errorf("cannot sync check runs: %w", err)
+		}
+	}
+	metrics.Add("sent", 1)
+	return nil
+}
+
+// Run sends queued statuses until ctx is cancelled.
+func (r *Reporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		statuses, err := r.s.ClaimCommitStatuses(ctx, batchSize, lease)
+		if err != nil {
+			slog.Error("store: cannot claim commit statuses", "err", err)
+		}
+
+		var wg sync.WaitGroup
+		for _, status := range statuses {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r.send(ctx, status)
+			}()
+		}
+		wg.Wait()
+		if len(statuses) == batchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+func (r *Reporter) send(ctx context.Context, status types.CommitStatus) {
+	logger := slog.With("commitStatusID", status.ID, "commit", status.CommitSHA, "state", status.State, "attempt", status.Attempts)
+
+	info, err := r.s.GetRepoStatusInfo(ctx, status.RepoID)
+	if err != nil {
+		r.retry(ctx, logger, status, fmt.Errorf("store: cannot get repo status info: %w", err), 0)
+		return
+	}
+
+	srv, ok := r.services[info.Service]
+	if !ok {
+		r.giveUp(ctx, logger, status, fmt.Errorf("service %s is not configured", info.Service))
+		return
+	}
+
+	if wait := r.paused(info.Service); wait > 0 {
+		r.retry(ctx, logger, status, fmt.Errorf("%s rate limit exceeded", info.Service), wait)
+		return
+	}
+
+	// Installation tokens are only valid for an hour, so the token is taken
+	// right before it is used.
+	token, err := service.RepoToken(ctx, r.s, srv, info.ServiceUserID, info.Token, info.InstallationID, info.RepoServiceID)
+	if errors.Is(err, service.ErrNoInstallation) {
+		r.giveUp(ctx, logger, status, err)
+		return
+	}
+	if err != nil {
+		r.sendFailed(ctx, logger, info.Service, status, err)
+		return
+	}
+	info.Token = token
+
+	// Status is not sent again when only its check runs failed.
+	if !status.Created {
+		err = createStatus(ctx, srv, info, status)
+		if service.IsNotFound(err) {
+			// Repository may have been renamed or transferred without
+			// event being received, it is found by its ID.
+			var renamed bool
+			renamed, err = r.resyncRepo(ctx, logger, srv, status.RepoID, info)
+			if err == nil && renamed {
+				err = createStatus(ctx, srv, info, status)
+			} else if err == nil {
+				err = fmt.Errorf("repository %s/%s is not found", info.RepoOwner, info.RepoName)
+			}
+		}
+		if err != nil {
+			r.sendFailed(ctx, logger, info.Service, status, err)
+			return
+		}
+
+		err = r.s.CommitStatusCreated(ctx, status.ID, status.Version)
+		if err != nil {
+			logger.Error("store: cannot mark commit status as created", "err", err)
+		}
+	}
+
+	// Check runs can be created only with installation token of the app.
+	if checkSrv, ok := srv.(service.CheckRunManager); ok && checkSrv.ChecksEnabled() && info.InstallationID != nil && status.PipelineID != nil {
+		err = r.syncCheckRuns(ctx, checkSrv, info, status)
+		if err != nil {
+			r.sendFailed(ctx, logger, info.Service, status, fmt.Errorf("cannot sync check runs: %w", err))
+			return
+		}
+	}
+
+	metrics.Add("sent", 1)
+	err = r.s.CommitStatusSent(ctx, status.ID, status.Version, nil)
+	if err != nil {
+		logger.Error("store: cannot mark commit status as sent", "err", err)
+	}
+}
+
+func createStatus(ctx context.Context, srv service.ServiceManager, info *types.RepoStatusInfo, status types.CommitStatus) error {
+	return srv.CreateStatus(ctx, &info.Token, info.RepoOwner, info.RepoName, status.CommitSHA, service.Status{
+		State:       status.State,
+		TargetURL:   status.TargetURL,
+		Context:     status.Context,
+		Description: status.Description,
+	})
+}
+
+// resyncRepo updates owner and name of the repository from the service. It
+// reports whether they changed.
+func (r *Reporter) resyncRepo(ctx context.Context, logger *slog.Logger, srv service.ServiceManager, repoID int64, info *types.RepoStatusInfo) (bool, error) {
+	repo, err := srv.GetRepo(ctx, &info.Token, info.RepoServiceID)
+	if err != nil {
+		return false, fmt.Errorf("cannot resync repository: %w", err)
+	}
+	if repo.Owner == info.RepoOwner && repo.Name == info.RepoName {
+		return false, nil
+	}
+
+	err = r.s.UpdateRepoName(ctx, repoID, repo.Owner, repo.Name)
+	if err != nil {
+		return false, fmt.Errorf("store: %w", err)
+	}
+	logger.Info("Repository was renamed.", "from", info.RepoOwner+"/"+info.RepoName, "to", repo.Owner+"/"+repo.Name)
+	info.RepoOwner = repo.Owner
+	info.RepoName = repo.Name
+	return true, nil
+}
+
+// syncCheckRuns creates or updates check runs of jobs of the pipeline the
+// status belongs to. Jobs are known once the worker loads the workflow, until
+// then only the status is shown.
+func (r *Reporter) syncCheckRuns(ctx context.Context, srv service.CheckRunManager, info *types.RepoStatusInfo, status types.CommitStatus) error {
+	pipeline, err := r.s.GetPipeline(ctx, *status.PipelineID)
+	if err != nil {
+		return fmt.Errorf("store: cannot get pipeline: %w", err)
+	}
+	checkRuns, err := r.s.GetPipelineCheckRuns(ctx, pipeline.ID)
+	if err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+
+	logs, err := r.s.GetPipelineLogs(ctx, pipeline.ID)
+	if err != nil {
+		return fmt.Errorf("store: cannot get pipeline logs: %w", err)
+	}
+
+	// Pipelines which had single check run before jobs got their own keep
+	// updating it.
+	if checkRunID, ok := checkRuns[""]; ok {
+		return srv.UpdateCheckRun(ctx, &info.Token, info.RepoOwner, info.RepoName, checkRunID, checks.Build(status.Context, pipeline, logs))
+	}
+	if pipeline.Workflow == nil {
+		return nil
+	}
+	jobs, err := types.ParseWorkflowJobs([]byte(*pipeline.Workflow))
+	if err != nil {
+		// Worker fails the pipeline with invalid workflow, its status
+		// tells why.
+		return nil
+	}
+
+	for _, job := range jobs {
+		// Pipeline runs a single job, so it has all the logs.
+		run := checks.Build(status.Context+" / "+job, pipeline, logs)
+		if checkRunID, ok := checkRuns[job]; ok {
+			err = srv.UpdateCheckRun(ctx, &info.Token, info.RepoOwner, info.RepoName, checkRunID, run)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		checkRunID, err := srv.CreateCheckRun(ctx, &info.Token, info.RepoOwner, info.RepoName, status.CommitSHA, run)
+		if err != nil {
+			return err
+		}
+		err = r.s.SetPipelineCheckRun(ctx, pipeline.ID, job, checkRunID)
+		if err != nil {
+			return fmt.Errorf("store: cannot save check run ID: %w", err)
+		}
+	}
+	return nil
+}
+
+// sendFailed schedules retry of the status and pauses the service if its rate
+// limit was exceeded.
+func (r *Reporter) sendFailed(ctx context.Context, logger *slog.Logger, srv types.Service, status types.CommitStatus, err error) {
+	var retryAfter time.Duration
+	var rateErr *service.RateLimitError
+	if errors.As(err, &rateErr) {
+		retryAfter = rateErr.RetryAfter
+		r.pause(srv, retryAfter)
+	}
+	r.retry(ctx, logger, status, err, retryAfter)
+}
+
+func (r *Reporter) retry(ctx context.Context, logger *slog.Logger, status types.CommitStatus, err error, retryAfter time.Duration) {
+	if status.Attempts >= maxAttempts {
+		r.giveUp(ctx, logger, status, err)
+		return
+	}
+
+	delay := max(retry.Backoff(status.Attempts, retryBase, retryMax), retryAfter)
+	logger.Warn("Sending commit status failed, will retry.", "in", delay, "err", err)
+	metrics.Add("retried", 1)
+	err = r.s.RetryCommitStatus(ctx, status.ID, err.Error(), delay)
+	if err != nil {
+		logger.Error("store: cannot schedule commit status retry", "err", err)
+	}
+}
+
+func (r *Reporter) giveUp(ctx context.Context, logger *slog.Logger, status types.CommitStatus, err error) {
+	logger.Error("Sending commit status failed, giving up.", "err", err)
+	metrics.Add("dropped", 1)
+	e := err.Error()
+	err = r.s.CommitStatusSent(ctx, status.ID, status.Version, &e)
+	if err != nil {
+		logger.Error("store: cannot mark commit status as sent", "err", err)
+	}
+}
+
+// pause stops sending statuses to the service for d.
+func (r *Reporter) pause(srv types.Service, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	until := time.Now().Add(d)
+	if until.After(r.pausedUntil[srv]) {
+		r.pausedUntil[srv] = until
+	}
+}
+
+func (r *Reporter) paused(srv types.Service) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return time.Until(r.pausedUntil[srv])
+}
@@ -0,0 +1,128 @@
+package commitstatus
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/shark-ci/shark-ci/internal/server/service"
+	"github.com/shark-ci/shark-ci/internal/server/store"
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+// fakeStore holds single repository and records what happened to its status.
+type fakeStore struct {
+	store.Storer
+	owner, name string
+	created     bool
+	sent        bool
+	retried     bool
+	sendErr     *string
+}
+
+func (s *fakeStore) GetRepoStatusInfo(ctx context.Context, repoID int64) (*types.RepoStatusInfo, error) {
+	return &types.RepoStatusInfo{
+		Service:       types.ServiceGitHub,
+		RepoOwner:     s.owner,
+		RepoName:      s.name,
+		RepoServiceID: 7,
+		Token:         oauth2.Token{AccessToken: "token"},
+	}, nil
+}
+
+func (s *fakeStore) UpdateRepoName(ctx context.Context, repoID int64, owner string, name string) error {
+	s.owner, s.name = owner, name
+	return nil
+}
+
+func (s *fakeStore) CommitStatusCreated(ctx context.Context, statusID int64, version int) error {
+	s.created = true
+	return nil
+}
+
+func (s *fakeStore) CommitStatusSent(ctx context.Context, statusID int64, version int, sendErr *string) error {
+	s.sent = true
+	s.sendErr = sendErr
+	return nil
+}
+
+func (s *fakeStore) RetryCommitStatus(ctx context.Context, statusID int64, errMsg string, delay time.Duration) error {
+	s.retried = true
+	return nil
+}
+
+// fakeService serves repository under its current owner and name.
+type fakeService struct {
+	service.ServiceManager
+	repo     types.Repo
+	getErr   error
+	statuses []string
+}
+
+func (f *fakeService) GetRepo(ctx context.Context, token *oauth2.Token, repoServiceID int64) (types.Repo, error) {
+	if f.getErr != nil {
+		return types.Repo{}, f.getErr
+	}
+	return f.repo, nil
+}
+
+func (f *fakeService) CreateStatus(ctx context.Context, token *oauth2.Token, owner string, repoName string, commit string, status service.Status) error {
+	if owner != f.repo.Owner || repoName != f.repo.Name {
+		return &service.APIError{StatusCode: http.StatusNotFound}
+	}
+	f.statuses = append(f.statuses, owner+"/"+repoName+"@"+commit)
+	return nil
+}
+
+func TestResyncRepo(t *testing.T) {
+	s := &fakeStore{owner: "old-owner", name: "old"}
+	srv := &fakeService{repo: types.Repo{Owner: "new-owner", Name: "new"}}
+	r := NewReporter(s, service.Services{types.ServiceGitHub: srv})
+	info, _ := s.GetRepoStatusInfo(context.Background(), 1)
+
+	renamed, err := r.resyncRepo(context.Background(), slog.Default(), srv, 1, info)
+	if err != nil {
+		t.Fatalf("resyncRepo() error = %v", err)
+	}
+	if !renamed || info.RepoOwner != "new-owner" || info.RepoName != "new" || s.owner != "new-owner" || s.name != "new" {
+		t.Errorf("resyncRepo() = %v, info %s/%s, store %s/%s", renamed, info.RepoOwner, info.RepoName, s.owner, s.name)
+	}
+
+	renamed, err = r.resyncRepo(context.Background(), slog.Default(), srv, 1, info)
+	if err != nil || renamed {
+		t.Errorf("resyncRepo() of unchanged repo = %v, %v", renamed, err)
+	}
+
+	srv.getErr = errors.New("unavailable")
+	if _, err := r.resyncRepo(context.Background(), slog.Default(), srv, 1, info); err == nil {
+		t.Error("resyncRepo() succeeded although repo could not be fetched")
+	}
+}
+
+func TestSendRenamedRepo(t *testing.T) {
+	s := &fakeStore{owner: "old-owner", name: "old"}
+	srv := &fakeService{repo: types.Repo{Owner: "new-owner", Name: "new"}}
+	r := NewReporter(s, service.Services{types.ServiceGitHub: srv})
+
+	r.send(context.Background(), types.CommitStatus{ID: 1, RepoID: 1, CommitSHA: "abc", State: types.Success})
+	if len(srv.statuses) != 1 || srv.statuses[0] != "new-owner/new@abc" || !s.created || !s.sent || s.sendErr != nil {
+		t.Errorf("statuses %v, created=%v sent=%v err=%v, want status sent to renamed repo", srv.statuses, s.created, s.sent, s.sendErr)
+	}
+}
+
+func TestSendMissingRepo(t *testing.T) {
+	s := &fakeStore{owner: "old-owner", name: "old"}
+	// Repository cannot be found by its ID either.
+	srv := &fakeService{repo: types.Repo{Owner: "new-owner", Name: "new"}, getErr: &service.APIError{StatusCode: http.StatusNotFound}}
+	r := NewReporter(s, service.Services{types.ServiceGitHub: srv})
+
+	r.send(context.Background(), types.CommitStatus{ID: 1, RepoID: 1, CommitSHA: "abc", State: types.Success})
+	if !s.retried || s.sent || len(srv.statuses) != 0 {
+		t.Errorf("retried=%v sent=%v statuses=%v, want retry", s.retried, s.sent, srv.statuses)
+	}
+}
@@ -0,0 +1,204 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: commit_status.sql
+
+package db
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const claimCommitStatuses = `-- name: ClaimCommitStatuses :many
+UPDATE "commit_status"
+SET attempts = attempts + 1, next_attempt_at = now() + $1::interval
+WHERE id IN (
+    SELECT cs.id
+    FROM "commit_status" cs
+    WHERE cs.version > cs.sent_version AND cs.next_attempt_at <= now()
+    ORDER BY cs.updated_at
+    LIMIT $2
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, repo_id, commit_sha, context, state, target_url, description, pipeline_id, version, sent_version, attempts, next_attempt_at, error, updated_at, status_sent_version
+`
+
+type ClaimCommitStatusesParams struct {
+	Lease       pgtype.Interval
+	MaxStatuses int32
+}
+
+func (q *Queries) ClaimCommitStatuses(ctx context.Context, arg ClaimCommitStatusesParams) ([]CommitStatus, error) {
+	rows, err := q.db.Query(ctx, claimCommitStatuses, arg.Lease, arg.MaxStatuses)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CommitStatus
+	for rows.Next() {
+		var i CommitStatus
+		if err := rows.Scan(
+			&i.ID,
+			&i.RepoID,
+			&i.CommitSha,
+			&i.Context,
+			&i.State,
+			&i.TargetUrl,
+			&i.Description,
+			&i.PipelineID,
+			&i.Version,
+			&i.SentVersion,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.Error,
+			&i.UpdatedAt,
+			&i.StatusSentVersion,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const commitStatusCreated = `-- name: CommitStatusCreated :exec
+UPDATE "commit_status"
+SET status_sent_version = $1
+WHERE id = $2 AND status_sent_version < $1
+`
+
+type CommitStatusCreatedParams struct {
+	Version int32
+	ID      int64
+}
+
+func (q *Queries) CommitStatusCreated(ctx context.Context, arg CommitStatusCreatedParams) error {
+	_, err := q.db.Exec(ctx, commitStatusCreated, arg.Version, arg.ID)
+	return err
+}
+
+const commitStatusSent = `-- name: CommitStatusSent :exec
+UPDATE "commit_status"
+SET sent_version = $1, error = $2
+WHERE id = $3 AND sent_version < $1
+`
+
+type CommitStatusSentParams struct {
+	Version int32
+	Error   pgtype.Text
+	ID      int64
+}
+
+func (q *Queries) CommitStatusSent(ctx context.Context, arg CommitStatusSentParams) error {
+	_, err := q.db.Exec(ctx, commitStatusSent, arg.Version, arg.Error, arg.ID)
+	return err
+}
+
+const getRepoStatusInfo = `-- name: GetRepoStatusInfo :one
+SELECT r.service, r.owner, r.name, r.repo_service_id, r.installation_id, r.archived_at, su.id AS service_user_id, su.access_token, su.refresh_token, su.token_type, su.token_expire, su.token_key_id
+FROM "repo" r JOIN "service_user" su ON r.service_user_id = su.id
+WHERE r.id = $1
+`
+
+type GetRepoStatusInfoRow struct {
+	Service        Service
+	Owner          string
+	Name           string
+	RepoServiceID  int64
+	InstallationID pgtype.Int8
+	ArchivedAt     pgtype.Timestamp
+	ServiceUserID  int64
+	AccessToken    string
+	RefreshToken   pgtype.Text
+	TokenType      string
+	TokenExpire    pgtype.Timestamp
+	TokenKeyID     pgtype.Text
+}
+
+func (q *Queries) GetRepoStatusInfo(ctx context.Context, id int64) (GetRepoStatusInfoRow, error) {
+	row := q.db.QueryRow(ctx, getRepoStatusInfo, id)
+	var i GetRepoStatusInfoRow
+	err := row.Scan(
+		&i.Service,
+		&i.Owner,
+		&i.Name,
+		&i.RepoServiceID,
+		&i.InstallationID,
+		&i.ArchivedAt,
+		&i.ServiceUserID,
+		&i.AccessToken,
+		&i.RefreshToken,
+		&i.TokenType,
+		&i.TokenExpire,
+		&i.TokenKeyID,
+	)
+	return i, err
+}
+
+const retryCommitStatus = `-- name: RetryCommitStatus :exec
+UPDATE "commit_status"
+SET error = $1, next_attempt_at = now() + $2::interval
+WHERE id = $3
+`
+
+type RetryCommitStatusParams struct {
+	Error pgtype.Text
+	Delay pgtype.Interval
+	ID    int64
+}
+
+func (q *Queries) RetryCommitStatus(ctx context.Context, arg RetryCommitStatusParams) error {
+	_, err := q.db.Exec(ctx, retryCommitStatus, arg.Error, arg.Delay, arg.ID)
+	return err
+}
+
+const upsertCommitStatus = `-- name: UpsertCommitStatus :exec
+INSERT INTO "commit_status" (repo_id, commit_sha, context, state, target_url, description, pipeline_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (repo_id, commit_sha, context) DO UPDATE
+SET state = EXCLUDED.state,
+    target_url = EXCLUDED.target_url,
+    description = EXCLUDED.description,
+    pipeline_id = EXCLUDED.pipeline_id,
+    version = "commit_status".version + 1,
+    attempts = 0,
+    next_attempt_at = now(),
+    error = NULL,
+    updated_at = now()
+WHERE "commit_status".pipeline_id IS NULL
+   OR EXCLUDED.pipeline_id > "commit_status".pipeline_id
+   OR (EXCLUDED.pipeline_id = "commit_status".pipeline_id
+       AND (CASE EXCLUDED.state WHEN 'queued' THEN 0 WHEN 'running' THEN 1 ELSE 2 END)
+       >= (CASE "commit_status".state WHEN 'queued' THEN 0 WHEN 'running' THEN 1 ELSE 2 END))
+`
+
+type UpsertCommitStatusParams struct {
+	RepoID      int64
+	CommitSha   string
+	Context     string
+	State       PipelineStatus
+	TargetUrl   string
+	Description string
+	PipelineID  pgtype.Int8
+}
+
+// Re-runs and later pipelines of the commit have greater ID, late status of
+// older pipeline must not replace them. Late status of the same pipeline must
+// not downgrade it, e.g. queued reported after the worker already started it.
+func (q *Queries) UpsertCommitStatus(ctx context.Context, arg UpsertCommitStatusParams) error {
+	_, err := q.db.Exec(ctx, upsertCommitStatus,
+		arg.RepoID,
+		arg.CommitSha,
+		arg.Context,
+		arg.State,
+		arg.TargetUrl,
+		arg.Description,
+		arg.PipelineID,
+	)
+	return err
+}
@@ -0,0 +1,105 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: job_token.sql
+
+package db
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const cleanJobTokens = `-- name: CleanJobTokens :exec
+DELETE FROM "job_token"
+WHERE expires_at < now() OR revoked_at IS NOT NULL
+`
+
+func (q *Queries) CleanJobTokens(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, cleanJobTokens)
+	return err
+}
+
+const extendJobToken = `-- name: ExtendJobToken :exec
+UPDATE "job_token"
+SET expires_at = now() + $1::interval
+WHERE pipeline_id = $2 AND revoked_at IS NULL AND expires_at > now()
+`
+
+type ExtendJobTokenParams struct {
+	Ttl        pgtype.Interval
+	PipelineID int64
+}
+
+func (q *Queries) ExtendJobToken(ctx context.Context, arg ExtendJobTokenParams) error {
+	_, err := q.db.Exec(ctx, extendJobToken, arg.Ttl, arg.PipelineID)
+	return err
+}
+
+const getJobTokenState = `-- name: GetJobTokenState :one
+SELECT (revoked_at IS NOT NULL)::bool AS revoked, (expires_at <= now())::bool AS expired
+FROM "job_token"
+WHERE pipeline_id = $1 AND token_hash = $2
+`
+
+type GetJobTokenStateParams struct {
+	PipelineID int64
+	TokenHash  []byte
+}
+
+type GetJobTokenStateRow struct {
+	Revoked bool
+	Expired bool
+}
+
+func (q *Queries) GetJobTokenState(ctx context.Context, arg GetJobTokenStateParams) (GetJobTokenStateRow, error) {
+	row := q.db.QueryRow(ctx, getJobTokenState, arg.PipelineID, arg.TokenHash)
+	var i GetJobTokenStateRow
+	err := row.Scan(&i.Revoked, &i.Expired)
+	return i, err
+}
+
+const revokeJobToken = `-- name: RevokeJobToken :exec
+UPDATE "job_token"
+SET revoked_at = now()
+WHERE pipeline_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeJobToken(ctx context.Context, pipelineID int64) error {
+	_, err := q.db.Exec(ctx, revokeJobToken, pipelineID)
+	return err
+}
+
+const revokeRepoJobTokens = `-- name: RevokeRepoJobTokens :exec
+UPDATE "job_token"
+SET revoked_at = now()
+WHERE revoked_at IS NULL AND pipeline_id IN (
+    SELECT id
+    FROM "pipeline"
+    WHERE repo_id = $1
+)
+`
+
+func (q *Queries) RevokeRepoJobTokens(ctx context.Context, repoID int64) error {
+	_, err := q.db.Exec(ctx, revokeRepoJobTokens, repoID)
+	return err
+}
+
+const upsertJobToken = `-- name: UpsertJobToken :exec
+INSERT INTO "job_token" (pipeline_id, token_hash, expires_at)
+VALUES ($1, $2, now() + $3::interval)
+ON CONFLICT (pipeline_id) DO UPDATE
+SET token_hash = EXCLUDED.token_hash, expires_at = EXCLUDED.expires_at, revoked_at = NULL
+`
+
+type UpsertJobTokenParams struct {
+	PipelineID int64
+	TokenHash  []byte
+	Ttl        pgtype.Interval
+}
+
+func (q *Queries) UpsertJobToken(ctx context.Context, arg UpsertJobTokenParams) error {
+	_, err := q.db.Exec(ctx, upsertJobToken, arg.PipelineID, arg.TokenHash, arg.Ttl)
+	return err
+}
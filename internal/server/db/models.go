@@ -12,13 +12,61 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type DeliveryStatus string
+
+const (
+	DeliveryStatusPending   DeliveryStatus = "pending"
+	DeliveryStatusProcessed DeliveryStatus = "processed"
+	DeliveryStatusIgnored   DeliveryStatus = "ignored"
+	DeliveryStatusFailed    DeliveryStatus = "failed"
+)
+
+func (e *DeliveryStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = DeliveryStatus(s)
+	case string:
+		*e = DeliveryStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for DeliveryStatus: %T", src)
+	}
+	return nil
+}
+
+type NullDeliveryStatus struct {
+	DeliveryStatus DeliveryStatus
+	Valid          bool // Valid is true if DeliveryStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullDeliveryStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.DeliveryStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.DeliveryStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullDeliveryStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.DeliveryStatus), nil
+}
+
 type PipelineStatus string
 
 const (
-	PipelineStatusSuccess PipelineStatus = "success"
-	PipelineStatusPending PipelineStatus = "pending"
-	PipelineStatusRunning PipelineStatus = "running"
-	PipelineStatusError   PipelineStatus = "error"
+	PipelineStatusSuccess   PipelineStatus = "success"
+	PipelineStatusQueued    PipelineStatus = "queued"
+	PipelineStatusRunning   PipelineStatus = "running"
+	PipelineStatusError     PipelineStatus = "error"
+	PipelineStatusCancelled PipelineStatus = "cancelled"
+	PipelineStatusFailure   PipelineStatus = "failure"
+	PipelineStatusTimedOut  PipelineStatus = "timed_out"
+	PipelineStatusSkipped   PipelineStatus = "skipped"
 )
 
 func (e *PipelineStatus) Scan(src interface{}) error {
@@ -61,6 +109,7 @@ type Service string
 const (
 	ServiceGitHub Service = "GitHub"
 	ServiceGitLab Service = "GitLab"
+	ServiceGitea  Service = "Gitea"
 )
 
 func (e *Service) Scan(src interface{}) error {
@@ -98,20 +147,86 @@ func (ns NullService) Value() (driver.Value, error) {
 	return string(ns.Service), nil
 }
 
+type CommitStatus struct {
+	ID                int64
+	RepoID            int64
+	CommitSha         string
+	Context           string
+	State             PipelineStatus
+	TargetUrl         string
+	Description       string
+	PipelineID        pgtype.Int8
+	Version           int32
+	SentVersion       int32
+	Attempts          int32
+	NextAttemptAt     pgtype.Timestamp
+	Error             pgtype.Text
+	UpdatedAt         pgtype.Timestamp
+	StatusSentVersion int32
+}
+
+type JobToken struct {
+	PipelineID int64
+	TokenHash  []byte
+	ExpiresAt  pgtype.Timestamp
+	RevokedAt  pgtype.Timestamp
+}
+
 type Oauth2State struct {
 	State  uuid.UUID
 	Expire pgtype.Timestamp
 }
 
 type Pipeline struct {
+	ID               int64
+	Url              pgtype.Text
+	Status           PipelineStatus
+	CloneUrl         string
+	CommitSha        string
+	StartedAt        pgtype.Timestamp
+	FinishedAt       pgtype.Timestamp
+	RepoID           int64
+	PrNumber         pgtype.Int4
+	SourceBranch     pgtype.Text
+	TargetBranch     pgtype.Text
+	FetchRef         pgtype.Text
+	Fork             bool
+	AwaitingApproval bool
+	ApprovedBy       pgtype.Int8
+	CreatedAt        pgtype.Timestamp
+	Event            string
+	Ref              pgtype.Text
+	Branch           pgtype.Text
+	Tag              pgtype.Text
+	CommitMessage    pgtype.Text
+	AuthorName       pgtype.Text
+	AuthorEmail      pgtype.Text
+	CommitterName    pgtype.Text
+	CommitterEmail   pgtype.Text
+	Pusher           pgtype.Text
+	CompareUrl       pgtype.Text
+	Number           int64
+	Attempt          int32
+	OriginalID       pgtype.Int8
+	Workflow         pgtype.Text
+	Inputs           []byte
+	SentAt           pgtype.Timestamp
+}
+
+type PipelineCheckRun struct {
+	PipelineID int64
+	Job        string
+	CheckRunID int64
+}
+
+type PipelineEvent struct {
 	ID         int64
-	Url        pgtype.Text
-	Status     PipelineStatus
-	CloneUrl   string
-	CommitSha  string
-	StartedAt  pgtype.Timestamp
-	FinishedAt pgtype.Timestamp
-	RepoID     int64
+	PipelineID int64
+	FromStatus NullPipelineStatus
+	ToStatus   PipelineStatus
+	Actor      string
+	Message    pgtype.Text
+	CreatedAt  pgtype.Timestamp
 }
 
 type PipelineLog struct {
@@ -121,28 +236,74 @@ type PipelineLog struct {
 	Output     string
 	ExitCode   int32
 	PipelineID int64
+	StartedAt  pgtype.Timestamp
+	FinishedAt pgtype.Timestamp
 }
 
 type Repo struct {
-	ID            int64
-	Service       Service
-	Owner         string
-	Name          string
-	RepoServiceID int64
-	WebhookID     int64
-	ServiceUserID int64
+	ID                     int64
+	Service                Service
+	Owner                  string
+	Name                   string
+	RepoServiceID          int64
+	WebhookID              pgtype.Int8
+	ServiceUserID          int64
+	InstallationID         pgtype.Int8
+	WebhookSecret          pgtype.Text
+	PreviousWebhookSecret  pgtype.Text
+	WebhookSecretKeyID     pgtype.Text
+	WebhookSecretRotatedAt pgtype.Timestamp
+	ArchivedAt             pgtype.Timestamp
+	WebhookCheckedAt       pgtype.Timestamp
+	WebhookCheckError      pgtype.Text
+	WebhookRepairedAt      pgtype.Timestamp
+	LastPipelineNumber     int64
+	ForgeArchivedAt        pgtype.Timestamp
+}
+
+type RepoDeployToken struct {
+	RepoID          int64
+	TokenID         int64
+	Username        string
+	Token           string
+	TokenKeyID      string
+	ExpiresAt       pgtype.Timestamp
+	PreviousTokenID pgtype.Int8
+}
+
+type Schedule struct {
+	ID             int64
+	RepoID         int64
+	Branch         string
+	Cron           string
+	Timezone       string
+	FromWorkflow   bool
+	NextRunAt      pgtype.Timestamp
+	LastRunAt      pgtype.Timestamp
+	LastPipelineID pgtype.Int8
+	LastError      pgtype.Text
+	CreatedAt      pgtype.Timestamp
+	ClaimedAt      pgtype.Timestamp
+}
+
+type ScheduleRun struct {
+	ScheduleID int64
+	RunAt      pgtype.Timestamp
+	PipelineID int64
 }
 
 type ServiceUser struct {
-	ID           int64
-	Service      Service
-	Username     string
-	Email        string
-	AccessToken  string
-	RefreshToken pgtype.Text
-	TokenType    string
-	TokenExpire  pgtype.Timestamp
-	UserID       int64
+	ID              int64
+	Service         Service
+	Username        string
+	Email           string
+	AccessToken     string
+	RefreshToken    pgtype.Text
+	TokenType       string
+	TokenExpire     pgtype.Timestamp
+	UserID          int64
+	ReloginRequired bool
+	TokenKeyID      pgtype.Text
 }
 
 type User struct {
@@ -150,3 +311,26 @@ type User struct {
 	Username string
 	Email    string
 }
+
+type WebhookDelivery struct {
+	ID            int64
+	Service       Service
+	DeliveryID    string
+	Event         string
+	Headers       []byte
+	Payload       []byte
+	Status        DeliveryStatus
+	Error         pgtype.Text
+	ReceivedAt    pgtype.Timestamp
+	ProcessedAt   pgtype.Timestamp
+	RepoID        pgtype.Int8
+	PipelineID    pgtype.Int8
+	Attempts      int32
+	NextAttemptAt pgtype.Timestamp
+}
+
+type WorkflowScheduleSync struct {
+	RepoID   int64
+	Branch   string
+	SyncedAt pgtype.Timestamp
+}
@@ -11,77 +11,424 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const approvePipeline = `-- name: ApprovePipeline :execrows
+UPDATE "pipeline"
+SET awaiting_approval = false, approved_by = $1
+WHERE id = $2 AND awaiting_approval
+`
+
+type ApprovePipelineParams struct {
+	ApprovedBy pgtype.Int8
+	ID         int64
+}
+
+func (q *Queries) ApprovePipeline(ctx context.Context, arg ApprovePipelineParams) (int64, error) {
+	result, err := q.db.Exec(ctx, approvePipeline, arg.ApprovedBy, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const createPipeline = `-- name: CreatePipeline :one
-INSERT INTO "pipeline" (status, clone_url, commit_sha, repo_id)
-VALUES ($1, $2, $3, $4)
-RETURNING id
+WITH "next" AS (
+    UPDATE "repo"
+    SET last_pipeline_number = last_pipeline_number + 1
+    WHERE id = $4
+    RETURNING last_pipeline_number
+)
+INSERT INTO "pipeline" (
+    status, clone_url, commit_sha, repo_id, pr_number, source_branch, target_branch, fetch_ref, fork, awaiting_approval,
+    event, ref, branch, tag, commit_message, author_name, author_email, committer_name, committer_email, pusher, compare_url,
+    attempt, original_id, workflow, inputs, number
+)
+SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, "next".last_pipeline_number
+FROM "next"
+RETURNING id, number
 `
 
 type CreatePipelineParams struct {
-	Status    PipelineStatus
-	CloneUrl  string
-	CommitSha string
-	RepoID    int64
+	Status           PipelineStatus
+	CloneUrl         string
+	CommitSha        string
+	RepoID           int64
+	PrNumber         pgtype.Int4
+	SourceBranch     pgtype.Text
+	TargetBranch     pgtype.Text
+	FetchRef         pgtype.Text
+	Fork             bool
+	AwaitingApproval bool
+	Event            string
+	Ref              pgtype.Text
+	Branch           pgtype.Text
+	Tag              pgtype.Text
+	CommitMessage    pgtype.Text
+	AuthorName       pgtype.Text
+	AuthorEmail      pgtype.Text
+	CommitterName    pgtype.Text
+	CommitterEmail   pgtype.Text
+	Pusher           pgtype.Text
+	CompareUrl       pgtype.Text
+	Attempt          int32
+	OriginalID       pgtype.Int8
+	Workflow         pgtype.Text
+	Inputs           []byte
+}
+
+type CreatePipelineRow struct {
+	ID     int64
+	Number int64
 }
 
-func (q *Queries) CreatePipeline(ctx context.Context, arg CreatePipelineParams) (int64, error) {
+// Number is taken from the repository row, which stays locked until the end of
+// the transaction, so concurrent pipelines of the repository get distinct numbers.
+func (q *Queries) CreatePipeline(ctx context.Context, arg CreatePipelineParams) (CreatePipelineRow, error) {
 	row := q.db.QueryRow(ctx, createPipeline,
 		arg.Status,
 		arg.CloneUrl,
 		arg.CommitSha,
 		arg.RepoID,
+		arg.PrNumber,
+		arg.SourceBranch,
+		arg.TargetBranch,
+		arg.FetchRef,
+		arg.Fork,
+		arg.AwaitingApproval,
+		arg.Event,
+		arg.Ref,
+		arg.Branch,
+		arg.Tag,
+		arg.CommitMessage,
+		arg.AuthorName,
+		arg.AuthorEmail,
+		arg.CommitterName,
+		arg.CommitterEmail,
+		arg.Pusher,
+		arg.CompareUrl,
+		arg.Attempt,
+		arg.OriginalID,
+		arg.Workflow,
+		arg.Inputs,
 	)
-	var id int64
-	err := row.Scan(&id)
-	return id, err
+	var i CreatePipelineRow
+	err := row.Scan(&i.ID, &i.Number)
+	return i, err
+}
+
+const createPipelineEvent = `-- name: CreatePipelineEvent :exec
+INSERT INTO "pipeline_event" (pipeline_id, from_status, to_status, actor, message)
+VALUES ($1, $2, $3, $4, $5)
+`
+
+type CreatePipelineEventParams struct {
+	PipelineID int64
+	FromStatus NullPipelineStatus
+	ToStatus   PipelineStatus
+	Actor      string
+	Message    pgtype.Text
+}
+
+func (q *Queries) CreatePipelineEvent(ctx context.Context, arg CreatePipelineEventParams) error {
+	_, err := q.db.Exec(ctx, createPipelineEvent,
+		arg.PipelineID,
+		arg.FromStatus,
+		arg.ToStatus,
+		arg.Actor,
+		arg.Message,
+	)
+	return err
+}
+
+const getLatestCommitPipeline = `-- name: GetLatestCommitPipeline :one
+SELECT id, url, status, clone_url, commit_sha, started_at, finished_at, repo_id, pr_number, source_branch, target_branch, fetch_ref, fork, awaiting_approval, approved_by, created_at, event, ref, branch, tag, commit_message, author_name, author_email, committer_name, committer_email, pusher, compare_url, number, attempt, original_id, workflow, inputs, sent_at
+FROM "pipeline"
+WHERE repo_id = $1 AND commit_sha = $2
+ORDER BY id DESC
+LIMIT 1
+`
+
+type GetLatestCommitPipelineParams struct {
+	RepoID    int64
+	CommitSha string
+}
+
+func (q *Queries) GetLatestCommitPipeline(ctx context.Context, arg GetLatestCommitPipelineParams) (Pipeline, error) {
+	row := q.db.QueryRow(ctx, getLatestCommitPipeline, arg.RepoID, arg.CommitSha)
+	var i Pipeline
+	err := row.Scan(
+		&i.ID,
+		&i.Url,
+		&i.Status,
+		&i.CloneUrl,
+		&i.CommitSha,
+		&i.StartedAt,
+		&i.FinishedAt,
+		&i.RepoID,
+		&i.PrNumber,
+		&i.SourceBranch,
+		&i.TargetBranch,
+		&i.FetchRef,
+		&i.Fork,
+		&i.AwaitingApproval,
+		&i.ApprovedBy,
+		&i.CreatedAt,
+		&i.Event,
+		&i.Ref,
+		&i.Branch,
+		&i.Tag,
+		&i.CommitMessage,
+		&i.AuthorName,
+		&i.AuthorEmail,
+		&i.CommitterName,
+		&i.CommitterEmail,
+		&i.Pusher,
+		&i.CompareUrl,
+		&i.Number,
+		&i.Attempt,
+		&i.OriginalID,
+		&i.Workflow,
+		&i.Inputs,
+		&i.SentAt,
+	)
+	return i, err
+}
+
+const getPipeline = `-- name: GetPipeline :one
+SELECT id, url, status, clone_url, commit_sha, started_at, finished_at, repo_id, pr_number, source_branch, target_branch, fetch_ref, fork, awaiting_approval, approved_by, created_at, event, ref, branch, tag, commit_message, author_name, author_email, committer_name, committer_email, pusher, compare_url, number, attempt, original_id, workflow, inputs, sent_at
+FROM "pipeline"
+WHERE id = $1
+`
+
+func (q *Queries) GetPipeline(ctx context.Context, id int64) (Pipeline, error) {
+	row := q.db.QueryRow(ctx, getPipeline, id)
+	var i Pipeline
+	err := row.Scan(
+		&i.ID,
+		&i.Url,
+		&i.Status,
+		&i.CloneUrl,
+		&i.CommitSha,
+		&i.StartedAt,
+		&i.FinishedAt,
+		&i.RepoID,
+		&i.PrNumber,
+		&i.SourceBranch,
+		&i.TargetBranch,
+		&i.FetchRef,
+		&i.Fork,
+		&i.AwaitingApproval,
+		&i.ApprovedBy,
+		&i.CreatedAt,
+		&i.Event,
+		&i.Ref,
+		&i.Branch,
+		&i.Tag,
+		&i.CommitMessage,
+		&i.AuthorName,
+		&i.AuthorEmail,
+		&i.CommitterName,
+		&i.CommitterEmail,
+		&i.Pusher,
+		&i.CompareUrl,
+		&i.Number,
+		&i.Attempt,
+		&i.OriginalID,
+		&i.Workflow,
+		&i.Inputs,
+		&i.SentAt,
+	)
+	return i, err
+}
+
+const getPipelineAttempts = `-- name: GetPipelineAttempts :many
+SELECT id, url, status, clone_url, commit_sha, started_at, finished_at, repo_id, pr_number, source_branch, target_branch, fetch_ref, fork, awaiting_approval, approved_by, created_at, event, ref, branch, tag, commit_message, author_name, author_email, committer_name, committer_email, pusher, compare_url, number, attempt, original_id, workflow, inputs, sent_at
+FROM "pipeline"
+WHERE id = $1 OR original_id = $1
+ORDER BY attempt
+`
+
+func (q *Queries) GetPipelineAttempts(ctx context.Context, id int64) ([]Pipeline, error) {
+	rows, err := q.db.Query(ctx, getPipelineAttempts, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Pipeline
+	for rows.Next() {
+		var i Pipeline
+		if err := rows.Scan(
+			&i.ID,
+			&i.Url,
+			&i.Status,
+			&i.CloneUrl,
+			&i.CommitSha,
+			&i.StartedAt,
+			&i.FinishedAt,
+			&i.RepoID,
+			&i.PrNumber,
+			&i.SourceBranch,
+			&i.TargetBranch,
+			&i.FetchRef,
+			&i.Fork,
+			&i.AwaitingApproval,
+			&i.ApprovedBy,
+			&i.CreatedAt,
+			&i.Event,
+			&i.Ref,
+			&i.Branch,
+			&i.Tag,
+			&i.CommitMessage,
+			&i.AuthorName,
+			&i.AuthorEmail,
+			&i.CommitterName,
+			&i.CommitterEmail,
+			&i.Pusher,
+			&i.CompareUrl,
+			&i.Number,
+			&i.Attempt,
+			&i.OriginalID,
+			&i.Workflow,
+			&i.Inputs,
+			&i.SentAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPipelineCheckRuns = `-- name: GetPipelineCheckRuns :many
+SELECT job, check_run_id
+FROM "pipeline_check_run"
+WHERE pipeline_id = $1
+`
+
+type GetPipelineCheckRunsRow struct {
+	Job        string
+	CheckRunID int64
+}
+
+func (q *Queries) GetPipelineCheckRuns(ctx context.Context, pipelineID int64) ([]GetPipelineCheckRunsRow, error) {
+	rows, err := q.db.Query(ctx, getPipelineCheckRuns, pipelineID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetPipelineCheckRunsRow
+	for rows.Next() {
+		var i GetPipelineCheckRunsRow
+		if err := rows.Scan(&i.Job, &i.CheckRunID); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
 const getPipelineCreationInfo = `-- name: GetPipelineCreationInfo :one
-SELECT su.username, su.access_token, su.refresh_token, su.token_type, su.token_expire, r.name
+SELECT su.id AS service_user_id, su.username, su.access_token, su.refresh_token, su.token_type, su.token_expire, su.token_key_id, r.name, r.service, r.repo_service_id, r.installation_id
 FROM "service_user" su JOIN "repo" r ON su.id = r.service_user_id
 WHERE r.id = $1
 `
 
 type GetPipelineCreationInfoRow struct {
-	Username     string
-	AccessToken  string
-	RefreshToken pgtype.Text
-	TokenType    string
-	TokenExpire  pgtype.Timestamp
-	Name         string
+	ServiceUserID  int64
+	Username       string
+	AccessToken    string
+	RefreshToken   pgtype.Text
+	TokenType      string
+	TokenExpire    pgtype.Timestamp
+	TokenKeyID     pgtype.Text
+	Name           string
+	Service        Service
+	RepoServiceID  int64
+	InstallationID pgtype.Int8
 }
 
 func (q *Queries) GetPipelineCreationInfo(ctx context.Context, id int64) (GetPipelineCreationInfoRow, error) {
 	row := q.db.QueryRow(ctx, getPipelineCreationInfo, id)
 	var i GetPipelineCreationInfoRow
 	err := row.Scan(
+		&i.ServiceUserID,
 		&i.Username,
 		&i.AccessToken,
 		&i.RefreshToken,
 		&i.TokenType,
 		&i.TokenExpire,
+		&i.TokenKeyID,
 		&i.Name,
+		&i.Service,
+		&i.RepoServiceID,
+		&i.InstallationID,
 	)
 	return i, err
 }
 
+const getPipelineEvents = `-- name: GetPipelineEvents :many
+SELECT id, pipeline_id, from_status, to_status, actor, message, created_at
+FROM "pipeline_event"
+WHERE pipeline_id = $1
+ORDER BY id
+`
+
+func (q *Queries) GetPipelineEvents(ctx context.Context, pipelineID int64) ([]PipelineEvent, error) {
+	rows, err := q.db.Query(ctx, getPipelineEvents, pipelineID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PipelineEvent
+	for rows.Next() {
+		var i PipelineEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.PipelineID,
+			&i.FromStatus,
+			&i.ToStatus,
+			&i.Actor,
+			&i.Message,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getPipelineStateChangeInfo = `-- name: GetPipelineStateChangeInfo :one
-SELECT p.url, p.commit_sha, p.started_at, r.service, r.owner, r.name, su.access_token, su.refresh_token, su.token_type, su.token_expire
+SELECT p.url, p.number, p.status, p.commit_sha, p.started_at, p.repo_id, p.pr_number, r.service, r.owner, r.name, su.id AS service_user_id, su.access_token, su.refresh_token, su.token_type, su.token_expire, su.token_key_id
 FROM public.pipeline p JOIN public.repo r ON p.repo_id = r.id JOIN public.service_user su ON r.service_user_id = su.id
 WHERE p.id = $1
 `
 
 type GetPipelineStateChangeInfoRow struct {
-	Url          pgtype.Text
-	CommitSha    string
-	StartedAt    pgtype.Timestamp
-	Service      Service
-	Owner        string
-	Name         string
-	AccessToken  string
-	RefreshToken pgtype.Text
-	TokenType    string
-	TokenExpire  pgtype.Timestamp
+	Url           pgtype.Text
+	Number        int64
+	Status        PipelineStatus
+	CommitSha     string
+	StartedAt     pgtype.Timestamp
+	RepoID        int64
+	PrNumber      pgtype.Int4
+	Service       Service
+	Owner         string
+	Name          string
+	ServiceUserID int64
+	AccessToken   string
+	RefreshToken  pgtype.Text
+	TokenType     string
+	TokenExpire   pgtype.Timestamp
+	TokenKeyID    pgtype.Text
 }
 
 func (q *Queries) GetPipelineStateChangeInfo(ctx context.Context, id int64) (GetPipelineStateChangeInfoRow, error) {
@@ -89,50 +436,240 @@ func (q *Queries) GetPipelineStateChangeInfo(ctx context.Context, id int64) (Get
 	var i GetPipelineStateChangeInfoRow
 	err := row.Scan(
 		&i.Url,
+		&i.Number,
+		&i.Status,
 		&i.CommitSha,
 		&i.StartedAt,
+		&i.RepoID,
+		&i.PrNumber,
 		&i.Service,
 		&i.Owner,
 		&i.Name,
+		&i.ServiceUserID,
 		&i.AccessToken,
 		&i.RefreshToken,
 		&i.TokenType,
 		&i.TokenExpire,
+		&i.TokenKeyID,
 	)
 	return i, err
 }
 
-const getPipelinesByRepo = `-- name: GetPipelinesByRepo :many
-SELECT "id", "url", "status", "commit_sha", "started_at", "finished_at"
+const getPipelinesAwaitingApproval = `-- name: GetPipelinesAwaitingApproval :many
+SELECT id, url, status, clone_url, commit_sha, started_at, finished_at, repo_id, pr_number, source_branch, target_branch, fetch_ref, fork, awaiting_approval, approved_by, created_at, event, ref, branch, tag, commit_message, author_name, author_email, committer_name, committer_email, pusher, compare_url, number, attempt, original_id, workflow, inputs, sent_at
 FROM "pipeline"
-WHERE "repo_id" = $1
+WHERE repo_id = $1 AND awaiting_approval
+ORDER BY id DESC
 `
 
-type GetPipelinesByRepoRow struct {
-	ID         int64
-	Url        pgtype.Text
-	Status     PipelineStatus
-	CommitSha  string
-	StartedAt  pgtype.Timestamp
-	FinishedAt pgtype.Timestamp
+func (q *Queries) GetPipelinesAwaitingApproval(ctx context.Context, repoID int64) ([]Pipeline, error) {
+	rows, err := q.db.Query(ctx, getPipelinesAwaitingApproval, repoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Pipeline
+	for rows.Next() {
+		var i Pipeline
+		if err := rows.Scan(
+			&i.ID,
+			&i.Url,
+			&i.Status,
+			&i.CloneUrl,
+			&i.CommitSha,
+			&i.StartedAt,
+			&i.FinishedAt,
+			&i.RepoID,
+			&i.PrNumber,
+			&i.SourceBranch,
+			&i.TargetBranch,
+			&i.FetchRef,
+			&i.Fork,
+			&i.AwaitingApproval,
+			&i.ApprovedBy,
+			&i.CreatedAt,
+			&i.Event,
+			&i.Ref,
+			&i.Branch,
+			&i.Tag,
+			&i.CommitMessage,
+			&i.AuthorName,
+			&i.AuthorEmail,
+			&i.CommitterName,
+			&i.CommitterEmail,
+			&i.Pusher,
+			&i.CompareUrl,
+			&i.Number,
+			&i.Attempt,
+			&i.OriginalID,
+			&i.Workflow,
+			&i.Inputs,
+			&i.SentAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-func (q *Queries) GetPipelinesByRepo(ctx context.Context, repoID int64) ([]GetPipelinesByRepoRow, error) {
-	rows, err := q.db.Query(ctx, getPipelinesByRepo, repoID)
+const getRepoActivePipelineIDs = `-- name: GetRepoActivePipelineIDs :many
+SELECT id
+FROM "pipeline"
+WHERE repo_id = $1 AND status IN ('queued', 'running')
+ORDER BY id
+FOR UPDATE
+`
+
+func (q *Queries) GetRepoActivePipelineIDs(ctx context.Context, repoID int64) ([]int64, error) {
+	rows, err := q.db.Query(ctx, getRepoActivePipelineIDs, repoID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []GetPipelinesByRepoRow
+	var items []int64
 	for rows.Next() {
-		var i GetPipelinesByRepoRow
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRepoPipelineByNumber = `-- name: GetRepoPipelineByNumber :one
+SELECT id, url, status, clone_url, commit_sha, started_at, finished_at, repo_id, pr_number, source_branch, target_branch, fetch_ref, fork, awaiting_approval, approved_by, created_at, event, ref, branch, tag, commit_message, author_name, author_email, committer_name, committer_email, pusher, compare_url, number, attempt, original_id, workflow, inputs, sent_at
+FROM "pipeline"
+WHERE repo_id = $1 AND number = $2
+`
+
+type GetRepoPipelineByNumberParams struct {
+	RepoID int64
+	Number int64
+}
+
+func (q *Queries) GetRepoPipelineByNumber(ctx context.Context, arg GetRepoPipelineByNumberParams) (Pipeline, error) {
+	row := q.db.QueryRow(ctx, getRepoPipelineByNumber, arg.RepoID, arg.Number)
+	var i Pipeline
+	err := row.Scan(
+		&i.ID,
+		&i.Url,
+		&i.Status,
+		&i.CloneUrl,
+		&i.CommitSha,
+		&i.StartedAt,
+		&i.FinishedAt,
+		&i.RepoID,
+		&i.PrNumber,
+		&i.SourceBranch,
+		&i.TargetBranch,
+		&i.FetchRef,
+		&i.Fork,
+		&i.AwaitingApproval,
+		&i.ApprovedBy,
+		&i.CreatedAt,
+		&i.Event,
+		&i.Ref,
+		&i.Branch,
+		&i.Tag,
+		&i.CommitMessage,
+		&i.AuthorName,
+		&i.AuthorEmail,
+		&i.CommitterName,
+		&i.CommitterEmail,
+		&i.Pusher,
+		&i.CompareUrl,
+		&i.Number,
+		&i.Attempt,
+		&i.OriginalID,
+		&i.Workflow,
+		&i.Inputs,
+		&i.SentAt,
+	)
+	return i, err
+}
+
+const getRepoPipelines = `-- name: GetRepoPipelines :many
+SELECT id, url, status, clone_url, commit_sha, started_at, finished_at, repo_id, pr_number, source_branch, target_branch, fetch_ref, fork, awaiting_approval, approved_by, created_at, event, ref, branch, tag, commit_message, author_name, author_email, committer_name, committer_email, pusher, compare_url, number, attempt, original_id, workflow, inputs, sent_at
+FROM "pipeline"
+WHERE repo_id = $1
+    AND ($2::pipeline_status IS NULL OR status = $2)
+    AND ($3::text IS NULL OR branch = $3)
+    AND ($4::timestamp IS NULL OR created_at >= $4)
+    AND ($5::timestamp IS NULL OR created_at < $5)
+    AND ($6::bigint IS NULL OR id < $6)
+ORDER BY id DESC
+LIMIT $7
+`
+
+type GetRepoPipelinesParams struct {
+	RepoID       int64
+	Status       NullPipelineStatus
+	Branch       pgtype.Text
+	CreatedFrom  pgtype.Timestamp
+	CreatedTo    pgtype.Timestamp
+	BeforeID     pgtype.Int8
+	MaxPipelines int32
+}
+
+func (q *Queries) GetRepoPipelines(ctx context.Context, arg GetRepoPipelinesParams) ([]Pipeline, error) {
+	rows, err := q.db.Query(ctx, getRepoPipelines,
+		arg.RepoID,
+		arg.Status,
+		arg.Branch,
+		arg.CreatedFrom,
+		arg.CreatedTo,
+		arg.BeforeID,
+		arg.MaxPipelines,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Pipeline
+	for rows.Next() {
+		var i Pipeline
 		if err := rows.Scan(
 			&i.ID,
 			&i.Url,
 			&i.Status,
+			&i.CloneUrl,
 			&i.CommitSha,
 			&i.StartedAt,
 			&i.FinishedAt,
+			&i.RepoID,
+			&i.PrNumber,
+			&i.SourceBranch,
+			&i.TargetBranch,
+			&i.FetchRef,
+			&i.Fork,
+			&i.AwaitingApproval,
+			&i.ApprovedBy,
+			&i.CreatedAt,
+			&i.Event,
+			&i.Ref,
+			&i.Branch,
+			&i.Tag,
+			&i.CommitMessage,
+			&i.AuthorName,
+			&i.AuthorEmail,
+			&i.CommitterName,
+			&i.CommitterEmail,
+			&i.Pusher,
+			&i.CompareUrl,
+			&i.Number,
+			&i.Attempt,
+			&i.OriginalID,
+			&i.Workflow,
+			&i.Inputs,
+			&i.SentAt,
 		); err != nil {
 			return nil, err
 		}
@@ -144,37 +681,94 @@ func (q *Queries) GetPipelinesByRepo(ctx context.Context, repoID int64) ([]GetPi
 	return items, nil
 }
 
-const pipelineFinished = `-- name: PipelineFinished :exec
-UPDATE "pipeline"
-SET status = $1, finished_at = $2
-WHERE id = $3
+const lockPipelineAttempts = `-- name: LockPipelineAttempts :many
+SELECT id, attempt, status
+FROM "pipeline"
+WHERE id = $1 OR original_id = $1
+ORDER BY attempt
+FOR UPDATE
 `
 
-type PipelineFinishedParams struct {
-	Status     PipelineStatus
-	FinishedAt pgtype.Timestamp
-	ID         int64
+type LockPipelineAttemptsRow struct {
+	ID      int64
+	Attempt int32
+	Status  PipelineStatus
+}
+
+// All attempts are locked, including the first one, so concurrent re-runs of
+// the pipeline are serialized.
+func (q *Queries) LockPipelineAttempts(ctx context.Context, id int64) ([]LockPipelineAttemptsRow, error) {
+	rows, err := q.db.Query(ctx, lockPipelineAttempts, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []LockPipelineAttemptsRow
+	for rows.Next() {
+		var i LockPipelineAttemptsRow
+		if err := rows.Scan(&i.ID, &i.Attempt, &i.Status); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockPipelineAwaitingApproval = `-- name: LockPipelineAwaitingApproval :one
+SELECT id
+FROM "pipeline"
+WHERE id = $1 AND awaiting_approval AND status = 'queued'
+FOR UPDATE
+`
+
+// Row stays locked until the end of transaction, so the pipeline cannot be
+// approved concurrently.
+func (q *Queries) LockPipelineAwaitingApproval(ctx context.Context, id int64) (int64, error) {
+	row := q.db.QueryRow(ctx, lockPipelineAwaitingApproval, id)
+	err := row.Scan(&id)
+	return id, err
 }
 
-func (q *Queries) PipelineFinished(ctx context.Context, arg PipelineFinishedParams) error {
-	_, err := q.db.Exec(ctx, pipelineFinished, arg.Status, arg.FinishedAt, arg.ID)
+const markPipelineSent = `-- name: MarkPipelineSent :exec
+UPDATE "pipeline"
+SET sent_at = now()
+WHERE id = $1
+`
+
+func (q *Queries) MarkPipelineSent(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, markPipelineSent, id)
 	return err
 }
 
-const pipelineStarted = `-- name: PipelineStarted :exec
+const revertPipelineApproval = `-- name: RevertPipelineApproval :exec
 UPDATE "pipeline"
-SET status = $1, started_at = $2
-WHERE id = $3
+SET awaiting_approval = true, approved_by = NULL
+WHERE id = $1 AND NOT awaiting_approval AND status = 'queued'
+`
+
+func (q *Queries) RevertPipelineApproval(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, revertPipelineApproval, id)
+	return err
+}
+
+const setPipelineCheckRun = `-- name: SetPipelineCheckRun :exec
+INSERT INTO "pipeline_check_run" (pipeline_id, job, check_run_id)
+VALUES ($1, $2, $3)
+ON CONFLICT (pipeline_id, job) DO UPDATE
+SET check_run_id = EXCLUDED.check_run_id
 `
 
-type PipelineStartedParams struct {
-	Status    PipelineStatus
-	StartedAt pgtype.Timestamp
-	ID        int64
+type SetPipelineCheckRunParams struct {
+	PipelineID int64
+	Job        string
+	CheckRunID int64
 }
 
-func (q *Queries) PipelineStarted(ctx context.Context, arg PipelineStartedParams) error {
-	_, err := q.db.Exec(ctx, pipelineStarted, arg.Status, arg.StartedAt, arg.ID)
+func (q *Queries) SetPipelineCheckRun(ctx context.Context, arg SetPipelineCheckRunParams) error {
+	_, err := q.db.Exec(ctx, setPipelineCheckRun, arg.PipelineID, arg.Job, arg.CheckRunID)
 	return err
 }
 
@@ -193,3 +787,79 @@ func (q *Queries) SetPipelineUrl(ctx context.Context, arg SetPipelineUrlParams)
 	_, err := q.db.Exec(ctx, setPipelineUrl, arg.Url, arg.ID)
 	return err
 }
+
+const setPipelineWorkflow = `-- name: SetPipelineWorkflow :execrows
+UPDATE "pipeline"
+SET workflow = $1
+WHERE id = $2 AND workflow IS NULL AND status = 'running'
+`
+
+type SetPipelineWorkflowParams struct {
+	Workflow pgtype.Text
+	ID       int64
+}
+
+func (q *Queries) SetPipelineWorkflow(ctx context.Context, arg SetPipelineWorkflowParams) (int64, error) {
+	result, err := q.db.Exec(ctx, setPipelineWorkflow, arg.Workflow, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const transitionPipeline = `-- name: TransitionPipeline :one
+WITH "current" AS (
+    SELECT cp.id, cp.status
+    FROM "pipeline" cp
+    WHERE cp.id = $1
+    FOR UPDATE
+), "updated" AS (
+    UPDATE "pipeline" p
+    SET status = $2::pipeline_status,
+        started_at = COALESCE($3::timestamp, p.started_at),
+        finished_at = COALESCE($4::timestamp, p.finished_at),
+        awaiting_approval = p.awaiting_approval AND $4::timestamp IS NULL
+    FROM "current" c
+    WHERE p.id = c.id AND c.status::text = ANY($5::text[])
+    RETURNING p.id
+), "event" AS (
+    INSERT INTO "pipeline_event" (pipeline_id, from_status, to_status, actor, message)
+    SELECT u.id, c.status, $2::pipeline_status, $6, $7
+    FROM "updated" u, "current" c
+)
+SELECT c.status, EXISTS(SELECT 1 FROM "updated") AS transitioned
+FROM "current" c
+`
+
+type TransitionPipelineParams struct {
+	PipelineID   int64
+	ToStatus     PipelineStatus
+	StartedAt    pgtype.Timestamp
+	FinishedAt   pgtype.Timestamp
+	FromStatuses []string
+	Actor        string
+	Message      pgtype.Text
+}
+
+type TransitionPipelineRow struct {
+	Status       PipelineStatus
+	Transitioned bool
+}
+
+// Pipeline row is locked so concurrent transitions are serialized, the status
+// is only changed when the current one is in from_statuses. Current status is
+// returned either way.
+func (q *Queries) TransitionPipeline(ctx context.Context, arg TransitionPipelineParams) (TransitionPipelineRow, error) {
+	row := q.db.QueryRow(ctx, transitionPipeline,
+		arg.PipelineID,
+		arg.ToStatus,
+		arg.StartedAt,
+		arg.FinishedAt,
+		arg.FromStatuses,
+		arg.Actor,
+		arg.Message,
+	)
+	var i TransitionPipelineRow
+	err := row.Scan(&i.Status, &i.Transitioned)
+	return i, err
+}
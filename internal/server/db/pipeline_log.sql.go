@@ -7,11 +7,16 @@ package db
 
 import (
 	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 const createPipelineLog = `-- name: CreatePipelineLog :one
-INSERT INTO "pipeline_log" ("order", "cmd", "output", "exit_code", "pipeline_id")
-VALUES ($1, $2, $3, $4, $5)
+INSERT INTO "pipeline_log" ("order", "cmd", "output", "exit_code", "pipeline_id", "started_at", "finished_at")
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT ("order", "pipeline_id") DO UPDATE
+SET "cmd" = EXCLUDED."cmd", "output" = EXCLUDED."output", "exit_code" = EXCLUDED."exit_code",
+    "started_at" = EXCLUDED."started_at", "finished_at" = EXCLUDED."finished_at"
 RETURNING "id"
 `
 
@@ -21,8 +26,11 @@ type CreatePipelineLogParams struct {
 	Output     string
 	ExitCode   int32
 	PipelineID int64
+	StartedAt  pgtype.Timestamp
+	FinishedAt pgtype.Timestamp
 }
 
+// Worker may send the same step again when its call timed out.
 func (q *Queries) CreatePipelineLog(ctx context.Context, arg CreatePipelineLogParams) (int64, error) {
 	row := q.db.QueryRow(ctx, createPipelineLog,
 		arg.Order,
@@ -30,6 +38,8 @@ func (q *Queries) CreatePipelineLog(ctx context.Context, arg CreatePipelineLogPa
 		arg.Output,
 		arg.ExitCode,
 		arg.PipelineID,
+		arg.StartedAt,
+		arg.FinishedAt,
 	)
 	var id int64
 	err := row.Scan(&id)
@@ -37,17 +47,19 @@ func (q *Queries) CreatePipelineLog(ctx context.Context, arg CreatePipelineLogPa
 }
 
 const getPipelineLogs = `-- name: GetPipelineLogs :many
-SELECT "order", "cmd", "output", "exit_code"
+SELECT "order", "cmd", "output", "exit_code", "started_at", "finished_at"
 FROM "pipeline_log"
 WHERE "pipeline_id" = $1
 ORDER BY "order"
 `
 
 type GetPipelineLogsRow struct {
-	Order    int32
-	Cmd      string
-	Output   string
-	ExitCode int32
+	Order      int32
+	Cmd        string
+	Output     string
+	ExitCode   int32
+	StartedAt  pgtype.Timestamp
+	FinishedAt pgtype.Timestamp
 }
 
 func (q *Queries) GetPipelineLogs(ctx context.Context, pipelineID int64) ([]GetPipelineLogsRow, error) {
@@ -64,6 +76,8 @@ func (q *Queries) GetPipelineLogs(ctx context.Context, pipelineID int64) ([]GetP
 			&i.Cmd,
 			&i.Output,
 			&i.ExitCode,
+			&i.StartedAt,
+			&i.FinishedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -7,23 +7,102 @@ package db
 
 import (
 	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const archiveRepo = `-- name: ArchiveRepo :exec
+UPDATE "repo"
+SET archived_at = now(), webhook_id = NULL, installation_id = NULL, webhook_secret = NULL, previous_webhook_secret = NULL,
+    webhook_secret_key_id = NULL, webhook_secret_rotated_at = NULL
+WHERE id = $1
+`
+
+func (q *Queries) ArchiveRepo(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, archiveRepo, id)
+	return err
+}
+
+const claimReposToCheckWebhook = `-- name: ClaimReposToCheckWebhook :many
+UPDATE "repo"
+SET webhook_checked_at = now()
+WHERE id IN (
+    SELECT id
+    FROM "repo"
+    WHERE webhook_id IS NOT NULL AND archived_at IS NULL
+        AND (webhook_checked_at IS NULL OR webhook_checked_at < now() - $1::interval)
+    ORDER BY webhook_checked_at NULLS FIRST
+    LIMIT $2
+    FOR UPDATE SKIP LOCKED
 )
+RETURNING id
+`
+
+type ClaimReposToCheckWebhookParams struct {
+	Interval pgtype.Interval
+	MaxRepos int32
+}
+
+func (q *Queries) ClaimReposToCheckWebhook(ctx context.Context, arg ClaimReposToCheckWebhookParams) ([]int64, error) {
+	rows, err := q.db.Query(ctx, claimReposToCheckWebhook, arg.Interval, arg.MaxRepos)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const clearInstallation = `-- name: ClearInstallation :exec
+UPDATE "repo"
+SET installation_id = NULL
+WHERE service = $1 AND installation_id = $2
+`
+
+type ClearInstallationParams struct {
+	Service        Service
+	InstallationID pgtype.Int8
+}
+
+func (q *Queries) ClearInstallation(ctx context.Context, arg ClearInstallationParams) error {
+	_, err := q.db.Exec(ctx, clearInstallation, arg.Service, arg.InstallationID)
+	return err
+}
 
 const createRepo = `-- name: CreateRepo :one
-INSERT INTO "repo" (service, owner, name, repo_service_id, webhook_id, service_user_id)
-VALUES ($1, $2, $3, $4, $5, $6)
+INSERT INTO "repo" (service, owner, name, repo_service_id, webhook_id, installation_id, service_user_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (service, repo_service_id) DO UPDATE
+SET owner = EXCLUDED.owner, name = EXCLUDED.name, webhook_id = EXCLUDED.webhook_id, installation_id = EXCLUDED.installation_id,
+    service_user_id = EXCLUDED.service_user_id, webhook_secret = NULL, previous_webhook_secret = NULL,
+    webhook_secret_key_id = NULL, webhook_secret_rotated_at = NULL, archived_at = NULL
+WHERE "repo".archived_at IS NOT NULL
 RETURNING id
 `
 
 type CreateRepoParams struct {
-	Service       Service
-	Owner         string
-	Name          string
-	RepoServiceID int64
-	WebhookID     int64
-	ServiceUserID int64
+	Service        Service
+	Owner          string
+	Name           string
+	RepoServiceID  int64
+	WebhookID      pgtype.Int8
+	InstallationID pgtype.Int8
+	ServiceUserID  int64
 }
 
+// Webhook secret is set by SetRepoWebhookSecretCiphertext, it is encrypted with
+// ID of the repository.
+// Archived repository is registered again with its pipeline history.
 func (q *Queries) CreateRepo(ctx context.Context, arg CreateRepoParams) (int64, error) {
 	row := q.db.QueryRow(ctx, createRepo,
 		arg.Service,
@@ -31,6 +110,7 @@ func (q *Queries) CreateRepo(ctx context.Context, arg CreateRepoParams) (int64,
 		arg.Name,
 		arg.RepoServiceID,
 		arg.WebhookID,
+		arg.InstallationID,
 		arg.ServiceUserID,
 	)
 	var id int64
@@ -38,6 +118,36 @@ func (q *Queries) CreateRepo(ctx context.Context, arg CreateRepoParams) (int64,
 	return id, err
 }
 
+const createRepoDeployToken = `-- name: CreateRepoDeployToken :execrows
+INSERT INTO "repo_deploy_token" (repo_id, token_id, username, token, token_key_id, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (repo_id) DO NOTHING
+`
+
+type CreateRepoDeployTokenParams struct {
+	RepoID     int64
+	TokenID    int64
+	Username   string
+	Token      string
+	TokenKeyID string
+	ExpiresAt  pgtype.Timestamp
+}
+
+func (q *Queries) CreateRepoDeployToken(ctx context.Context, arg CreateRepoDeployTokenParams) (int64, error) {
+	result, err := q.db.Exec(ctx, createRepoDeployToken,
+		arg.RepoID,
+		arg.TokenID,
+		arg.Username,
+		arg.Token,
+		arg.TokenKeyID,
+		arg.ExpiresAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const deleteRepo = `-- name: DeleteRepo :exec
 DELETE FROM "repo"
 WHERE id = $1
@@ -48,10 +158,68 @@ func (q *Queries) DeleteRepo(ctx context.Context, id int64) error {
 	return err
 }
 
+const getRegisteredRepoServiceIDs = `-- name: GetRegisteredRepoServiceIDs :many
+SELECT repo_service_id
+FROM "repo"
+WHERE service = $1 AND repo_service_id = ANY($2::bigint[]) AND archived_at IS NULL
+`
+
+type GetRegisteredRepoServiceIDsParams struct {
+	Service        Service
+	RepoServiceIds []int64
+}
+
+func (q *Queries) GetRegisteredRepoServiceIDs(ctx context.Context, arg GetRegisteredRepoServiceIDsParams) ([]int64, error) {
+	rows, err := q.db.Query(ctx, getRegisteredRepoServiceIDs, arg.Service, arg.RepoServiceIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var repo_service_id int64
+		if err := rows.Scan(&repo_service_id); err != nil {
+			return nil, err
+		}
+		items = append(items, repo_service_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRepoDeployToken = `-- name: GetRepoDeployToken :one
+SELECT token_id, username, token, token_key_id, expires_at
+FROM "repo_deploy_token"
+WHERE repo_id = $1
+`
+
+type GetRepoDeployTokenRow struct {
+	TokenID    int64
+	Username   string
+	Token      string
+	TokenKeyID string
+	ExpiresAt  pgtype.Timestamp
+}
+
+func (q *Queries) GetRepoDeployToken(ctx context.Context, repoID int64) (GetRepoDeployTokenRow, error) {
+	row := q.db.QueryRow(ctx, getRepoDeployToken, repoID)
+	var i GetRepoDeployTokenRow
+	err := row.Scan(
+		&i.TokenID,
+		&i.Username,
+		&i.Token,
+		&i.TokenKeyID,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
 const getRepoIDByServiceRepoID = `-- name: GetRepoIDByServiceRepoID :one
 SELECT id
 FROM "repo"
-WHERE service = $1 AND repo_service_id = $2
+WHERE service = $1 AND repo_service_id = $2 AND archived_at IS NULL
 `
 
 type GetRepoIDByServiceRepoIDParams struct {
@@ -66,21 +234,209 @@ func (q *Queries) GetRepoIDByServiceRepoID(ctx context.Context, arg GetRepoIDByS
 	return id, err
 }
 
+const getRepoWebhookChangeInfo = `-- name: GetRepoWebhookChangeInfo :one
+SELECT r.id, r.service, r.owner, r.name, r.repo_service_id, r.webhook_id, r.forge_archived_at, su.id AS service_user_id, su.user_id, su.access_token, su.refresh_token, su.token_type, su.token_expire, su.token_key_id
+FROM "repo" r JOIN "service_user" su ON r.service_user_id = su.id
+WHERE r.id = $1
+`
+
+type GetRepoWebhookChangeInfoRow struct {
+	ID              int64
+	Service         Service
+	Owner           string
+	Name            string
+	RepoServiceID   int64
+	WebhookID       pgtype.Int8
+	ForgeArchivedAt pgtype.Timestamp
+	ServiceUserID   int64
+	UserID          int64
+	AccessToken     string
+	RefreshToken    pgtype.Text
+	TokenType       string
+	TokenExpire     pgtype.Timestamp
+	TokenKeyID      pgtype.Text
+}
+
+func (q *Queries) GetRepoWebhookChangeInfo(ctx context.Context, id int64) (GetRepoWebhookChangeInfoRow, error) {
+	row := q.db.QueryRow(ctx, getRepoWebhookChangeInfo, id)
+	var i GetRepoWebhookChangeInfoRow
+	err := row.Scan(
+		&i.ID,
+		&i.Service,
+		&i.Owner,
+		&i.Name,
+		&i.RepoServiceID,
+		&i.WebhookID,
+		&i.ForgeArchivedAt,
+		&i.ServiceUserID,
+		&i.UserID,
+		&i.AccessToken,
+		&i.RefreshToken,
+		&i.TokenType,
+		&i.TokenExpire,
+		&i.TokenKeyID,
+	)
+	return i, err
+}
+
+const getRepoWebhookHealth = `-- name: GetRepoWebhookHealth :one
+SELECT r.webhook_id, r.webhook_checked_at, r.webhook_check_error, r.webhook_repaired_at, (
+    SELECT max(wd.received_at)
+    FROM "webhook_delivery" wd
+    WHERE wd.repo_id = r.id AND wd.status IN ('processed', 'ignored')
+)::timestamp AS last_delivery_at
+FROM "repo" r
+WHERE r.id = $1
+`
+
+type GetRepoWebhookHealthRow struct {
+	WebhookID         pgtype.Int8
+	WebhookCheckedAt  pgtype.Timestamp
+	WebhookCheckError pgtype.Text
+	WebhookRepairedAt pgtype.Timestamp
+	LastDeliveryAt    pgtype.Timestamp
+}
+
+func (q *Queries) GetRepoWebhookHealth(ctx context.Context, id int64) (GetRepoWebhookHealthRow, error) {
+	row := q.db.QueryRow(ctx, getRepoWebhookHealth, id)
+	var i GetRepoWebhookHealthRow
+	err := row.Scan(
+		&i.WebhookID,
+		&i.WebhookCheckedAt,
+		&i.WebhookCheckError,
+		&i.WebhookRepairedAt,
+		&i.LastDeliveryAt,
+	)
+	return i, err
+}
+
+const getRepoWebhookSecretForUpdate = `-- name: GetRepoWebhookSecretForUpdate :one
+SELECT webhook_secret, webhook_secret_key_id
+FROM "repo"
+WHERE id = $1
+FOR UPDATE
+`
+
+type GetRepoWebhookSecretForUpdateRow struct {
+	WebhookSecret      pgtype.Text
+	WebhookSecretKeyID pgtype.Text
+}
+
+func (q *Queries) GetRepoWebhookSecretForUpdate(ctx context.Context, id int64) (GetRepoWebhookSecretForUpdateRow, error) {
+	row := q.db.QueryRow(ctx, getRepoWebhookSecretForUpdate, id)
+	var i GetRepoWebhookSecretForUpdateRow
+	err := row.Scan(&i.WebhookSecret, &i.WebhookSecretKeyID)
+	return i, err
+}
+
+const getRepoWebhookSecrets = `-- name: GetRepoWebhookSecrets :one
+SELECT id, webhook_secret, previous_webhook_secret, webhook_secret_key_id, webhook_secret_rotated_at
+FROM "repo"
+WHERE service = $1 AND repo_service_id = $2 AND archived_at IS NULL
+`
+
+type GetRepoWebhookSecretsParams struct {
+	Service       Service
+	RepoServiceID int64
+}
+
+type GetRepoWebhookSecretsRow struct {
+	ID                     int64
+	WebhookSecret          pgtype.Text
+	PreviousWebhookSecret  pgtype.Text
+	WebhookSecretKeyID     pgtype.Text
+	WebhookSecretRotatedAt pgtype.Timestamp
+}
+
+func (q *Queries) GetRepoWebhookSecrets(ctx context.Context, arg GetRepoWebhookSecretsParams) (GetRepoWebhookSecretsRow, error) {
+	row := q.db.QueryRow(ctx, getRepoWebhookSecrets, arg.Service, arg.RepoServiceID)
+	var i GetRepoWebhookSecretsRow
+	err := row.Scan(
+		&i.ID,
+		&i.WebhookSecret,
+		&i.PreviousWebhookSecret,
+		&i.WebhookSecretKeyID,
+		&i.WebhookSecretRotatedAt,
+	)
+	return i, err
+}
+
+const getRepoWebhookSecretsToEncrypt = `-- name: GetRepoWebhookSecretsToEncrypt :many
+SELECT id, webhook_secret, previous_webhook_secret, webhook_secret_key_id
+FROM "repo"
+WHERE webhook_secret IS NOT NULL
+    AND (webhook_secret_key_id IS DISTINCT FROM $1::text OR webhook_secret NOT LIKE 'v2:%')
+ORDER BY id
+LIMIT $2
+FOR UPDATE SKIP LOCKED
+`
+
+type GetRepoWebhookSecretsToEncryptParams struct {
+	KeyID     string
+	BatchSize int32
+}
+
+type GetRepoWebhookSecretsToEncryptRow struct {
+	ID                    int64
+	WebhookSecret         pgtype.Text
+	PreviousWebhookSecret pgtype.Text
+	WebhookSecretKeyID    pgtype.Text
+}
+
+// Secrets without v2: prefix were encrypted before they were bound to their row.
+func (q *Queries) GetRepoWebhookSecretsToEncrypt(ctx context.Context, arg GetRepoWebhookSecretsToEncryptParams) ([]GetRepoWebhookSecretsToEncryptRow, error) {
+	rows, err := q.db.Query(ctx, getRepoWebhookSecretsToEncrypt, arg.KeyID, arg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRepoWebhookSecretsToEncryptRow
+	for rows.Next() {
+		var i GetRepoWebhookSecretsToEncryptRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.WebhookSecret,
+			&i.PreviousWebhookSecret,
+			&i.WebhookSecretKeyID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getUserRepos = `-- name: GetUserRepos :many
-SELECT r.id, r.service, r.owner, r.name, r.repo_service_id, r.webhook_id, r.service_user_id
+SELECT r.id, r.service, r.owner, r.name, r.repo_service_id, r.webhook_id, r.installation_id, r.service_user_id, r.archived_at
 FROM "repo" r JOIN "service_user" su ON r.service_user_id = su.id
 WHERE su.user_id = $1
+ORDER BY r.archived_at NULLS FIRST, r.id
 `
 
-func (q *Queries) GetUserRepos(ctx context.Context, userID int64) ([]Repo, error) {
+type GetUserReposRow struct {
+	ID             int64
+	Service        Service
+	Owner          string
+	Name           string
+	RepoServiceID  int64
+	WebhookID      pgtype.Int8
+	InstallationID pgtype.Int8
+	ServiceUserID  int64
+	ArchivedAt     pgtype.Timestamp
+}
+
+func (q *Queries) GetUserRepos(ctx context.Context, userID int64) ([]GetUserReposRow, error) {
 	rows, err := q.db.Query(ctx, getUserRepos, userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Repo
+	var items []GetUserReposRow
 	for rows.Next() {
-		var i Repo
+		var i GetUserReposRow
 		if err := rows.Scan(
 			&i.ID,
 			&i.Service,
@@ -88,7 +444,9 @@ func (q *Queries) GetUserRepos(ctx context.Context, userID int64) ([]Repo, error
 			&i.Name,
 			&i.RepoServiceID,
 			&i.WebhookID,
+			&i.InstallationID,
 			&i.ServiceUserID,
+			&i.ArchivedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -100,6 +458,195 @@ func (q *Queries) GetUserRepos(ctx context.Context, userID int64) ([]Repo, error
 	return items, nil
 }
 
+const lockRepoDeployToken = `-- name: LockRepoDeployToken :one
+SELECT token_id, previous_token_id
+FROM "repo_deploy_token"
+WHERE repo_id = $1
+FOR UPDATE
+`
+
+type LockRepoDeployTokenRow struct {
+	TokenID         int64
+	PreviousTokenID pgtype.Int8
+}
+
+// Row stays locked until the end of transaction, so the token cannot be
+// replaced concurrently.
+func (q *Queries) LockRepoDeployToken(ctx context.Context, repoID int64) (LockRepoDeployTokenRow, error) {
+	row := q.db.QueryRow(ctx, lockRepoDeployToken, repoID)
+	var i LockRepoDeployTokenRow
+	err := row.Scan(&i.TokenID, &i.PreviousTokenID)
+	return i, err
+}
+
+const replaceRepoDeployToken = `-- name: ReplaceRepoDeployToken :exec
+UPDATE "repo_deploy_token"
+SET token_id = $2, username = $3, token = $4, token_key_id = $5, expires_at = $6, previous_token_id = token_id
+WHERE repo_id = $1
+`
+
+type ReplaceRepoDeployTokenParams struct {
+	RepoID     int64
+	TokenID    int64
+	Username   string
+	Token      string
+	TokenKeyID string
+	ExpiresAt  pgtype.Timestamp
+}
+
+func (q *Queries) ReplaceRepoDeployToken(ctx context.Context, arg ReplaceRepoDeployTokenParams) error {
+	_, err := q.db.Exec(ctx, replaceRepoDeployToken,
+		arg.RepoID,
+		arg.TokenID,
+		arg.Username,
+		arg.Token,
+		arg.TokenKeyID,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const revertRepoWebhookSecret = `-- name: RevertRepoWebhookSecret :exec
+UPDATE "repo"
+SET webhook_secret = previous_webhook_secret, previous_webhook_secret = NULL, webhook_secret_rotated_at = NULL
+WHERE id = $1
+`
+
+func (q *Queries) RevertRepoWebhookSecret(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, revertRepoWebhookSecret, id)
+	return err
+}
+
+const rotateRepoWebhookSecret = `-- name: RotateRepoWebhookSecret :exec
+UPDATE "repo"
+SET webhook_secret = $2, previous_webhook_secret = $3, webhook_secret_key_id = $4, webhook_secret_rotated_at = now()
+WHERE id = $1
+`
+
+type RotateRepoWebhookSecretParams struct {
+	ID                    int64
+	WebhookSecret         pgtype.Text
+	PreviousWebhookSecret pgtype.Text
+	WebhookSecretKeyID    pgtype.Text
+}
+
+func (q *Queries) RotateRepoWebhookSecret(ctx context.Context, arg RotateRepoWebhookSecretParams) error {
+	_, err := q.db.Exec(ctx, rotateRepoWebhookSecret,
+		arg.ID,
+		arg.WebhookSecret,
+		arg.PreviousWebhookSecret,
+		arg.WebhookSecretKeyID,
+	)
+	return err
+}
+
+const setRepoForgeArchived = `-- name: SetRepoForgeArchived :exec
+UPDATE "repo"
+SET forge_archived_at = CASE WHEN $1::boolean THEN coalesce(forge_archived_at, now()) END
+WHERE id = $2
+`
+
+type SetRepoForgeArchivedParams struct {
+	Archived bool
+	ID       int64
+}
+
+func (q *Queries) SetRepoForgeArchived(ctx context.Context, arg SetRepoForgeArchivedParams) error {
+	_, err := q.db.Exec(ctx, setRepoForgeArchived, arg.Archived, arg.ID)
+	return err
+}
+
+const setRepoInstallation = `-- name: SetRepoInstallation :exec
+UPDATE "repo"
+SET installation_id = $1
+WHERE service = $2 AND repo_service_id = $3
+`
+
+type SetRepoInstallationParams struct {
+	InstallationID pgtype.Int8
+	Service        Service
+	RepoServiceID  int64
+}
+
+func (q *Queries) SetRepoInstallation(ctx context.Context, arg SetRepoInstallationParams) error {
+	_, err := q.db.Exec(ctx, setRepoInstallation, arg.InstallationID, arg.Service, arg.RepoServiceID)
+	return err
+}
+
+const setRepoWebhookCheck = `-- name: SetRepoWebhookCheck :exec
+UPDATE "repo"
+SET webhook_checked_at = now(), webhook_check_error = $1,
+    webhook_repaired_at = CASE WHEN $2::boolean THEN now() ELSE webhook_repaired_at END
+WHERE id = $3
+`
+
+type SetRepoWebhookCheckParams struct {
+	CheckError pgtype.Text
+	Repaired   bool
+	ID         int64
+}
+
+func (q *Queries) SetRepoWebhookCheck(ctx context.Context, arg SetRepoWebhookCheckParams) error {
+	_, err := q.db.Exec(ctx, setRepoWebhookCheck, arg.CheckError, arg.Repaired, arg.ID)
+	return err
+}
+
+const setRepoWebhookID = `-- name: SetRepoWebhookID :exec
+UPDATE "repo"
+SET webhook_id = $2
+WHERE id = $1
+`
+
+type SetRepoWebhookIDParams struct {
+	ID        int64
+	WebhookID pgtype.Int8
+}
+
+func (q *Queries) SetRepoWebhookID(ctx context.Context, arg SetRepoWebhookIDParams) error {
+	_, err := q.db.Exec(ctx, setRepoWebhookID, arg.ID, arg.WebhookID)
+	return err
+}
+
+const setRepoWebhookSecretCiphertext = `-- name: SetRepoWebhookSecretCiphertext :exec
+UPDATE "repo"
+SET webhook_secret = $2, previous_webhook_secret = $3, webhook_secret_key_id = $4
+WHERE id = $1
+`
+
+type SetRepoWebhookSecretCiphertextParams struct {
+	ID                    int64
+	WebhookSecret         pgtype.Text
+	PreviousWebhookSecret pgtype.Text
+	WebhookSecretKeyID    pgtype.Text
+}
+
+func (q *Queries) SetRepoWebhookSecretCiphertext(ctx context.Context, arg SetRepoWebhookSecretCiphertextParams) error {
+	_, err := q.db.Exec(ctx, setRepoWebhookSecretCiphertext,
+		arg.ID,
+		arg.WebhookSecret,
+		arg.PreviousWebhookSecret,
+		arg.WebhookSecretKeyID,
+	)
+	return err
+}
+
+const updateRepoName = `-- name: UpdateRepoName :exec
+UPDATE "repo"
+SET owner = $2, name = $3
+WHERE id = $1
+`
+
+type UpdateRepoNameParams struct {
+	ID    int64
+	Owner string
+	Name  string
+}
+
+func (q *Queries) UpdateRepoName(ctx context.Context, arg UpdateRepoNameParams) error {
+	_, err := q.db.Exec(ctx, updateRepoName, arg.ID, arg.Owner, arg.Name)
+	return err
+}
+
 const userOwnRepo = `-- name: UserOwnRepo :one
 SELECT EXISTS(
     SELECT r.id
@@ -0,0 +1,336 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: schedule.sql
+
+package db
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const createSchedule = `-- name: CreateSchedule :one
+INSERT INTO "schedule" (repo_id, branch, cron, timezone, from_workflow, next_run_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (repo_id, branch, cron, timezone) DO NOTHING
+RETURNING id
+`
+
+type CreateScheduleParams struct {
+	RepoID       int64
+	Branch       string
+	Cron         string
+	Timezone     string
+	FromWorkflow bool
+	NextRunAt    pgtype.Timestamp
+}
+
+func (q *Queries) CreateSchedule(ctx context.Context, arg CreateScheduleParams) (int64, error) {
+	row := q.db.QueryRow(ctx, createSchedule,
+		arg.RepoID,
+		arg.Branch,
+		arg.Cron,
+		arg.Timezone,
+		arg.FromWorkflow,
+		arg.NextRunAt,
+	)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createScheduleRun = `-- name: CreateScheduleRun :one
+INSERT INTO "schedule_run" (schedule_id, run_at, pipeline_id)
+VALUES ($1, $2, $3)
+ON CONFLICT (schedule_id, run_at) DO NOTHING
+RETURNING pipeline_id
+`
+
+type CreateScheduleRunParams struct {
+	ScheduleID int64
+	RunAt      pgtype.Timestamp
+	PipelineID int64
+}
+
+func (q *Queries) CreateScheduleRun(ctx context.Context, arg CreateScheduleRunParams) (int64, error) {
+	row := q.db.QueryRow(ctx, createScheduleRun, arg.ScheduleID, arg.RunAt, arg.PipelineID)
+	var pipeline_id int64
+	err := row.Scan(&pipeline_id)
+	return pipeline_id, err
+}
+
+const deleteSchedule = `-- name: DeleteSchedule :execrows
+DELETE FROM "schedule"
+WHERE id = $1 AND repo_id = $2
+`
+
+type DeleteScheduleParams struct {
+	ID     int64
+	RepoID int64
+}
+
+func (q *Queries) DeleteSchedule(ctx context.Context, arg DeleteScheduleParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteSchedule, arg.ID, arg.RepoID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteWorkflowSchedules = `-- name: DeleteWorkflowSchedules :exec
+DELETE FROM "schedule"
+WHERE repo_id = $1 AND branch = $2 AND from_workflow
+    AND NOT (cron || ' ' || timezone = ANY ($3::text[]))
+`
+
+type DeleteWorkflowSchedulesParams struct {
+	RepoID int64
+	Branch string
+	Keep   []string
+}
+
+func (q *Queries) DeleteWorkflowSchedules(ctx context.Context, arg DeleteWorkflowSchedulesParams) error {
+	_, err := q.db.Exec(ctx, deleteWorkflowSchedules, arg.RepoID, arg.Branch, arg.Keep)
+	return err
+}
+
+const getDueSchedules = `-- name: GetDueSchedules :many
+SELECT s.id, s.repo_id, s.branch, s.cron, s.timezone, s.from_workflow, s.next_run_at, s.last_run_at, s.last_pipeline_id, s.last_error, s.created_at, s.claimed_at
+FROM "schedule" s JOIN "repo" r ON s.repo_id = r.id
+WHERE ((s.claimed_at IS NULL AND s.next_run_at <= $1::timestamp)
+        OR s.claimed_at <= $2::timestamp)
+    AND r.archived_at IS NULL AND r.forge_archived_at IS NULL
+ORDER BY s.next_run_at
+LIMIT $3
+`
+
+type GetDueSchedulesParams struct {
+	Now          pgtype.Timestamp
+	StaleBefore  pgtype.Timestamp
+	MaxSchedules int32
+}
+
+// Schedules are due when their next run is due and no run is claimed, or
+// when their claimed run was not finished in time.
+func (q *Queries) GetDueSchedules(ctx context.Context, arg GetDueSchedulesParams) ([]Schedule, error) {
+	rows, err := q.db.Query(ctx, getDueSchedules, arg.Now, arg.StaleBefore, arg.MaxSchedules)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Schedule
+	for rows.Next() {
+		var i Schedule
+		if err := rows.Scan(
+			&i.ID,
+			&i.RepoID,
+			&i.Branch,
+			&i.Cron,
+			&i.Timezone,
+			&i.FromWorkflow,
+			&i.NextRunAt,
+			&i.LastRunAt,
+			&i.LastPipelineID,
+			&i.LastError,
+			&i.CreatedAt,
+			&i.ClaimedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRepoSchedules = `-- name: GetRepoSchedules :many
+SELECT s.id, s.repo_id, s.branch, s.cron, s.timezone, s.from_workflow, s.next_run_at, s.last_run_at, s.last_pipeline_id, s.last_error, s.created_at, s.claimed_at, p.number AS last_pipeline_number
+FROM "schedule" s LEFT JOIN "pipeline" p ON s.last_pipeline_id = p.id
+WHERE s.repo_id = $1
+ORDER BY s.branch, s.id
+`
+
+type GetRepoSchedulesRow struct {
+	Schedule           Schedule
+	LastPipelineNumber pgtype.Int8
+}
+
+func (q *Queries) GetRepoSchedules(ctx context.Context, repoID int64) ([]GetRepoSchedulesRow, error) {
+	rows, err := q.db.Query(ctx, getRepoSchedules, repoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRepoSchedulesRow
+	for rows.Next() {
+		var i GetRepoSchedulesRow
+		if err := rows.Scan(
+			&i.Schedule.ID,
+			&i.Schedule.RepoID,
+			&i.Schedule.Branch,
+			&i.Schedule.Cron,
+			&i.Schedule.Timezone,
+			&i.Schedule.FromWorkflow,
+			&i.Schedule.NextRunAt,
+			&i.Schedule.LastRunAt,
+			&i.Schedule.LastPipelineID,
+			&i.Schedule.LastError,
+			&i.Schedule.CreatedAt,
+			&i.Schedule.ClaimedAt,
+			&i.LastPipelineNumber,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getScheduleRunPipeline = `-- name: GetScheduleRunPipeline :one
+SELECT p.id, p.url, p.status, p.clone_url, p.commit_sha, p.started_at, p.finished_at, p.repo_id, p.pr_number, p.source_branch, p.target_branch, p.fetch_ref, p.fork, p.awaiting_approval, p.approved_by, p.created_at, p.event, p.ref, p.branch, p.tag, p.commit_message, p.author_name, p.author_email, p.committer_name, p.committer_email, p.pusher, p.compare_url, p.number, p.attempt, p.original_id, p.workflow, p.inputs, p.sent_at
+FROM "schedule_run" sr JOIN "pipeline" p ON sr.pipeline_id = p.id
+WHERE sr.schedule_id = $1 AND sr.run_at = $2
+`
+
+type GetScheduleRunPipelineParams struct {
+	ScheduleID int64
+	RunAt      pgtype.Timestamp
+}
+
+type GetScheduleRunPipelineRow struct {
+	Pipeline Pipeline
+}
+
+func (q *Queries) GetScheduleRunPipeline(ctx context.Context, arg GetScheduleRunPipelineParams) (GetScheduleRunPipelineRow, error) {
+	row := q.db.QueryRow(ctx, getScheduleRunPipeline, arg.ScheduleID, arg.RunAt)
+	var i GetScheduleRunPipelineRow
+	err := row.Scan(
+		&i.Pipeline.ID,
+		&i.Pipeline.Url,
+		&i.Pipeline.Status,
+		&i.Pipeline.CloneUrl,
+		&i.Pipeline.CommitSha,
+		&i.Pipeline.StartedAt,
+		&i.Pipeline.FinishedAt,
+		&i.Pipeline.RepoID,
+		&i.Pipeline.PrNumber,
+		&i.Pipeline.SourceBranch,
+		&i.Pipeline.TargetBranch,
+		&i.Pipeline.FetchRef,
+		&i.Pipeline.Fork,
+		&i.Pipeline.AwaitingApproval,
+		&i.Pipeline.ApprovedBy,
+		&i.Pipeline.CreatedAt,
+		&i.Pipeline.Event,
+		&i.Pipeline.Ref,
+		&i.Pipeline.Branch,
+		&i.Pipeline.Tag,
+		&i.Pipeline.CommitMessage,
+		&i.Pipeline.AuthorName,
+		&i.Pipeline.AuthorEmail,
+		&i.Pipeline.CommitterName,
+		&i.Pipeline.CommitterEmail,
+		&i.Pipeline.Pusher,
+		&i.Pipeline.CompareUrl,
+		&i.Pipeline.Number,
+		&i.Pipeline.Attempt,
+		&i.Pipeline.OriginalID,
+		&i.Pipeline.Workflow,
+		&i.Pipeline.Inputs,
+		&i.Pipeline.SentAt,
+	)
+	return i, err
+}
+
+const markWorkflowSchedulesSynced = `-- name: MarkWorkflowSchedulesSynced :execrows
+INSERT INTO "workflow_schedule_sync" (repo_id, branch, synced_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (repo_id, branch) DO UPDATE
+SET synced_at = EXCLUDED.synced_at
+WHERE "workflow_schedule_sync".synced_at < EXCLUDED.synced_at
+`
+
+type MarkWorkflowSchedulesSyncedParams struct {
+	RepoID   int64
+	Branch   string
+	SyncedAt pgtype.Timestamp
+}
+
+// Row is locked until the end of transaction, so syncs of the branch are
+// serialized. No row is changed when newer workflow was already synced.
+func (q *Queries) MarkWorkflowSchedulesSynced(ctx context.Context, arg MarkWorkflowSchedulesSyncedParams) (int64, error) {
+	result, err := q.db.Exec(ctx, markWorkflowSchedulesSynced, arg.RepoID, arg.Branch, arg.SyncedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const reclaimScheduleRun = `-- name: ReclaimScheduleRun :exec
+UPDATE "schedule"
+SET claimed_at = $2
+WHERE id = $1
+`
+
+type ReclaimScheduleRunParams struct {
+	ID        int64
+	ClaimedAt pgtype.Timestamp
+}
+
+func (q *Queries) ReclaimScheduleRun(ctx context.Context, arg ReclaimScheduleRunParams) error {
+	_, err := q.db.Exec(ctx, reclaimScheduleRun, arg.ID, arg.ClaimedAt)
+	return err
+}
+
+const setScheduleNextRun = `-- name: SetScheduleNextRun :exec
+UPDATE "schedule"
+SET last_run_at = next_run_at, next_run_at = $2, last_pipeline_id = NULL, last_error = NULL,
+    claimed_at = $3
+WHERE id = $1
+`
+
+type SetScheduleNextRunParams struct {
+	ID        int64
+	NextRunAt pgtype.Timestamp
+	ClaimedAt pgtype.Timestamp
+}
+
+func (q *Queries) SetScheduleNextRun(ctx context.Context, arg SetScheduleNextRunParams) error {
+	_, err := q.db.Exec(ctx, setScheduleNextRun, arg.ID, arg.NextRunAt, arg.ClaimedAt)
+	return err
+}
+
+const setScheduleResult = `-- name: SetScheduleResult :exec
+UPDATE "schedule"
+SET last_pipeline_id = $2, last_error = $3, claimed_at = NULL
+WHERE id = $1
+`
+
+type SetScheduleResultParams struct {
+	ID             int64
+	LastPipelineID pgtype.Int8
+	LastError      pgtype.Text
+}
+
+func (q *Queries) SetScheduleResult(ctx context.Context, arg SetScheduleResultParams) error {
+	_, err := q.db.Exec(ctx, setScheduleResult, arg.ID, arg.LastPipelineID, arg.LastError)
+	return err
+}
+
+const tryLockScheduler = `-- name: TryLockScheduler :one
+SELECT pg_try_advisory_xact_lock($1::bigint)
+`
+
+func (q *Queries) TryLockScheduler(ctx context.Context, key int64) (bool, error) {
+	row := q.db.QueryRow(ctx, tryLockScheduler, key)
+	var pg_try_advisory_xact_lock bool
+	err := row.Scan(&pg_try_advisory_xact_lock)
+	return pg_try_advisory_xact_lock, err
+}
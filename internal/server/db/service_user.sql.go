@@ -11,13 +11,13 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
-const createServiceUser = `-- name: CreateServiceUser :one
-INSERT INTO "service_user" (service, username, email, access_token, refresh_token, token_type, token_expire, user_id)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-RETURNING id
+const createServiceUser = `-- name: CreateServiceUser :exec
+INSERT INTO "service_user" (id, service, username, email, access_token, refresh_token, token_type, token_expire, user_id, token_key_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 `
 
 type CreateServiceUserParams struct {
+	ID           int64
 	Service      Service
 	Username     string
 	Email        string
@@ -26,10 +26,12 @@ type CreateServiceUserParams struct {
 	TokenType    string
 	TokenExpire  pgtype.Timestamp
 	UserID       int64
+	TokenKeyID   pgtype.Text
 }
 
-func (q *Queries) CreateServiceUser(ctx context.Context, arg CreateServiceUserParams) (int64, error) {
-	row := q.db.QueryRow(ctx, createServiceUser,
+func (q *Queries) CreateServiceUser(ctx context.Context, arg CreateServiceUserParams) error {
+	_, err := q.db.Exec(ctx, createServiceUser,
+		arg.ID,
 		arg.Service,
 		arg.Username,
 		arg.Email,
@@ -38,14 +40,40 @@ func (q *Queries) CreateServiceUser(ctx context.Context, arg CreateServiceUserPa
 		arg.TokenType,
 		arg.TokenExpire,
 		arg.UserID,
+		arg.TokenKeyID,
 	)
-	var id int64
-	err := row.Scan(&id)
-	return id, err
+	return err
+}
+
+const getReloginRequiredServices = `-- name: GetReloginRequiredServices :many
+SELECT service
+FROM "service_user"
+WHERE user_id = $1 AND relogin_required
+ORDER BY service
+`
+
+func (q *Queries) GetReloginRequiredServices(ctx context.Context, userID int64) ([]Service, error) {
+	rows, err := q.db.Query(ctx, getReloginRequiredServices, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Service
+	for rows.Next() {
+		var service Service
+		if err := rows.Scan(&service); err != nil {
+			return nil, err
+		}
+		items = append(items, service)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
 const getServiceUserByUserID = `-- name: GetServiceUserByUserID :one
-SELECT id, username, username, email, access_token, refresh_token, token_type, token_expire
+SELECT id, username, email, access_token, refresh_token, token_type, token_expire, relogin_required, token_key_id
 FROM "service_user"
 WHERE user_id = $1 AND service = $2
 `
@@ -56,14 +84,15 @@ type GetServiceUserByUserIDParams struct {
 }
 
 type GetServiceUserByUserIDRow struct {
-	ID           int64
-	Username     string
-	Username_2   string
-	Email        string
-	AccessToken  string
-	RefreshToken pgtype.Text
-	TokenType    string
-	TokenExpire  pgtype.Timestamp
+	ID              int64
+	Username        string
+	Email           string
+	AccessToken     string
+	RefreshToken    pgtype.Text
+	TokenType       string
+	TokenExpire     pgtype.Timestamp
+	ReloginRequired bool
+	TokenKeyID      pgtype.Text
 }
 
 func (q *Queries) GetServiceUserByUserID(ctx context.Context, arg GetServiceUserByUserIDParams) (GetServiceUserByUserIDRow, error) {
@@ -72,16 +101,92 @@ func (q *Queries) GetServiceUserByUserID(ctx context.Context, arg GetServiceUser
 	err := row.Scan(
 		&i.ID,
 		&i.Username,
-		&i.Username_2,
 		&i.Email,
 		&i.AccessToken,
 		&i.RefreshToken,
 		&i.TokenType,
 		&i.TokenExpire,
+		&i.ReloginRequired,
+		&i.TokenKeyID,
+	)
+	return i, err
+}
+
+const getServiceUserTokenForUpdate = `-- name: GetServiceUserTokenForUpdate :one
+SELECT access_token, refresh_token, token_type, token_expire, token_key_id
+FROM "service_user"
+WHERE id = $1
+FOR UPDATE
+`
+
+type GetServiceUserTokenForUpdateRow struct {
+	AccessToken  string
+	RefreshToken pgtype.Text
+	TokenType    string
+	TokenExpire  pgtype.Timestamp
+	TokenKeyID   pgtype.Text
+}
+
+func (q *Queries) GetServiceUserTokenForUpdate(ctx context.Context, id int64) (GetServiceUserTokenForUpdateRow, error) {
+	row := q.db.QueryRow(ctx, getServiceUserTokenForUpdate, id)
+	var i GetServiceUserTokenForUpdateRow
+	err := row.Scan(
+		&i.AccessToken,
+		&i.RefreshToken,
+		&i.TokenType,
+		&i.TokenExpire,
+		&i.TokenKeyID,
 	)
 	return i, err
 }
 
+const getServiceUserTokensToEncrypt = `-- name: GetServiceUserTokensToEncrypt :many
+SELECT id, access_token, refresh_token, token_key_id
+FROM "service_user"
+WHERE token_key_id IS DISTINCT FROM $1::text OR access_token NOT LIKE 'v2:%'
+ORDER BY id
+LIMIT $2
+FOR UPDATE SKIP LOCKED
+`
+
+type GetServiceUserTokensToEncryptParams struct {
+	KeyID     string
+	BatchSize int32
+}
+
+type GetServiceUserTokensToEncryptRow struct {
+	ID           int64
+	AccessToken  string
+	RefreshToken pgtype.Text
+	TokenKeyID   pgtype.Text
+}
+
+// Tokens without v2: prefix were encrypted before they were bound to their row.
+func (q *Queries) GetServiceUserTokensToEncrypt(ctx context.Context, arg GetServiceUserTokensToEncryptParams) ([]GetServiceUserTokensToEncryptRow, error) {
+	rows, err := q.db.Query(ctx, getServiceUserTokensToEncrypt, arg.KeyID, arg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetServiceUserTokensToEncryptRow
+	for rows.Next() {
+		var i GetServiceUserTokensToEncryptRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.AccessToken,
+			&i.RefreshToken,
+			&i.TokenKeyID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getUserIDByServiceUser = `-- name: GetUserIDByServiceUser :one
 SELECT user_id
 FROM "service_user"
@@ -99,3 +204,77 @@ func (q *Queries) GetUserIDByServiceUser(ctx context.Context, arg GetUserIDBySer
 	err := row.Scan(&user_id)
 	return user_id, err
 }
+
+const nextServiceUserID = `-- name: NextServiceUserID :one
+SELECT nextval(pg_get_serial_sequence('service_user', 'id'))::bigint
+`
+
+// ID is taken before the service user is created, so its tokens can be
+// encrypted with it.
+func (q *Queries) NextServiceUserID(ctx context.Context) (int64, error) {
+	row := q.db.QueryRow(ctx, nextServiceUserID)
+	var column_1 int64
+	err := row.Scan(&column_1)
+	return column_1, err
+}
+
+const setServiceUserReloginRequired = `-- name: SetServiceUserReloginRequired :exec
+UPDATE "service_user"
+SET relogin_required = true
+WHERE id = $1
+`
+
+func (q *Queries) SetServiceUserReloginRequired(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, setServiceUserReloginRequired, id)
+	return err
+}
+
+const setServiceUserTokenCiphertext = `-- name: SetServiceUserTokenCiphertext :exec
+UPDATE "service_user"
+SET access_token = $2, refresh_token = $3, token_key_id = $4
+WHERE id = $1
+`
+
+type SetServiceUserTokenCiphertextParams struct {
+	ID           int64
+	AccessToken  string
+	RefreshToken pgtype.Text
+	TokenKeyID   pgtype.Text
+}
+
+func (q *Queries) SetServiceUserTokenCiphertext(ctx context.Context, arg SetServiceUserTokenCiphertextParams) error {
+	_, err := q.db.Exec(ctx, setServiceUserTokenCiphertext,
+		arg.ID,
+		arg.AccessToken,
+		arg.RefreshToken,
+		arg.TokenKeyID,
+	)
+	return err
+}
+
+const updateServiceUserToken = `-- name: UpdateServiceUserToken :exec
+UPDATE "service_user"
+SET access_token = $2, refresh_token = $3, token_type = $4, token_expire = $5, token_key_id = $6, relogin_required = false
+WHERE id = $1
+`
+
+type UpdateServiceUserTokenParams struct {
+	ID           int64
+	AccessToken  string
+	RefreshToken pgtype.Text
+	TokenType    string
+	TokenExpire  pgtype.Timestamp
+	TokenKeyID   pgtype.Text
+}
+
+func (q *Queries) UpdateServiceUserToken(ctx context.Context, arg UpdateServiceUserTokenParams) error {
+	_, err := q.db.Exec(ctx, updateServiceUserToken,
+		arg.ID,
+		arg.AccessToken,
+		arg.RefreshToken,
+		arg.TokenType,
+		arg.TokenExpire,
+		arg.TokenKeyID,
+	)
+	return err
+}
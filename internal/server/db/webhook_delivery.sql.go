@@ -0,0 +1,317 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: webhook_delivery.sql
+
+package db
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const claimWebhookDeliveries = `-- name: ClaimWebhookDeliveries :many
+UPDATE "webhook_delivery"
+SET attempts = attempts + 1, next_attempt_at = now() + $1::interval
+WHERE id IN (
+    SELECT wd.id
+    FROM "webhook_delivery" wd
+    WHERE wd.status = 'pending' AND wd.next_attempt_at <= now()
+    ORDER BY wd.received_at
+    LIMIT $2
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, service, delivery_id, event, headers, payload, status, error, received_at, processed_at, repo_id, pipeline_id, attempts, next_attempt_at
+`
+
+type ClaimWebhookDeliveriesParams struct {
+	Lease         pgtype.Interval
+	MaxDeliveries int32
+}
+
+func (q *Queries) ClaimWebhookDeliveries(ctx context.Context, arg ClaimWebhookDeliveriesParams) ([]WebhookDelivery, error) {
+	rows, err := q.db.Query(ctx, claimWebhookDeliveries, arg.Lease, arg.MaxDeliveries)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebhookDelivery
+	for rows.Next() {
+		var i WebhookDelivery
+		if err := rows.Scan(
+			&i.ID,
+			&i.Service,
+			&i.DeliveryID,
+			&i.Event,
+			&i.Headers,
+			&i.Payload,
+			&i.Status,
+			&i.Error,
+			&i.ReceivedAt,
+			&i.ProcessedAt,
+			&i.RepoID,
+			&i.PipelineID,
+			&i.Attempts,
+			&i.NextAttemptAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const cleanWebhookDeliveries = `-- name: CleanWebhookDeliveries :exec
+DELETE FROM "webhook_delivery"
+WHERE received_at < $1
+`
+
+func (q *Queries) CleanWebhookDeliveries(ctx context.Context, receivedAt pgtype.Timestamp) error {
+	_, err := q.db.Exec(ctx, cleanWebhookDeliveries, receivedAt)
+	return err
+}
+
+const createWebhookDelivery = `-- name: CreateWebhookDelivery :one
+INSERT INTO "webhook_delivery" (service, delivery_id, event, headers, payload, repo_id)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (service, delivery_id) DO NOTHING
+RETURNING id, received_at
+`
+
+type CreateWebhookDeliveryParams struct {
+	Service    Service
+	DeliveryID string
+	Event      string
+	Headers    []byte
+	Payload    []byte
+	RepoID     pgtype.Int8
+}
+
+type CreateWebhookDeliveryRow struct {
+	ID         int64
+	ReceivedAt pgtype.Timestamp
+}
+
+func (q *Queries) CreateWebhookDelivery(ctx context.Context, arg CreateWebhookDeliveryParams) (CreateWebhookDeliveryRow, error) {
+	row := q.db.QueryRow(ctx, createWebhookDelivery,
+		arg.Service,
+		arg.DeliveryID,
+		arg.Event,
+		arg.Headers,
+		arg.Payload,
+		arg.RepoID,
+	)
+	var i CreateWebhookDeliveryRow
+	err := row.Scan(&i.ID, &i.ReceivedAt)
+	return i, err
+}
+
+const getRepoWebhookDeliveries = `-- name: GetRepoWebhookDeliveries :many
+SELECT d.id, d.service, d.delivery_id, d.event, d.headers, d.payload, d.status, d.error, d.received_at, d.processed_at, d.repo_id, d.pipeline_id, d.attempts, d.next_attempt_at, p.number AS pipeline_number
+FROM "webhook_delivery" d LEFT JOIN "pipeline" p ON d.pipeline_id = p.id
+WHERE d.repo_id = $1
+ORDER BY d.received_at DESC
+LIMIT $2
+`
+
+type GetRepoWebhookDeliveriesParams struct {
+	RepoID pgtype.Int8
+	Limit  int32
+}
+
+type GetRepoWebhookDeliveriesRow struct {
+	WebhookDelivery WebhookDelivery
+	PipelineNumber  pgtype.Int8
+}
+
+func (q *Queries) GetRepoWebhookDeliveries(ctx context.Context, arg GetRepoWebhookDeliveriesParams) ([]GetRepoWebhookDeliveriesRow, error) {
+	rows, err := q.db.Query(ctx, getRepoWebhookDeliveries, arg.RepoID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRepoWebhookDeliveriesRow
+	for rows.Next() {
+		var i GetRepoWebhookDeliveriesRow
+		if err := rows.Scan(
+			&i.WebhookDelivery.ID,
+			&i.WebhookDelivery.Service,
+			&i.WebhookDelivery.DeliveryID,
+			&i.WebhookDelivery.Event,
+			&i.WebhookDelivery.Headers,
+			&i.WebhookDelivery.Payload,
+			&i.WebhookDelivery.Status,
+			&i.WebhookDelivery.Error,
+			&i.WebhookDelivery.ReceivedAt,
+			&i.WebhookDelivery.ProcessedAt,
+			&i.WebhookDelivery.RepoID,
+			&i.WebhookDelivery.PipelineID,
+			&i.WebhookDelivery.Attempts,
+			&i.WebhookDelivery.NextAttemptAt,
+			&i.PipelineNumber,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getWebhookDelivery = `-- name: GetWebhookDelivery :one
+SELECT id, service, delivery_id, event, headers, payload, status, error, received_at, processed_at, repo_id, pipeline_id, attempts, next_attempt_at
+FROM "webhook_delivery"
+WHERE id = $1
+`
+
+func (q *Queries) GetWebhookDelivery(ctx context.Context, id int64) (WebhookDelivery, error) {
+	row := q.db.QueryRow(ctx, getWebhookDelivery, id)
+	var i WebhookDelivery
+	err := row.Scan(
+		&i.ID,
+		&i.Service,
+		&i.DeliveryID,
+		&i.Event,
+		&i.Headers,
+		&i.Payload,
+		&i.Status,
+		&i.Error,
+		&i.ReceivedAt,
+		&i.ProcessedAt,
+		&i.RepoID,
+		&i.PipelineID,
+		&i.Attempts,
+		&i.NextAttemptAt,
+	)
+	return i, err
+}
+
+const getWebhookDeliveryByDeliveryID = `-- name: GetWebhookDeliveryByDeliveryID :one
+SELECT id, service, delivery_id, event, headers, payload, status, error, received_at, processed_at, repo_id, pipeline_id, attempts, next_attempt_at
+FROM "webhook_delivery"
+WHERE service = $1 AND delivery_id = $2
+`
+
+type GetWebhookDeliveryByDeliveryIDParams struct {
+	Service    Service
+	DeliveryID string
+}
+
+func (q *Queries) GetWebhookDeliveryByDeliveryID(ctx context.Context, arg GetWebhookDeliveryByDeliveryIDParams) (WebhookDelivery, error) {
+	row := q.db.QueryRow(ctx, getWebhookDeliveryByDeliveryID, arg.Service, arg.DeliveryID)
+	var i WebhookDelivery
+	err := row.Scan(
+		&i.ID,
+		&i.Service,
+		&i.DeliveryID,
+		&i.Event,
+		&i.Headers,
+		&i.Payload,
+		&i.Status,
+		&i.Error,
+		&i.ReceivedAt,
+		&i.ProcessedAt,
+		&i.RepoID,
+		&i.PipelineID,
+		&i.Attempts,
+		&i.NextAttemptAt,
+	)
+	return i, err
+}
+
+const getWebhookDeliveryQueueStats = `-- name: GetWebhookDeliveryQueueStats :one
+SELECT count(*) AS pending, COALESCE(EXTRACT(EPOCH FROM now() - min(received_at)), 0)::float8 AS oldest_pending_seconds
+FROM "webhook_delivery"
+WHERE status = 'pending'
+`
+
+type GetWebhookDeliveryQueueStatsRow struct {
+	Pending              int64
+	OldestPendingSeconds float64
+}
+
+func (q *Queries) GetWebhookDeliveryQueueStats(ctx context.Context) (GetWebhookDeliveryQueueStatsRow, error) {
+	row := q.db.QueryRow(ctx, getWebhookDeliveryQueueStats)
+	var i GetWebhookDeliveryQueueStatsRow
+	err := row.Scan(&i.Pending, &i.OldestPendingSeconds)
+	return i, err
+}
+
+const requeueWebhookDelivery = `-- name: RequeueWebhookDelivery :exec
+UPDATE "webhook_delivery"
+SET status = 'pending', attempts = 0, error = NULL, processed_at = NULL, next_attempt_at = now(),
+    pipeline_id = CASE WHEN $1::boolean THEN NULL ELSE pipeline_id END
+WHERE id = $2
+`
+
+type RequeueWebhookDeliveryParams struct {
+	Replay bool
+	ID     int64
+}
+
+func (q *Queries) RequeueWebhookDelivery(ctx context.Context, arg RequeueWebhookDeliveryParams) error {
+	_, err := q.db.Exec(ctx, requeueWebhookDelivery, arg.Replay, arg.ID)
+	return err
+}
+
+const retryWebhookDelivery = `-- name: RetryWebhookDelivery :exec
+UPDATE "webhook_delivery"
+SET error = $1, next_attempt_at = now() + $2::interval
+WHERE id = $3
+`
+
+type RetryWebhookDeliveryParams struct {
+	Error pgtype.Text
+	Delay pgtype.Interval
+	ID    int64
+}
+
+func (q *Queries) RetryWebhookDelivery(ctx context.Context, arg RetryWebhookDeliveryParams) error {
+	_, err := q.db.Exec(ctx, retryWebhookDelivery, arg.Error, arg.Delay, arg.ID)
+	return err
+}
+
+const setWebhookDeliveryPipeline = `-- name: SetWebhookDeliveryPipeline :exec
+UPDATE "webhook_delivery"
+SET pipeline_id = $2
+WHERE id = $1
+`
+
+type SetWebhookDeliveryPipelineParams struct {
+	ID         int64
+	PipelineID pgtype.Int8
+}
+
+func (q *Queries) SetWebhookDeliveryPipeline(ctx context.Context, arg SetWebhookDeliveryPipelineParams) error {
+	_, err := q.db.Exec(ctx, setWebhookDeliveryPipeline, arg.ID, arg.PipelineID)
+	return err
+}
+
+const setWebhookDeliveryStatus = `-- name: SetWebhookDeliveryStatus :exec
+UPDATE "webhook_delivery"
+SET status = $1, error = $2, pipeline_id = $3, processed_at = now()
+WHERE id = $4
+`
+
+type SetWebhookDeliveryStatusParams struct {
+	Status     DeliveryStatus
+	Error      pgtype.Text
+	PipelineID pgtype.Int8
+	ID         int64
+}
+
+func (q *Queries) SetWebhookDeliveryStatus(ctx context.Context, arg SetWebhookDeliveryStatusParams) error {
+	_, err := q.db.Exec(ctx, setWebhookDeliveryStatus,
+		arg.Status,
+		arg.Error,
+		arg.PipelineID,
+		arg.ID,
+	)
+	return err
+}
@@ -0,0 +1,243 @@
+// Package encryption encrypts secrets stored in the database with envelope
+// encryption. Every value is encrypted with its own random data key, which is
+// encrypted with key-encryption key (KEK) from the config.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// keySize is size of both key-encryption and data keys, AES-256 is used.
+const keySize = 32
+
+// rowPrefix marks values whose additional data includes primary key of their
+// row. Values without it were encrypted with only table and column as
+// additional data by older versions, they are still decrypted until they are
+// encrypted again. Queries selecting values to encrypt again match the prefix.
+const rowPrefix = "v2:"
+
+// secretKeyID is ID of the key derived from the secret key of the server.
+const secretKeyID = "secret-key"
+
+// ErrUnknownKey is returned when value was encrypted with key that is not in
+// the keyring.
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// Field is encrypted column of the table row. It is additional data of the
+// encryption, so encrypted value cannot be moved to other column or row.
+type Field struct {
+	Table  string
+	Column string
+	// Row is primary key of the row.
+	Row int64
+}
+
+func (f Field) additionalData() []byte {
+	return []byte(fmt.Sprintf("%s.%s:%d", f.Table, f.Column, f.Row))
+}
+
+// legacyAdditionalData is additional data of values encrypted before they
+// were bound to their row.
+func (f Field) legacyAdditionalData() []byte {
+	return []byte(f.Table + "." + f.Column)
+}
+
+// Key is key-encryption key identified by ID stored next to encrypted values.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Keyring encrypts values with the current key and decrypts values encrypted
+// with any of its keys, so keys can be rotated.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring creates keyring. The first key is current and used for
+// encryption, others are only used for decryption of values encrypted before
+// rotation.
+func NewKeyring(keys []Key) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one key is required")
+	}
+
+	k := &Keyring{
+		current: keys[0].ID,
+		keys:    make(map[string]cipher.AEAD, len(keys)),
+	}
+	for _, key := range keys {
+		if key.ID == "" {
+			return nil, errors.New("key ID must not be empty")
+		}
+		if _, ok := k.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key ID %s", key.ID)
+		}
+		if len(key.Secret) != keySize {
+			return nil, fmt.Errorf("key %s must be %d bytes long", key.ID, keySize)
+		}
+
+		aead, err := newAEAD(key.Secret)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[key.ID] = aead
+	}
+
+	return k, nil
+}
+
+// ParseKeys parses keys in format "id:base64-secret" separated by commas or
+// new lines.
+func ParseKeys(s string) ([]Key, error) {
+	var keys []Key
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		field = strings.TrimSpace(field)
+		if field == "" || strings.HasPrefix(field, "#") {
+			continue
+		}
+
+		id, secret, ok := strings.Cut(field, ":")
+		if !ok {
+			return nil, errors.New("key must be in format id:base64-secret")
+		}
+		b, err := base64.StdEncoding.DecodeString(secret)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode key %s: %w", id, err)
+		}
+		keys = append(keys, Key{ID: id, Secret: b})
+	}
+
+	return keys, nil
+}
+
+// LoadKeyring creates keyring from keys in value or in file if value is
+// empty. Key derived from secretKey is the last one, so it is current when no
+// keys are set and values encrypted with it are still decrypted after keys
+// are set.
+func LoadKeyring(value string, file string, secretKey string) (*Keyring, error) {
+	if value == "" && file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read encryption keys: %w", err)
+		}
+		value = string(b)
+	}
+
+	keys, err := ParseKeys(value)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyring(append(keys, deriveKey(secretKey)))
+}
+
+// deriveKey returns key derived from the secret key of the server.
+func deriveKey(secretKey string) Key {
+	secret := sha256.Sum256([]byte("shark-ci encryption key\x00" + secretKey))
+	return Key{ID: secretKeyID, Secret: secret[:]}
+}
+
+// CurrentKeyID returns ID of the key new values are encrypted with.
+func (k *Keyring) CurrentKeyID() string {
+	return k.current
+}
+
+// Encrypt encrypts plaintext of the field with the current key.
+func (k *Keyring) Encrypt(plaintext string, field Field) (string, error) {
+	additionalData := field.additionalData()
+
+	dataKey := make([]byte, keySize)
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := seal(k.keys[k.current], dataKey, additionalData)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(aead, []byte(plaintext), additionalData)
+	if err != nil {
+		return "", err
+	}
+
+	return rowPrefix + base64.RawStdEncoding.EncodeToString(append(wrappedKey, ciphertext...)), nil
+}
+
+// Decrypt decrypts value of the field encrypted with key keyID.
+func (k *Keyring) Decrypt(keyID string, value string, field Field) (string, error) {
+	kek, ok := k.keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("%w %s", ErrUnknownKey, keyID)
+	}
+
+	additionalData := field.legacyAdditionalData()
+	if v, ok := strings.CutPrefix(value, rowPrefix); ok {
+		value = v
+		additionalData = field.additionalData()
+	}
+
+	b, err := base64.RawStdEncoding.DecodeString(value)
+	if err != nil {
+		return "", fmt.Errorf("cannot decode encrypted value: %w", err)
+	}
+	wrappedKeySize := kek.NonceSize() + keySize + kek.Overhead()
+	if len(b) < wrappedKeySize {
+		return "", errors.New("encrypted value is too short")
+	}
+
+	dataKey, err := open(kek, b[:wrappedKeySize], additionalData)
+	if err != nil {
+		return "", fmt.Errorf("cannot decrypt data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(aead, b[wrappedKeySize:], additionalData)
+	if err != nil {
+		return "", fmt.Errorf("cannot decrypt value: %w", err)
+	}
+
+	return string(plaintext), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with random nonce prepended to the ciphertext.
+func seal(aead cipher.AEAD, plaintext []byte, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
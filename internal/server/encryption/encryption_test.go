@@ -0,0 +1,125 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func testKey(id string, b byte) Key {
+	return Key{ID: id, Secret: bytes.Repeat([]byte{b}, keySize)}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	old, err := NewKeyring([]Key{testKey("old", 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	field := Field{Table: "service_user", Column: "access_token", Row: 1}
+	encrypted, err := old.Encrypt("token", field)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	// Rotated keyring still decrypts values of the old key.
+	rotated, err := NewKeyring([]Key{testKey("new", 2), testKey("old", 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.CurrentKeyID() != "new" {
+		t.Errorf("CurrentKeyID() = %s, want new", rotated.CurrentKeyID())
+	}
+	got, err := rotated.Decrypt("old", encrypted, field)
+	if err != nil || got != "token" {
+		t.Errorf("Decrypt() = %q, %v, want token", got, err)
+	}
+
+	if _, err := rotated.Decrypt("old", encrypted, Field{Table: "service_user", Column: "refresh_token", Row: 1}); err == nil {
+		t.Error("Decrypt() succeeded with different column")
+	}
+	if _, err := rotated.Decrypt("old", encrypted, Field{Table: "service_user", Column: "access_token", Row: 2}); err == nil {
+		t.Error("Decrypt() succeeded with different row")
+	}
+	if _, err := rotated.Decrypt("new", encrypted, field); err == nil {
+		t.Error("Decrypt() succeeded with different key")
+	}
+	if _, err := old.Decrypt("new", encrypted, field); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Decrypt() error = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestDecryptLegacy(t *testing.T) {
+	k, err := NewKeyring([]Key{testKey("old", 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Value encrypted by older version with only table and column as
+	// additional data.
+	additionalData := []byte("repo.webhook_secret")
+	dataKey := bytes.Repeat([]byte{3}, keySize)
+	wrappedKey, err := seal(k.keys["old"], dataKey, additionalData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := seal(aead, []byte("secret"), additionalData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := base64.RawStdEncoding.EncodeToString(append(wrappedKey, ciphertext...))
+
+	got, err := k.Decrypt("old", legacy, Field{Table: "repo", Column: "webhook_secret", Row: 7})
+	if err != nil || got != "secret" {
+		t.Errorf("Decrypt() of legacy value = %q, %v, want secret", got, err)
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	derived, err := LoadKeyring("", "", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if derived.CurrentKeyID() != secretKeyID {
+		t.Errorf("CurrentKeyID() without keys = %s, want %s", derived.CurrentKeyID(), secretKeyID)
+	}
+	field := Field{Table: "repo", Column: "webhook_secret", Row: 1}
+	encrypted, err := derived.Encrypt("secret", field)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Values encrypted with the derived key are decrypted after keys are set.
+	configured, err := LoadKeyring("new:"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, keySize)), "", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configured.CurrentKeyID() != "new" {
+		t.Errorf("CurrentKeyID() = %s, want new", configured.CurrentKeyID())
+	}
+	got, err := configured.Decrypt(secretKeyID, encrypted, field)
+	if err != nil || got != "secret" {
+		t.Errorf("Decrypt() of value encrypted with derived key = %q, %v, want secret", got, err)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, keySize))
+	keys, err := ParseKeys("# rotated 2025-01\nnew:" + secret + "\nold:" + secret + "\n")
+	if err != nil {
+		t.Fatalf("ParseKeys() error = %v", err)
+	}
+	if len(keys) != 2 || keys[0].ID != "new" || keys[1].ID != "old" {
+		t.Errorf("ParseKeys() = %v", keys)
+	}
+
+	if _, err := ParseKeys("key"); err == nil {
+		t.Error("ParseKeys() accepted key without ID")
+	}
+	if _, err := NewKeyring([]Key{{ID: "short", Secret: []byte("short")}}); err == nil {
+		t.Error("NewKeyring() accepted short key")
+	}
+}
@@ -0,0 +1,332 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/shark-ci/shark-ci/internal/config"
+	"github.com/shark-ci/shark-ci/internal/messagequeue"
+	"github.com/shark-ci/shark-ci/internal/server/commitstatus"
+	"github.com/shark-ci/shark-ci/internal/server/jobtoken"
+	"github.com/shark-ci/shark-ci/internal/server/retry"
+	"github.com/shark-ci/shark-ci/internal/server/service"
+	"github.com/shark-ci/shark-ci/internal/server/store"
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+const (
+	// maxAttempts is how many times is delivery processed before it is
+	// marked as failed.
+	maxAttempts = 5
+	// lease is how long is claimed delivery hidden from other processors. If
+	// processor crashes, delivery is picked up again after lease expires.
+	lease        = 5 * time.Minute
+	pollInterval = 2 * time.Second
+	retryBase    = 5 * time.Second
+	retryMax     = 5 * time.Minute
+)
+
+var metrics = expvar.NewMap("webhook_deliveries")
+
+// Processor processes recorded webhook deliveries in the background so
+// webhook requests can be answered immediately.
+type Processor struct {
+	s        store.Storer
+	mq       messagequeue.MessageQueuer
+	services service.Services
+	reporter *commitstatus.Reporter
+	workers  int
+	wake     chan struct{}
+}
+
+func NewProcessor(s store.Storer, mq messagequeue.MessageQueuer, services service.Services, reporter *commitstatus.Reporter, workers int) *Processor {
+	p := &Processor{
+		s:        s,
+		mq:       mq,
+		services: services,
+		reporter: reporter,
+		workers:  max(workers, 1),
+		wake:     make(chan struct{}, 1),
+	}
+	expvar.Publish("webhook_queue", expvar.Func(p.queueStats))
+	return p
+}
+
+// Notify wakes up processor so new delivery is processed without waiting for
+// next poll.
+func (p *Processor) Notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run claims pending deliveries and processes them by pool of workers until
+// ctx is cancelled.
+func (p *Processor) Run(ctx context.Context) {
+	deliveries := make(chan types.WebhookDelivery)
+	defer close(deliveries)
+	for range p.workers {
+		go func() {
+			for d := range deliveries {
+				p.process(ctx, d)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		claimed, err := p.s.ClaimWebhookDeliveries(ctx, int32(p.workers), lease)
+		if err != nil {
+			slog.Error("store: cannot claim webhook deliveries", "err", err)
+		}
+		for _, d := range claimed {
+			select {
+			case deliveries <- d:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if len(claimed) == p.workers {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.wake:
+		}
+	}
+}
+
+func (p *Processor) process(ctx context.Context, delivery types.WebhookDelivery) {
+	logger := slog.With("deliveryID", delivery.ID, "service", delivery.Service, "event", delivery.Event, "attempt", delivery.Attempts)
+
+	pipeline, err := p.handle(ctx, delivery)
+
+	var pipelineID *int64
+	if pipeline != nil && pipeline.ID != 0 {
+		pipelineID = &pipeline.ID
+	}
+
+	if err != nil && !errors.Is(err, service.ErrEventNotSupported) && delivery.Attempts < maxAttempts {
+		delay := retry.Backoff(delivery.Attempts, retryBase, retryMax)
+		logger.Warn("Processing webhook delivery failed, will retry.", "in", delay, "err", err)
+		metrics.Add("retried", 1)
+		err = p.s.RetryWebhookDelivery(ctx, delivery.ID, err.Error(), delay)
+		if err != nil {
+			logger.Error("store: cannot schedule webhook delivery retry", "err", err)
+		}
+		return
+	}
+
+	status := types.DeliveryProcessed
+	var errMsg *string
+	if errors.Is(err, service.ErrEventNotSupported) {
+		status = types.DeliveryIgnored
+	} else if err != nil {
+		status = types.DeliveryFailed
+		e := err.Error()
+		errMsg = &e
+		logger.Error("Processing webhook delivery failed.", "err", err)
+		if pipeline != nil && pipeline.ID != 0 {
+			p.Fail(ctx, pipeline, err)
+		}
+	}
+	metrics.Add(string(status), 1)
+
+	err = p.s.SetWebhookDeliveryStatus(ctx, delivery.ID, status, errMsg, pipelineID)
+	if err != nil {
+		logger.Error("store: cannot set webhook delivery status", "err", err)
+	}
+}
+
+// handle passes delivery to the service and starts pipeline if the event
+// produced one. Pipeline created by the previous attempt is started instead
+// unless it was already sent to workers.
+func (p *Processor) handle(ctx context.Context, delivery types.WebhookDelivery) (*types.Pipeline, error) {
+	if delivery.PipelineID != nil {
+		pipeline, err := p.s.GetPipeline(ctx, *delivery.PipelineID)
+		if err != nil {
+			return nil, fmt.Errorf("store: cannot get pipeline of previous attempt: %w", err)
+		}
+		if pipeline.Status != types.Queued || pipeline.AwaitingApproval || pipeline.SentAt != nil {
+			return &pipeline, nil
+		}
+		return &pipeline, p.Enqueue(ctx, &pipeline)
+	}
+
+	srv, ok := p.services[delivery.Service]
+	if !ok {
+		return nil, fmt.Errorf("service %s is not configured", delivery.Service)
+	}
+
+	// Signature was checked when the delivery was received.
+	pipeline, err := srv.ParseEvent(ctx, delivery.Event, delivery.Payload)
+	if err != nil || pipeline == nil {
+		return pipeline, err
+	}
+
+	return pipeline, p.startPipeline(ctx, delivery.ID, pipeline)
+}
+
+func (p *Processor) startPipeline(ctx context.Context, deliveryID int64, pipeline *types.Pipeline) error {
+	pipeline.AwaitingApproval = pipeline.Fork && config.ServerConf.ForkPRApproval
+	_, err := p.s.CreateDeliveryPipeline(ctx, deliveryID, pipeline)
+	if errors.Is(err, store.ErrConflict) {
+		// Re-run was requested while previous attempt of the pipeline runs.
+		return fmt.Errorf("%w: %w", service.ErrEventNotSupported, err)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot create pipeline: %w", err)
+	}
+
+	if pipeline.AwaitingApproval {
+		p.report(ctx, pipeline, types.Queued, fmt.Sprintf("Pipeline #%d is waiting for maintainer approval", pipeline.Number))
+		return nil
+	}
+
+	return p.Enqueue(ctx, pipeline)
+}
+
+// Enqueue sends created pipeline to workers.
+func (p *Processor) Enqueue(ctx context.Context, pipeline *types.Pipeline) error {
+	token, tokenHash, err := jobtoken.New()
+	if err != nil {
+		return fmt.Errorf("cannot generate job token: %w", err)
+	}
+	err = p.s.CreateJobToken(ctx, pipeline.ID, tokenHash, jobtoken.TTL)
+	if err != nil {
+		return fmt.Errorf("store: cannot create job token: %w", err)
+	}
+
+	// Queued is reported before the work is sent, so it cannot be reported
+	// after the worker reports the pipeline running.
+	p.report(ctx, pipeline, types.Queued, fmt.Sprintf("Pipeline #%d is queued", pipeline.Number))
+
+	work := types.Work{
+		Pipeline: *pipeline,
+		JobToken: token,
+	}
+	err = p.mq.SendWork(ctx, work)
+	if err != nil {
+		return fmt.Errorf("message queue: cannot send work: %w", err)
+	}
+
+	// Pipeline is sent, so failing to record it must not cause it to be sent
+	// again by the caller.
+	err = p.s.MarkPipelineSent(ctx, pipeline.ID)
+	if err != nil {
+		slog.Error("store: cannot mark pipeline as sent", "pipelineID", pipeline.ID, "err", err)
+		return nil
+	}
+	now := time.Now()
+	pipeline.SentAt = &now
+	return nil
+}
+
+// Start sends created pipeline to workers like Enqueue. Pipeline which cannot
+// be sent is moved to error, so it does not stay queued forever.
+func (p *Processor) Start(ctx context.Context, pipeline *types.Pipeline) error {
+	err := p.Enqueue(ctx, pipeline)
+	if err != nil {
+		p.Fail(ctx, pipeline, err)
+	}
+	return err
+}
+
+// Fail moves queued pipeline which cannot be sent to workers to error and
+// reports it. Pipelines in other statuses are left as they are.
+func (p *Processor) Fail(ctx context.Context, pipeline *types.Pipeline, cause error) {
+	from := types.Queued
+	msg := "Cannot send pipeline to workers: " + cause.Error()
+	_, transitioned, err := p.s.TransitionPipeline(ctx, pipeline.ID, types.PipelineTransition{
+		From:    &from,
+		To:      types.Error,
+		Actor:   types.ActorSystem,
+		Message: &msg,
+		At:      time.Now(),
+	})
+	if err != nil {
+		slog.Error("store: cannot move pipeline to error", "pipelineID", pipeline.ID, "err", err)
+		return
+	}
+	if !transitioned {
+		return
+	}
+	pipeline.Status = types.Error
+
+	err = p.s.RevokeJobToken(ctx, pipeline.ID)
+	if err != nil {
+		slog.Warn("store: cannot revoke job token", "pipelineID", pipeline.ID, "err", err)
+	}
+	p.report(ctx, pipeline, types.Error, fmt.Sprintf("Pipeline #%d could not be started", pipeline.Number))
+}
+
+// Approve approves the pipeline awaiting approval and sends it to workers.
+// Approval of pipeline which cannot be sent is reverted, so the maintainer can
+// approve it again. It returns false if the pipeline was not awaiting approval.
+func (p *Processor) Approve(ctx context.Context, pipeline *types.Pipeline, userID int64) (bool, error) {
+	approved, err := p.s.ApprovePipeline(ctx, pipeline.ID, userID)
+	if err != nil || !approved {
+		return approved, err
+	}
+	pipeline.AwaitingApproval = false
+	pipeline.ApprovedBy = &userID
+
+	err = p.Enqueue(ctx, pipeline)
+	if err == nil {
+		return true, nil
+	}
+	revertErr := p.s.RevertPipelineApproval(ctx, pipeline.ID)
+	if revertErr != nil {
+		slog.Error("store: cannot revert pipeline approval", "pipelineID", pipeline.ID, "err", revertErr)
+		p.Fail(ctx, pipeline, err)
+		return true, err
+	}
+	pipeline.AwaitingApproval = true
+	pipeline.ApprovedBy = nil
+
+	revertErr = p.s.RevokeJobToken(ctx, pipeline.ID)
+	if revertErr != nil {
+		slog.Warn("store: cannot revoke job token", "pipelineID", pipeline.ID, "err", revertErr)
+	}
+	p.report(ctx, pipeline, types.Queued, fmt.Sprintf("Pipeline #%d is waiting for maintainer approval", pipeline.Number))
+	return true, err
+}
+
+// report reports status of the pipeline. Pipeline is already created so
+// failing to report status must not cause the delivery to be processed again.
+func (p *Processor) report(ctx context.Context, pipeline *types.Pipeline, state types.PipelineStatus, description string) {
+	err := p.reporter.Report(ctx, types.CommitStatus{
+		RepoID:      pipeline.RepoID,
+		CommitSHA:   pipeline.CommitSHA,
+		Context:     commitstatus.ContextFor(pipeline.PRNumber),
+		State:       state,
+		TargetURL:   pipeline.URL,
+		Description: description,
+		PipelineID:  &pipeline.ID,
+	})
+	if err != nil {
+		slog.Warn("Cannot report pipeline status.", "pipelineID", pipeline.ID, "err", err)
+	}
+}
+
+func (p *Processor) queueStats() any {
+	pending, oldest, err := p.s.GetWebhookDeliveryQueueStats(context.Background())
+	if err != nil {
+		slog.Error("store: cannot get webhook delivery queue stats", "err", err)
+		return nil
+	}
+
+	return map[string]any{
+		"pending":                pending,
+		"oldest_pending_seconds": oldest.Seconds(),
+	}
+}
@@ -0,0 +1,136 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shark-ci/shark-ci/internal/server/service"
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+// ErrRepoArchived is returned when pipeline is triggered for unregistered
+// repository.
+var ErrRepoArchived = errors.New("repository is not registered")
+
+// Target is commit of the repository pipeline can be triggered for.
+type Target struct {
+	RepoID   int64
+	CloneURL string
+	Commit   service.Commit
+	// Workflow is nil when the commit has no workflow file, pipeline of the
+	// target is skipped then.
+	Workflow *string
+	Inputs   types.WorkflowInputs
+}
+
+// Resolve finds commit the branch or tag of the repository points to and
+// inputs declared in its workflow.
+func (p *Processor) Resolve(ctx context.Context, repoID int64, ref string) (Target, error) {
+	info, err := p.s.GetRepoStatusInfo(ctx, repoID)
+	if err != nil {
+		return Target{}, fmt.Errorf("store: cannot get repo info: %w", err)
+	}
+	if info.Archived {
+		return Target{}, ErrRepoArchived
+	}
+	srv, ok := p.services[info.Service]
+	if !ok {
+		return Target{}, fmt.Errorf("service %s is not configured", info.Service)
+	}
+
+	token, err := service.RepoToken(ctx, p.s, srv, info.ServiceUserID, info.Token, info.InstallationID, info.RepoServiceID)
+	if err != nil {
+		return Target{}, err
+	}
+	repo, err := srv.GetRepo(ctx, &token, info.RepoServiceID)
+	if err != nil {
+		return Target{}, fmt.Errorf("cannot get repo: %w", err)
+	}
+
+	commit, err := srv.ResolveRef(ctx, &token, repo.Owner, repo.Name, ref)
+	if err != nil {
+		return Target{}, fmt.Errorf("cannot resolve %s: %w", ref, err)
+	}
+
+	target := Target{
+		RepoID:   repoID,
+		CloneURL: repo.CloneURL,
+		Commit:   commit,
+	}
+	workflow, err := srv.GetFile(ctx, &token, repo.Owner, repo.Name, types.WorkflowPath, commit.SHA)
+	if service.IsNotFound(err) {
+		return target, nil
+	}
+	if err != nil {
+		return Target{}, fmt.Errorf("cannot get workflow: %w", err)
+	}
+
+	target.Inputs, err = types.ParseWorkflowInputs(workflow)
+	if err != nil {
+		return Target{}, err
+	}
+	w := string(workflow)
+	target.Workflow = &w
+	return target, nil
+}
+
+// Trigger creates pipeline of the target with given values of its inputs and
+// sends it to workers. Actor is recorded as pusher of the pipeline unless it
+// is empty. Invalid values are reported by types.InputsError.
+func (p *Processor) Trigger(ctx context.Context, target Target, event types.PipelineEvent, actor string, values map[string]string) (*types.Pipeline, error) {
+	pipeline, err := target.pipeline(event, actor, values)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = p.s.CreatePipeline(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create pipeline: %w", err)
+	}
+
+	err = p.Start(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return pipeline, nil
+}
+
+// TriggerSchedule creates pipeline of the target for the run of the schedule
+// at runAt like Trigger, inputs get their defaults. Run creates at most one
+// pipeline, store.ErrAlreadyExists is returned when it already did.
+func (p *Processor) TriggerSchedule(ctx context.Context, target Target, scheduleID int64, runAt time.Time) (*types.Pipeline, error) {
+	pipeline, err := target.pipeline(types.EventSchedule, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = p.s.CreateSchedulePipeline(ctx, scheduleID, runAt, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create pipeline: %w", err)
+	}
+
+	err = p.Start(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return pipeline, nil
+}
+
+// pipeline returns new pipeline of the target with given values of its inputs.
+func (t Target) pipeline(event types.PipelineEvent, actor string, values map[string]string) (*types.Pipeline, error) {
+	inputs, err := t.Inputs.Values(values)
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline := t.Commit.Pipeline(t.RepoID, t.CloneURL, event)
+	if actor != "" {
+		pipeline.Pusher = &actor
+	}
+	// Pipeline runs the workflow its inputs were validated against.
+	pipeline.Workflow = t.Workflow
+	pipeline.Inputs = inputs
+	return pipeline, nil
+}
@@ -0,0 +1,44 @@
+package grpc
+
+import (
+	"context"
+	"log/slog"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/shark-ci/shark-ci/internal/server/jobtoken"
+)
+
+// jobRequest is request of worker on behalf of the pipeline.
+type jobRequest interface {
+	GetPipelineId() int64
+	GetJobToken() string
+}
+
+// JobTokenInterceptor rejects requests without valid job token of their
+// pipeline, so only the worker which received the pipeline can report it.
+// Revoked token is rejected with PermissionDenied, so the worker can tell the
+// pipeline was cancelled from other rejections.
+func (s *GRPCServer) JobTokenInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	in, ok := req.(jobRequest)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "request has no job token")
+	}
+
+	state, err := s.s.GetJobTokenState(ctx, in.GetPipelineId(), jobtoken.Hash(in.GetJobToken()))
+	if err != nil {
+		slog.Error("store: cannot validate job token", "pipelineID", in.GetPipelineId(), "err", err)
+		return nil, status.Error(codes.Internal, "cannot validate job token")
+	}
+	switch state {
+	case jobtoken.Valid:
+		return handler(ctx, req)
+	case jobtoken.Revoked:
+		return nil, status.Error(codes.PermissionDenied, "job token was revoked")
+	case jobtoken.Expired:
+		return nil, status.Error(codes.Unauthenticated, "job token expired")
+	}
+	return nil, status.Error(codes.Unauthenticated, "invalid job token")
+}
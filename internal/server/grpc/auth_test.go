@@ -0,0 +1,63 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/shark-ci/shark-ci/internal/proto"
+	"github.com/shark-ci/shark-ci/internal/server/jobtoken"
+	"github.com/shark-ci/shark-ci/internal/server/store"
+)
+
+// tokenStore knows job token of single pipeline.
+type tokenStore struct {
+	store.Storer
+	pipelineID int64
+	tokenHash  []byte
+	state      jobtoken.State
+}
+
+func (s tokenStore) GetJobTokenState(ctx context.Context, pipelineID int64, tokenHash []byte) (jobtoken.State, error) {
+	if pipelineID != s.pipelineID || !bytes.Equal(tokenHash, s.tokenHash) {
+		return jobtoken.Invalid, nil
+	}
+	return s.state, nil
+}
+
+func TestJobTokenInterceptor(t *testing.T) {
+	token, hash, err := jobtoken.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return &pb.Empty{}, nil
+	}
+
+	tests := []struct {
+		name  string
+		state jobtoken.State
+		req   any
+		code  codes.Code
+	}{
+		{"valid", jobtoken.Valid, &pb.PipelineStartedRequest{PipelineId: 1, JobToken: token}, codes.OK},
+		{"expired", jobtoken.Expired, &pb.PipelineHeartbeatRequest{PipelineId: 1, JobToken: token}, codes.Unauthenticated},
+		{"revoked", jobtoken.Revoked, &pb.PipelineHeartbeatRequest{PipelineId: 1, JobToken: token}, codes.PermissionDenied},
+		{"other pipeline", jobtoken.Valid, &pb.CommandOutputRequest{PipelineId: 2, JobToken: token}, codes.Unauthenticated},
+		{"missing token", jobtoken.Valid, &pb.WorkflowLoadedRequest{PipelineId: 1}, codes.Unauthenticated},
+		{"no token field", jobtoken.Valid, &pb.Empty{}, codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewGRPCServer(tokenStore{pipelineID: 1, tokenHash: hash, state: tt.state}, nil, nil)
+			_, err := s.JobTokenInterceptor(context.Background(), tt.req, &grpc.UnaryServerInfo{}, handler)
+			if status.Code(err) != tt.code {
+				t.Errorf("JobTokenInterceptor() error = %v, want code %v", err, tt.code)
+			}
+		})
+	}
+}
@@ -2,9 +2,18 @@ package grpc
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
 	pb "github.com/shark-ci/shark-ci/internal/proto"
+	"github.com/shark-ci/shark-ci/internal/server/commitstatus"
+	"github.com/shark-ci/shark-ci/internal/server/jobtoken"
+	"github.com/shark-ci/shark-ci/internal/server/schedule"
 	"github.com/shark-ci/shark-ci/internal/server/service"
 	"github.com/shark-ci/shark-ci/internal/server/store"
 	"github.com/shark-ci/shark-ci/internal/types"
@@ -13,18 +22,22 @@ import (
 type GRPCServer struct {
 	pb.UnimplementedPipelineReporterServer
 	s        store.Storer
+	reporter *commitstatus.Reporter
 	services service.Services
 }
 
 var _ pb.PipelineReporterServer = &GRPCServer{}
 
-func NewGRPCServer(s store.Storer, services service.Services) *GRPCServer {
+func NewGRPCServer(s store.Storer, reporter *commitstatus.Reporter, services service.Services) *GRPCServer {
 	return &GRPCServer{
 		s:        s,
+		reporter: reporter,
 		services: services,
 	}
 }
 
+// PipelineStarted moves queued pipeline to running. Repeated calls for running
+// pipeline succeed without reporting the status again.
 func (s *GRPCServer) PipelineStarted(ctx context.Context, in *pb.PipelineStartedRequest) (*pb.Empty, error) {
 	info, err := s.s.GetPipelineStateChangeInfo(ctx, in.PipelineId)
 	if err != nil {
@@ -32,33 +45,69 @@ func (s *GRPCServer) PipelineStarted(ctx context.Context, in *pb.PipelineStarted
 		return nil, err
 	}
 
-	srv, ok := s.services[info.Service]
-	if !ok {
-		slog.Error("service: service not found", "service", info.Service)
+	// Token of queued pipeline is valid for the wait in the queue, from now on
+	// it is kept valid by heartbeats.
+	err = s.s.ExtendJobToken(ctx, in.PipelineId, jobtoken.RunTTL)
+	if err != nil {
+		slog.Error("store: cannot extend job token", "pipelineID", in.PipelineId, "err", err)
 		return nil, err
 	}
 
 	pipelineStatus := types.Running
-	err = s.s.PipelineStarted(ctx, in.PipelineId, pipelineStatus, in.GetStartedAt().AsTime())
+	current, transitioned, err := s.s.TransitionPipeline(ctx, in.PipelineId, types.PipelineTransition{
+		To:    pipelineStatus,
+		Actor: types.ActorWorker,
+		At:    in.GetStartedAt().AsTime(),
+	})
 	if err != nil {
 		slog.Error("store: cannot update pipeline", "err", err)
 		return nil, err
 	}
+	if !transitioned {
+		if current == pipelineStatus {
+			return &pb.Empty{}, nil
+		}
+		return nil, status.Errorf(codes.FailedPrecondition, "pipeline is %s", current)
+	}
 
-	status := service.Status{
+	err = s.reporter.Report(ctx, types.CommitStatus{
+		RepoID:      info.RepoID,
+		CommitSHA:   info.CommitSHA,
+		Context:     commitstatus.ContextFor(info.PRNumber),
 		State:       pipelineStatus,
 		TargetURL:   info.URL,
-		Context:     "Shark CI",
-		Description: "Pipeline is running",
-	}
-	err = srv.CreateStatus(ctx, &info.Token, info.RepoOwner, info.RepoName, info.CommitSHA, status)
+		Description: fmt.Sprintf("Pipeline #%d is running", info.Number),
+		PipelineID:  &in.PipelineId,
+	})
 	if err != nil {
-		slog.Error("service: cannot create status", "err", err)
+		slog.Error("Cannot report pipeline status.", "err", err)
 		return nil, err
 	}
-	return &pb.Empty{}, err
+	return &pb.Empty{}, nil
 }
 
+// finishedStatus returns pipeline status and commit status description for
+// status reported by worker. Unknown statuses are errors.
+func finishedStatus(s pb.PipelineFinnishedStatus, number int64) (types.PipelineStatus, string) {
+	switch s {
+	case pb.PipelineFinnishedStatus_SUCCESS:
+		return types.Success, fmt.Sprintf("Pipeline #%d finnished successfully", number)
+	case pb.PipelineFinnishedStatus_FAILURE:
+		return types.Failure, fmt.Sprintf("Pipeline #%d failed", number)
+	case pb.PipelineFinnishedStatus_CANCELLED:
+		return types.Cancelled, fmt.Sprintf("Pipeline #%d was cancelled", number)
+	case pb.PipelineFinnishedStatus_TIMED_OUT:
+		return types.TimedOut, fmt.Sprintf("Pipeline #%d timed out", number)
+	case pb.PipelineFinnishedStatus_SKIPPED:
+		return types.Skipped, fmt.Sprintf("Pipeline #%d was skipped, it has nothing to run", number)
+	default:
+		return types.Error, fmt.Sprintf("Pipeline #%d could not run", number)
+	}
+}
+
+// PipelineFinnished moves queued or running pipeline to the final status.
+// Calls for already finished pipeline succeed without changing it, so
+// cancelled pipelines keep their status.
 func (s *GRPCServer) PipelineFinnished(ctx context.Context, in *pb.PipelineFinnishedRequest) (*pb.Empty, error) {
 	info, err := s.s.GetPipelineStateChangeInfo(ctx, in.PipelineId)
 	if err != nil {
@@ -66,49 +115,184 @@ func (s *GRPCServer) PipelineFinnished(ctx context.Context, in *pb.PipelineFinni
 		return nil, err
 	}
 
-	srv, ok := s.services[info.Service]
-	if !ok {
-		slog.Error("service: service not found", "service", info.Service)
+	pipelineStatus, description := finishedStatus(in.Status, info.Number)
+	current, transitioned, err := s.s.TransitionPipeline(ctx, in.PipelineId, types.PipelineTransition{
+		To:      pipelineStatus,
+		Actor:   types.ActorWorker,
+		Message: in.Error,
+		At:      in.GetFinishedAt().AsTime(),
+	})
+	if err != nil {
+		slog.Error("store: cannot update pipeline", "err", err)
 		return nil, err
 	}
-
-	pipelineStatus := types.Success
-	description := "Pipeline finnished successfully"
-	if in.Status == pb.PipelineFinnishedStatus_FAILURE {
-		pipelineStatus = types.Error
-		description = "Pipeline failed"
+	if !transitioned {
+		if current != pipelineStatus {
+			slog.Info("Finished pipeline keeps its status.", "pipelineID", in.PipelineId, "status", current, "reported", pipelineStatus)
+		}
+		return &pb.Empty{}, nil
 	}
-	err = s.s.PipelineFinnished(ctx, in.PipelineId, pipelineStatus, in.GetFinishedAt().AsTime())
+
+	err = s.s.RevokeJobToken(ctx, in.PipelineId)
 	if err != nil {
-		slog.Error("store: cannot update pipeline", "err", err)
-		return nil, err
+		slog.Error("store: cannot revoke job token", "pipelineID", in.PipelineId, "err", err)
+	}
+	// Pipeline is skipped when its commit has no workflow file, e.g. the push
+	// deleted it, so its schedules are removed.
+	if pipelineStatus == types.Skipped {
+		s.syncSchedules(ctx, in.PipelineId, nil)
 	}
 
-	status := service.Status{
+	err = s.reporter.Report(ctx, types.CommitStatus{
+		RepoID:      info.RepoID,
+		CommitSHA:   info.CommitSHA,
+		Context:     commitstatus.ContextFor(info.PRNumber),
 		State:       pipelineStatus,
 		TargetURL:   info.URL,
-		Context:     "Shark CI",
 		Description: description,
-	}
-	err = srv.CreateStatus(ctx, &info.Token, info.RepoOwner, info.RepoName, info.CommitSHA, status)
+		PipelineID:  &in.PipelineId,
+	})
 	if err != nil {
-		slog.Error("service: cannot create status", "err", err)
+		slog.Error("Cannot report pipeline status.", "err", err)
 		return nil, err
 	}
 	return &pb.Empty{}, nil
 }
 
 func (s *GRPCServer) CommandOutput(ctx context.Context, in *pb.CommandOutputRequest) (*pb.Empty, error) {
-	_, err := s.s.CreatePipelineLog(ctx, types.PipelineLog{
+	log := types.PipelineLog{
 		Order:      int(in.Order),
 		Cmd:        in.Cmd,
 		Output:     in.Output,
 		ExitCode:   int(in.ExitCode),
 		PipelineID: in.PipelineId,
-	})
+	}
+	if in.StartedAt != nil && in.FinishedAt != nil {
+		startedAt, finishedAt := in.StartedAt.AsTime(), in.FinishedAt.AsTime()
+		log.StartedAt = &startedAt
+		log.FinishedAt = &finishedAt
+	}
+	_, err := s.s.CreatePipelineLog(ctx, log)
 	if err != nil {
 		slog.Error("Cannot create pipeline log.", "err", err)
 		return nil, err
 	}
+
+	// Status is reported again so check run shows progress of the steps.
+	// Failing to report it must not fail the already saved log.
+	info, err := s.s.GetPipelineStateChangeInfo(ctx, in.PipelineId)
+	if err != nil {
+		slog.Warn("store: cannot get info for pipeline state change", "pipelineID", in.PipelineId, "err", err)
+		return &pb.Empty{}, nil
+	}
+	// Late output of finished pipeline must not report it as running again.
+	if info.Status != types.Running {
+		return &pb.Empty{}, nil
+	}
+	err = s.reporter.Report(ctx, types.CommitStatus{
+		RepoID:      info.RepoID,
+		CommitSHA:   info.CommitSHA,
+		Context:     commitstatus.ContextFor(info.PRNumber),
+		State:       types.Running,
+		TargetURL:   info.URL,
+		Description: fmt.Sprintf("Pipeline #%d is running, step %d finished", info.Number, in.Order),
+		PipelineID:  &in.PipelineId,
+	})
+	if err != nil {
+		slog.Warn("Cannot report pipeline status.", "err", err)
+	}
+	return &pb.Empty{}, nil
+}
+
+// WorkflowLoaded saves workflow of the pipeline for its re-runs. Workflow
+// pushed to a branch replaces schedules the branch declares.
+func (s *GRPCServer) WorkflowLoaded(ctx context.Context, in *pb.WorkflowLoadedRequest) (*pb.Empty, error) {
+	saved, err := s.s.SetPipelineWorkflow(ctx, in.PipelineId, in.Workflow)
+	if err != nil {
+		slog.Error("store: cannot set pipeline workflow", "pipelineID", in.PipelineId, "err", err)
+		return nil, err
+	}
+	// Only the first report of the running pipeline is saved, so the
+	// workflow and its schedules cannot be replaced later.
+	if !saved {
+		return nil, status.Error(codes.FailedPrecondition, "pipeline is not running or its workflow is already saved")
+	}
+
+	s.syncSchedules(ctx, in.PipelineId, []byte(in.Workflow))
+	return &pb.Empty{}, nil
+}
+
+// syncSchedules replaces schedules of the branch pushed by the pipeline by
+// schedules of its workflow. Nil workflow means the branch has no workflow
+// anymore.
+func (s *GRPCServer) syncSchedules(ctx context.Context, pipelineID int64, workflow []byte) {
+	pipeline, err := s.s.GetPipeline(ctx, pipelineID)
+	if err != nil {
+		slog.Error("store: cannot get pipeline", "pipelineID", pipelineID, "err", err)
+		return
+	}
+	if pipeline.Event != types.EventPush || pipeline.Branch == nil {
+		return
+	}
+	// Pipelines are created in order of pushes, so workflow of older push
+	// reported late is ignored.
+	err = schedule.SyncWorkflow(ctx, s.s, pipeline.RepoID, *pipeline.Branch, pipeline.CreatedAt, workflow)
+	if err != nil {
+		slog.Warn("Cannot sync workflow schedules.", "pipelineID", pipelineID, "err", err)
+	}
+}
+
+// GetCloneCredential returns short-lived credential for cloning repository of
+// the pipeline.
+func (s *GRPCServer) GetCloneCredential(ctx context.Context, in *pb.CloneCredentialRequest) (*pb.CloneCredential, error) {
+	pipeline, err := s.s.GetPipeline(ctx, in.PipelineId)
+	if err != nil {
+		slog.Error("store: cannot get pipeline", "pipelineID", in.PipelineId, "err", err)
+		return nil, status.Error(codes.Internal, "cannot get pipeline")
+	}
+	// Fork pipelines run code from other repository, so they never get
+	// credentials and clone the repository anonymously.
+	if pipeline.Fork {
+		return &pb.CloneCredential{}, nil
+	}
+	info, err := s.s.GetPipelineCreationInfo(ctx, pipeline.RepoID)
+	if err != nil {
+		slog.Error("store: cannot get service user", "pipelineID", in.PipelineId, "err", err)
+		return nil, status.Error(codes.Internal, "cannot get service user")
+	}
+
+	srv, ok := s.services[info.Service]
+	if !ok {
+		return nil, status.Errorf(codes.FailedPrecondition, "service %s is not configured", info.Service)
+	}
+	credential, err := service.CloneCredential(ctx, s.s, srv, info.ServiceUserID, info.Token, info.InstallationID, pipeline.RepoID, info.RepoServiceID)
+	if errors.Is(err, service.ErrPrivateRepo) || errors.Is(err, service.ErrNoInstallation) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		slog.Error("Cannot get clone credential.", "pipelineID", in.PipelineId, "err", err)
+		return nil, status.Error(codes.Unavailable, "cannot get clone credential")
+	}
+
+	resp := &pb.CloneCredential{
+		Username: credential.Username,
+		Password: credential.Password,
+	}
+	if !credential.ExpiresAt.IsZero() {
+		resp.ExpiresAt = timestamppb.New(credential.ExpiresAt)
+	}
+	return resp, nil
+}
+
+// PipelineHeartbeat tells the worker the pipeline still runs and extends its
+// job token. Job token is checked by JobTokenInterceptor and it is revoked when
+// the pipeline is cancelled, so the worker stops the pipeline when the
+// heartbeat is rejected as revoked.
+func (s *GRPCServer) PipelineHeartbeat(ctx context.Context, in *pb.PipelineHeartbeatRequest) (*pb.Empty, error) {
+	err := s.s.ExtendJobToken(ctx, in.PipelineId, jobtoken.RunTTL)
+	if err != nil {
+		slog.Error("store: cannot extend job token", "pipelineID", in.PipelineId, "err", err)
+		return nil, err
+	}
 	return &pb.Empty{}, nil
 }
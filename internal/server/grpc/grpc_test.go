@@ -0,0 +1,123 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/shark-ci/shark-ci/internal/proto"
+	"github.com/shark-ci/shark-ci/internal/server/commitstatus"
+	"github.com/shark-ci/shark-ci/internal/server/store"
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+// workflowStore holds single pipeline and records synced schedules.
+type workflowStore struct {
+	store.Storer
+	pipeline  types.Pipeline
+	schedules []types.Schedule
+	synced    bool
+}
+
+func (s *workflowStore) SetPipelineWorkflow(ctx context.Context, pipelineID int64, workflow string) (bool, error) {
+	if s.pipeline.Status != types.Running || s.pipeline.Workflow != nil {
+		return false, nil
+	}
+	s.pipeline.Workflow = &workflow
+	return true, nil
+}
+
+func (s *workflowStore) GetPipeline(ctx context.Context, pipelineID int64) (types.Pipeline, error) {
+	return s.pipeline, nil
+}
+
+func (s *workflowStore) SyncWorkflowSchedules(ctx context.Context, repoID int64, branch string, at time.Time, schedules []types.Schedule) error {
+	if !at.Equal(s.pipeline.CreatedAt) {
+		return errors.New("schedules synced at other time than push")
+	}
+	s.schedules = schedules
+	s.synced = true
+	return nil
+}
+
+func (s *workflowStore) GetPipelineStateChangeInfo(ctx context.Context, pipelineID int64) (*types.PipelineStateChangeInfo, error) {
+	return &types.PipelineStateChangeInfo{RepoID: s.pipeline.RepoID, Number: 1}, nil
+}
+
+func (s *workflowStore) TransitionPipeline(ctx context.Context, pipelineID int64, t types.PipelineTransition) (types.PipelineStatus, bool, error) {
+	if !s.pipeline.Status.CanTransition(t.To) {
+		return s.pipeline.Status, false, nil
+	}
+	s.pipeline.Status = t.To
+	return t.To, true, nil
+}
+
+func (s *workflowStore) RevokeJobToken(ctx context.Context, pipelineID int64) error {
+	return nil
+}
+
+func (s *workflowStore) UpsertCommitStatus(ctx context.Context, status types.CommitStatus) error {
+	return nil
+}
+
+func TestWorkflowLoaded(t *testing.T) {
+	branch := "main"
+	workflow := "on:\n  schedule:\n    - cron: \"0 3 * * *\"\n"
+
+	s := &workflowStore{pipeline: types.Pipeline{ID: 1, RepoID: 1, Status: types.Running, Event: types.EventPush, Branch: &branch, CreatedAt: time.Now()}}
+	srv := NewGRPCServer(s, nil, nil)
+	_, err := srv.WorkflowLoaded(context.Background(), &pb.WorkflowLoadedRequest{PipelineId: 1, Workflow: workflow})
+	if err != nil {
+		t.Fatalf("WorkflowLoaded() error = %v", err)
+	}
+	if s.pipeline.Workflow == nil || !s.synced || len(s.schedules) != 1 {
+		t.Errorf("workflow = %v, synced schedules %v, want workflow saved and schedule synced", s.pipeline.Workflow, s.schedules)
+	}
+
+	// Later reports cannot replace the workflow or its schedules.
+	s.synced = false
+	_, err = srv.WorkflowLoaded(context.Background(), &pb.WorkflowLoadedRequest{PipelineId: 1, Workflow: "steps: []\n"})
+	if status.Code(err) != codes.FailedPrecondition || s.synced || *s.pipeline.Workflow != workflow {
+		t.Errorf("WorkflowLoaded() of saved workflow error = %v, synced = %v", err, s.synced)
+	}
+
+	for _, pipelineStatus := range []types.PipelineStatus{types.Queued, types.Success, types.Cancelled} {
+		s := &workflowStore{pipeline: types.Pipeline{ID: 1, RepoID: 1, Status: pipelineStatus, Event: types.EventPush, Branch: &branch}}
+		srv := NewGRPCServer(s, nil, nil)
+		_, err := srv.WorkflowLoaded(context.Background(), &pb.WorkflowLoadedRequest{PipelineId: 1, Workflow: workflow})
+		if status.Code(err) != codes.FailedPrecondition || s.synced {
+			t.Errorf("WorkflowLoaded() of %s pipeline error = %v, synced = %v", pipelineStatus, err, s.synced)
+		}
+	}
+}
+
+func TestPipelineFinishedWithoutWorkflow(t *testing.T) {
+	branch := "main"
+	for _, tt := range []struct {
+		name       string
+		event      types.PipelineEvent
+		status     pb.PipelineFinnishedStatus
+		wantSynced bool
+	}{
+		{"push skipped", types.EventPush, pb.PipelineFinnishedStatus_SKIPPED, true},
+		{"push succeeded", types.EventPush, pb.PipelineFinnishedStatus_SUCCESS, false},
+		{"schedule skipped", types.EventSchedule, pb.PipelineFinnishedStatus_SKIPPED, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &workflowStore{pipeline: types.Pipeline{ID: 1, RepoID: 1, Status: types.Running, Event: tt.event, Branch: &branch, CreatedAt: time.Now()}}
+			srv := NewGRPCServer(s, commitstatus.NewReporter(s, nil), nil)
+			_, err := srv.PipelineFinnished(context.Background(), &pb.PipelineFinnishedRequest{PipelineId: 1, Status: tt.status})
+			if err != nil {
+				t.Fatalf("PipelineFinnished() error = %v", err)
+			}
+			// Branch without workflow has no schedules.
+			if s.synced != tt.wantSynced || len(s.schedules) != 0 {
+				t.Errorf("synced = %v with schedules %v, want synced %v", s.synced, s.schedules, tt.wantSynced)
+			}
+		})
+	}
+}
@@ -0,0 +1,383 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/exp/slog"
+
+	"github.com/shark-ci/shark-ci/internal/server/event"
+	"github.com/shark-ci/shark-ci/internal/server/middleware"
+	"github.com/shark-ci/shark-ci/internal/server/store"
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+// apiPipeline is pipeline as returned by JSON API.
+type apiPipeline struct {
+	ID               int64                `json:"id"`
+	Number           int64                `json:"number"`
+	URL              string               `json:"url"`
+	Status           types.PipelineStatus `json:"status"`
+	Event            types.PipelineEvent  `json:"event"`
+	Ref              *string              `json:"ref"`
+	Branch           *string              `json:"branch"`
+	Tag              *string              `json:"tag"`
+	CommitSHA        string               `json:"commit_sha"`
+	CommitMessage    *string              `json:"commit_message"`
+	AuthorName       *string              `json:"author_name"`
+	AuthorEmail      *string              `json:"author_email"`
+	CommitterName    *string              `json:"committer_name"`
+	CommitterEmail   *string              `json:"committer_email"`
+	Pusher           *string              `json:"pusher"`
+	CompareURL       *string              `json:"compare_url"`
+	PRNumber         *int32               `json:"pr_number"`
+	SourceBranch     *string              `json:"source_branch"`
+	TargetBranch     *string              `json:"target_branch"`
+	AwaitingApproval bool                 `json:"awaiting_approval"`
+	Attempt          int32                `json:"attempt"`
+	// OriginalID is ID of the first attempt, nil for the first attempt.
+	OriginalID *int64            `json:"original_id"`
+	Inputs     map[string]string `json:"inputs,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	StartedAt  *time.Time        `json:"started_at"`
+	FinishedAt *time.Time        `json:"finished_at"`
+	// DurationSeconds is nil for pipelines which did not start.
+	DurationSeconds *float64 `json:"duration_seconds"`
+}
+
+func newAPIPipeline(p types.Pipeline) apiPipeline {
+	pipeline := apiPipeline{
+		ID:               p.ID,
+		Number:           p.Number,
+		URL:              p.URL,
+		Status:           p.Status,
+		Event:            p.Event,
+		Ref:              p.Ref,
+		Branch:           p.Branch,
+		Tag:              p.Tag,
+		CommitSHA:        p.CommitSHA,
+		CommitMessage:    p.CommitMessage,
+		AuthorName:       p.AuthorName,
+		AuthorEmail:      p.AuthorEmail,
+		CommitterName:    p.CommitterName,
+		CommitterEmail:   p.CommitterEmail,
+		Pusher:           p.Pusher,
+		CompareURL:       p.CompareURL,
+		PRNumber:         p.PRNumber,
+		SourceBranch:     p.SourceBranch,
+		TargetBranch:     p.TargetBranch,
+		AwaitingApproval: p.AwaitingApproval,
+		Attempt:          p.Attempt,
+		OriginalID:       p.OriginalID,
+		Inputs:           p.Inputs,
+		CreatedAt:        p.CreatedAt,
+		StartedAt:        p.StartedAt,
+		FinishedAt:       p.FinishedAt,
+	}
+	if p.StartedAt != nil {
+		seconds := p.Duration().Seconds()
+		pipeline.DurationSeconds = &seconds
+	}
+	return pipeline
+}
+
+// HandleAPIRepoPipelines returns pipelines of the repository. It takes the
+// same filters as the pipelines page, next page is requested with before set
+// to returned next_before.
+func (h *RepoHandler) HandleAPIRepoPipelines(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := middleware.UserFromContext(ctx, w)
+	repoID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		APIError(w, http.StatusBadRequest, "invalid repo ID", err)
+		return
+	}
+
+	ownRepo, err := h.s.UserOwnRepo(ctx, user.ID, repoID)
+	if err != nil {
+		APIError(w, http.StatusInternalServerError, "cannot check if user own repo", err)
+		return
+	}
+	if !ownRepo {
+		APIError(w, http.StatusNotFound, "repo not found", nil)
+		return
+	}
+
+	filter, err := pipelineFilterFromQuery(r.URL.Query())
+	if err != nil {
+		APIError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	pipelines, next, err := h.repoPipelines(ctx, repoID, filter)
+	if err != nil {
+		APIError(w, http.StatusInternalServerError, "cannot get repo pipelines", err)
+		return
+	}
+
+	res := struct {
+		Pipelines  []apiPipeline `json:"pipelines"`
+		NextBefore *int64        `json:"next_before"`
+	}{
+		Pipelines:  make([]apiPipeline, 0, len(pipelines)),
+		NextBefore: next,
+	}
+	for _, p := range pipelines {
+		res.Pipelines = append(res.Pipelines, newAPIPipeline(p))
+	}
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		slog.Error("Cannot encode JSON.", "err", err)
+	}
+}
+
+// apiStep is finished step of pipeline as returned by JSON API. Output is
+// downloaded from the pipeline log.
+type apiStep struct {
+	Order    int    `json:"order"`
+	Cmd      string `json:"cmd"`
+	ExitCode int    `json:"exit_code"`
+	// DurationSeconds is nil for steps reported by older workers.
+	DurationSeconds *float64 `json:"duration_seconds"`
+}
+
+// apiTransition is change of pipeline status as returned by JSON API.
+type apiTransition struct {
+	From    *types.PipelineStatus `json:"from"`
+	To      types.PipelineStatus  `json:"to"`
+	Actor   string                `json:"actor"`
+	Message *string               `json:"message"`
+	At      time.Time             `json:"at"`
+}
+
+// apiAttempt is attempt of pipeline as returned by JSON API.
+type apiAttempt struct {
+	Number  int64                `json:"number"`
+	Attempt int32                `json:"attempt"`
+	Status  types.PipelineStatus `json:"status"`
+	URL     string               `json:"url"`
+}
+
+// HandleAPIRepoPipeline returns pipeline of the repository by its number with
+// its finished steps, status history and attempts.
+func (h *RepoHandler) HandleAPIRepoPipeline(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := middleware.UserFromContext(ctx, w)
+	pipeline, ok := apiRepoPipeline(w, r, h.s, user.ID)
+	if !ok {
+		return
+	}
+
+	logs, err := h.s.GetPipelineLogs(ctx, pipeline.ID)
+	if err != nil {
+		APIError(w, http.StatusInternalServerError, "cannot get pipeline logs", err)
+		return
+	}
+
+	transitions, err := h.s.GetPipelineTransitions(ctx, pipeline.ID)
+	if err != nil {
+		APIError(w, http.StatusInternalServerError, "cannot get pipeline history", err)
+		return
+	}
+
+	attempts, err := h.s.GetPipelineAttempts(ctx, pipeline.FirstAttemptID())
+	if err != nil {
+		APIError(w, http.StatusInternalServerError, "cannot get pipeline attempts", err)
+		return
+	}
+
+	res := struct {
+		apiPipeline
+		Steps    []apiStep       `json:"steps"`
+		History  []apiTransition `json:"history"`
+		Attempts []apiAttempt    `json:"attempts"`
+	}{
+		apiPipeline: newAPIPipeline(pipeline),
+		Steps:       make([]apiStep, 0, len(logs)),
+		History:     make([]apiTransition, 0, len(transitions)),
+		Attempts:    make([]apiAttempt, 0, len(attempts)),
+	}
+	for _, a := range attempts {
+		res.Attempts = append(res.Attempts, apiAttempt{
+			Number:  a.Number,
+			Attempt: a.Attempt,
+			Status:  a.Status,
+			URL:     a.URL,
+		})
+	}
+	for _, t := range transitions {
+		res.History = append(res.History, apiTransition(t))
+	}
+	for _, l := range logs {
+		step := apiStep{
+			Order:    l.Order,
+			Cmd:      l.Cmd,
+			ExitCode: l.ExitCode,
+		}
+		if l.StartedAt != nil {
+			seconds := l.Duration().Seconds()
+			step.DurationSeconds = &seconds
+		}
+		res.Steps = append(res.Steps, step)
+	}
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		slog.Error("Cannot encode JSON.", "err", err)
+	}
+}
+
+// HandleAPIRerunPipeline creates new attempt of the finished pipeline and
+// returns it.
+func (h *PipelineHandler) HandleAPIRerunPipeline(w http.ResponseWriter, r *http.Request) {
+	h.handleAPIRerun(w, r, false)
+}
+
+// HandleAPIRerunFailedPipeline creates new attempt of the pipeline which did
+// not succeed and returns it.
+func (h *PipelineHandler) HandleAPIRerunFailedPipeline(w http.ResponseWriter, r *http.Request) {
+	h.handleAPIRerun(w, r, true)
+}
+
+func (h *PipelineHandler) handleAPIRerun(w http.ResponseWriter, r *http.Request, failedOnly bool) {
+	ctx := r.Context()
+	user := middleware.UserFromContext(ctx, w)
+	pipeline, ok := apiRepoPipeline(w, r, h.s, user.ID)
+	if !ok {
+		return
+	}
+
+	rerun, err := h.rerun(ctx, pipeline, failedOnly, user)
+	if errors.Is(err, errNoFailedJobs) || errors.Is(err, store.ErrConflict) || errors.Is(err, event.ErrRepoArchived) {
+		APIError(w, http.StatusConflict, strings.ToLower(rerunErrorMsg(err)), err)
+		return
+	}
+	if err != nil {
+		APIError(w, http.StatusInternalServerError, "cannot re-run pipeline", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(newAPIPipeline(rerun))
+	if err != nil {
+		slog.Error("Cannot encode JSON.", "err", err)
+	}
+}
+
+// apiTriggerRequest is body of request for manually triggered pipeline.
+type apiTriggerRequest struct {
+	Ref string `json:"ref"`
+	// Inputs are strings or booleans.
+	Inputs map[string]any `json:"inputs"`
+}
+
+// HandleAPITriggerPipeline runs pipeline of branch or tag with given inputs
+// and returns it.
+func (h *PipelineHandler) HandleAPITriggerPipeline(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := middleware.UserFromContext(ctx, w)
+	repoID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		APIError(w, http.StatusBadRequest, "invalid repo ID", err)
+		return
+	}
+
+	ownRepo, err := h.s.UserOwnRepo(ctx, user.ID, repoID)
+	if err != nil {
+		APIError(w, http.StatusInternalServerError, "cannot check if user own repo", err)
+		return
+	}
+	if !ownRepo {
+		APIError(w, http.StatusNotFound, "repo not found", nil)
+		return
+	}
+
+	var req apiTriggerRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		APIError(w, http.StatusBadRequest, "invalid request body", err)
+		return
+	}
+	if req.Ref == "" {
+		APIError(w, http.StatusBadRequest, "ref is required", nil)
+		return
+	}
+	values := make(map[string]string, len(req.Inputs))
+	for name, v := range req.Inputs {
+		switch v := v.(type) {
+		case string:
+			values[name] = v
+		case bool:
+			values[name] = strconv.FormatBool(v)
+		default:
+			APIError(w, http.StatusBadRequest, "input "+name+" must be a string or boolean", nil)
+			return
+		}
+	}
+
+	target, err := h.processor.Resolve(ctx, repoID, req.Ref)
+	if err != nil {
+		apiTriggerError(w, err)
+		return
+	}
+	pipeline, err := h.processor.Trigger(ctx, target, types.EventManual, user.Username, values)
+	if err != nil {
+		apiTriggerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(newAPIPipeline(*pipeline))
+	if err != nil {
+		slog.Error("Cannot encode JSON.", "err", err)
+	}
+}
+
+func apiTriggerError(w http.ResponseWriter, err error) {
+	if msgs := triggerErrors(err); msgs != nil {
+		APIError(w, http.StatusBadRequest, strings.Join(msgs, "; "), err)
+		return
+	}
+	APIError(w, http.StatusInternalServerError, "cannot run pipeline", err)
+}
+
+// apiRepoPipeline returns pipeline from the request URL if it belongs to the
+// repo owned by the user. Otherwise it writes error response.
+func apiRepoPipeline(w http.ResponseWriter, r *http.Request, s store.Storer, userID int64) (types.Pipeline, bool) {
+	ctx := r.Context()
+	repoID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		APIError(w, http.StatusBadRequest, "invalid repo ID", err)
+		return types.Pipeline{}, false
+	}
+	number, err := strconv.ParseInt(mux.Vars(r)["number"], 10, 64)
+	if err != nil {
+		APIError(w, http.StatusBadRequest, "invalid pipeline number", err)
+		return types.Pipeline{}, false
+	}
+
+	ownRepo, err := s.UserOwnRepo(ctx, userID, repoID)
+	if err != nil {
+		APIError(w, http.StatusInternalServerError, "cannot check if user own repo", err)
+		return types.Pipeline{}, false
+	}
+	if !ownRepo {
+		APIError(w, http.StatusNotFound, "repo not found", nil)
+		return types.Pipeline{}, false
+	}
+
+	pipeline, err := s.GetRepoPipelineByNumber(ctx, repoID, number)
+	if errors.Is(err, store.ErrNotFound) {
+		APIError(w, http.StatusNotFound, "pipeline not found", nil)
+		return types.Pipeline{}, false
+	}
+	if err != nil {
+		APIError(w, http.StatusInternalServerError, "cannot get pipeline", err)
+		return types.Pipeline{}, false
+	}
+
+	return pipeline, true
+}
@@ -117,6 +117,18 @@ func (h AuthHandler) OAuth2Callback(w http.ResponseWriter, r *http.Request) {
 			Error5xx(w, http.StatusInternalServerError, "Cannot create user and service user.", err)
 			return
 		}
+	} else {
+		// New token replaces the old one, which may no longer be refreshable.
+		existing, err := h.s.GetServiceUserByUserID(ctx, serviceUser.Service, userID)
+		if err != nil {
+			Error5xx(w, http.StatusInternalServerError, "Cannot get service user.", err)
+			return
+		}
+		err = h.s.UpdateServiceUserToken(ctx, existing.ID, *token)
+		if err != nil {
+			Error5xx(w, http.StatusInternalServerError, "Cannot update service user token.", err)
+			return
+		}
 	}
 
 	s, _ := session.Store.Get(r, "session")
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+	"golang.org/x/exp/slog"
+
+	"github.com/shark-ci/shark-ci/internal/server/middleware"
+	"github.com/shark-ci/shark-ci/templates"
+)
+
+const deliveriesPageSize = 50
+
+func (h *EventHandler) HandleRepoDeliveries(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := middleware.UserFromContext(ctx, w)
+	repoID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		Error400(w, "Invalid repo ID")
+		return
+	}
+
+	ownRepo, err := h.s.UserOwnRepo(ctx, user.ID, repoID)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot check if user own repo", err)
+		return
+	}
+	if !ownRepo {
+		Error404(w)
+		return
+	}
+
+	deliveries, err := h.s.GetRepoWebhookDeliveries(ctx, repoID, deliveriesPageSize)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot get webhook deliveries", err)
+		return
+	}
+
+	health, err := h.s.GetRepoWebhookHealth(ctx, repoID)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot get webhook health", err)
+		return
+	}
+
+	err = templates.DeliveriesTmpl.Execute(w, map[string]any{
+		"Username":       user.Username,
+		"RepoID":         repoID,
+		"Deliveries":     deliveries,
+		"Health":         health,
+		csrf.TemplateTag: csrf.TemplateField(r),
+	})
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot execute template.", err)
+		return
+	}
+}
+
+func (h *EventHandler) HandleReplayDelivery(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := middleware.UserFromContext(ctx, w)
+	repoID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		Error400(w, "Invalid repo ID")
+		return
+	}
+	deliveryID, err := strconv.ParseInt(mux.Vars(r)["delivery_id"], 10, 64)
+	if err != nil {
+		Error400(w, "Invalid delivery ID")
+		return
+	}
+
+	ownRepo, err := h.s.UserOwnRepo(ctx, user.ID, repoID)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot check if user own repo", err)
+		return
+	}
+	if !ownRepo {
+		Error404(w)
+		return
+	}
+
+	delivery, err := h.s.GetWebhookDelivery(ctx, deliveryID)
+	if err != nil || delivery.RepoID == nil || *delivery.RepoID != repoID {
+		Error404(w)
+		return
+	}
+
+	err = h.s.RequeueWebhookDelivery(ctx, delivery.ID, true)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot requeue webhook delivery", err)
+		return
+	}
+	h.processor.Notify()
+
+	http.Redirect(w, r, fmt.Sprintf("/repositories/%d/deliveries", repoID), http.StatusFound)
+}
+
+// HandleCheckWebhook checks webhook of the repository on the service and
+// repairs it. Result is shown on the deliveries page.
+func (h *EventHandler) HandleCheckWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := middleware.UserFromContext(ctx, w)
+	repoID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		Error400(w, "Invalid repo ID")
+		return
+	}
+
+	ownRepo, err := h.s.UserOwnRepo(ctx, user.ID, repoID)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot check if user own repo", err)
+		return
+	}
+	if !ownRepo {
+		Error404(w)
+		return
+	}
+
+	err = h.checker.Check(ctx, repoID)
+	if err != nil {
+		slog.Warn("Webhook check failed.", "repoID", repoID, "err", err)
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/repositories/%d/deliveries", repoID), http.StatusFound)
+}
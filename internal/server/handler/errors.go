@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"encoding/json"
 	"net/http"
 
 	"github.com/shark-ci/shark-ci/templates"
@@ -30,3 +31,15 @@ func Error5xx(w http.ResponseWriter, code int, msg string, err error) {
 		slog.Error("Cannot execute template.", "err", err)
 	}
 }
+
+// APIError writes error response of JSON API.
+func APIError(w http.ResponseWriter, code int, msg string, err error) {
+	if code >= http.StatusInternalServerError {
+		slog.Error(msg, "err", err)
+	}
+	w.WriteHeader(code)
+	err = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+	if err != nil {
+		slog.Error("Cannot encode JSON.", "err", err)
+	}
+}
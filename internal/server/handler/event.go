@@ -1,29 +1,31 @@
 package handler
 
 import (
-	"errors"
 	"net/http"
 
 	"github.com/gorilla/mux"
 	"golang.org/x/exp/slog"
 
-	"github.com/shark-ci/shark-ci/internal/messagequeue"
+	"github.com/shark-ci/shark-ci/internal/server/event"
 	"github.com/shark-ci/shark-ci/internal/server/service"
 	"github.com/shark-ci/shark-ci/internal/server/store"
+	"github.com/shark-ci/shark-ci/internal/server/webhookcheck"
 	"github.com/shark-ci/shark-ci/internal/types"
 )
 
 type EventHandler struct {
-	s        store.Storer
-	mq       messagequeue.MessageQueuer
-	services service.Services
+	s         store.Storer
+	processor *event.Processor
+	services  service.Services
+	checker   *webhookcheck.Checker
 }
 
-func NewEventHandler(s store.Storer, mq messagequeue.MessageQueuer, services service.Services) *EventHandler {
+func NewEventHandler(s store.Storer, processor *event.Processor, services service.Services, checker *webhookcheck.Checker) *EventHandler {
 	return &EventHandler{
-		s:        s,
-		mq:       mq,
-		services: services,
+		s:         s,
+		processor: processor,
+		services:  services,
+		checker:   checker,
 	}
 }
 
@@ -37,58 +39,57 @@ func (h *EventHandler) HandleEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pipeline, err := srv.HandleEvent(ctx, w, r)
+	payload, err := srv.ValidatePayload(r)
 	if err != nil {
-		if errors.Is(err, service.ErrEventNotSupported) {
-			http.Error(w, "cannot handle this type of event", http.StatusNotImplemented)
-		} else {
-			slog.Error("Cannot handle event.", "err", err)
-			w.WriteHeader(http.StatusInternalServerError)
-		}
+		slog.Warn("Invalid event payload.", "service", serviceName, "err", err)
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	if pipeline == nil {
-		// TODO: handle rest of event somewhere else so HandleEvent should return pipeline but just error
+
+	info := srv.DeliveryInfo(r, payload)
+	if info.DeliveryID == "" {
+		http.Error(w, "missing delivery ID", http.StatusBadRequest)
 		return
 	}
 
-	_, err = h.s.CreatePipeline(ctx, pipeline)
-	if err != nil {
-		slog.Error("Cannot create pipeline", "err", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	delivery := types.WebhookDelivery{
+		Service:    srv.Name(),
+		DeliveryID: info.DeliveryID,
+		Event:      info.Event,
+		Headers:    r.Header.Clone(),
+		Payload:    payload,
+	}
+	if info.RepoServiceID != 0 {
+		repoID, err := h.s.GetRepoIDByServiceRepoID(ctx, srv.Name(), info.RepoServiceID)
+		if err == nil {
+			delivery.RepoID = &repoID
+		}
 	}
 
-	info, err := h.s.GetPipelineCreationInfo(ctx, pipeline.RepoID)
+	delivery, created, err := h.s.CreateWebhookDelivery(ctx, delivery)
 	if err != nil {
-		slog.Error("store: cannot get service user", "err", err)
+		slog.Error("Cannot record webhook delivery.", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-
-	work := types.Work{
-		Pipeline: *pipeline,
-		Token:    info.Token,
-	}
-	err = h.mq.SendWork(ctx, work)
-	if err != nil {
-		slog.Error("message queue: cannot send work", "err", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	if !created && delivery.Done() {
+		slog.Info("Skipping already received webhook delivery.", "service", serviceName, "deliveryID", delivery.DeliveryID, "status", delivery.Status)
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	status := service.Status{
-		State:       types.Pending,
-		TargetURL:   pipeline.URL,
-		Context:     "Shark CI",
-		Description: "Pipeline is pending",
-	}
-	err = srv.CreateStatus(ctx, &info.Token, info.Username, info.RepoName, pipeline.CommitSHA, status)
-	if err != nil {
-		slog.Error("cannot create status", "err", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	// Pending delivery is already queued, it may be processed right now, so
+	// it is not requeued.
+	if !created && delivery.Status == types.DeliveryFailed {
+		// Forge redelivered delivery which failed before.
+		err = h.s.RequeueWebhookDelivery(ctx, delivery.ID, false)
+		if err != nil {
+			slog.Error("Cannot requeue webhook delivery.", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	h.processor.Notify()
+	w.WriteHeader(http.StatusAccepted)
 }
@@ -2,19 +2,26 @@ package handler
 
 import (
 	"net/http"
+	"slices"
+
+	"github.com/gorilla/csrf"
 
 	"github.com/shark-ci/shark-ci/internal/server/middleware"
+	"github.com/shark-ci/shark-ci/internal/server/service"
 	"github.com/shark-ci/shark-ci/internal/server/store"
+	"github.com/shark-ci/shark-ci/internal/types"
 	"github.com/shark-ci/shark-ci/templates"
 )
 
 type IndexHandler struct {
-	s store.Storer
+	s        store.Storer
+	services service.Services
 }
 
-func NewIndexHandler(s store.Storer) *IndexHandler {
+func NewIndexHandler(s store.Storer, services service.Services) *IndexHandler {
 	return &IndexHandler{
-		s: s,
+		s:        s,
+		services: services,
 	}
 }
 
@@ -28,9 +35,25 @@ func (h *IndexHandler) Index(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	reloginServices, err := h.s.GetReloginRequiredServices(ctx, user.ID)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot get relogin required services.", err)
+		return
+	}
+
+	serviceNames := make([]types.Service, 0, len(h.services))
+	for name := range h.services {
+		serviceNames = append(serviceNames, name)
+	}
+	slices.Sort(serviceNames)
+
 	err = templates.IndexTmpl.Execute(w, map[string]any{
 		"Username": user.Username,
 		"Repos":    repos,
+		"Services": serviceNames,
+		"Relogin":  reloginServices,
+
+		csrf.TemplateTag: csrf.TemplateField(r),
 	})
 	if err != nil {
 		Error5xx(w, http.StatusInternalServerError, "Cannot execute template", err)
@@ -0,0 +1,355 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+	"golang.org/x/exp/slog"
+
+	"github.com/shark-ci/shark-ci/internal/server/commitstatus"
+	"github.com/shark-ci/shark-ci/internal/server/event"
+	"github.com/shark-ci/shark-ci/internal/server/middleware"
+	"github.com/shark-ci/shark-ci/internal/server/store"
+	"github.com/shark-ci/shark-ci/internal/types"
+	"github.com/shark-ci/shark-ci/templates"
+)
+
+type PipelineHandler struct {
+	s         store.Storer
+	processor *event.Processor
+	reporter  *commitstatus.Reporter
+}
+
+func NewPipelineHandler(s store.Storer, processor *event.Processor, reporter *commitstatus.Reporter) *PipelineHandler {
+	return &PipelineHandler{
+		s:         s,
+		processor: processor,
+		reporter:  reporter,
+	}
+}
+
+func (h *PipelineHandler) HandleApprovals(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := middleware.UserFromContext(ctx, w)
+	repoID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		Error400(w, "Invalid repo ID")
+		return
+	}
+
+	ownRepo, err := h.s.UserOwnRepo(ctx, user.ID, repoID)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot check if user own repo", err)
+		return
+	}
+	if !ownRepo {
+		Error404(w)
+		return
+	}
+
+	pipelines, err := h.s.GetPipelinesAwaitingApproval(ctx, repoID)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot get pipelines awaiting approval", err)
+		return
+	}
+
+	err = templates.ApprovalsTmpl.Execute(w, map[string]any{
+		"Username":       user.Username,
+		"RepoID":         repoID,
+		"Pipelines":      pipelines,
+		csrf.TemplateTag: csrf.TemplateField(r),
+	})
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot execute template.", err)
+		return
+	}
+}
+
+// HandlePipeline shows the pipeline with output of its steps.
+func (h *PipelineHandler) HandlePipeline(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := middleware.UserFromContext(ctx, w)
+	pipeline, ok := h.repoPipeline(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	logs, err := h.s.GetPipelineLogs(ctx, pipeline.ID)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot get pipeline logs", err)
+		return
+	}
+
+	transitions, err := h.s.GetPipelineTransitions(ctx, pipeline.ID)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot get pipeline history", err)
+		return
+	}
+
+	attempts, err := h.s.GetPipelineAttempts(ctx, pipeline.FirstAttemptID())
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot get pipeline attempts", err)
+		return
+	}
+
+	err = templates.PipelineTmpl.Execute(w, map[string]any{
+		"Username":       user.Username,
+		"Pipeline":       pipeline,
+		"Logs":           logs,
+		"Transitions":    transitions,
+		"Attempts":       attempts,
+		csrf.TemplateTag: csrf.TemplateField(r),
+	})
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot execute template.", err)
+		return
+	}
+}
+
+// HandlePipelineLog downloads output of all steps of the pipeline as plain
+// text.
+func (h *PipelineHandler) HandlePipelineLog(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := middleware.UserFromContext(ctx, w)
+	pipeline, ok := h.repoPipeline(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	logs, err := h.s.GetPipelineLogs(ctx, pipeline.ID)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot get pipeline logs", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="pipeline-%d.log"`, pipeline.Number))
+	for _, log := range logs {
+		_, err = fmt.Fprintf(w, "$ %s\n%s\n[exit code %d]\n\n", log.Cmd, log.Output, log.ExitCode)
+		if err != nil {
+			slog.Warn("Cannot write pipeline log.", "pipelineID", pipeline.ID, "err", err)
+			return
+		}
+	}
+}
+
+func (h *PipelineHandler) HandleApprovePipeline(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := middleware.UserFromContext(ctx, w)
+	pipeline, ok := h.repoPipeline(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	_, err := h.processor.Approve(ctx, &pipeline, user.ID)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot approve pipeline", err)
+		return
+	}
+
+	redirectAfterApproval(w, r, pipeline)
+}
+
+func (h *PipelineHandler) HandleRejectPipeline(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := middleware.UserFromContext(ctx, w)
+	pipeline, ok := h.repoPipeline(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	rejected, err := h.s.RejectPipeline(ctx, pipeline.ID, user.Username)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot reject pipeline", err)
+		return
+	}
+	if rejected {
+		err = h.reporter.Report(ctx, types.CommitStatus{
+			RepoID:      pipeline.RepoID,
+			CommitSHA:   pipeline.CommitSHA,
+			Context:     commitstatus.ContextFor(pipeline.PRNumber),
+			State:       types.Cancelled,
+			TargetURL:   pipeline.URL,
+			Description: fmt.Sprintf("Pipeline #%d was rejected by maintainer", pipeline.Number),
+			PipelineID:  &pipeline.ID,
+		})
+		if err != nil {
+			slog.Warn("Cannot report pipeline status.", "pipelineID", pipeline.ID, "err", err)
+		}
+	}
+
+	redirectAfterApproval(w, r, pipeline)
+}
+
+// HandleRerunPipeline creates new attempt of the finished pipeline and shows
+// it.
+func (h *PipelineHandler) HandleRerunPipeline(w http.ResponseWriter, r *http.Request) {
+	h.handleRerun(w, r, false)
+}
+
+// HandleRerunFailedPipeline creates new attempt of the pipeline which did not
+// succeed and shows it.
+func (h *PipelineHandler) HandleRerunFailedPipeline(w http.ResponseWriter, r *http.Request) {
+	h.handleRerun(w, r, true)
+}
+
+func (h *PipelineHandler) handleRerun(w http.ResponseWriter, r *http.Request, failedOnly bool) {
+	ctx := r.Context()
+	user := middleware.UserFromContext(ctx, w)
+	pipeline, ok := h.repoPipeline(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	rerun, err := h.rerun(ctx, pipeline, failedOnly, user)
+	if errors.Is(err, errNoFailedJobs) || errors.Is(err, store.ErrConflict) || errors.Is(err, event.ErrRepoArchived) {
+		Error400(w, rerunErrorMsg(err))
+		return
+	}
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot re-run pipeline", err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/repos/%d/builds/%d", rerun.RepoID, rerun.Number), http.StatusFound)
+}
+
+// errNoFailedJobs is returned when failed jobs of pipeline which did not fail
+// are re-run.
+var errNoFailedJobs = errors.New("pipeline has no failed jobs")
+
+// rerun creates and enqueues next attempt of the pipeline requested by the
+// user. Pipeline runs a single job, so re-run of failed jobs re-runs it only
+// when the pipeline failed. Pipelines of unregistered repositories are kept
+// for history, but they cannot be re-run.
+func (h *PipelineHandler) rerun(ctx context.Context, pipeline types.Pipeline, failedOnly bool, user types.User) (types.Pipeline, error) {
+	if failedOnly && !pipeline.Status.Failed() {
+		return types.Pipeline{}, errNoFailedJobs
+	}
+	info, err := h.s.GetRepoStatusInfo(ctx, pipeline.RepoID)
+	if err != nil {
+		return types.Pipeline{}, fmt.Errorf("store: cannot get repo info: %w", err)
+	}
+	if info.Archived {
+		return types.Pipeline{}, event.ErrRepoArchived
+	}
+
+	rerun := pipeline.Rerun()
+	// Maintainer requesting the re-run approves the fork pipeline.
+	if rerun.Fork {
+		rerun.ApprovedBy = &user.ID
+	}
+	_, err = h.s.CreatePipelineRerun(ctx, &rerun, user.Username)
+	if err != nil {
+		return types.Pipeline{}, err
+	}
+
+	// Queued status of the new attempt replaces status of the previous one
+	// on the service. Attempt which cannot be sent fails, so it does not
+	// block further re-runs.
+	err = h.processor.Start(ctx, &rerun)
+	if err != nil {
+		return types.Pipeline{}, fmt.Errorf("cannot enqueue pipeline: %w", err)
+	}
+	return rerun, nil
+}
+
+// rerunErrorMsg returns message for user for re-run which is not allowed.
+func rerunErrorMsg(err error) string {
+	if errors.Is(err, errNoFailedJobs) {
+		return "Pipeline has no failed jobs"
+	}
+	if errors.Is(err, event.ErrRepoArchived) {
+		return "Repository is not registered"
+	}
+	return "Pipeline can be re-run only when all its attempts finished"
+}
+
+// redirectAfterApproval returns user back to the pipeline page when the
+// pipeline was approved or rejected there, otherwise to the approvals page.
+func redirectAfterApproval(w http.ResponseWriter, r *http.Request, pipeline types.Pipeline) {
+	if r.FormValue("from") == "pipeline" {
+		http.Redirect(w, r, fmt.Sprintf("/repos/%d/builds/%d", pipeline.RepoID, pipeline.Number), http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/repositories/%d/approvals", pipeline.RepoID), http.StatusFound)
+}
+
+// HandlePipelineByID redirects to the pipeline page by its global ID, pipeline
+// pages were addressed by it before pipelines were numbered.
+func (h *PipelineHandler) HandlePipelineByID(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context(), w)
+	repoID, ok := ownRepoID(w, r, h.s, user.ID)
+	if !ok {
+		return
+	}
+	pipelineID, err := strconv.ParseInt(mux.Vars(r)["pipeline_id"], 10, 64)
+	if err != nil {
+		Error400(w, "Invalid pipeline ID")
+		return
+	}
+
+	pipeline, err := h.s.GetPipeline(r.Context(), pipelineID)
+	if errors.Is(err, store.ErrNotFound) {
+		Error404(w)
+		return
+	}
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot get pipeline", err)
+		return
+	}
+	if pipeline.RepoID != repoID {
+		Error404(w)
+		return
+	}
+
+	url := fmt.Sprintf("/repos/%d/builds/%d", repoID, pipeline.Number)
+	if strings.HasSuffix(r.URL.Path, "/log") {
+		url += "/log"
+	}
+	http.Redirect(w, r, url, http.StatusMovedPermanently)
+}
+
+// repoPipeline returns pipeline from the request URL if it belongs to the repo
+// owned by the user. Otherwise it writes error response.
+func (h *PipelineHandler) repoPipeline(w http.ResponseWriter, r *http.Request, userID int64) (types.Pipeline, bool) {
+	ctx := r.Context()
+	repoID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		Error400(w, "Invalid repo ID")
+		return types.Pipeline{}, false
+	}
+	number, err := strconv.ParseInt(mux.Vars(r)["number"], 10, 64)
+	if err != nil {
+		Error400(w, "Invalid pipeline number")
+		return types.Pipeline{}, false
+	}
+
+	ownRepo, err := h.s.UserOwnRepo(ctx, userID, repoID)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot check if user own repo", err)
+		return types.Pipeline{}, false
+	}
+	if !ownRepo {
+		Error404(w)
+		return types.Pipeline{}, false
+	}
+
+	pipeline, err := h.s.GetRepoPipelineByNumber(ctx, repoID, number)
+	if errors.Is(err, store.ErrNotFound) {
+		Error404(w)
+		return types.Pipeline{}, false
+	}
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot get pipeline", err)
+		return types.Pipeline{}, false
+	}
+
+	return pipeline, true
+}
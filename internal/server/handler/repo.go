@@ -1,28 +1,51 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/csrf"
 	"github.com/gorilla/mux"
+	"golang.org/x/exp/slog"
+
+	"github.com/shark-ci/shark-ci/internal/server/commitstatus"
 	"github.com/shark-ci/shark-ci/internal/server/middleware"
 	"github.com/shark-ci/shark-ci/internal/server/service"
 	"github.com/shark-ci/shark-ci/internal/server/store"
 	"github.com/shark-ci/shark-ci/internal/types"
 	"github.com/shark-ci/shark-ci/templates"
+	"golang.org/x/oauth2"
+)
+
+const (
+	pipelinesPageSize    = 25
+	maxPipelinesPageSize = 100
 )
 
+// reposRegisterPageSize is how many unregistered repositories are shown at
+// once in the register dialog.
+const reposRegisterPageSize = 20
+
 type RepoHandler struct {
-	s        store.Storer
-	services service.Services
+	s         store.Storer
+	services  service.Services
+	reporter  *commitstatus.Reporter
+	repoCache *service.RepoCache
 }
 
-func NewRepoHandler(s store.Storer, services service.Services) *RepoHandler {
+func NewRepoHandler(s store.Storer, services service.Services, reporter *commitstatus.Reporter) *RepoHandler {
 	return &RepoHandler{
-		s:        s,
-		services: services,
+		s:         s,
+		services:  services,
+		reporter:  reporter,
+		repoCache: service.NewRepoCache(),
 	}
 }
 
@@ -43,14 +66,54 @@ func (h *RepoHandler) FetchUnregistredRepos(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	repos, err := srv.GetUserRepos(ctx, serviceUser.Token(), serviceUser.ID)
+	token, ok := h.userToken(ctx, w, srv, serviceUser.ID, *serviceUser.Token())
+	if !ok {
+		return
+	}
+
+	repos, err := h.repoCache.UserRepos(ctx, srv, token, serviceUser.ID)
 	if err != nil {
-		Error5xx(w, http.StatusInternalServerError, "Cannot get user unregistered repos.", err)
+		Error5xx(w, http.StatusInternalServerError, "Cannot get user repos.", err)
 		return
 	}
 
+	repoServiceIDs := make([]int64, 0, len(repos))
+	for _, repo := range repos {
+		repoServiceIDs = append(repoServiceIDs, repo.RepoServiceID)
+	}
+	registered, err := h.s.GetRegisteredRepoServiceIDs(ctx, srv.Name(), repoServiceIDs)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot get registered repos.", err)
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	unregistered := make([]types.Repo, 0, len(repos))
+	for _, repo := range repos {
+		if slices.Contains(registered, repo.RepoServiceID) {
+			continue
+		}
+		if query != "" && !strings.Contains(strings.ToLower(repo.Owner+"/"+repo.Name), strings.ToLower(query)) {
+			continue
+		}
+		unregistered = append(unregistered, repo)
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	start := min((page-1)*reposRegisterPageSize, len(unregistered))
+	end := min(start+reposRegisterPageSize, len(unregistered))
+
 	err = templates.ReposRegisterTmpl.Execute(w, map[string]any{
-		"Repos":          repos,
+		"Service":        srv.Name(),
+		"Repos":          unregistered[start:end],
+		"Query":          query,
+		"Page":           page,
+		"PrevPage":       page - 1,
+		"NextPage":       page + 1,
+		"HasNext":        end < len(unregistered),
 		csrf.TemplateTag: csrf.TemplateField(r),
 	})
 	if err != nil {
@@ -91,20 +154,52 @@ func (h *RepoHandler) HandleRegisterRepo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	hookID, err := srv.CreateWebhook(ctx, serviceUser.Token(), owner, repoName)
-	if err != nil {
-		Error5xx(w, http.StatusInternalServerError, "Cannot create webhook", err)
+	token, ok := h.userToken(ctx, w, srv, serviceUser.ID, *serviceUser.Token())
+	if !ok {
 		return
 	}
 
-	_, err = h.s.CreateRepo(ctx, types.Repo{
+	repo := types.Repo{
 		Service:       srv.Name(),
 		Owner:         owner,
 		Name:          repoName,
 		RepoServiceID: repoID,
-		WebhookID:     hookID,
 		ServiceUserID: serviceUser.ID,
-	})
+	}
+	if im, ok := srv.(service.InstallationManager); ok && im.UsesInstallations() {
+		// App receives events without webhook, but the user must have access
+		// to the repository through app installation.
+		userRepos, err := h.repoCache.UserRepos(ctx, srv, token, serviceUser.ID)
+		if err != nil {
+			Error5xx(w, http.StatusInternalServerError, "Cannot get user repos.", err)
+			return
+		}
+		i := slices.IndexFunc(userRepos, func(r types.Repo) bool { return r.RepoServiceID == repoID })
+		if i == -1 {
+			Error400(w, "App is not installed on the repo")
+			return
+		}
+		repo = userRepos[i]
+	} else {
+		secret, err := service.NewWebhookSecret()
+		if err != nil {
+			Error5xx(w, http.StatusInternalServerError, "Cannot generate webhook secret", err)
+			return
+		}
+		hookID, err := srv.CreateWebhook(ctx, token, owner, repoName, secret)
+		if err != nil {
+			Error5xx(w, http.StatusInternalServerError, "Cannot create webhook", err)
+			return
+		}
+		repo.WebhookID = &hookID
+		repo.WebhookSecret = &secret
+	}
+
+	_, err = h.s.CreateRepo(ctx, repo)
+	if errors.Is(err, store.ErrAlreadyExists) {
+		Error400(w, "Repo is already registered")
+		return
+	}
 	if err != nil {
 		Error5xx(w, http.StatusInternalServerError, "Cannot create repo", err)
 		return
@@ -113,42 +208,96 @@ func (h *RepoHandler) HandleRegisterRepo(w http.ResponseWriter, r *http.Request)
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
-func (h *RepoHandler) HandleDeleteRepo(w http.ResponseWriter, r *http.Request) {
-	//ctx := r.Context()
-	//serviceUser, repo, srv, err := h.getInfoFromRequest(ctx, w, r)
-	//if err != nil {
-	//	slog.Error("cannot get info from request", "err", err)
-	//	w.WriteHeader(http.StatusInternalServerError)
-	//	return
-	//}
-
-	//repo, err = h.s.GetRepo(ctx, repo.ID)
-
-	//err = srv.DeleteWebhook(ctx, serviceUser, repo)
-	//if err != nil {
-	//	slog.Error("service: cannot delete webhook", "err", err)
-	//	w.WriteHeader(http.StatusInternalServerError)
-	//	return
-	//}
-
-	//err = h.s.DeleteRepo(ctx, repo.ID)
-	//if err != nil {
-	//	Error5xx(w, http.StatusInternalServerError, "Cannot delete repo", err)
-	//	return
-	//}
+// HandleRotateWebhookSecret replaces webhook secret of the repository in the
+// store and on the forge. Payloads signed with the previous secret are
+// accepted for a while.
+func (h *RepoHandler) HandleRotateWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := middleware.UserFromContext(ctx, w)
+
+	repoID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		Error400(w, "Invalid repo ID")
+		return
+	}
+
+	ownRepo, err := h.s.UserOwnRepo(ctx, user.ID, repoID)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot check if user own repo", err)
+		return
+	}
+	if !ownRepo {
+		Error404(w)
+		return
+	}
+
+	info, err := h.s.GetRepoWebhookChangeInfo(ctx, repoID)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot get repo", err)
+		return
+	}
+	if info.WebhookID == nil {
+		Error400(w, "Repo is accessed through app installation and has no webhook")
+		return
+	}
+	srv, ok := h.services[info.Service]
+	if !ok {
+		Error400(w, fmt.Sprintf("Unknown service %s", info.Service))
+		return
+	}
+
+	token, ok := h.userToken(ctx, w, srv, info.ServiceUserID, info.Token)
+	if !ok {
+		return
+	}
+
+	err = service.RotateWebhookSecret(ctx, h.s, srv, token, info)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot rotate webhook secret", err)
+		return
+	}
 
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
-func (h *RepoHandler) HandleRepoPipelines(w http.ResponseWriter, r *http.Request) {
+// userToken returns valid token of the service user. Error response is
+// written when it cannot be refreshed.
+func (h *RepoHandler) userToken(ctx context.Context, w http.ResponseWriter, srv service.ServiceManager, serviceUserID int64, userToken oauth2.Token) (*oauth2.Token, bool) {
+	token, err := service.UserToken(ctx, h.s, srv, serviceUserID, userToken)
+	if errors.Is(err, service.ErrReloginRequired) {
+		Error400(w, fmt.Sprintf("Your %s login has expired, log in again.", srv.Name()))
+		return nil, false
+	}
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot refresh token.", err)
+		return nil, false
+	}
+	return &token, true
+}
+
+// HandleDeleteRepo unregisters the repository. Running pipelines are
+// cancelled and their statuses sent before the webhook is removed from the
+// forge. By default pipelines history is kept, in delete mode the repository
+// is deleted with its pipelines.
+func (h *RepoHandler) HandleDeleteRepo(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	user := middleware.UserFromContext(ctx, w)
-	repoID, err := strconv.ParseInt(mux.Vars(r)["repo_id"], 10, 64)
+
+	repoID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
 	if err != nil {
 		Error400(w, "Invalid repo ID")
 		return
 	}
 
+	mode := r.FormValue("mode")
+	if mode == "" {
+		mode = "archive"
+	}
+	if mode != "delete" && mode != "archive" {
+		Error400(w, fmt.Sprintf("Unknown mode %s", mode))
+		return
+	}
+
 	ownRepo, err := h.s.UserOwnRepo(ctx, user.ID, repoID)
 	if err != nil {
 		Error5xx(w, http.StatusInternalServerError, "Cannot check if user own repo", err)
@@ -159,18 +308,197 @@ func (h *RepoHandler) HandleRepoPipelines(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	//pipelines, err := h.s.GetPipelinesByRepo(ctx, repoID)
-	//if err != nil {
-	//	Error5xx(w, http.StatusInternalServerError, "Cannot fetch repo pipelines", err)
-	//	return
-	//}
+	info, err := h.s.GetRepoWebhookChangeInfo(ctx, repoID)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot get repo", err)
+		return
+	}
+	srv, ok := h.services[info.Service]
+	if !ok {
+		Error400(w, fmt.Sprintf("Unknown service %s", info.Service))
+		return
+	}
+
+	var token *oauth2.Token
+	if info.WebhookID != nil {
+		token, ok = h.userToken(ctx, w, srv, info.ServiceUserID, info.Token)
+		if !ok {
+			return
+		}
+	}
+
+	// Workers stop cancelled pipelines once their job tokens are revoked.
+	pipelines, err := h.s.CancelRepoPipelines(ctx, repoID, user.Username, "Repository was unregistered")
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot cancel repo pipelines", err)
+		return
+	}
+	// Statuses are sent right away, the repository loses its credentials
+	// and in delete mode its queued statuses too.
+	for _, pipeline := range pipelines {
+		err = h.reporter.SendNow(ctx, types.CommitStatus{
+			RepoID:      pipeline.RepoID,
+			CommitSHA:   pipeline.CommitSHA,
+			Context:     commitstatus.ContextFor(pipeline.PRNumber),
+			State:       types.Cancelled,
+			TargetURL:   pipeline.URL,
+			Description: fmt.Sprintf("Pipeline #%d was cancelled, repository was unregistered", pipeline.Number),
+			PipelineID:  &pipeline.ID,
+		})
+		if err != nil {
+			slog.Error("Cannot report cancelled pipeline.", "pipelineID", pipeline.ID, "err", err)
+		}
+	}
+
+	if info.WebhookID != nil {
+		// Webhook may be already deleted by the user on the forge.
+		err = srv.DeleteWebhook(ctx, token, info.RepoOwner, info.RepoName, *info.WebhookID)
+		if err != nil && !service.IsNotFound(err) {
+			Error5xx(w, http.StatusInternalServerError, "Cannot delete webhook", err)
+			return
+		}
+	}
+
+	if mode == "archive" {
+		err = h.s.ArchiveRepo(ctx, repoID)
+	} else {
+		err = h.s.DeleteRepo(ctx, repoID)
+	}
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot unregister repo", err)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// HandleRepoPipelines shows pipeline history of the repository.
+func (h *RepoHandler) HandleRepoPipelines(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := middleware.UserFromContext(ctx, w)
+	repoID, ok := ownRepoID(w, r, h.s, user.ID)
+	if !ok {
+		return
+	}
+
+	filter, err := pipelineFilterFromQuery(r.URL.Query())
+	if err != nil {
+		Error400(w, "Invalid filter: "+err.Error())
+		return
+	}
+	pipelines, next, err := h.repoPipelines(ctx, repoID, filter)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot get repo pipelines", err)
+		return
+	}
+
+	var nextURL string
+	if next != nil {
+		q := r.URL.Query()
+		q.Set("before", strconv.FormatInt(*next, 10))
+		nextURL = "?" + q.Encode()
+	}
+
+	err = templates.PipelinesTmpl.Execute(w, map[string]any{
+		"Username":  user.Username,
+		"RepoID":    repoID,
+		"Pipelines": pipelines,
+		"Statuses":  types.PipelineStatuses,
+		"Filter":    r.URL.Query(),
+		"NextURL":   nextURL,
+	})
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot execute template.", err)
+		return
+	}
+}
+
+// ownRepoID returns ID of the repository from URL. Error response is written
+// when the user does not own it.
+func ownRepoID(w http.ResponseWriter, r *http.Request, s store.Storer, userID int64) (int64, bool) {
+	ctx := r.Context()
+	repoID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		Error400(w, "Invalid repo ID")
+		return 0, false
+	}
+
+	ownRepo, err := s.UserOwnRepo(ctx, userID, repoID)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot check if user own repo", err)
+		return 0, false
+	}
+	if !ownRepo {
+		Error404(w)
+		return 0, false
+	}
+	return repoID, true
+}
+
+// repoPipelines returns page of pipelines and ID of the last one when there
+// is next page.
+func (h *RepoHandler) repoPipelines(ctx context.Context, repoID int64, filter types.PipelineFilter) ([]types.Pipeline, *int64, error) {
+	pageSize := filter.Limit
+	// One more pipeline is fetched to find out if there is next page.
+	filter.Limit++
+	pipelines, err := h.s.GetRepoPipelines(ctx, repoID, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(pipelines) <= int(pageSize) {
+		return pipelines, nil, nil
+	}
+	pipelines = pipelines[:pageSize]
+	return pipelines, &pipelines[pageSize-1].ID, nil
+}
+
+// pipelineFilterFromQuery parses filter of pipelines from query parameters
+// status, branch, from and to (dates, both inclusive), before and limit.
+func pipelineFilterFromQuery(q url.Values) (types.PipelineFilter, error) {
+	filter := types.PipelineFilter{Limit: pipelinesPageSize}
 
-	// TODO: Create template for this
-	//err = templates..Execute(w, map[string]any{
-	//	"Pipelines":          pipelines,
-	//})
-	//if err != nil {
-	//	Error5xx(w, http.StatusInternalServerError, "Cannot execute template.", err)
-	//	return
-	//}
+	if status := q.Get("status"); status != "" {
+		s := types.PipelineStatus(status)
+		if !slices.Contains(types.PipelineStatuses, s) {
+			return filter, fmt.Errorf("unknown status %s", status)
+		}
+		filter.Status = &s
+	}
+	if branch := q.Get("branch"); branch != "" {
+		filter.Branch = &branch
+	}
+	if from := q.Get("from"); from != "" {
+		t, err := time.Parse(time.DateOnly, from)
+		if err != nil {
+			return filter, fmt.Errorf("invalid date %s", from)
+		}
+		filter.From = &t
+	}
+	if to := q.Get("to"); to != "" {
+		t, err := time.Parse(time.DateOnly, to)
+		if err != nil {
+			return filter, fmt.Errorf("invalid date %s", to)
+		}
+		t = t.AddDate(0, 0, 1)
+		filter.To = &t
+	}
+	if before := q.Get("before"); before != "" {
+		id, err := strconv.ParseInt(before, 10, 64)
+		if err != nil {
+			return filter, errors.New("invalid pipeline ID")
+		}
+		filter.BeforeID = &id
+	}
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPipelinesPageSize {
+			return filter, fmt.Errorf("limit must be between 1 and %d", maxPipelinesPageSize)
+		}
+		filter.Limit = int32(n)
+	}
+	return filter, nil
 }
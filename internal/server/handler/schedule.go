@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+
+	"github.com/shark-ci/shark-ci/internal/server/event"
+	"github.com/shark-ci/shark-ci/internal/server/middleware"
+	"github.com/shark-ci/shark-ci/internal/server/schedule"
+	"github.com/shark-ci/shark-ci/internal/server/store"
+	"github.com/shark-ci/shark-ci/internal/types"
+	"github.com/shark-ci/shark-ci/templates"
+)
+
+type ScheduleHandler struct {
+	s         store.Storer
+	processor *event.Processor
+}
+
+func NewScheduleHandler(s store.Storer, processor *event.Processor) *ScheduleHandler {
+	return &ScheduleHandler{
+		s:         s,
+		processor: processor,
+	}
+}
+
+// HandleSchedules shows schedules of the repository with their next and last
+// runs.
+func (h *ScheduleHandler) HandleSchedules(w http.ResponseWriter, r *http.Request) {
+	user := middleware.UserFromContext(r.Context(), w)
+	repoID, ok := ownRepoID(w, r, h.s, user.ID)
+	if !ok {
+		return
+	}
+
+	h.renderSchedules(w, r, http.StatusOK, user.Username, repoID, "")
+}
+
+// HandleCreateSchedule adds schedule of the branch from the form.
+func (h *ScheduleHandler) HandleCreateSchedule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := middleware.UserFromContext(ctx, w)
+	repoID, ok := ownRepoID(w, r, h.s, user.ID)
+	if !ok {
+		return
+	}
+
+	sched := types.Schedule{
+		RepoID:   repoID,
+		Branch:   strings.TrimSpace(r.FormValue("branch")),
+		Cron:     strings.TrimSpace(r.FormValue("cron")),
+		Timezone: strings.TrimSpace(r.FormValue("timezone")),
+	}
+	if sched.Timezone == "" {
+		sched.Timezone = "UTC"
+	}
+	if sched.Branch == "" {
+		h.renderSchedules(w, r, http.StatusBadRequest, user.Username, repoID, "Branch is required")
+		return
+	}
+	next, err := sched.Next(time.Now())
+	if err != nil {
+		h.renderSchedules(w, r, http.StatusBadRequest, user.Username, repoID, err.Error())
+		return
+	}
+	sched.NextRunAt = next
+
+	_, err = h.s.CreateSchedule(ctx, sched)
+	if errors.Is(err, store.ErrAlreadyExists) {
+		h.renderSchedules(w, r, http.StatusBadRequest, user.Username, repoID, "The branch already has this schedule")
+		return
+	}
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot create schedule", err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/repositories/%d/schedules", repoID), http.StatusFound)
+}
+
+// HandleSyncWorkflowSchedules replaces schedules declared in the workflow of
+// the branch by schedules of its current workflow. They are replaced on each
+// push too, this picks up schedules of branches which were not pushed since.
+func (h *ScheduleHandler) HandleSyncWorkflowSchedules(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := middleware.UserFromContext(ctx, w)
+	repoID, ok := ownRepoID(w, r, h.s, user.ID)
+	if !ok {
+		return
+	}
+	branch := strings.TrimSpace(r.FormValue("branch"))
+	if branch == "" {
+		h.renderSchedules(w, r, http.StatusBadRequest, user.Username, repoID, "Branch is required")
+		return
+	}
+
+	target, err := h.processor.Resolve(ctx, repoID, branch)
+	if err != nil {
+		h.syncError(w, r, user.Username, repoID, err)
+		return
+	}
+	if !strings.HasPrefix(target.Commit.Ref, "refs/heads/") {
+		h.renderSchedules(w, r, http.StatusBadRequest, user.Username, repoID, "Branch not found")
+		return
+	}
+	var workflow []byte
+	if target.Workflow != nil {
+		workflow = []byte(*target.Workflow)
+	}
+	err = schedule.SyncWorkflow(ctx, h.s, repoID, branch, time.Now(), workflow)
+	if err != nil {
+		h.syncError(w, r, user.Username, repoID, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/repositories/%d/schedules", repoID), http.StatusFound)
+}
+
+// HandleDeleteSchedule deletes schedule of the repository. Schedule declared
+// in the workflow comes back when the workflow is pushed again.
+func (h *ScheduleHandler) HandleDeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := middleware.UserFromContext(ctx, w)
+	repoID, ok := ownRepoID(w, r, h.s, user.ID)
+	if !ok {
+		return
+	}
+	scheduleID, err := strconv.ParseInt(mux.Vars(r)["schedule_id"], 10, 64)
+	if err != nil {
+		Error400(w, "Invalid schedule ID")
+		return
+	}
+
+	err = h.s.DeleteSchedule(ctx, repoID, scheduleID)
+	if errors.Is(err, store.ErrNotFound) {
+		Error404(w)
+		return
+	}
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot delete schedule", err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/repositories/%d/schedules", repoID), http.StatusFound)
+}
+
+func (h *ScheduleHandler) syncError(w http.ResponseWriter, r *http.Request, username string, repoID int64, err error) {
+	if msgs := triggerErrors(err); msgs != nil {
+		h.renderSchedules(w, r, http.StatusBadRequest, username, repoID, strings.Join(msgs, "; "))
+		return
+	}
+	Error5xx(w, http.StatusInternalServerError, "Cannot sync workflow schedules", err)
+}
+
+// renderSchedules shows schedules page, errMsg is error of the submitted form.
+func (h *ScheduleHandler) renderSchedules(w http.ResponseWriter, r *http.Request, status int, username string, repoID int64, errMsg string) {
+	schedules, err := h.s.GetRepoSchedules(r.Context(), repoID)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot get repo schedules", err)
+		return
+	}
+
+	w.WriteHeader(status)
+	err = templates.SchedulesTmpl.Execute(w, map[string]any{
+		"Username":       username,
+		"RepoID":         repoID,
+		"Schedules":      schedules,
+		"Error":          errMsg,
+		"Form":           r.PostForm,
+		csrf.TemplateTag: csrf.TemplateField(r),
+	})
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot execute template.", err)
+		return
+	}
+}
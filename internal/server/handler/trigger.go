@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/csrf"
+
+	"github.com/shark-ci/shark-ci/internal/server/event"
+	"github.com/shark-ci/shark-ci/internal/server/middleware"
+	"github.com/shark-ci/shark-ci/internal/server/service"
+	"github.com/shark-ci/shark-ci/internal/types"
+	"github.com/shark-ci/shark-ci/templates"
+)
+
+// HandleTriggerForm shows form for running pipeline of branch or tag. Inputs
+// declared in the workflow are shown once the ref is chosen.
+func (h *PipelineHandler) HandleTriggerForm(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := middleware.UserFromContext(ctx, w)
+	repoID, ok := ownRepoID(w, r, h.s, user.ID)
+	if !ok {
+		return
+	}
+
+	data := map[string]any{
+		"Username":       user.Username,
+		"RepoID":         repoID,
+		"Ref":            r.URL.Query().Get("ref"),
+		csrf.TemplateTag: csrf.TemplateField(r),
+	}
+	status := http.StatusOK
+	if ref := r.URL.Query().Get("ref"); ref != "" {
+		target, err := h.processor.Resolve(ctx, repoID, ref)
+		if msgs := triggerErrors(err); msgs != nil {
+			data["Errors"] = msgs
+			status = http.StatusBadRequest
+		} else if err != nil {
+			Error5xx(w, http.StatusInternalServerError, "Cannot resolve branch or tag", err)
+			return
+		} else {
+			values := map[string]string{}
+			for _, input := range target.Inputs {
+				values[input.Name] = input.Default
+			}
+			data["Target"] = target
+			data["Values"] = values
+		}
+	}
+
+	renderTriggerForm(w, status, data)
+}
+
+// HandleTriggerPipeline runs pipeline of branch or tag with inputs from the
+// form and shows it. Invalid inputs are shown in the form.
+func (h *PipelineHandler) HandleTriggerPipeline(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := middleware.UserFromContext(ctx, w)
+	repoID, ok := ownRepoID(w, r, h.s, user.ID)
+	if !ok {
+		return
+	}
+	ref := r.FormValue("ref")
+	if ref == "" {
+		Error400(w, "Branch or tag is required")
+		return
+	}
+
+	data := map[string]any{
+		"Username":       user.Username,
+		"RepoID":         repoID,
+		"Ref":            ref,
+		csrf.TemplateTag: csrf.TemplateField(r),
+	}
+	target, err := h.processor.Resolve(ctx, repoID, ref)
+	if msgs := triggerErrors(err); msgs != nil {
+		data["Errors"] = msgs
+		renderTriggerForm(w, http.StatusBadRequest, data)
+		return
+	}
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot resolve branch or tag", err)
+		return
+	}
+
+	values := map[string]string{}
+	for _, input := range target.Inputs {
+		if v, ok := r.PostForm["input."+input.Name]; ok {
+			values[input.Name] = v[0]
+		}
+	}
+	pipeline, err := h.processor.Trigger(ctx, target, types.EventManual, user.Username, values)
+	if msgs := triggerErrors(err); msgs != nil {
+		data["Errors"] = msgs
+		data["Target"] = target
+		data["Values"] = values
+		renderTriggerForm(w, http.StatusBadRequest, data)
+		return
+	}
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot run pipeline", err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/repos/%d/builds/%d", pipeline.RepoID, pipeline.Number), http.StatusFound)
+}
+
+func renderTriggerForm(w http.ResponseWriter, status int, data map[string]any) {
+	w.WriteHeader(status)
+	err := templates.TriggerTmpl.Execute(w, data)
+	if err != nil {
+		Error5xx(w, http.StatusInternalServerError, "Cannot execute template.", err)
+	}
+}
+
+// triggerErrors returns messages for user of errors caused by the trigger
+// request, nil for other errors.
+func triggerErrors(err error) []string {
+	var inputsErr *types.InputsError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &inputsErr):
+		msgs := make([]string, 0, len(inputsErr.Errs))
+		for _, e := range inputsErr.Errs {
+			msgs = append(msgs, e.Error())
+		}
+		return msgs
+	case errors.Is(err, service.ErrRefNotFound):
+		return []string{"Branch or tag not found"}
+	case errors.Is(err, types.ErrInvalidWorkflow), errors.Is(err, event.ErrRepoArchived):
+		return []string{err.Error()}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,51 @@
+// Package jobtoken issues tokens with which workers authenticate requests of
+// a single pipeline.
+package jobtoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"time"
+)
+
+// TTL is how long is the token of queued pipeline valid. It covers waiting in
+// the queue, the token is revoked as soon as the pipeline finishes.
+const TTL = 24 * time.Hour
+
+// RunTTL is how long is the token of running pipeline valid after its start or
+// last heartbeat, so the token of long running pipeline does not expire while
+// its worker is alive.
+const RunTTL = 10 * time.Minute
+
+// State of the job token presented by the worker.
+type State int
+
+const (
+	// Invalid token does not belong to the pipeline.
+	Invalid State = iota
+	Valid
+	// Expired token belongs to the pipeline whose worker did not report it in
+	// time.
+	Expired
+	// Revoked token belongs to the pipeline which was cancelled or finished.
+	Revoked
+)
+
+// New generates random token. Only hash of the token should be stored.
+func New() (token string, hash []byte, err error) {
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", nil, err
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, Hash(token), nil
+}
+
+// Hash returns hash of the token under which is the token stored.
+func Hash(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
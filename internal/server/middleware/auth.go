@@ -7,28 +7,53 @@ import (
 
 	"github.com/shark-ci/shark-ci/internal/server/session"
 	"github.com/shark-ci/shark-ci/internal/server/store"
+	"github.com/shark-ci/shark-ci/internal/types"
 )
 
 func AuthMiddleware(s store.Storer) mux.MiddlewareFunc {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			sess, _ := session.Store.Get(r, "session")
-			id, ok := sess.Values[session.SessionKey].(int64)
+			user, ok := userFromSession(s, r)
 			if !ok {
 				http.Redirect(w, r, "/login", http.StatusFound)
 				return
 			}
 
-			ctx := r.Context()
-			user, err := s.GetUser(ctx, id)
-			if err != nil {
-				http.Redirect(w, r, "/login", http.StatusFound)
+			ctx := ContextWithUser(r.Context(), user)
+			r = r.WithContext(ctx)
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// APIAuthMiddleware responds with 401 instead of redirecting to login page.
+func APIAuthMiddleware(s store.Storer) mux.MiddlewareFunc {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := userFromSession(s, r)
+			if !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":"login required"}`))
 				return
 			}
 
-			ctx = ContextWithUser(ctx, user)
+			ctx := ContextWithUser(r.Context(), user)
 			r = r.WithContext(ctx)
 			h.ServeHTTP(w, r)
 		})
 	}
 }
+
+func userFromSession(s store.Storer, r *http.Request) (types.User, bool) {
+	sess, _ := session.Store.Get(r, "session")
+	id, ok := sess.Values[session.SessionKey].(int64)
+	if !ok {
+		return types.User{}, false
+	}
+
+	user, err := s.GetUser(r.Context(), id)
+	if err != nil {
+		return types.User{}, false
+	}
+	return user, true
+}
@@ -0,0 +1,22 @@
+package retry
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Backoff returns delay before next attempt. Delay grows exponentially with
+// attempt number (starting at 1), is capped at maxDelay and jittered so retries of
+// many failed jobs do not happen at once.
+func Backoff(attempt int, base time.Duration, maxDelay time.Duration) time.Duration {
+	d := base
+	for i := 1; i < attempt && d < maxDelay; i++ {
+		d *= 2
+	}
+	if d > maxDelay {
+		d = maxDelay
+	}
+
+	half := d / 2
+	return half + rand.N(half+1)
+}
@@ -0,0 +1,170 @@
+// Package schedule runs pipelines of repository schedules when they are due.
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/shark-ci/shark-ci/internal/server/event"
+	"github.com/shark-ci/shark-ci/internal/server/store"
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+const (
+	// batchSize is how many due schedules are claimed each poll.
+	batchSize    = 20
+	pollInterval = 30 * time.Second
+	// claimTimeout is how long claimed run may take before it is claimed
+	// again, e.g. because the server crashed while running it.
+	claimTimeout = 10 * time.Minute
+	// retryDelay is next run of schedule whose next run cannot be computed,
+	// e.g. because its timezone is no longer known.
+	retryDelay = 24 * time.Hour
+)
+
+// Scheduler creates pipelines of due schedules. Runs missed while no server
+// was running are not caught up, the schedule runs once and continues with
+// its next run. Run claimed by server which crashed before saving its result
+// is claimed again, it creates its pipeline unless it already did, so each run
+// creates at most one pipeline.
+type Scheduler struct {
+	s         store.Storer
+	processor triggerer
+}
+
+// triggerer creates pipelines, it is implemented by event.Processor.
+type triggerer interface {
+	Resolve(ctx context.Context, repoID int64, ref string) (event.Target, error)
+	TriggerSchedule(ctx context.Context, target event.Target, scheduleID int64, runAt time.Time) (*types.Pipeline, error)
+	Start(ctx context.Context, pipeline *types.Pipeline) error
+}
+
+func NewScheduler(s store.Storer, processor *event.Processor) *Scheduler {
+	return &Scheduler{
+		s:         s,
+		processor: processor,
+	}
+}
+
+func (sc *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		sc.runDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (sc *Scheduler) runDue(ctx context.Context) {
+	schedules, err := sc.s.ClaimDueSchedules(ctx, batchSize, claimTimeout, next)
+	if err != nil {
+		slog.Error("Cannot claim due schedules.", "err", err)
+		return
+	}
+
+	for _, schedule := range schedules {
+		var pipelineID *int64
+		var errMsg *string
+		pipeline, err := sc.run(ctx, schedule)
+		if err != nil {
+			slog.Warn("Scheduled pipeline was not created.", "scheduleID", schedule.ID, "repoID", schedule.RepoID, "err", err)
+			msg := err.Error()
+			errMsg = &msg
+		} else {
+			pipelineID = &pipeline.ID
+		}
+
+		err = sc.s.SetScheduleResult(ctx, schedule.ID, pipelineID, errMsg)
+		if err != nil {
+			slog.Error("Cannot set schedule result.", "scheduleID", schedule.ID, "err", err)
+		}
+	}
+}
+
+// run creates pipeline of the head of the schedule branch for the claimed run
+// at schedule.LastRunAt. Inputs of the workflow get their defaults. Pipeline
+// the run already created is returned instead, it is sent to workers if the
+// crashed server did not send it.
+func (sc *Scheduler) run(ctx context.Context, schedule types.Schedule) (*types.Pipeline, error) {
+	if schedule.LastRunAt == nil {
+		return nil, errors.New("claimed schedule has no run")
+	}
+	runAt := *schedule.LastRunAt
+
+	// Run reclaimed from crashed server may have created its pipeline.
+	pipeline, err := sc.s.GetSchedulePipeline(ctx, schedule.ID, runAt)
+	if err == nil {
+		if pipeline.Status == types.Queued && pipeline.SentAt == nil {
+			return &pipeline, sc.processor.Start(ctx, &pipeline)
+		}
+		return &pipeline, nil
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+
+	target, err := sc.processor.Resolve(ctx, schedule.RepoID, schedule.Branch)
+	if err != nil {
+		return nil, err
+	}
+	created, err := sc.processor.TriggerSchedule(ctx, target, schedule.ID, runAt)
+	if errors.Is(err, store.ErrAlreadyExists) {
+		// Server whose claim was considered stale created it meanwhile.
+		pipeline, err = sc.s.GetSchedulePipeline(ctx, schedule.ID, runAt)
+		if err != nil {
+			return nil, err
+		}
+		return &pipeline, nil
+	}
+	return created, err
+}
+
+// next returns the next run of the claimed schedule, it is computed from now,
+// so runs are not caught up.
+func next(schedule types.Schedule) time.Time {
+	now := time.Now()
+	t, err := schedule.Next(now)
+	if err != nil {
+		slog.Warn("Cannot compute next run of schedule.", "scheduleID", schedule.ID, "err", err)
+		return now.Add(retryDelay).UTC()
+	}
+	return t
+}
+
+// SyncWorkflow replaces schedules of the branch declared in the workflow by
+// schedules in on.schedule of the new workflow pushed at the time. Nil
+// workflow removes them. Workflow pushed before the last synced one is
+// ignored, so late reports cannot bring back old schedules.
+func SyncWorkflow(ctx context.Context, s store.Storer, repoID int64, branch string, at time.Time, workflow []byte) error {
+	declared, err := types.ParseWorkflowSchedules(workflow)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	schedules := make([]types.Schedule, 0, len(declared))
+	for _, d := range declared {
+		schedule := types.Schedule{
+			RepoID:       repoID,
+			Branch:       branch,
+			Cron:         d.Cron,
+			Timezone:     d.Timezone,
+			FromWorkflow: true,
+		}
+		schedule.NextRunAt, err = schedule.Next(now)
+		if err != nil {
+			return fmt.Errorf("%w: %w", types.ErrInvalidWorkflow, err)
+		}
+		schedules = append(schedules, schedule)
+	}
+	return s.SyncWorkflowSchedules(ctx, repoID, branch, at, schedules)
+}
@@ -0,0 +1,179 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shark-ci/shark-ci/internal/server/event"
+	"github.com/shark-ci/shark-ci/internal/server/service"
+	"github.com/shark-ci/shark-ci/internal/server/store"
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+// fakeStore hands out due schedules and records results of their runs.
+type fakeStore struct {
+	store.Storer
+	due          []types.Schedule
+	claimErr     error
+	claimTimeout time.Duration
+	results      map[int64]result
+	// runs are pipelines created by runs of schedules.
+	runs     map[int64]types.Pipeline
+	synced   []types.Schedule
+	syncedAt time.Time
+}
+
+type result struct {
+	pipelineID *int64
+	errMsg     *string
+}
+
+func (s *fakeStore) ClaimDueSchedules(ctx context.Context, limit int32, claimTimeout time.Duration, next func(schedule types.Schedule) time.Time) ([]types.Schedule, error) {
+	s.claimTimeout = claimTimeout
+	if s.claimErr != nil {
+		return nil, s.claimErr
+	}
+	claimed := s.due
+	s.due = nil
+	return claimed, nil
+}
+
+func (s *fakeStore) SetScheduleResult(ctx context.Context, scheduleID int64, pipelineID *int64, errMsg *string) error {
+	s.results[scheduleID] = result{pipelineID, errMsg}
+	return nil
+}
+
+func (s *fakeStore) GetSchedulePipeline(ctx context.Context, scheduleID int64, runAt time.Time) (types.Pipeline, error) {
+	pipeline, ok := s.runs[scheduleID]
+	if !ok || !pipeline.CreatedAt.Equal(runAt) {
+		return types.Pipeline{}, store.ErrNotFound
+	}
+	return pipeline, nil
+}
+
+func (s *fakeStore) SyncWorkflowSchedules(ctx context.Context, repoID int64, branch string, at time.Time, schedules []types.Schedule) error {
+	s.synced = schedules
+	s.syncedAt = at
+	return nil
+}
+
+// fakeProcessor creates pipelines of existing branches.
+type fakeProcessor struct {
+	branches  map[string]string
+	triggered []string
+	started   []int64
+}
+
+func (p *fakeProcessor) Resolve(ctx context.Context, repoID int64, ref string) (event.Target, error) {
+	sha, ok := p.branches[ref]
+	if !ok {
+		return event.Target{}, service.ErrRefNotFound
+	}
+	return event.Target{RepoID: repoID, Commit: service.Commit{SHA: sha, Ref: "refs/heads/" + ref}}, nil
+}
+
+func (p *fakeProcessor) TriggerSchedule(ctx context.Context, target event.Target, scheduleID int64, runAt time.Time) (*types.Pipeline, error) {
+	p.triggered = append(p.triggered, target.Commit.SHA)
+	return &types.Pipeline{ID: int64(len(p.triggered)), RepoID: target.RepoID, CommitSHA: target.Commit.SHA, CreatedAt: runAt}, nil
+}
+
+func (p *fakeProcessor) Start(ctx context.Context, pipeline *types.Pipeline) error {
+	p.started = append(p.started, pipeline.ID)
+	return nil
+}
+
+func TestRunDue(t *testing.T) {
+	runAt := time.Now().Add(-time.Minute)
+	s := &fakeStore{
+		due: []types.Schedule{
+			{ID: 1, RepoID: 1, Branch: "main", Cron: "@daily", Timezone: "UTC", LastRunAt: &runAt},
+			{ID: 2, RepoID: 1, Branch: "deleted", Cron: "@daily", Timezone: "UTC", LastRunAt: &runAt},
+			// Runs reclaimed from crashed server which created their pipelines.
+			{ID: 3, RepoID: 1, Branch: "main", Cron: "@hourly", Timezone: "UTC", LastRunAt: &runAt},
+			{ID: 4, RepoID: 1, Branch: "main", Cron: "@hourly", Timezone: "UTC", LastRunAt: &runAt},
+		},
+		results: map[int64]result{},
+		runs: map[int64]types.Pipeline{
+			3: {ID: 9, Status: types.Queued, CreatedAt: runAt, SentAt: &runAt},
+			4: {ID: 10, Status: types.Queued, CreatedAt: runAt},
+		},
+	}
+	p := &fakeProcessor{branches: map[string]string{"main": "abc"}}
+	sc := &Scheduler{s: s, processor: p}
+
+	sc.runDue(context.Background())
+	if s.claimTimeout != claimTimeout {
+		t.Errorf("claimed with timeout %v, want %v", s.claimTimeout, claimTimeout)
+	}
+	if len(p.triggered) != 1 || p.triggered[0] != "abc" {
+		t.Errorf("triggered pipelines of %v, want abc", p.triggered)
+	}
+	if r, ok := s.results[1]; !ok || r.pipelineID == nil || *r.pipelineID != 1 || r.errMsg != nil {
+		t.Errorf("result of schedule 1 = %+v, want pipeline 1", r)
+	}
+	// Failed run is finished with its error, so it is not run again.
+	if r, ok := s.results[2]; !ok || r.pipelineID != nil || r.errMsg == nil {
+		t.Errorf("result of schedule 2 = %+v, want error", r)
+	}
+	if r, ok := s.results[3]; !ok || r.pipelineID == nil || *r.pipelineID != 9 {
+		t.Errorf("result of schedule 3 = %+v, want pipeline 9 of its run", r)
+	}
+	if r, ok := s.results[4]; !ok || r.pipelineID == nil || *r.pipelineID != 10 {
+		t.Errorf("result of schedule 4 = %+v, want pipeline 10 of its run", r)
+	}
+	if len(p.started) != 1 || p.started[0] != 10 {
+		t.Errorf("started pipelines %v, want only unsent pipeline 10", p.started)
+	}
+
+	s.claimErr = errors.New("database is down")
+	s.results = map[int64]result{}
+	sc.runDue(context.Background())
+	if len(s.results) != 0 || len(p.triggered) != 1 {
+		t.Errorf("runDue() without claimed schedules set results %v", s.results)
+	}
+}
+
+func TestNext(t *testing.T) {
+	now := time.Now()
+	got := next(types.Schedule{Cron: "*/5 * * * *", Timezone: "UTC"})
+	if !got.After(now) || got.After(now.Add(5*time.Minute)) {
+		t.Errorf("next() = %v, want within 5 minutes after %v", got, now)
+	}
+
+	// Schedule is retried later when its next run cannot be computed.
+	got = next(types.Schedule{Cron: "@daily", Timezone: "Nowhere/Unknown"})
+	if got.Before(now.Add(retryDelay)) || got.After(time.Now().Add(retryDelay)) {
+		t.Errorf("next() of unknown timezone = %v, want in %v", got, retryDelay)
+	}
+}
+
+func TestSyncWorkflow(t *testing.T) {
+	s := &fakeStore{}
+	pushedAt := time.Now().Add(-time.Minute)
+	workflow := []byte("on:\n  schedule:\n    - cron: \"0 3 * * *\"\n      timezone: Europe/Prague\n")
+
+	err := SyncWorkflow(context.Background(), s, 1, "main", pushedAt, workflow)
+	if err != nil {
+		t.Fatalf("SyncWorkflow() error = %v", err)
+	}
+	if !s.syncedAt.Equal(pushedAt) || len(s.synced) != 1 {
+		t.Fatalf("synced %+v at %v, want one schedule at %v", s.synced, s.syncedAt, pushedAt)
+	}
+	sched := s.synced[0]
+	if !sched.FromWorkflow || sched.Branch != "main" || sched.Cron != "0 3 * * *" || sched.Timezone != "Europe/Prague" || !sched.NextRunAt.After(time.Now()) {
+		t.Errorf("synced schedule %+v", sched)
+	}
+
+	// Branch without workflow has no workflow schedules.
+	err = SyncWorkflow(context.Background(), s, 1, "main", time.Now(), nil)
+	if err != nil || len(s.synced) != 0 {
+		t.Errorf("SyncWorkflow() of missing workflow = %v, synced %+v", err, s.synced)
+	}
+
+	err = SyncWorkflow(context.Background(), s, 1, "main", time.Now(), []byte("on:\n  schedule:\n    - cron: \"bad\"\n"))
+	if !errors.Is(err, types.ErrInvalidWorkflow) {
+		t.Errorf("SyncWorkflow() of invalid cron error = %v, want %v", err, types.ErrInvalidWorkflow)
+	}
+}
@@ -0,0 +1,531 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+
+	"github.com/shark-ci/shark-ci/internal/config"
+	"github.com/shark-ci/shark-ci/internal/server/store"
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+// giteaPageSize is default maximum page size of Gitea API.
+const giteaPageSize = 50
+
+// GiteaManager manages repositories on Gitea and Gitea compatible forges like
+// Forgejo.
+type GiteaManager struct {
+	s            store.Storer
+	baseURL      string
+	oauth2Config *oauth2.Config
+}
+
+var _ ServiceManager = &GiteaManager{}
+
+// NewGiteaManager creates manager for Gitea instance running at baseURL.
+func NewGiteaManager(baseURL string, clientID string, clientSecret string, s store.Storer) *GiteaManager {
+	baseURL = strings.TrimRight(baseURL, "/")
+	return &GiteaManager{
+		s:       s,
+		baseURL: baseURL,
+		oauth2Config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Scopes:       []string{"read:user", "write:repository"},
+			RedirectURL:  config.ServerConf.Host + "/oauth2/callback?service=" + string(types.ServiceGitea),
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  baseURL + "/login/oauth/authorize",
+				TokenURL: baseURL + "/login/oauth/access_token",
+			},
+		},
+	}
+}
+
+func (*GiteaManager) Name() types.Service {
+	return types.ServiceGitea
+}
+
+func (*GiteaManager) StatusName(status types.PipelineStatus) string {
+	switch status {
+	case types.Success:
+		return "success"
+	case types.Queued:
+		return "pending"
+	case types.Running:
+		return "pending"
+	case types.Failure, types.TimedOut:
+		return "failure"
+	case types.Error, types.Cancelled:
+		return "error"
+	case types.Skipped:
+		return "success"
+	// Unknown status never lets the commit pass.
+	default:
+		return "error"
+	}
+}
+
+func (m *GiteaManager) OAuth2Config() *oauth2.Config {
+	return m.oauth2Config
+}
+
+func (m *GiteaManager) GetServiceUser(ctx context.Context, token *oauth2.Token) (types.ServiceUser, error) {
+	var user struct {
+		Login string `json:"login"`
+		Email string `json:"email"`
+	}
+	err := m.client(ctx, token).do(ctx, http.MethodGet, "/user", nil, &user)
+	if err != nil {
+		return types.ServiceUser{}, err
+	}
+
+	serviceUser := types.ServiceUser{
+		Username:    user.Login,
+		Email:       user.Email,
+		Service:     m.Name(),
+		AccessToken: token.AccessToken,
+		TokenType:   token.TokenType,
+	}
+	if token.RefreshToken != "" {
+		serviceUser.RefreshToken = &token.RefreshToken
+	}
+	if !token.Expiry.IsZero() {
+		serviceUser.TokenExpire = &token.Expiry
+	}
+	return serviceUser, nil
+}
+
+// GetUserRepos returns repositories where user is admin so they can create
+// webhooks.
+func (m *GiteaManager) GetUserRepos(ctx context.Context, token *oauth2.Token, serviceUserID int64) ([]types.Repo, error) {
+	client := m.client(ctx, token)
+
+	var repos []types.Repo
+	for page := 1; ; page++ {
+		var r []struct {
+			ID       int64  `json:"id"`
+			Name     string `json:"name"`
+			Archived bool   `json:"archived"`
+			Owner    struct {
+				Login string `json:"login"`
+			} `json:"owner"`
+			Permissions struct {
+				Admin bool `json:"admin"`
+			} `json:"permissions"`
+		}
+		err := client.do(ctx, http.MethodGet, fmt.Sprintf("/user/repos?limit=%d&page=%d", giteaPageSize, page), nil, &r)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, repo := range r {
+			if repo.Archived || !repo.Permissions.Admin {
+				continue
+			}
+			repos = append(repos, types.Repo{
+				RepoServiceID: repo.ID,
+				Name:          repo.Name,
+				Owner:         repo.Owner.Login,
+				Service:       m.Name(),
+				ServiceUserID: serviceUserID,
+			})
+		}
+		if len(r) < giteaPageSize {
+			return repos, nil
+		}
+	}
+}
+
+// GetRepo of Gitea is used to find renamed and transferred repositories,
+// repository webhooks are not sent when it happens.
+func (m *GiteaManager) GetRepo(ctx context.Context, token *oauth2.Token, repoServiceID int64) (types.Repo, error) {
+	var repo struct {
+		ID    int64  `json:"id"`
+		Name  string `json:"name"`
+		Owner struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+		CloneURL string `json:"clone_url"`
+		Archived bool   `json:"archived"`
+		Private  bool   `json:"private"`
+	}
+	err := m.client(ctx, token).do(ctx, http.MethodGet, fmt.Sprintf("/repositories/%d", repoServiceID), nil, &repo)
+	if err != nil {
+		return types.Repo{}, err
+	}
+
+	return types.Repo{
+		Service:       m.Name(),
+		Owner:         repo.Owner.Login,
+		Name:          repo.Name,
+		RepoServiceID: repo.ID,
+		CloneURL:      repo.CloneURL,
+		ForgeArchived: repo.Archived,
+		Private:       repo.Private,
+	}, nil
+}
+
+func (m *GiteaManager) CreateWebhook(ctx context.Context, token *oauth2.Token, owner string, repoName string, secret string) (int64, error) {
+	hook := m.hook(secret)
+	hook["type"] = "gitea"
+
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	err := m.client(ctx, token).do(ctx, http.MethodPost, repoPath(owner, repoName)+"/hooks", hook, &created)
+	if err != nil {
+		return 0, err
+	}
+
+	return created.ID, nil
+}
+
+func (m *GiteaManager) UpdateWebhookSecret(ctx context.Context, token *oauth2.Token, owner string, repoName string, webhookID int64, secret string) error {
+	endpoint := fmt.Sprintf("%s/hooks/%d", repoPath(owner, repoName), webhookID)
+	return m.client(ctx, token).do(ctx, http.MethodPatch, endpoint, map[string]any{"config": m.hookConfig(secret)}, nil)
+}
+
+func (m *GiteaManager) ExpectedWebhook() types.Webhook {
+	return types.Webhook{
+		URL:         config.ServerConf.Host + "/event_handler/" + string(m.Name()),
+		ContentType: "json",
+		Events:      []string{"push", "pull_request"},
+		Active:      true,
+	}
+}
+
+func (m *GiteaManager) GetWebhook(ctx context.Context, token *oauth2.Token, owner string, repoName string, webhookID int64) (types.Webhook, error) {
+	var hook struct {
+		ID     int64             `json:"id"`
+		Config map[string]string `json:"config"`
+		Events []string          `json:"events"`
+		Active bool              `json:"active"`
+	}
+	endpoint := fmt.Sprintf("%s/hooks/%d", repoPath(owner, repoName), webhookID)
+	err := m.client(ctx, token).do(ctx, http.MethodGet, endpoint, nil, &hook)
+	if err != nil {
+		return types.Webhook{}, err
+	}
+
+	return types.Webhook{
+		ID:          hook.ID,
+		URL:         hook.Config["url"],
+		ContentType: hook.Config["content_type"],
+		Events:      hook.Events,
+		Active:      hook.Active,
+	}, nil
+}
+
+func (m *GiteaManager) RepairWebhook(ctx context.Context, token *oauth2.Token, owner string, repoName string, webhookID int64, secret string) error {
+	endpoint := fmt.Sprintf("%s/hooks/%d", repoPath(owner, repoName), webhookID)
+	return m.client(ctx, token).do(ctx, http.MethodPatch, endpoint, m.hook(secret), nil)
+}
+
+func (m *GiteaManager) hook(secret string) map[string]any {
+	expected := m.ExpectedWebhook()
+	return map[string]any{
+		"active": expected.Active,
+		"events": expected.Events,
+		"config": m.hookConfig(secret),
+	}
+}
+
+func (m *GiteaManager) hookConfig(secret string) map[string]string {
+	expected := m.ExpectedWebhook()
+	return map[string]string{
+		"url":          expected.URL,
+		"content_type": expected.ContentType,
+		"secret":       secret,
+	}
+}
+
+func (m *GiteaManager) DeleteWebhook(ctx context.Context, token *oauth2.Token, owner string, repoName string, webhookID int64) error {
+	endpoint := fmt.Sprintf("%s/hooks/%d", repoPath(owner, repoName), webhookID)
+	return m.client(ctx, token).do(ctx, http.MethodDelete, endpoint, nil, nil)
+}
+
+// ValidatePayload checks HMAC-SHA256 signature of the payload with secret of
+// the repository.
+func (m *GiteaManager) ValidatePayload(r *http.Request) ([]byte, error) {
+	payload, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := hex.DecodeString(r.Header.Get("X-Gitea-Signature"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid X-Gitea-Signature: %w", err)
+	}
+
+	secrets, err := webhookSecrets(r.Context(), m.s, m.Name(), m.DeliveryInfo(r, payload).RepoServiceID)
+	if err != nil {
+		return nil, err
+	}
+	for _, secret := range secrets {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(payload)
+		if hmac.Equal(signature, mac.Sum(nil)) {
+			return payload, nil
+		}
+	}
+	return nil, errors.New("payload signature does not match")
+}
+
+func (m *GiteaManager) DeliveryInfo(r *http.Request, payload []byte) DeliveryInfo {
+	var p struct {
+		Repo struct {
+			ID int64 `json:"id"`
+		} `json:"repository"`
+	}
+	// Not every event is related to repository so errors are ignored.
+	_ = json.Unmarshal(payload, &p)
+
+	return DeliveryInfo{
+		DeliveryID:    r.Header.Get("X-Gitea-Delivery"),
+		Event:         r.Header.Get("X-Gitea-Event"),
+		RepoServiceID: p.Repo.ID,
+	}
+}
+
+// giteaHookRepo is repository as sent in webhook payloads.
+type giteaHookRepo struct {
+	ID       int64  `json:"id"`
+	CloneURL string `json:"clone_url"`
+}
+
+// giteaHookUser is user as sent in webhook payloads.
+type giteaHookUser struct {
+	Login string `json:"login"`
+}
+
+// giteaHookCommit is commit as sent in webhook payloads.
+type giteaHookCommit struct {
+	Message   string              `json:"message"`
+	Author    giteaHookCommitUser `json:"author"`
+	Committer giteaHookCommitUser `json:"committer"`
+}
+
+type giteaHookCommitUser struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+func (m *GiteaManager) ParseEvent(ctx context.Context, event string, payload []byte) (*types.Pipeline, error) {
+	switch event {
+	case "push":
+		return m.handlePush(ctx, payload)
+	case "pull_request":
+		return m.handlePullRequest(ctx, payload)
+	default:
+		return nil, ErrEventNotSupported
+	}
+}
+
+func (m *GiteaManager) handlePush(ctx context.Context, payload []byte) (*types.Pipeline, error) {
+	var e struct {
+		Ref        string          `json:"ref"`
+		After      string          `json:"after"`
+		CompareURL string          `json:"compare_url"`
+		HeadCommit giteaHookCommit `json:"head_commit"`
+		Pusher     giteaHookUser   `json:"pusher"`
+		Repo       giteaHookRepo   `json:"repository"`
+	}
+	err := json.Unmarshal(payload, &e)
+	if err != nil {
+		return nil, err
+	}
+
+	repoID, err := registeredRepoID(ctx, m.s, m.Name(), e.Repo.ID)
+	if err != nil {
+		return nil, err
+	}
+	// Deleted branch or tag has nothing to build.
+	if e.After == "" || e.After == zeroSHA {
+		err = BranchDeleted(ctx, m.s, repoID, e.Ref)
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrEventNotSupported
+	}
+
+	event, branch, tag := pushRef(e.Ref)
+	pipeline := &types.Pipeline{
+		CommitSHA:      e.After,
+		CloneURL:       e.Repo.CloneURL,
+		Status:         types.Queued,
+		RepoID:         repoID,
+		Event:          event,
+		Ref:            optional(e.Ref),
+		Branch:         branch,
+		Tag:            tag,
+		CommitMessage:  optional(e.HeadCommit.Message),
+		AuthorName:     optional(e.HeadCommit.Author.Name),
+		AuthorEmail:    optional(e.HeadCommit.Author.Email),
+		CommitterName:  optional(e.HeadCommit.Committer.Name),
+		CommitterEmail: optional(e.HeadCommit.Committer.Email),
+		Pusher:         optional(e.Pusher.Login),
+		CompareURL:     optional(e.CompareURL),
+	}
+
+	return pipeline, nil
+}
+
+// handlePullRequest creates pipeline for head commit of opened or updated pull
+// request. Gitea has no merge ref so the head commit is always built.
+func (m *GiteaManager) handlePullRequest(ctx context.Context, payload []byte) (*types.Pipeline, error) {
+	var e struct {
+		Action      string        `json:"action"`
+		Number      int32         `json:"number"`
+		Repo        giteaHookRepo `json:"repository"`
+		Sender      giteaHookUser `json:"sender"`
+		PullRequest struct {
+			HTMLURL string `json:"html_url"`
+			Head    struct {
+				Ref    string `json:"ref"`
+				SHA    string `json:"sha"`
+				RepoID int64  `json:"repo_id"`
+			} `json:"head"`
+			Base struct {
+				Ref    string `json:"ref"`
+				RepoID int64  `json:"repo_id"`
+			} `json:"base"`
+		} `json:"pull_request"`
+	}
+	err := json.Unmarshal(payload, &e)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.Action {
+	case "opened", "reopened", "synchronized":
+	default:
+		return nil, ErrEventNotSupported
+	}
+
+	repoID, err := registeredRepoID(ctx, m.s, m.Name(), e.Repo.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	head := e.PullRequest.Head
+	base := e.PullRequest.Base
+	pipeline := &types.Pipeline{
+		CommitSHA: head.SHA,
+		// Base repository contains pull request commits too, so forks
+		// don't have to be accessible.
+		CloneURL:     e.Repo.CloneURL,
+		Status:       types.Queued,
+		RepoID:       repoID,
+		PRNumber:     &e.Number,
+		SourceBranch: &head.Ref,
+		TargetBranch: &base.Ref,
+		Fork:         head.RepoID != base.RepoID,
+		Event:        types.EventPullRequest,
+		Ref:          optional(fmt.Sprintf("refs/pull/%d/head", e.Number)),
+		Branch:       &head.Ref,
+		Pusher:       optional(e.Sender.Login),
+		CompareURL:   optional(e.PullRequest.HTMLURL),
+	}
+
+	return pipeline, nil
+}
+
+func (m *GiteaManager) CreateStatus(ctx context.Context, token *oauth2.Token, owner string, repoName string, commit string, status Status) error {
+	s := map[string]string{
+		"state":       m.StatusName(status.State),
+		"target_url":  status.TargetURL,
+		"context":     status.Context,
+		"description": status.Description,
+	}
+	endpoint := fmt.Sprintf("%s/statuses/%s", repoPath(owner, repoName), commit)
+	return m.client(ctx, token).do(ctx, http.MethodPost, endpoint, s, nil)
+}
+
+func (m *GiteaManager) ResolveRef(ctx context.Context, token *oauth2.Token, owner string, repoName string, name string) (Commit, error) {
+	client := m.client(ctx, token)
+
+	// Tags have only SHA of the commit, so details are requested
+	// separately for both.
+	var sha, ref string
+	var branch struct {
+		Commit struct {
+			ID string `json:"id"`
+		} `json:"commit"`
+	}
+	err := client.do(ctx, http.MethodGet, repoPath(owner, repoName)+"/branches/"+escapePath(name), nil, &branch)
+	if err == nil {
+		sha, ref = branch.Commit.ID, "refs/heads/"+name
+	} else if IsNotFound(err) {
+		var tag struct {
+			Commit struct {
+				SHA string `json:"sha"`
+			} `json:"commit"`
+		}
+		err = client.do(ctx, http.MethodGet, repoPath(owner, repoName)+"/tags/"+escapePath(name), nil, &tag)
+		if IsNotFound(err) {
+			return Commit{}, ErrRefNotFound
+		}
+		sha, ref = tag.Commit.SHA, "refs/tags/"+name
+	}
+	if err != nil {
+		return Commit{}, err
+	}
+
+	var commit struct {
+		SHA    string `json:"sha"`
+		Commit struct {
+			Message   string              `json:"message"`
+			Author    giteaHookCommitUser `json:"author"`
+			Committer giteaHookCommitUser `json:"committer"`
+		} `json:"commit"`
+	}
+	err = client.do(ctx, http.MethodGet, repoPath(owner, repoName)+"/git/commits/"+url.PathEscape(sha), nil, &commit)
+	if err != nil {
+		return Commit{}, err
+	}
+
+	return Commit{
+		SHA:            commit.SHA,
+		Ref:            ref,
+		Message:        commit.Commit.Message,
+		AuthorName:     commit.Commit.Author.Name,
+		AuthorEmail:    commit.Commit.Author.Email,
+		CommitterName:  commit.Commit.Committer.Name,
+		CommitterEmail: commit.Commit.Committer.Email,
+	}, nil
+}
+
+func (m *GiteaManager) GetFile(ctx context.Context, token *oauth2.Token, owner string, repoName string, path string, commit string) ([]byte, error) {
+	var file struct {
+		Content string `json:"content"`
+	}
+	endpoint := fmt.Sprintf("%s/contents/%s?ref=%s", repoPath(owner, repoName), escapePath(path), url.QueryEscape(commit))
+	err := m.client(ctx, token).do(ctx, http.MethodGet, endpoint, nil, &file)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(file.Content)
+}
+
+func (m *GiteaManager) client(ctx context.Context, token *oauth2.Token) restClient {
+	return restClient{
+		client:  httpClient(ctx, token),
+		baseURL: m.baseURL + "/api/v1",
+	}
+}
+
+func repoPath(owner string, repoName string) string {
+	return "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(repoName)
+}
@@ -0,0 +1,401 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/shark-ci/shark-ci/internal/config"
+	"github.com/shark-ci/shark-ci/internal/server/store"
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+const testSecret = "secret"
+
+// fakeStore knows single registered repository.
+type fakeStore struct {
+	store.Storer
+	repoServiceID int64
+	repoID        int64
+	secrets       types.WebhookSecrets
+	// cleared records branches whose workflow schedules were removed.
+	cleared *[]string
+}
+
+func (s fakeStore) GetRepoIDByServiceRepoID(ctx context.Context, service types.Service, serviceRepoID int64) (int64, error) {
+	if serviceRepoID != s.repoServiceID {
+		return 0, store.ErrNotFound
+	}
+	return s.repoID, nil
+}
+
+func (s fakeStore) GetRepoWebhookSecrets(ctx context.Context, service types.Service, serviceRepoID int64) (types.WebhookSecrets, error) {
+	if serviceRepoID != s.repoServiceID {
+		return types.WebhookSecrets{}, store.ErrNotFound
+	}
+	return s.secrets, nil
+}
+
+func (s fakeStore) SyncWorkflowSchedules(ctx context.Context, repoID int64, branch string, at time.Time, schedules []types.Schedule) error {
+	if s.cleared != nil && len(schedules) == 0 {
+		*s.cleared = append(*s.cleared, branch)
+	}
+	return nil
+}
+
+// giteaStandIn serves subset of Gitea API used by GiteaManager.
+type giteaStandIn struct {
+	repos    []map[string]any
+	hooks    []map[string]any
+	statuses map[string]map[string]string
+}
+
+func (g *giteaStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/user/repos":
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		start := min((page-1)*limit, len(g.repos))
+		end := min(start+limit, len(g.repos))
+		json.NewEncoder(w).Encode(g.repos[start:end])
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/repos/owner/repo/hooks":
+		var hook map[string]any
+		json.NewDecoder(r.Body).Decode(&hook)
+		g.hooks = append(g.hooks, hook)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{"id": len(g.hooks)})
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/repos/owner/repo/statuses/abc":
+		var status map[string]string
+		json.NewDecoder(r.Body).Decode(&status)
+		g.statuses["abc"] = status
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("{}"))
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/repos/owner/repo/branches/feature/x":
+		json.NewEncoder(w).Encode(map[string]any{"name": "feature/x", "commit": map[string]any{"id": "abc"}})
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/repos/owner/repo/tags/v1.0.0":
+		json.NewEncoder(w).Encode(map[string]any{"name": "v1.0.0", "commit": map[string]any{"sha": "def"}})
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/v1/repos/owner/repo/git/commits/"):
+		json.NewEncoder(w).Encode(map[string]any{
+			"sha": path.Base(r.URL.Path),
+			"commit": map[string]any{
+				"message":   "Release",
+				"author":    map[string]any{"name": "Author", "email": "author@example.com"},
+				"committer": map[string]any{"name": "Committer", "email": "committer@example.com"},
+			},
+		})
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/repos/owner/repo/contents/.shark-ci/workflow.yaml" && r.URL.Query().Get("ref") == "abc":
+		json.NewEncoder(w).Encode(map[string]any{"encoding": "base64", "content": base64.StdEncoding.EncodeToString([]byte("image: golang\n"))})
+	case r.URL.Path == "/api/v1/repos/owner/limited/statuses/abc":
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestGitea(t *testing.T) (*GiteaManager, *giteaStandIn) {
+	t.Helper()
+	config.ServerConf.Host = "https://ci.example.com"
+	config.ServerConf.SecretKey = testSecret
+
+	standIn := &giteaStandIn{statuses: map[string]map[string]string{}}
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+
+	m := NewGiteaManager(server.URL+"/", "id", "secret", fakeStore{repoServiceID: 7, repoID: 1})
+	return m, standIn
+}
+
+var testToken = &oauth2.Token{AccessToken: "token", TokenType: "Bearer"}
+
+func TestGiteaGetUserRepos(t *testing.T) {
+	m, standIn := newTestGitea(t)
+	for i := range giteaPageSize + 2 {
+		standIn.repos = append(standIn.repos, map[string]any{
+			"id":          i,
+			"name":        fmt.Sprintf("repo%d", i),
+			"owner":       map[string]any{"login": "owner"},
+			"archived":    i == 1,
+			"permissions": map[string]any{"admin": i != 2},
+		})
+	}
+
+	repos, err := m.GetUserRepos(context.Background(), testToken, 5)
+	if err != nil {
+		t.Fatalf("GetUserRepos() error = %v", err)
+	}
+
+	// Archived repo and repo without admin permission are skipped.
+	if len(repos) != giteaPageSize {
+		t.Fatalf("GetUserRepos() returned %d repos, want %d", len(repos), giteaPageSize)
+	}
+	last := repos[len(repos)-1]
+	want := types.Repo{RepoServiceID: giteaPageSize + 1, Name: fmt.Sprintf("repo%d", giteaPageSize+1), Owner: "owner", Service: types.ServiceGitea, ServiceUserID: 5}
+	if last != want {
+		t.Errorf("last repo = %+v, want %+v", last, want)
+	}
+}
+
+func TestGiteaCreateWebhook(t *testing.T) {
+	m, standIn := newTestGitea(t)
+
+	hookID, err := m.CreateWebhook(context.Background(), testToken, "owner", "repo", "repo-secret")
+	if err != nil {
+		t.Fatalf("CreateWebhook() error = %v", err)
+	}
+	if hookID != 1 {
+		t.Errorf("CreateWebhook() = %d, want 1", hookID)
+	}
+
+	hookConfig := standIn.hooks[0]["config"].(map[string]any)
+	if hookConfig["url"] != "https://ci.example.com/event_handler/Gitea" || hookConfig["secret"] != "repo-secret" || hookConfig["content_type"] != "json" {
+		t.Errorf("unexpected hook config %v", hookConfig)
+	}
+}
+
+func TestGiteaCreateStatus(t *testing.T) {
+	m, standIn := newTestGitea(t)
+
+	err := m.CreateStatus(context.Background(), testToken, "owner", "repo", "abc", Status{
+		State:       types.Running,
+		TargetURL:   "https://ci.example.com/repos/1/builds/1",
+		Context:     "Shark CI",
+		Description: "Pipeline is running",
+	})
+	if err != nil {
+		t.Fatalf("CreateStatus() error = %v", err)
+	}
+	if got := standIn.statuses["abc"]; got["state"] != "pending" || got["context"] != "Shark CI" {
+		t.Errorf("unexpected status %v", got)
+	}
+
+	err = m.CreateStatus(context.Background(), testToken, "owner", "limited", "abc", Status{State: types.Success})
+	rateErr, ok := err.(*RateLimitError)
+	if !ok || rateErr.RetryAfter.Seconds() != 30 {
+		t.Errorf("CreateStatus() error = %v, want rate limit error", err)
+	}
+}
+
+func TestGiteaResolveRef(t *testing.T) {
+	m, _ := newTestGitea(t)
+
+	commit, err := m.ResolveRef(context.Background(), testToken, "owner", "repo", "feature/x")
+	if err != nil {
+		t.Fatalf("ResolveRef() error = %v", err)
+	}
+	want := Commit{
+		SHA:            "abc",
+		Ref:            "refs/heads/feature/x",
+		Message:        "Release",
+		AuthorName:     "Author",
+		AuthorEmail:    "author@example.com",
+		CommitterName:  "Committer",
+		CommitterEmail: "committer@example.com",
+	}
+	if commit != want {
+		t.Errorf("ResolveRef() = %+v, want %+v", commit, want)
+	}
+
+	commit, err = m.ResolveRef(context.Background(), testToken, "owner", "repo", "v1.0.0")
+	if err != nil {
+		t.Fatalf("ResolveRef() error = %v", err)
+	}
+	if commit.SHA != "def" || commit.Ref != "refs/tags/v1.0.0" {
+		t.Errorf("ResolveRef() = %+v, want tag v1.0.0", commit)
+	}
+
+	_, err = m.ResolveRef(context.Background(), testToken, "owner", "repo", "missing")
+	if !errors.Is(err, ErrRefNotFound) {
+		t.Errorf("ResolveRef() error = %v, want ErrRefNotFound", err)
+	}
+}
+
+func TestGiteaGetFile(t *testing.T) {
+	m, _ := newTestGitea(t)
+
+	content, err := m.GetFile(context.Background(), testToken, "owner", "repo", types.WorkflowPath, "abc")
+	if err != nil {
+		t.Fatalf("GetFile() error = %v", err)
+	}
+	if string(content) != "image: golang\n" {
+		t.Errorf("GetFile() = %q", content)
+	}
+
+	_, err = m.GetFile(context.Background(), testToken, "owner", "repo", types.WorkflowPath, "def")
+	if !IsNotFound(err) {
+		t.Errorf("GetFile() error = %v, want not found", err)
+	}
+}
+
+func giteaWebhook(t *testing.T, event string, payload any, secret string) *http.Request {
+	t.Helper()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	r := httptest.NewRequest(http.MethodPost, "/event_handler/Gitea", bytes.NewReader(body))
+	r.Header.Set("X-Gitea-Event", event)
+	r.Header.Set("X-Gitea-Delivery", "delivery")
+	r.Header.Set("X-Gitea-Signature", hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+func TestGiteaParseEvent(t *testing.T) {
+	m, _ := newTestGitea(t)
+	repo := map[string]any{"id": 7, "clone_url": "https://git.example.com/owner/repo.git"}
+
+	t.Run("push", func(t *testing.T) {
+		r := giteaWebhook(t, "push", map[string]any{
+			"ref":         "refs/heads/main",
+			"after":       "abc",
+			"repository":  repo,
+			"pusher":      map[string]any{"login": "john"},
+			"head_commit": map[string]any{"message": "Fix build\n\nDetails.", "author": map[string]any{"name": "John", "email": "john@example.com"}},
+		}, testSecret)
+		pipeline, err := receive(m, r)
+		if err != nil {
+			t.Fatalf("receive() error = %v", err)
+		}
+		if pipeline.CommitSHA != "abc" || pipeline.RepoID != 1 || pipeline.CloneURL != "https://git.example.com/owner/repo.git" {
+			t.Errorf("unexpected pipeline %+v", pipeline)
+		}
+		if pipeline.Event != types.EventPush || deref(pipeline.Branch) != "main" || pipeline.Tag != nil || deref(pipeline.Pusher) != "john" || deref(pipeline.AuthorEmail) != "john@example.com" || pipeline.CommitTitle() != "Fix build" {
+			t.Errorf("unexpected pipeline metadata %+v", pipeline)
+		}
+	})
+
+	t.Run("tag", func(t *testing.T) {
+		r := giteaWebhook(t, "push", map[string]any{"ref": "refs/tags/v1.0.0", "after": "abc", "repository": repo}, testSecret)
+		pipeline, err := receive(m, r)
+		if err != nil {
+			t.Fatalf("receive() error = %v", err)
+		}
+		if pipeline.Event != types.EventTag || deref(pipeline.Tag) != "v1.0.0" || pipeline.Branch != nil {
+			t.Errorf("unexpected pipeline metadata %+v", pipeline)
+		}
+	})
+
+	t.Run("deleted branch", func(t *testing.T) {
+		var cleared []string
+		orig := m.s
+		m.s = fakeStore{repoServiceID: 7, repoID: 1, cleared: &cleared}
+		t.Cleanup(func() { m.s = orig })
+
+		r := giteaWebhook(t, "push", map[string]any{"ref": "refs/heads/main", "after": zeroSHA, "repository": repo}, testSecret)
+		_, err := receive(m, r)
+		if err != ErrEventNotSupported {
+			t.Errorf("receive() error = %v, want %v", err, ErrEventNotSupported)
+		}
+		if len(cleared) != 1 || cleared[0] != "main" {
+			t.Errorf("cleared schedules of %v, want main", cleared)
+		}
+	})
+
+	t.Run("pull request from fork", func(t *testing.T) {
+		r := giteaWebhook(t, "pull_request", map[string]any{
+			"action":     "synchronized",
+			"number":     3,
+			"repository": repo,
+			"pull_request": map[string]any{
+				"head": map[string]any{"ref": "feature", "sha": "def", "repo_id": 8},
+				"base": map[string]any{"ref": "main", "repo_id": 7},
+			},
+		}, testSecret)
+		pipeline, err := receive(m, r)
+		if err != nil {
+			t.Fatalf("receive() error = %v", err)
+		}
+		if pipeline.CommitSHA != "def" || *pipeline.PRNumber != 3 || *pipeline.SourceBranch != "feature" || *pipeline.TargetBranch != "main" || !pipeline.Fork {
+			t.Errorf("unexpected pipeline %+v", pipeline)
+		}
+	})
+
+	t.Run("stored delivery", func(t *testing.T) {
+		// Payload was validated when it was received, its secret may have been
+		// rotated since.
+		payload, err := json.Marshal(map[string]any{"ref": "refs/heads/main", "after": "abc", "repository": repo})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pipeline, err := m.ParseEvent(context.Background(), "push", payload)
+		if err != nil || pipeline.CommitSHA != "abc" {
+			t.Errorf("ParseEvent() = %+v, %v, want pipeline of abc", pipeline, err)
+		}
+	})
+
+	t.Run("stored delivery of unregistered repo", func(t *testing.T) {
+		other := map[string]any{"id": 9, "clone_url": "https://git.example.com/owner/other.git"}
+		payload, err := json.Marshal(map[string]any{"ref": "refs/heads/main", "after": "abc", "repository": other})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = m.ParseEvent(context.Background(), "push", payload)
+		if err != ErrEventNotSupported {
+			t.Errorf("ParseEvent() error = %v, want %v", err, ErrEventNotSupported)
+		}
+	})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		r := giteaWebhook(t, "push", map[string]any{"after": "abc", "repository": repo}, "other")
+		_, err := receive(m, r)
+		if err == nil {
+			t.Error("receive() accepted payload with invalid signature")
+		}
+	})
+
+	t.Run("unregistered repo", func(t *testing.T) {
+		other := map[string]any{"id": 9, "clone_url": "https://git.example.com/owner/other.git"}
+		r := giteaWebhook(t, "push", map[string]any{"after": "abc", "repository": other}, testSecret)
+		_, err := receive(m, r)
+		if err == nil {
+			t.Error("receive() accepted payload of unregistered repo")
+		}
+	})
+}
+
+func TestGiteaValidatePayloadRepoSecret(t *testing.T) {
+	m, _ := newTestGitea(t)
+	rotatedAt := time.Now().Add(-time.Hour)
+	m.s = fakeStore{repoServiceID: 7, repoID: 1, secrets: types.WebhookSecrets{Secret: "new", Previous: "old", RotatedAt: &rotatedAt}}
+	payload := map[string]any{"after": "abc", "repository": map[string]any{"id": 7}}
+
+	for _, secret := range []string{"new", "old"} {
+		_, err := m.ValidatePayload(giteaWebhook(t, "push", payload, secret))
+		if err != nil {
+			t.Errorf("ValidatePayload() with %s secret error = %v", secret, err)
+		}
+	}
+
+	// Secret key is only used by repositories without own secret.
+	if _, err := m.ValidatePayload(giteaWebhook(t, "push", payload, testSecret)); err == nil {
+		t.Error("ValidatePayload() accepted payload signed with secret key")
+	}
+
+	rotatedAt = time.Now().Add(-webhookSecretGracePeriod)
+	if _, err := m.ValidatePayload(giteaWebhook(t, "push", payload, "old")); err == nil {
+		t.Error("ValidatePayload() accepted previous secret after grace period")
+	}
+}
@@ -1,8 +1,15 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/google/go-github/v62/github"
 	"golang.org/x/oauth2"
@@ -16,17 +23,32 @@ import (
 type GitHubManager struct {
 	s            store.Storer
 	oauth2Config *oauth2.Config
+	checks       bool
+	// app is nil when repositories are accessed with user tokens.
+	app *GitHubApp
 }
 
 var _ ServiceManager = &GitHubManager{}
+var _ CheckRunManager = &GitHubManager{}
+var _ InstallationManager = &GitHubManager{}
+
+// NewGitHubManager creates GitHub manager. If app is not nil, client ID and
+// secret must belong to the app and user tokens are used only for login.
+func NewGitHubManager(clientID string, clientSecret string, checks bool, app *GitHubApp, s store.Storer) *GitHubManager {
+	scopes := []string{"repo"}
+	if app != nil {
+		// Permissions of GitHub App user tokens are given by the app.
+		scopes = nil
+	}
 
-func NewGitHubManager(clientID string, clientSecret string, s store.Storer) *GitHubManager {
 	return &GitHubManager{
-		s: s,
+		s:      s,
+		checks: checks,
+		app:    app,
 		oauth2Config: &oauth2.Config{
 			ClientID:     clientID,
 			ClientSecret: clientSecret,
-			Scopes:       []string{"repo"},
+			Scopes:       scopes,
 			Endpoint:     oauth2_github.Endpoint,
 		},
 	}
@@ -40,14 +62,21 @@ func (*GitHubManager) StatusName(status types.PipelineStatus) string {
 	switch status {
 	case types.Success:
 		return "success"
-	case types.Pending:
+	case types.Queued:
 		return "pending"
 	case types.Running:
 		return "pending"
-	case types.Error:
+	case types.Failure, types.TimedOut:
+		return "failure"
+	case types.Error, types.Cancelled:
 		return "error"
+	// Commit statuses have no neutral state, skipped pipeline must not
+	// block merging.
+	case types.Skipped:
+		return "success"
+	// Unknown status never lets the commit pass.
 	default:
-		return ""
+		return "error"
 	}
 }
 
@@ -81,43 +110,112 @@ func (m *GitHubManager) GetServiceUser(ctx context.Context, token *oauth2.Token)
 
 func (m *GitHubManager) GetUserRepos(ctx context.Context, token *oauth2.Token, serviceUserID int64) ([]types.Repo, error) {
 	client := m.clientWithToken(ctx, token)
-	r, _, err := client.Repositories.ListByAuthenticatedUser(ctx, &github.RepositoryListByAuthenticatedUserOptions{ListOptions: github.ListOptions{PerPage: 50}})
-	if err != nil {
-		return nil, err
+	if m.app != nil {
+		return m.getInstallationRepos(ctx, client, serviceUserID)
 	}
 
-	repos := make([]types.Repo, 0, len(r))
-	for _, repo := range r {
-		if repo.GetArchived() {
-			continue
+	// Hooks can only be created by repository admins.
+	var repos []types.Repo
+	opts := &github.RepositoryListByAuthenticatedUserOptions{
+		Affiliation: "owner,collaborator,organization_member",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		page, resp, err := client.Repositories.ListByAuthenticatedUser(ctx, opts)
+		if err != nil {
+			return nil, githubError(err)
+		}
+		for _, repo := range page {
+			if repo.GetArchived() || !repo.GetPermissions()["admin"] {
+				continue
+			}
+			repos = append(repos, types.Repo{
+				RepoServiceID: repo.GetID(),
+				Name:          repo.GetName(),
+				Owner:         repo.GetOwner().GetLogin(),
+				Service:       m.Name(),
+				ServiceUserID: serviceUserID,
+			})
 		}
-		repo := types.Repo{
-			RepoServiceID: repo.GetID(),
-			Name:          repo.GetName(),
-			Owner:         repo.GetOwner().GetLogin(),
-			Service:       m.Name(),
-			ServiceUserID: serviceUserID,
+		if resp.NextPage == 0 {
+			return repos, nil
 		}
-		repos = append(repos, repo)
+		opts.Page = resp.NextPage
 	}
+}
 
-	return repos, err
+// getInstallationRepos returns repositories of app installations accessible by
+// the user.
+func (m *GitHubManager) getInstallationRepos(ctx context.Context, client *github.Client, serviceUserID int64) ([]types.Repo, error) {
+	var installations []*github.Installation
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := client.Apps.ListUserInstallations(ctx, opts)
+		if err != nil {
+			return nil, githubError(err)
+		}
+		installations = append(installations, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	var repos []types.Repo
+	for _, installation := range installations {
+		installationID := installation.GetID()
+		opts := &github.ListOptions{PerPage: 100}
+		for {
+			page, resp, err := client.Apps.ListUserRepos(ctx, installationID, opts)
+			if err != nil {
+				return nil, githubError(err)
+			}
+			for _, repo := range page.Repositories {
+				if repo.GetArchived() {
+					continue
+				}
+				repos = append(repos, types.Repo{
+					RepoServiceID:  repo.GetID(),
+					Name:           repo.GetName(),
+					Owner:          repo.GetOwner().GetLogin(),
+					Service:        m.Name(),
+					InstallationID: &installationID,
+					ServiceUserID:  serviceUserID,
+				})
+			}
+			if resp.NextPage == 0 {
+				break
+			}
+			opts.Page = resp.NextPage
+		}
+	}
+
+	return repos, nil
 }
 
-func (m *GitHubManager) CreateWebhook(ctx context.Context, token *oauth2.Token, owner string, repoName string) (int64, error) {
+func (m *GitHubManager) GetRepo(ctx context.Context, token *oauth2.Token, repoServiceID int64) (types.Repo, error) {
 	client := m.clientWithToken(ctx, token)
 
-	hook := &github.Hook{
-		Active: github.Bool(true),
-		Events: []string{"push", "pull_request"},
-		Config: &github.HookConfig{
-			ContentType: github.String("json"),
-			URL:         github.String(config.ServerConf.Host + "/event_handler/" + string(m.Name())),
-			Secret:      github.String(config.ServerConf.SecretKey),
-		},
+	repo, _, err := client.Repositories.GetByID(ctx, repoServiceID)
+	if err != nil {
+		return types.Repo{}, err
 	}
 
-	hook, _, err := client.Repositories.CreateHook(ctx, owner, repoName, hook)
+	return types.Repo{
+		Service:       m.Name(),
+		Owner:         repo.GetOwner().GetLogin(),
+		Name:          repo.GetName(),
+		RepoServiceID: repo.GetID(),
+		CloneURL:      repo.GetCloneURL(),
+		ForgeArchived: repo.GetArchived(),
+		Private:       repo.GetPrivate(),
+	}, nil
+}
+
+func (m *GitHubManager) CreateWebhook(ctx context.Context, token *oauth2.Token, owner string, repoName string, secret string) (int64, error) {
+	client := m.clientWithToken(ctx, token)
+
+	hook, _, err := client.Repositories.CreateHook(ctx, owner, repoName, m.hook(secret))
 	if err != nil {
 		return 0, err
 	}
@@ -125,6 +223,64 @@ func (m *GitHubManager) CreateWebhook(ctx context.Context, token *oauth2.Token,
 	return hook.GetID(), nil
 }
 
+func (m *GitHubManager) UpdateWebhookSecret(ctx context.Context, token *oauth2.Token, owner string, repoName string, webhookID int64, secret string) error {
+	client := m.clientWithToken(ctx, token)
+
+	_, _, err := client.Repositories.EditHookConfiguration(ctx, owner, repoName, webhookID, m.hookConfig(secret))
+	return err
+}
+
+func (m *GitHubManager) ExpectedWebhook() types.Webhook {
+	return types.Webhook{
+		URL:         config.ServerConf.Host + "/event_handler/" + string(m.Name()),
+		ContentType: "json",
+		Events:      []string{"push", "pull_request", "check_run", "check_suite", "repository"},
+		Active:      true,
+	}
+}
+
+func (m *GitHubManager) GetWebhook(ctx context.Context, token *oauth2.Token, owner string, repoName string, webhookID int64) (types.Webhook, error) {
+	client := m.clientWithToken(ctx, token)
+
+	hook, _, err := client.Repositories.GetHook(ctx, owner, repoName, webhookID)
+	if err != nil {
+		return types.Webhook{}, err
+	}
+
+	return types.Webhook{
+		ID:          hook.GetID(),
+		URL:         hook.GetConfig().GetURL(),
+		ContentType: hook.GetConfig().GetContentType(),
+		Events:      hook.Events,
+		Active:      hook.GetActive(),
+	}, nil
+}
+
+func (m *GitHubManager) RepairWebhook(ctx context.Context, token *oauth2.Token, owner string, repoName string, webhookID int64, secret string) error {
+	client := m.clientWithToken(ctx, token)
+
+	_, _, err := client.Repositories.EditHook(ctx, owner, repoName, webhookID, m.hook(secret))
+	return err
+}
+
+func (m *GitHubManager) hook(secret string) *github.Hook {
+	expected := m.ExpectedWebhook()
+	return &github.Hook{
+		Active: github.Bool(expected.Active),
+		Events: expected.Events,
+		Config: m.hookConfig(secret),
+	}
+}
+
+func (m *GitHubManager) hookConfig(secret string) *github.HookConfig {
+	expected := m.ExpectedWebhook()
+	return &github.HookConfig{
+		ContentType: github.String(expected.ContentType),
+		URL:         github.String(expected.URL),
+		Secret:      github.String(secret),
+	}
+}
+
 func (m *GitHubManager) DeleteWebhook(ctx context.Context, token *oauth2.Token, owner string, repoName string, webhookID int64) error {
 	client := m.clientWithToken(ctx, token)
 
@@ -132,12 +288,62 @@ func (m *GitHubManager) DeleteWebhook(ctx context.Context, token *oauth2.Token,
 	return err
 }
 
-func (m *GitHubManager) HandleEvent(ctx context.Context, w http.ResponseWriter, r *http.Request) (*types.Pipeline, error) {
-	payload, err := github.ValidatePayload(r, []byte(config.ServerConf.SecretKey))
+// ValidatePayload checks signature of the payload. When the app is configured
+// repositories receive only app events signed by the app webhook secret,
+// otherwise events from repository webhooks are signed by secret of the
+// repository.
+func (m *GitHubManager) ValidatePayload(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		return nil, err
 	}
-	event, err := github.ParseWebHook(github.WebHookType(r), payload)
+	contentType := r.Header.Get("Content-Type")
+	signature := r.Header.Get(github.SHA256SignatureHeader)
+	if signature == "" {
+		signature = r.Header.Get(github.SHA1SignatureHeader)
+	}
+
+	if m.app != nil {
+		return github.ValidatePayloadFromBody(contentType, bytes.NewReader(body), signature, []byte(m.app.webhookSecret))
+	}
+
+	// Payload is only parsed to find the repository, it is not trusted
+	// until the signature is checked.
+	unverified, err := github.ValidatePayloadFromBody(contentType, bytes.NewReader(body), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := webhookSecrets(r.Context(), m.s, m.Name(), m.DeliveryInfo(r, unverified).RepoServiceID)
+	if err != nil {
+		return nil, err
+	}
+	for _, secret := range secrets {
+		payload, err := github.ValidatePayloadFromBody(contentType, bytes.NewReader(body), signature, []byte(secret))
+		if err == nil {
+			return payload, nil
+		}
+	}
+	return nil, errors.New("payload signature does not match")
+}
+
+func (m *GitHubManager) DeliveryInfo(r *http.Request, payload []byte) DeliveryInfo {
+	var p struct {
+		Repo struct {
+			ID int64 `json:"id"`
+		} `json:"repository"`
+	}
+	// Not every event is related to repository so errors are ignored.
+	_ = json.Unmarshal(payload, &p)
+
+	return DeliveryInfo{
+		DeliveryID:    github.DeliveryID(r),
+		Event:         github.WebHookType(r),
+		RepoServiceID: p.Repo.ID,
+	}
+}
+
+func (m *GitHubManager) ParseEvent(ctx context.Context, eventType string, payload []byte) (*types.Pipeline, error) {
+	event, err := github.ParseWebHook(eventType, payload)
 	if err != nil {
 		return nil, err
 	}
@@ -145,31 +351,214 @@ func (m *GitHubManager) HandleEvent(ctx context.Context, w http.ResponseWriter,
 	switch event := event.(type) {
 	case *github.PushEvent:
 		return m.handlePush(ctx, event)
+	case *github.PullRequestEvent:
+		return m.handlePullRequest(ctx, event)
+	case *github.CheckRunEvent:
+		if event.GetAction() != "rerequested" {
+			return nil, ErrEventNotSupported
+		}
+		return m.handleRerun(ctx, event.GetRepo(), event.GetSender(), event.GetCheckRun().GetHeadSHA(), event.GetCheckRun())
+	case *github.CheckSuiteEvent:
+		if event.GetAction() != "rerequested" {
+			return nil, ErrEventNotSupported
+		}
+		return m.handleRerun(ctx, event.GetRepo(), event.GetSender(), event.GetCheckSuite().GetHeadSHA(), nil)
+	case *github.RepositoryEvent:
+		return nil, m.handleRepository(ctx, event)
+	case *github.InstallationEvent:
+		return nil, m.handleInstallation(ctx, event)
+	case *github.InstallationRepositoriesEvent:
+		return nil, m.handleInstallationRepositories(ctx, event)
 	case *github.PingEvent:
-		w.Write([]byte("pong"))
 		return nil, nil
 	default:
 		return nil, ErrEventNotSupported
 	}
 }
 
+// handleRepository keeps owner and name of registered repository up to date
+// when it is renamed or transferred. Repository archived on GitHub keeps its
+// registration until it is unarchived, deleted repository is unregistered.
+func (m *GitHubManager) handleRepository(ctx context.Context, e *github.RepositoryEvent) error {
+	repoID, err := m.repoID(ctx, e.GetRepo().GetID())
+	if err != nil {
+		return err
+	}
+
+	switch e.GetAction() {
+	case "renamed", "transferred":
+		return m.s.UpdateRepoName(ctx, repoID, e.GetRepo().GetOwner().GetLogin(), e.GetRepo().GetName())
+	case "archived", "unarchived":
+		return RepoArchived(ctx, m.s, repoID, e.GetAction() == "archived", e.GetSender().GetLogin())
+	case "deleted":
+		return RepoDeleted(ctx, m.s, repoID, e.GetSender().GetLogin())
+	default:
+		return ErrEventNotSupported
+	}
+}
+
+// handleInstallation keeps installations of registered repositories up to date
+// when the app is installed, uninstalled or suspended.
+func (m *GitHubManager) handleInstallation(ctx context.Context, e *github.InstallationEvent) error {
+	installationID := e.GetInstallation().GetID()
+	switch e.GetAction() {
+	case "created", "unsuspend":
+		for _, repo := range e.Repositories {
+			err := m.s.SetRepoInstallation(ctx, m.Name(), repo.GetID(), &installationID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case "deleted", "suspend":
+		return m.s.ClearInstallation(ctx, m.Name(), installationID)
+	default:
+		return ErrEventNotSupported
+	}
+}
+
+// handleInstallationRepositories keeps installations of registered
+// repositories up to date when repositories are added to or removed from
+// the installation.
+func (m *GitHubManager) handleInstallationRepositories(ctx context.Context, e *github.InstallationRepositoriesEvent) error {
+	installationID := e.GetInstallation().GetID()
+	for _, repo := range e.RepositoriesAdded {
+		err := m.s.SetRepoInstallation(ctx, m.Name(), repo.GetID(), &installationID)
+		if err != nil {
+			return err
+		}
+	}
+	for _, repo := range e.RepositoriesRemoved {
+		err := m.s.SetRepoInstallation(ctx, m.Name(), repo.GetID(), nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// repoID returns ID of registered repository. Apps receive events of all
+// installed repositories so events of unregistered ones are not supported.
+func (m *GitHubManager) repoID(ctx context.Context, repoServiceID int64) (int64, error) {
+	return registeredRepoID(ctx, m.s, m.Name(), repoServiceID)
+}
+
 func (m *GitHubManager) handlePush(ctx context.Context, e *github.PushEvent) (*types.Pipeline, error) {
+	repoID, err := m.repoID(ctx, e.Repo.GetID())
+	if err != nil {
+		return nil, err
+	}
+	// Deleted branch or tag has nothing to build.
+	if e.GetDeleted() {
+		err = BranchDeleted(ctx, m.s, repoID, e.GetRef())
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrEventNotSupported
+	}
 	commit := e.HeadCommit.GetID()
-	repoID, err := m.s.GetRepoIDByServiceRepoID(ctx, m.Name(), e.Repo.GetID())
+
+	event, branch, tag := pushRef(e.GetRef())
+	head := e.GetHeadCommit()
+	pipeline := &types.Pipeline{
+		CommitSHA:      commit,
+		CloneURL:       e.Repo.GetCloneURL(),
+		Status:         types.Queued,
+		RepoID:         repoID,
+		Event:          event,
+		Ref:            optional(e.GetRef()),
+		Branch:         branch,
+		Tag:            tag,
+		CommitMessage:  optional(head.GetMessage()),
+		AuthorName:     optional(head.GetAuthor().GetName()),
+		AuthorEmail:    optional(head.GetAuthor().GetEmail()),
+		CommitterName:  optional(head.GetCommitter().GetName()),
+		CommitterEmail: optional(head.GetCommitter().GetEmail()),
+		Pusher:         optional(e.GetSender().GetLogin()),
+		CompareURL:     optional(e.GetCompare()),
+	}
+
+	return pipeline, nil
+}
+
+// handlePullRequest creates pipeline for head commit of opened or updated pull
+// request.
+func (m *GitHubManager) handlePullRequest(ctx context.Context, e *github.PullRequestEvent) (*types.Pipeline, error) {
+	switch e.GetAction() {
+	case "opened", "synchronize", "reopened":
+	default:
+		return nil, ErrEventNotSupported
+	}
+
+	repoID, err := m.repoID(ctx, e.Repo.GetID())
 	if err != nil {
 		return nil, err
 	}
 
+	pr := e.GetPullRequest()
+	number := int32(pr.GetNumber())
+	sourceBranch := pr.GetHead().GetRef()
+	targetBranch := pr.GetBase().GetRef()
 	pipeline := &types.Pipeline{
-		CommitSHA: commit,
-		CloneURL:  e.Repo.GetCloneURL(),
-		Status:    types.Pending,
-		RepoID:    repoID,
+		CommitSHA: pr.GetHead().GetSHA(),
+		// Base repository contains pull request commits too, so forks
+		// don't have to be accessible.
+		CloneURL:     e.Repo.GetCloneURL(),
+		Status:       types.Queued,
+		RepoID:       repoID,
+		PRNumber:     &number,
+		SourceBranch: &sourceBranch,
+		TargetBranch: &targetBranch,
+		// Head repository of deleted fork is missing.
+		Fork:       pr.GetHead().GetRepo().GetID() != e.Repo.GetID(),
+		Event:      types.EventPullRequest,
+		Ref:        optional(fmt.Sprintf("refs/pull/%d/head", number)),
+		Branch:     &sourceBranch,
+		Pusher:     optional(e.GetSender().GetLogin()),
+		CompareURL: optional(pr.GetHTMLURL()),
+	}
+	if config.ServerConf.PRMergeRef {
+		ref := fmt.Sprintf("refs/pull/%d/merge", number)
+		pipeline.FetchRef = &ref
 	}
 
 	return pipeline, nil
 }
 
+// handleRerun returns next attempt of the pipeline whose check run or check
+// suite was requested again on GitHub. Check run knows its pipeline, check
+// suite re-runs the last pipeline of the commit.
+func (m *GitHubManager) handleRerun(ctx context.Context, repo *github.Repository, sender *github.User, commit string, checkRun *github.CheckRun) (*types.Pipeline, error) {
+	repoID, err := m.repoID(ctx, repo.GetID())
+	if err != nil {
+		return nil, err
+	}
+
+	var pipeline types.Pipeline
+	if checkRun != nil {
+		pipelineID, parseErr := strconv.ParseInt(checkRun.GetExternalID(), 10, 64)
+		if parseErr != nil {
+			return nil, ErrEventNotSupported
+		}
+		pipeline, err = m.s.GetPipeline(ctx, pipelineID)
+	} else {
+		pipeline, err = m.s.GetLatestCommitPipeline(ctx, repoID, commit)
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrEventNotSupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	if pipeline.RepoID != repoID || pipeline.CommitSHA != commit {
+		return nil, ErrEventNotSupported
+	}
+
+	rerun := pipeline.Rerun()
+	rerun.Pusher = optional(sender.GetLogin())
+	return &rerun, nil
+}
+
 func (m *GitHubManager) CreateStatus(ctx context.Context, token *oauth2.Token, owner string, repoName string, commit string, status Status) error {
 	client := m.clientWithToken(ctx, token)
 
@@ -181,10 +570,208 @@ func (m *GitHubManager) CreateStatus(ctx context.Context, token *oauth2.Token, o
 	}
 	_, _, err := client.Repositories.CreateStatus(ctx, owner, repoName, commit, s)
 
+	return githubError(err)
+}
+
+func (m *GitHubManager) ResolveRef(ctx context.Context, token *oauth2.Token, owner string, repoName string, name string) (Commit, error) {
+	client := m.clientWithToken(ctx, token)
+
+	for _, ref := range []string{"refs/heads/" + name, "refs/tags/" + name} {
+		r, _, err := client.Git.GetRef(ctx, owner, repoName, ref)
+		if IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return Commit{}, githubError(err)
+		}
+
+		sha := r.GetObject().GetSHA()
+		// Annotated tag points to tag object instead of the commit.
+		if r.GetObject().GetType() == "tag" {
+			tag, _, err := client.Git.GetTag(ctx, owner, repoName, sha)
+			if err != nil {
+				return Commit{}, githubError(err)
+			}
+			sha = tag.GetObject().GetSHA()
+		}
+
+		commit, _, err := client.Git.GetCommit(ctx, owner, repoName, sha)
+		if err != nil {
+			return Commit{}, githubError(err)
+		}
+		return Commit{
+			SHA:            commit.GetSHA(),
+			Ref:            ref,
+			Message:        commit.GetMessage(),
+			AuthorName:     commit.GetAuthor().GetName(),
+			AuthorEmail:    commit.GetAuthor().GetEmail(),
+			CommitterName:  commit.GetCommitter().GetName(),
+			CommitterEmail: commit.GetCommitter().GetEmail(),
+		}, nil
+	}
+	return Commit{}, ErrRefNotFound
+}
+
+func (m *GitHubManager) GetFile(ctx context.Context, token *oauth2.Token, owner string, repoName string, path string, commit string) ([]byte, error) {
+	client := m.clientWithToken(ctx, token)
+
+	file, _, _, err := client.Repositories.GetContents(ctx, owner, repoName, path, &github.RepositoryContentGetOptions{Ref: commit})
+	if err != nil {
+		return nil, githubError(err)
+	}
+	if file == nil {
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+	content, err := file.GetContent()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(content), nil
+}
+
+func (m *GitHubManager) UsesInstallations() bool {
+	return m.app != nil
+}
+
+func (m *GitHubManager) CloneToken(ctx context.Context, installationID int64, repoServiceID int64) (*oauth2.Token, error) {
+	if m.app == nil {
+		return nil, errors.New("GitHub App is not configured")
+	}
+	return m.app.CloneToken(ctx, installationID, repoServiceID)
+}
+
+func (m *GitHubManager) InstallationToken(ctx context.Context, installationID int64, repoServiceID int64) (*oauth2.Token, error) {
+	if m.app == nil {
+		return nil, errors.New("GitHub App is not configured")
+	}
+	return m.app.InstallationToken(ctx, installationID, repoServiceID)
+}
+
+// ChecksEnabled reports whether check runs are created. Only GitHub App can
+// create them.
+func (m *GitHubManager) ChecksEnabled() bool {
+	return m.checks && m.app != nil
+}
+
+func (m *GitHubManager) CreateCheckRun(ctx context.Context, token *oauth2.Token, owner string, repoName string, commit string, run CheckRun) (int64, error) {
+	client := m.clientWithToken(ctx, token)
+
+	status, conclusion := m.checkRunStatus(run.State)
+	opts := github.CreateCheckRunOptions{
+		Name:        run.Name,
+		HeadSHA:     commit,
+		DetailsURL:  github.String(run.DetailsURL),
+		ExternalID:  github.String(run.ExternalID),
+		Status:      github.String(status),
+		Conclusion:  conclusion,
+		StartedAt:   githubTimestamp(run.StartedAt),
+		CompletedAt: githubTimestamp(run.CompletedAt),
+		Output:      checkRunOutput(run),
+	}
+	checkRun, _, err := client.Checks.CreateCheckRun(ctx, owner, repoName, opts)
+	if err != nil {
+		return 0, githubError(err)
+	}
+
+	return checkRun.GetID(), nil
+}
+
+func (m *GitHubManager) UpdateCheckRun(ctx context.Context, token *oauth2.Token, owner string, repoName string, checkRunID int64, run CheckRun) error {
+	client := m.clientWithToken(ctx, token)
+
+	status, conclusion := m.checkRunStatus(run.State)
+	opts := github.UpdateCheckRunOptions{
+		Name:        run.Name,
+		DetailsURL:  github.String(run.DetailsURL),
+		ExternalID:  github.String(run.ExternalID),
+		Status:      github.String(status),
+		Conclusion:  conclusion,
+		CompletedAt: githubTimestamp(run.CompletedAt),
+		Output:      checkRunOutput(run),
+	}
+	_, _, err := client.Checks.UpdateCheckRun(ctx, owner, repoName, checkRunID, opts)
+
+	return githubError(err)
+}
+
+// checkRunStatus returns status and conclusion of check run.
+func (*GitHubManager) checkRunStatus(status types.PipelineStatus) (string, *string) {
+	switch status {
+	case types.Queued:
+		return "queued", nil
+	case types.Running:
+		return "in_progress", nil
+	case types.Success:
+		return "completed", github.String("success")
+	case types.Cancelled:
+		return "completed", github.String("cancelled")
+	case types.TimedOut:
+		return "completed", github.String("timed_out")
+	case types.Skipped:
+		return "completed", github.String("skipped")
+	default:
+		return "completed", github.String("failure")
+	}
+}
+
+// GitHub accepts at most 50 annotations in single request.
+const maxCheckRunAnnotations = 50
+
+func checkRunOutput(run CheckRun) *github.CheckRunOutput {
+	output := &github.CheckRunOutput{
+		Title:   github.String(run.Title),
+		Summary: github.String(run.Summary),
+		Text:    github.String(run.Text),
+	}
+	for i, a := range run.Annotations {
+		if i == maxCheckRunAnnotations {
+			break
+		}
+		annotation := &github.CheckRunAnnotation{
+			Path:            github.String(a.Path),
+			StartLine:       github.Int(a.Line),
+			EndLine:         github.Int(a.Line),
+			AnnotationLevel: github.String(string(a.Level)),
+			Message:         github.String(a.Message),
+		}
+		if a.Column > 0 {
+			annotation.StartColumn = github.Int(a.Column)
+			annotation.EndColumn = github.Int(a.Column)
+		}
+		output.Annotations = append(output.Annotations, annotation)
+	}
+	return output
+}
+
+func githubTimestamp(t *time.Time) *github.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return &github.Timestamp{Time: *t}
+}
+
+// githubError converts GitHub rate limit errors to RateLimitError.
+func githubError(err error) error {
+	var rateErr *github.RateLimitError
+	if errors.As(err, &rateErr) {
+		return &RateLimitError{RetryAfter: time.Until(rateErr.Rate.Reset.Time), Err: err}
+	}
+
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		return &RateLimitError{RetryAfter: abuseErr.GetRetryAfter(), Err: err}
+	}
+
+	var respErr *github.ErrorResponse
+	if errors.As(err, &respErr) && respErr.Response != nil {
+		if seconds, convErr := strconv.Atoi(respErr.Response.Header.Get("Retry-After")); convErr == nil {
+			return &RateLimitError{RetryAfter: time.Duration(seconds) * time.Second, Err: err}
+		}
+	}
+
 	return err
 }
 
 func (m *GitHubManager) clientWithToken(ctx context.Context, token *oauth2.Token) *github.Client {
-	client := m.oauth2Config.Client(ctx, token)
-	return github.NewClient(client)
+	return github.NewClient(httpClient(ctx, token))
 }
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v62/github"
+	"golang.org/x/oauth2"
+)
+
+// installationTokenMinTTL is how long must cached installation token stay
+// valid to be reused. Clones of big repositories can take a while.
+const installationTokenMinTTL = 10 * time.Minute
+
+// GitHubApp mints installation tokens of the GitHub App.
+type GitHubApp struct {
+	id            int64
+	key           *rsa.PrivateKey
+	webhookSecret string
+
+	mu     sync.Mutex
+	tokens map[installationRepo]*oauth2.Token
+}
+
+type installationRepo struct {
+	installationID int64
+	repoServiceID  int64
+	cloneOnly      bool
+}
+
+// NewGitHubAppFromFile creates GitHub App with private key in PEM file
+// downloaded from the app settings.
+func NewGitHubAppFromFile(id int64, privateKeyFile string, webhookSecret string) (*GitHubApp, error) {
+	b, err := os.ReadFile(privateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read GitHub App private key: %w", err)
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("GitHub App private key is not PEM encoded")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		pkcs8Key, pkcs8Err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		rsaKey, ok := pkcs8Key.(*rsa.PrivateKey)
+		if pkcs8Err != nil || !ok {
+			return nil, fmt.Errorf("cannot parse GitHub App private key: %w", err)
+		}
+		key = rsaKey
+	}
+
+	return &GitHubApp{
+		id:            id,
+		key:           key,
+		webhookSecret: webhookSecret,
+		tokens:        map[installationRepo]*oauth2.Token{},
+	}, nil
+}
+
+// InstallationToken returns token of the installation which can only read
+// contents of the repository and report statuses and checks.
+func (a *GitHubApp) InstallationToken(ctx context.Context, installationID int64, repoServiceID int64) (*oauth2.Token, error) {
+	return a.token(ctx, installationRepo{installationID: installationID, repoServiceID: repoServiceID})
+}
+
+// CloneToken returns token of the installation which can only read contents
+// of the repository, it is given to workers.
+func (a *GitHubApp) CloneToken(ctx context.Context, installationID int64, repoServiceID int64) (*oauth2.Token, error) {
+	return a.token(ctx, installationRepo{installationID: installationID, repoServiceID: repoServiceID, cloneOnly: true})
+}
+
+func (a *GitHubApp) token(ctx context.Context, key installationRepo) (*oauth2.Token, error) {
+
+	a.mu.Lock()
+	token, ok := a.tokens[key]
+	a.mu.Unlock()
+	if ok && time.Until(token.Expiry) > installationTokenMinTTL {
+		return token, nil
+	}
+
+	jwt, err := a.jwt(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := &github.InstallationPermissions{
+		Contents: github.String("read"),
+		Metadata: github.String("read"),
+	}
+	if !key.cloneOnly {
+		permissions.Statuses = github.String("write")
+		permissions.Checks = github.String("write")
+	}
+	client := github.NewClient(nil).WithAuthToken(jwt)
+	t, _, err := client.Apps.CreateInstallationToken(ctx, key.installationID, &github.InstallationTokenOptions{
+		RepositoryIDs: []int64{key.repoServiceID},
+		Permissions:   permissions,
+	})
+	if err != nil {
+		return nil, githubError(err)
+	}
+
+	token = &oauth2.Token{
+		AccessToken: t.GetToken(),
+		TokenType:   "Bearer",
+		Expiry:      t.GetExpiresAt().Time,
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, t := range a.tokens {
+		if time.Until(t.Expiry) <= installationTokenMinTTL {
+			delete(a.tokens, k)
+		}
+	}
+	a.tokens[key] = token
+
+	return token, nil
+}
+
+// jwt returns JSON Web Token authenticating the app itself.
+func (a *GitHubApp) jwt(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	// Issued time is in the past to allow for clock drift.
+	claims, err := json.Marshal(map[string]int64{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": a.id,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("cannot sign GitHub App JWT: %w", err)
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
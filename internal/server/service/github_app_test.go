@@ -0,0 +1,68 @@
+package service
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGitHubAppJWT(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "app.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	err = os.WriteFile(keyFile, keyPEM, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app, err := NewGitHubAppFromFile(42, keyFile, "secret")
+	if err != nil {
+		t.Fatalf("NewGitHubAppFromFile() error = %v", err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	jwt, err := app.jwt(now)
+	if err != nil {
+		t.Fatalf("jwt() error = %v", err)
+	}
+
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		t.Fatalf("jwt() = %q, want 3 parts", jwt)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature)
+	if err != nil {
+		t.Errorf("invalid signature: %v", err)
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims map[string]int64
+	err = json.Unmarshal(b, &claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["iss"] != 42 || claims["iat"] != now.Unix()-60 || claims["exp"] != now.Unix()+540 {
+		t.Errorf("unexpected claims %v", claims)
+	}
+}
@@ -0,0 +1,412 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v62/github"
+	"golang.org/x/oauth2"
+
+	"github.com/shark-ci/shark-ci/internal/config"
+	"github.com/shark-ci/shark-ci/internal/server/store"
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+func newTestGitHub(t *testing.T, app *GitHubApp) *GitHubManager {
+	t.Helper()
+	config.ServerConf.Host = "https://ci.example.com"
+	config.ServerConf.SecretKey = testSecret
+
+	return NewGitHubManager("id", "secret", false, app, fakeStore{repoServiceID: 7, repoID: 1, secrets: types.WebhookSecrets{Secret: "repo-secret"}})
+}
+
+func githubWebhook(t *testing.T, event string, payload any, secret string) *http.Request {
+	t.Helper()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	r := httptest.NewRequest(http.MethodPost, "/event_handler/GitHub", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(github.EventTypeHeader, event)
+	r.Header.Set(github.DeliveryIDHeader, "delivery")
+	r.Header.Set(github.SHA256SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+func TestGitHubValidatePayloadApp(t *testing.T) {
+	m := newTestGitHub(t, &GitHubApp{webhookSecret: "app-secret"})
+	payload := map[string]any{"repository": map[string]any{"id": 7}}
+
+	if _, err := m.ValidatePayload(githubWebhook(t, "push", payload, "app-secret")); err != nil {
+		t.Errorf("ValidatePayload() with app secret error = %v", err)
+	}
+	// Repository webhooks are not trusted once the app is configured.
+	for _, secret := range []string{"repo-secret", testSecret} {
+		if _, err := m.ValidatePayload(githubWebhook(t, "push", payload, secret)); err == nil {
+			t.Errorf("ValidatePayload() accepted %s with app configured", secret)
+		}
+	}
+}
+
+func TestGitHubValidatePayload(t *testing.T) {
+	m := newTestGitHub(t, nil)
+	payload := map[string]any{"repository": map[string]any{"id": 7}}
+
+	if _, err := m.ValidatePayload(githubWebhook(t, "push", payload, "repo-secret")); err != nil {
+		t.Errorf("ValidatePayload() with repo secret error = %v", err)
+	}
+	for _, secret := range []string{"other", testSecret} {
+		if _, err := m.ValidatePayload(githubWebhook(t, "push", payload, secret)); err == nil {
+			t.Errorf("ValidatePayload() accepted payload signed with %s", secret)
+		}
+	}
+	other := map[string]any{"repository": map[string]any{"id": 9}}
+	if _, err := m.ValidatePayload(githubWebhook(t, "push", other, "repo-secret")); err == nil {
+		t.Error("ValidatePayload() accepted payload of unregistered repo")
+	}
+
+	rotatedAt := time.Now().Add(-time.Hour)
+	m.s = fakeStore{repoServiceID: 7, repoID: 1, secrets: types.WebhookSecrets{Secret: "new", Previous: "old", RotatedAt: &rotatedAt}}
+	for _, secret := range []string{"new", "old"} {
+		if _, err := m.ValidatePayload(githubWebhook(t, "push", payload, secret)); err != nil {
+			t.Errorf("ValidatePayload() with %s secret error = %v", secret, err)
+		}
+	}
+
+	expired := time.Now().Add(-2 * webhookSecretGracePeriod)
+	m.s = fakeStore{repoServiceID: 7, repoID: 1, secrets: types.WebhookSecrets{Secret: "new", Previous: "old", RotatedAt: &expired}}
+	if _, err := m.ValidatePayload(githubWebhook(t, "push", payload, "old")); err == nil {
+		t.Error("ValidatePayload() accepted previous secret after grace period")
+	}
+}
+
+func TestGitHubValidatePayloadLegacySecret(t *testing.T) {
+	m := newTestGitHub(t, nil)
+	payload := map[string]any{"repository": map[string]any{"id": 7}}
+
+	// Repository registered before it had own secret.
+	m.s = fakeStore{repoServiceID: 7, repoID: 1}
+	if _, err := m.ValidatePayload(githubWebhook(t, "push", payload, testSecret)); err != nil {
+		t.Errorf("ValidatePayload() with secret key error = %v", err)
+	}
+
+	// Secret key stays valid for the grace period after it got own secret.
+	rotatedAt := time.Now().Add(-time.Hour)
+	m.s = fakeStore{repoServiceID: 7, repoID: 1, secrets: types.WebhookSecrets{Secret: "own", RotatedAt: &rotatedAt}}
+	for _, secret := range []string{"own", testSecret} {
+		if _, err := m.ValidatePayload(githubWebhook(t, "push", payload, secret)); err != nil {
+			t.Errorf("ValidatePayload() with %s secret error = %v", secret, err)
+		}
+	}
+	expired := time.Now().Add(-2 * webhookSecretGracePeriod)
+	m.s = fakeStore{repoServiceID: 7, repoID: 1, secrets: types.WebhookSecrets{Secret: "own", RotatedAt: &expired}}
+	if _, err := m.ValidatePayload(githubWebhook(t, "push", payload, testSecret)); err == nil {
+		t.Error("ValidatePayload() accepted secret key after grace period")
+	}
+}
+
+// repoEventStore records changes of the repository made by repository events.
+type repoEventStore struct {
+	fakeStore
+	owner, name   string
+	forgeArchived bool
+	cancelled     bool
+	archived      bool
+}
+
+func (s *repoEventStore) UpdateRepoName(ctx context.Context, repoID int64, owner string, name string) error {
+	s.owner, s.name = owner, name
+	return nil
+}
+
+func (s *repoEventStore) SetRepoForgeArchived(ctx context.Context, repoID int64, archived bool) error {
+	s.forgeArchived = archived
+	return nil
+}
+
+func (s *repoEventStore) CancelRepoPipelines(ctx context.Context, repoID int64, actor string, message string) ([]types.Pipeline, error) {
+	s.cancelled = true
+	return nil, nil
+}
+
+func (s *repoEventStore) ArchiveRepo(ctx context.Context, repoID int64) error {
+	s.archived = true
+	return nil
+}
+
+func TestGitHubHandleRepository(t *testing.T) {
+	event := func(action string) map[string]any {
+		return map[string]any{
+			"action":     action,
+			"repository": map[string]any{"id": 7, "name": "renamed", "owner": map[string]any{"login": "new-owner"}},
+			"sender":     map[string]any{"login": "john"},
+		}
+	}
+	newStore := func() *repoEventStore {
+		return &repoEventStore{fakeStore: fakeStore{repoServiceID: 7, repoID: 1, secrets: types.WebhookSecrets{Secret: "repo-secret"}}}
+	}
+	m := newTestGitHub(t, nil)
+
+	tests := []struct {
+		action string
+		check  func(s *repoEventStore) bool
+	}{
+		{"renamed", func(s *repoEventStore) bool { return s.owner == "new-owner" && s.name == "renamed" }},
+		{"transferred", func(s *repoEventStore) bool { return s.owner == "new-owner" && s.name == "renamed" }},
+		// Archived repository keeps its webhook, so it works once unarchived.
+		{"archived", func(s *repoEventStore) bool { return s.forgeArchived && s.cancelled && !s.archived }},
+		{"deleted", func(s *repoEventStore) bool { return s.cancelled && s.archived }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			s := newStore()
+			m.s = s
+			_, err := receive(m, githubWebhook(t, "repository", event(tt.action), "repo-secret"))
+			if err != nil {
+				t.Fatalf("receive() error = %v", err)
+			}
+			if !tt.check(s) {
+				t.Errorf("unexpected store state %+v", s)
+			}
+		})
+	}
+
+	t.Run("unarchived", func(t *testing.T) {
+		s := newStore()
+		s.forgeArchived = true
+		m.s = s
+		_, err := receive(m, githubWebhook(t, "repository", event("unarchived"), "repo-secret"))
+		if err != nil {
+			t.Fatalf("receive() error = %v", err)
+		}
+		if s.forgeArchived || s.cancelled || s.archived {
+			t.Errorf("unexpected store state %+v", s)
+		}
+	})
+
+	t.Run("unsupported action", func(t *testing.T) {
+		m.s = newStore()
+		_, err := receive(m, githubWebhook(t, "repository", event("publicized"), "repo-secret"))
+		if err != ErrEventNotSupported {
+			t.Errorf("receive() error = %v, want %v", err, ErrEventNotSupported)
+		}
+	})
+}
+
+func TestGitHubHandleDeletedBranch(t *testing.T) {
+	m := newTestGitHub(t, nil)
+	var cleared []string
+	m.s = fakeStore{repoServiceID: 7, repoID: 1, secrets: types.WebhookSecrets{Secret: "repo-secret"}, cleared: &cleared}
+
+	for _, ref := range []string{"refs/heads/main", "refs/tags/v1.0.0"} {
+		r := githubWebhook(t, "push", map[string]any{"ref": ref, "deleted": true, "after": zeroSHA, "repository": map[string]any{"id": 7}}, "repo-secret")
+		_, err := receive(m, r)
+		if err != ErrEventNotSupported {
+			t.Errorf("receive() of deleted %s error = %v, want %v", ref, err, ErrEventNotSupported)
+		}
+	}
+	// Deleted tag has no schedules.
+	if len(cleared) != 1 || cleared[0] != "main" {
+		t.Errorf("cleared schedules of %v, want main", cleared)
+	}
+}
+
+// rerunStore holds pipelines of the repository.
+type rerunStore struct {
+	fakeStore
+	pipelines []types.Pipeline
+}
+
+func (s rerunStore) GetPipeline(ctx context.Context, pipelineID int64) (types.Pipeline, error) {
+	for _, p := range s.pipelines {
+		if p.ID == pipelineID {
+			return p, nil
+		}
+	}
+	return types.Pipeline{}, store.ErrNotFound
+}
+
+func (s rerunStore) GetLatestCommitPipeline(ctx context.Context, repoID int64, commitSHA string) (types.Pipeline, error) {
+	for i := len(s.pipelines) - 1; i >= 0; i-- {
+		if p := s.pipelines[i]; p.RepoID == repoID && p.CommitSHA == commitSHA {
+			return p, nil
+		}
+	}
+	return types.Pipeline{}, store.ErrNotFound
+}
+
+func TestGitHubHandleRerun(t *testing.T) {
+	m := newTestGitHub(t, nil)
+	original := int64(3)
+	m.s = rerunStore{
+		fakeStore: fakeStore{repoServiceID: 7, repoID: 1, secrets: types.WebhookSecrets{Secret: "repo-secret"}},
+		pipelines: []types.Pipeline{
+			{ID: 3, RepoID: 1, CommitSHA: "abc", Event: types.EventPush, Attempt: 1, Status: types.Failure},
+			{ID: 5, RepoID: 1, CommitSHA: "abc", Event: types.EventPush, Attempt: 2, OriginalID: &original, Status: types.Failure},
+			{ID: 6, RepoID: 2, CommitSHA: "abc", Event: types.EventPush, Attempt: 1, Status: types.Failure},
+		},
+	}
+	repo := map[string]any{"id": 7}
+	sender := map[string]any{"login": "john"}
+
+	tests := []struct {
+		name    string
+		event   string
+		payload map[string]any
+	}{
+		{"check run", "check_run", map[string]any{"action": "rerequested", "repository": repo, "sender": sender, "check_run": map[string]any{"head_sha": "abc", "external_id": "5"}}},
+		{"check suite", "check_suite", map[string]any{"action": "rerequested", "repository": repo, "sender": sender, "check_suite": map[string]any{"head_sha": "abc"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline, err := receive(m, githubWebhook(t, tt.event, tt.payload, "repo-secret"))
+			if err != nil {
+				t.Fatalf("receive() error = %v", err)
+			}
+			// Re-run is the next attempt of the original pipeline.
+			if pipeline.ID != 0 || pipeline.OriginalID == nil || *pipeline.OriginalID != 3 || pipeline.Event != types.EventPush || pipeline.Status != types.Queued || deref(pipeline.Pusher) != "john" {
+				t.Errorf("unexpected re-run %+v", pipeline)
+			}
+		})
+	}
+
+	for name, externalID := range map[string]string{"unknown pipeline": "4", "pipeline of other repo": "6", "other app": "run"} {
+		t.Run(name, func(t *testing.T) {
+			payload := map[string]any{"action": "rerequested", "repository": repo, "sender": sender, "check_run": map[string]any{"head_sha": "abc", "external_id": externalID}}
+			_, err := receive(m, githubWebhook(t, "check_run", payload, "repo-secret"))
+			if err != ErrEventNotSupported {
+				t.Errorf("receive() error = %v, want %v", err, ErrEventNotSupported)
+			}
+		})
+	}
+}
+
+// githubAPI returns context whose OAuth2 clients send GitHub API requests to
+// the handler.
+func githubAPI(t *testing.T, handler http.HandlerFunc) context.Context {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: rewriteTransport{serverURL}}
+	return context.WithValue(context.Background(), oauth2.HTTPClient, client)
+}
+
+// rewriteTransport sends all requests to the server.
+type rewriteTransport struct {
+	server *url.URL
+}
+
+func (t rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = t.server.Scheme
+	r.URL.Host = t.server.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestGitHubResolveRef(t *testing.T) {
+	refs := map[string]map[string]string{
+		"/repos/owner/repo/git/ref/heads/main":     {"type": "commit", "sha": "branch-sha"},
+		"/repos/owner/repo/git/ref/heads/v1":       {"type": "commit", "sha": "branch-v1-sha"},
+		"/repos/owner/repo/git/ref/tags/v1":        {"type": "commit", "sha": "tag-v1-sha"},
+		"/repos/owner/repo/git/ref/tags/light":     {"type": "commit", "sha": "light-sha"},
+		"/repos/owner/repo/git/ref/tags/annotated": {"type": "tag", "sha": "tag-object-sha"},
+	}
+	ctx := githubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if object, ok := refs[r.URL.Path]; ok {
+			json.NewEncoder(w).Encode(map[string]any{"object": object})
+			return
+		}
+		switch r.URL.Path {
+		case "/repos/owner/repo/git/tags/tag-object-sha":
+			json.NewEncoder(w).Encode(map[string]any{"sha": "tag-object-sha", "object": map[string]string{"type": "commit", "sha": "annotated-sha"}})
+		case "/repos/owner/repo/git/commits/branch-sha", "/repos/owner/repo/git/commits/branch-v1-sha", "/repos/owner/repo/git/commits/light-sha", "/repos/owner/repo/git/commits/annotated-sha":
+			json.NewEncoder(w).Encode(map[string]any{
+				"sha":       path.Base(r.URL.Path),
+				"message":   "Fix build",
+				"author":    map[string]string{"name": "John", "email": "john@example.com"},
+				"committer": map[string]string{"name": "Jane", "email": "jane@example.com"},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "Not Found"})
+		}
+	})
+	m := newTestGitHub(t, nil)
+
+	tests := []struct {
+		name    string
+		wantSHA string
+		wantRef string
+	}{
+		{"main", "branch-sha", "refs/heads/main"},
+		// Branch wins over tag with the same name.
+		{"v1", "branch-v1-sha", "refs/heads/v1"},
+		{"light", "light-sha", "refs/tags/light"},
+		// Annotated tag is resolved to the commit it points to.
+		{"annotated", "annotated-sha", "refs/tags/annotated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commit, err := m.ResolveRef(ctx, testToken, "owner", "repo", tt.name)
+			if err != nil {
+				t.Fatalf("ResolveRef() error = %v", err)
+			}
+			if commit.SHA != tt.wantSHA || commit.Ref != tt.wantRef || commit.Message != "Fix build" || commit.AuthorEmail != "john@example.com" || commit.CommitterName != "Jane" {
+				t.Errorf("ResolveRef() = %+v, want %s at %s", commit, tt.wantSHA, tt.wantRef)
+			}
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := m.ResolveRef(ctx, testToken, "owner", "repo", "missing")
+		if !errors.Is(err, ErrRefNotFound) {
+			t.Errorf("ResolveRef() error = %v, want %v", err, ErrRefNotFound)
+		}
+	})
+}
+
+func TestGitHubGetFile(t *testing.T) {
+	ctx := githubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/owner/repo/contents/"+types.WorkflowPath || r.URL.Query().Get("ref") != "abc" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "Not Found"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"type":     "file",
+			"encoding": "base64",
+			"content":  base64.StdEncoding.EncodeToString([]byte("image: golang\n")),
+		})
+	})
+	m := newTestGitHub(t, nil)
+
+	file, err := m.GetFile(ctx, testToken, "owner", "repo", types.WorkflowPath, "abc")
+	if err != nil || string(file) != "image: golang\n" {
+		t.Errorf("GetFile() = %q, %v", file, err)
+	}
+	_, err = m.GetFile(ctx, testToken, "owner", "repo", types.WorkflowPath, "def")
+	if !IsNotFound(err) {
+		t.Errorf("GetFile() of missing file error = %v, want not found", err)
+	}
+}
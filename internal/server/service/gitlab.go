@@ -0,0 +1,608 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/shark-ci/shark-ci/internal/config"
+	"github.com/shark-ci/shark-ci/internal/server/store"
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+const (
+	// gitlabPageSize is maximum page size allowed by GitLab API.
+	gitlabPageSize = 100
+	// deployTokenTTL is how long are deploy tokens given to workers valid.
+	// Token of the project is reused by its pipelines while it stays valid
+	// for at least deployTokenMinTTL.
+	deployTokenTTL    = 2 * time.Hour
+	deployTokenMinTTL = 10 * time.Minute
+)
+
+type GitLabManager struct {
+	s            store.Storer
+	baseURL      string
+	oauth2Config *oauth2.Config
+}
+
+var (
+	_ ServiceManager     = &GitLabManager{}
+	_ DeployTokenManager = &GitLabManager{}
+)
+
+// NewGitLabManager creates manager for GitLab instance running at baseURL,
+// e.g. https://gitlab.com.
+func NewGitLabManager(baseURL string, clientID string, clientSecret string, s store.Storer) *GitLabManager {
+	baseURL = strings.TrimRight(baseURL, "/")
+	return &GitLabManager{
+		s:       s,
+		baseURL: baseURL,
+		oauth2Config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Scopes:       []string{"api"},
+			RedirectURL:  config.ServerConf.Host + "/oauth2/callback?service=" + string(types.ServiceGitLab),
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  baseURL + "/oauth/authorize",
+				TokenURL: baseURL + "/oauth/token",
+			},
+		},
+	}
+}
+
+func (*GitLabManager) Name() types.Service {
+	return types.ServiceGitLab
+}
+
+func (*GitLabManager) StatusName(status types.PipelineStatus) string {
+	switch status {
+	case types.Success:
+		return "success"
+	case types.Queued:
+		return "pending"
+	case types.Running:
+		return "running"
+	case types.Failure, types.Error, types.TimedOut:
+		return "failed"
+	case types.Cancelled:
+		return "canceled"
+	case types.Skipped:
+		return "skipped"
+	// Unknown status never lets the commit pass.
+	default:
+		return "failed"
+	}
+}
+
+func (m *GitLabManager) OAuth2Config() *oauth2.Config {
+	return m.oauth2Config
+}
+
+func (m *GitLabManager) GetServiceUser(ctx context.Context, token *oauth2.Token) (types.ServiceUser, error) {
+	var user struct {
+		Username    string `json:"username"`
+		Email       string `json:"email"`
+		PublicEmail string `json:"public_email"`
+	}
+	err := m.client(ctx, token).do(ctx, http.MethodGet, "/user", nil, &user)
+	if err != nil {
+		return types.ServiceUser{}, err
+	}
+
+	email := user.Email
+	if email == "" {
+		email = user.PublicEmail
+	}
+	serviceUser := types.ServiceUser{
+		Username:    user.Username,
+		Email:       email,
+		Service:     m.Name(),
+		AccessToken: token.AccessToken,
+		TokenType:   token.TokenType,
+	}
+	if token.RefreshToken != "" {
+		serviceUser.RefreshToken = &token.RefreshToken
+	}
+	if !token.Expiry.IsZero() {
+		serviceUser.TokenExpire = &token.Expiry
+	}
+	return serviceUser, nil
+}
+
+// GetUserRepos returns projects where user is at least maintainer so they can
+// create webhooks.
+func (m *GitLabManager) GetUserRepos(ctx context.Context, token *oauth2.Token, serviceUserID int64) ([]types.Repo, error) {
+	client := m.client(ctx, token)
+
+	var repos []types.Repo
+	for page := 1; ; page++ {
+		var projects []struct {
+			ID        int64  `json:"id"`
+			Path      string `json:"path"`
+			Namespace struct {
+				FullPath string `json:"full_path"`
+			} `json:"namespace"`
+		}
+		endpoint := fmt.Sprintf("/projects?membership=true&min_access_level=40&archived=false&simple=true&per_page=%d&page=%d", gitlabPageSize, page)
+		err := client.do(ctx, http.MethodGet, endpoint, nil, &projects)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range projects {
+			repos = append(repos, types.Repo{
+				RepoServiceID: p.ID,
+				Name:          p.Path,
+				Owner:         p.Namespace.FullPath,
+				Service:       m.Name(),
+				ServiceUserID: serviceUserID,
+			})
+		}
+		if len(projects) < gitlabPageSize {
+			return repos, nil
+		}
+	}
+}
+
+// GetRepo of GitLab is used to find renamed and transferred projects, project
+// hooks are not sent when it happens.
+func (m *GitLabManager) GetRepo(ctx context.Context, token *oauth2.Token, repoServiceID int64) (types.Repo, error) {
+	var project struct {
+		ID        int64  `json:"id"`
+		Path      string `json:"path"`
+		Namespace struct {
+			FullPath string `json:"full_path"`
+		} `json:"namespace"`
+		HTTPURLToRepo string `json:"http_url_to_repo"`
+		Archived      bool   `json:"archived"`
+		Visibility    string `json:"visibility"`
+	}
+	err := m.client(ctx, token).do(ctx, http.MethodGet, fmt.Sprintf("/projects/%d", repoServiceID), nil, &project)
+	if err != nil {
+		return types.Repo{}, err
+	}
+
+	return types.Repo{
+		Service:       m.Name(),
+		Owner:         project.Namespace.FullPath,
+		Name:          project.Path,
+		RepoServiceID: project.ID,
+		CloneURL:      project.HTTPURLToRepo,
+		ForgeArchived: project.Archived,
+		Private:       project.Visibility != "public",
+	}, nil
+}
+
+func (m *GitLabManager) CreateWebhook(ctx context.Context, token *oauth2.Token, owner string, repoName string, secret string) (int64, error) {
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	err := m.client(ctx, token).do(ctx, http.MethodPost, projectPath(owner, repoName)+"/hooks", m.hook(secret), &created)
+	if err != nil {
+		return 0, err
+	}
+
+	return created.ID, nil
+}
+
+func (m *GitLabManager) UpdateWebhookSecret(ctx context.Context, token *oauth2.Token, owner string, repoName string, webhookID int64, secret string) error {
+	endpoint := fmt.Sprintf("%s/hooks/%d", projectPath(owner, repoName), webhookID)
+	return m.client(ctx, token).do(ctx, http.MethodPut, endpoint, m.hook(secret), nil)
+}
+
+// gitlabHookEvents maps names of events to fields of GitLab project hook.
+var gitlabHookEvents = map[string]string{
+	"push":           "push_events",
+	"tag_push":       "tag_push_events",
+	"merge_requests": "merge_requests_events",
+}
+
+// ExpectedWebhook of GitLab has no content type, GitLab always sends JSON.
+// Project hooks cannot be disabled, so they are always active.
+func (m *GitLabManager) ExpectedWebhook() types.Webhook {
+	return types.Webhook{
+		URL:    config.ServerConf.Host + "/event_handler/" + string(m.Name()),
+		Events: []string{"push", "tag_push", "merge_requests"},
+		Active: true,
+	}
+}
+
+func (m *GitLabManager) GetWebhook(ctx context.Context, token *oauth2.Token, owner string, repoName string, webhookID int64) (types.Webhook, error) {
+	var hook map[string]any
+	endpoint := fmt.Sprintf("%s/hooks/%d", projectPath(owner, repoName), webhookID)
+	err := m.client(ctx, token).do(ctx, http.MethodGet, endpoint, nil, &hook)
+	if err != nil {
+		return types.Webhook{}, err
+	}
+
+	webhook := types.Webhook{
+		ID:     webhookID,
+		Active: true,
+	}
+	webhook.URL, _ = hook["url"].(string)
+	for event, field := range gitlabHookEvents {
+		if enabled, _ := hook[field].(bool); enabled {
+			webhook.Events = append(webhook.Events, event)
+		}
+	}
+	return webhook, nil
+}
+
+func (m *GitLabManager) RepairWebhook(ctx context.Context, token *oauth2.Token, owner string, repoName string, webhookID int64, secret string) error {
+	return m.UpdateWebhookSecret(ctx, token, owner, repoName, webhookID, secret)
+}
+
+func (m *GitLabManager) hook(secret string) map[string]any {
+	expected := m.ExpectedWebhook()
+	hook := map[string]any{
+		"url":                     expected.URL,
+		"token":                   secret,
+		"enable_ssl_verification": true,
+	}
+	for _, event := range expected.Events {
+		hook[gitlabHookEvents[event]] = true
+	}
+	return hook
+}
+
+func (m *GitLabManager) DeleteWebhook(ctx context.Context, token *oauth2.Token, owner string, repoName string, webhookID int64) error {
+	endpoint := fmt.Sprintf("%s/hooks/%d", projectPath(owner, repoName), webhookID)
+	return m.client(ctx, token).do(ctx, http.MethodDelete, endpoint, nil, nil)
+}
+
+// ValidatePayload checks secret token GitLab sends with every webhook against
+// secret of the project.
+func (m *GitLabManager) ValidatePayload(r *http.Request) ([]byte, error) {
+	payload, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets, err := webhookSecrets(r.Context(), m.s, m.Name(), m.DeliveryInfo(r, payload).RepoServiceID)
+	if err != nil {
+		return nil, err
+	}
+	token := r.Header.Get("X-Gitlab-Token")
+	for _, secret := range secrets {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1 {
+			return payload, nil
+		}
+	}
+	return nil, errors.New("invalid X-Gitlab-Token")
+}
+
+func (m *GitLabManager) DeliveryInfo(r *http.Request, payload []byte) DeliveryInfo {
+	var p struct {
+		Project struct {
+			ID int64 `json:"id"`
+		} `json:"project"`
+	}
+	// Not every event is related to project so errors are ignored.
+	_ = json.Unmarshal(payload, &p)
+
+	// Older GitLab versions don't send event UUID, so the same payload is
+	// considered the same delivery.
+	deliveryID := r.Header.Get("X-Gitlab-Event-UUID")
+	if deliveryID == "" {
+		sum := sha256.Sum256(payload)
+		deliveryID = "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	return DeliveryInfo{
+		DeliveryID:    deliveryID,
+		Event:         r.Header.Get("X-Gitlab-Event"),
+		RepoServiceID: p.Project.ID,
+	}
+}
+
+// gitlabHookProject is project as sent in webhook payloads.
+type gitlabHookProject struct {
+	ID         int64  `json:"id"`
+	GitHTTPURL string `json:"git_http_url"`
+	WebURL     string `json:"web_url"`
+}
+
+// gitlabHookCommit is commit as sent in webhook payloads.
+type gitlabHookCommit struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+	Author  struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	} `json:"author"`
+}
+
+func (m *GitLabManager) ParseEvent(ctx context.Context, event string, payload []byte) (*types.Pipeline, error) {
+	switch event {
+	case "Push Hook", "Tag Push Hook":
+		return m.handlePush(ctx, payload)
+	case "Merge Request Hook":
+		return m.handleMergeRequest(ctx, payload)
+	default:
+		return nil, ErrEventNotSupported
+	}
+}
+
+func (m *GitLabManager) handlePush(ctx context.Context, payload []byte) (*types.Pipeline, error) {
+	var e struct {
+		Ref          string             `json:"ref"`
+		Before       string             `json:"before"`
+		After        string             `json:"after"`
+		CheckoutSHA  *string            `json:"checkout_sha"`
+		UserUsername string             `json:"user_username"`
+		Project      gitlabHookProject  `json:"project"`
+		Commits      []gitlabHookCommit `json:"commits"`
+	}
+	err := json.Unmarshal(payload, &e)
+	if err != nil {
+		return nil, err
+	}
+
+	repoID, err := registeredRepoID(ctx, m.s, m.Name(), e.Project.ID)
+	if err != nil {
+		return nil, err
+	}
+	// Deleted branch or tag has nothing to build.
+	if e.CheckoutSHA == nil {
+		err = BranchDeleted(ctx, m.s, repoID, e.Ref)
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrEventNotSupported
+	}
+
+	event, branch, tag := pushRef(e.Ref)
+	pipeline := &types.Pipeline{
+		CommitSHA: *e.CheckoutSHA,
+		CloneURL:  e.Project.GitHTTPURL,
+		Status:    types.Queued,
+		RepoID:    repoID,
+		Event:     event,
+		Ref:       optional(e.Ref),
+		Branch:    branch,
+		Tag:       tag,
+		Pusher:    optional(e.UserUsername),
+	}
+	// GitLab doesn't send committer of commits.
+	for _, c := range e.Commits {
+		if c.ID == *e.CheckoutSHA {
+			pipeline.CommitMessage = optional(c.Message)
+			pipeline.AuthorName = optional(c.Author.Name)
+			pipeline.AuthorEmail = optional(c.Author.Email)
+		}
+	}
+	// New branches and tags have nothing to compare with.
+	if e.Before != "" && e.Before != zeroSHA && e.Project.WebURL != "" {
+		pipeline.CompareURL = optional(fmt.Sprintf("%s/-/compare/%s...%s", e.Project.WebURL, e.Before, e.After))
+	}
+
+	return pipeline, nil
+}
+
+// handleMergeRequest creates pipeline for head commit of opened merge request
+// or when new commits are pushed to it.
+func (m *GitLabManager) handleMergeRequest(ctx context.Context, payload []byte) (*types.Pipeline, error) {
+	var e struct {
+		Project gitlabHookProject `json:"project"`
+		User    struct {
+			Username string `json:"username"`
+		} `json:"user"`
+		ObjectAttributes struct {
+			IID             int32            `json:"iid"`
+			Action          string           `json:"action"`
+			OldRev          string           `json:"oldrev"`
+			SourceBranch    string           `json:"source_branch"`
+			TargetBranch    string           `json:"target_branch"`
+			SourceProjectID int64            `json:"source_project_id"`
+			TargetProjectID int64            `json:"target_project_id"`
+			URL             string           `json:"url"`
+			LastCommit      gitlabHookCommit `json:"last_commit"`
+		} `json:"object_attributes"`
+	}
+	err := json.Unmarshal(payload, &e)
+	if err != nil {
+		return nil, err
+	}
+
+	mr := e.ObjectAttributes
+	switch {
+	case mr.Action == "open", mr.Action == "reopen":
+	case mr.Action == "update" && mr.OldRev != "":
+	default:
+		return nil, ErrEventNotSupported
+	}
+
+	repoID, err := registeredRepoID(ctx, m.s, m.Name(), e.Project.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline := &types.Pipeline{
+		CommitSHA: mr.LastCommit.ID,
+		// Target project contains merge request commits too, so forks
+		// don't have to be accessible.
+		CloneURL:      e.Project.GitHTTPURL,
+		Status:        types.Queued,
+		RepoID:        repoID,
+		PRNumber:      &mr.IID,
+		SourceBranch:  &mr.SourceBranch,
+		TargetBranch:  &mr.TargetBranch,
+		Fork:          mr.SourceProjectID != mr.TargetProjectID,
+		Event:         types.EventPullRequest,
+		Ref:           optional(fmt.Sprintf("refs/merge-requests/%d/head", mr.IID)),
+		Branch:        &mr.SourceBranch,
+		CommitMessage: optional(mr.LastCommit.Message),
+		AuthorName:    optional(mr.LastCommit.Author.Name),
+		AuthorEmail:   optional(mr.LastCommit.Author.Email),
+		Pusher:        optional(e.User.Username),
+		CompareURL:    optional(mr.URL),
+	}
+	if config.ServerConf.PRMergeRef {
+		ref := fmt.Sprintf("refs/merge-requests/%d/merge", mr.IID)
+		pipeline.FetchRef = &ref
+	}
+
+	return pipeline, nil
+}
+
+func (m *GitLabManager) CreateStatus(ctx context.Context, token *oauth2.Token, owner string, repoName string, commit string, status Status) error {
+	s := map[string]string{
+		"state":       m.StatusName(status.State),
+		"target_url":  status.TargetURL,
+		"name":        status.Context,
+		"description": status.Description,
+	}
+	endpoint := fmt.Sprintf("%s/statuses/%s", projectPath(owner, repoName), commit)
+	return m.client(ctx, token).do(ctx, http.MethodPost, endpoint, s, nil)
+}
+
+func (m *GitLabManager) ResolveRef(ctx context.Context, token *oauth2.Token, owner string, repoName string, name string) (Commit, error) {
+	client := m.client(ctx, token)
+
+	refs := []struct{ endpoint, prefix string }{
+		{"branches", "refs/heads/"},
+		{"tags", "refs/tags/"},
+	}
+	for _, ref := range refs {
+		// Branches and tags have the same commit.
+		var r struct {
+			Commit struct {
+				ID             string `json:"id"`
+				Message        string `json:"message"`
+				AuthorName     string `json:"author_name"`
+				AuthorEmail    string `json:"author_email"`
+				CommitterName  string `json:"committer_name"`
+				CommitterEmail string `json:"committer_email"`
+			} `json:"commit"`
+		}
+		endpoint := fmt.Sprintf("%s/repository/%s/%s", projectPath(owner, repoName), ref.endpoint, url.PathEscape(name))
+		err := client.do(ctx, http.MethodGet, endpoint, nil, &r)
+		if IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return Commit{}, err
+		}
+
+		return Commit{
+			SHA:            r.Commit.ID,
+			Ref:            ref.prefix + name,
+			Message:        r.Commit.Message,
+			AuthorName:     r.Commit.AuthorName,
+			AuthorEmail:    r.Commit.AuthorEmail,
+			CommitterName:  r.Commit.CommitterName,
+			CommitterEmail: r.Commit.CommitterEmail,
+		}, nil
+	}
+	return Commit{}, ErrRefNotFound
+}
+
+func (m *GitLabManager) GetFile(ctx context.Context, token *oauth2.Token, owner string, repoName string, path string, commit string) ([]byte, error) {
+	var file struct {
+		Content string `json:"content"`
+	}
+	endpoint := fmt.Sprintf("%s/repository/files/%s?ref=%s", projectPath(owner, repoName), url.PathEscape(path), url.QueryEscape(commit))
+	err := m.client(ctx, token).do(ctx, http.MethodGet, endpoint, nil, &file)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(file.Content)
+}
+
+// DeployToken returns deploy token of the project which can only read its
+// repository. Token is stored, so it is shared by pipelines and servers until
+// it is about to expire. Replaced token stays valid for pipelines which got it
+// and it is revoked when its successor is replaced.
+func (m *GitLabManager) DeployToken(ctx context.Context, token *oauth2.Token, repoID int64, repoServiceID int64) (Credential, error) {
+	current, err := m.s.GetRepoDeployToken(ctx, repoID)
+	var replacedID *int64
+	if err == nil {
+		if time.Until(current.ExpiresAt) > deployTokenMinTTL {
+			return deployCredential(current), nil
+		}
+		replacedID = &current.ID
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return Credential{}, fmt.Errorf("store: cannot get deploy token: %w", err)
+	}
+
+	created, err := m.createDeployToken(ctx, token, repoServiceID)
+	if err != nil {
+		return Credential{}, err
+	}
+	revokeID, err := m.s.ReplaceRepoDeployToken(ctx, repoID, replacedID, created)
+	if errors.Is(err, store.ErrConflict) {
+		// Other server replaced the token meanwhile, its token is used.
+		m.revokeDeployToken(ctx, token, repoServiceID, created.ID)
+		current, err = m.s.GetRepoDeployToken(ctx, repoID)
+		if err != nil {
+			return Credential{}, fmt.Errorf("store: cannot get deploy token: %w", err)
+		}
+		return deployCredential(current), nil
+	}
+	if err != nil {
+		m.revokeDeployToken(ctx, token, repoServiceID, created.ID)
+		return Credential{}, fmt.Errorf("store: cannot save deploy token: %w", err)
+	}
+	if revokeID != nil {
+		m.revokeDeployToken(ctx, token, repoServiceID, *revokeID)
+	}
+	return deployCredential(created), nil
+}
+
+func (m *GitLabManager) createDeployToken(ctx context.Context, token *oauth2.Token, repoServiceID int64) (types.DeployToken, error) {
+	expiresAt := time.Now().Add(deployTokenTTL).UTC().Truncate(time.Second)
+	body := map[string]any{
+		"name":       "Shark CI",
+		"scopes":     []string{"read_repository"},
+		"expires_at": expiresAt.Format(time.RFC3339),
+	}
+	var t struct {
+		ID       int64  `json:"id"`
+		Username string `json:"username"`
+		Token    string `json:"token"`
+	}
+	err := m.client(ctx, token).do(ctx, http.MethodPost, fmt.Sprintf("/projects/%d/deploy_tokens", repoServiceID), body, &t)
+	if err != nil {
+		return types.DeployToken{}, err
+	}
+	return types.DeployToken{ID: t.ID, Username: t.Username, Token: t.Token, ExpiresAt: expiresAt}, nil
+}
+
+// revokeDeployToken revokes deploy token of the project. Token expires anyway,
+// so failure is only logged.
+func (m *GitLabManager) revokeDeployToken(ctx context.Context, token *oauth2.Token, repoServiceID int64, tokenID int64) {
+	err := m.client(ctx, token).do(ctx, http.MethodDelete, fmt.Sprintf("/projects/%d/deploy_tokens/%d", repoServiceID, tokenID), nil, nil)
+	if err != nil && !IsNotFound(err) {
+		slog.Warn("Cannot revoke deploy token.", "repoServiceID", repoServiceID, "tokenID", tokenID, "err", err)
+	}
+}
+
+func deployCredential(token types.DeployToken) Credential {
+	return Credential{Username: token.Username, Password: token.Token, ExpiresAt: token.ExpiresAt}
+}
+
+func (m *GitLabManager) client(ctx context.Context, token *oauth2.Token) restClient {
+	return restClient{
+		client:  httpClient(ctx, token),
+		baseURL: m.baseURL + "/api/v4",
+	}
+}
+
+// projectPath returns API path of the project. Owner may be nested group.
+func projectPath(owner string, repoName string) string {
+	return "/projects/" + url.PathEscape(owner+"/"+repoName)
+}
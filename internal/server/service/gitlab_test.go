@@ -0,0 +1,393 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shark-ci/shark-ci/internal/config"
+	"github.com/shark-ci/shark-ci/internal/server/store"
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+func newTestGitLab(t *testing.T, baseURL string) *GitLabManager {
+	t.Helper()
+	config.ServerConf.Host = "https://ci.example.com"
+	config.ServerConf.SecretKey = testSecret
+
+	return NewGitLabManager(baseURL, "id", "secret", fakeStore{repoServiceID: 7, repoID: 1, secrets: types.WebhookSecrets{Secret: "repo-secret"}})
+}
+
+func gitlabWebhook(t *testing.T, event string, payload any, token string) *http.Request {
+	t.Helper()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/event_handler/GitLab", bytes.NewReader(body))
+	r.Header.Set("X-Gitlab-Event", event)
+	r.Header.Set("X-Gitlab-Event-UUID", "delivery")
+	r.Header.Set("X-Gitlab-Token", token)
+	return r
+}
+
+func TestGitLabValidatePayload(t *testing.T) {
+	m := newTestGitLab(t, "https://gitlab.example.com")
+	payload := map[string]any{"project": map[string]any{"id": 7}}
+
+	if _, err := m.ValidatePayload(gitlabWebhook(t, "Push Hook", payload, "repo-secret")); err != nil {
+		t.Errorf("ValidatePayload() with repo secret error = %v", err)
+	}
+	if _, err := m.ValidatePayload(gitlabWebhook(t, "Push Hook", payload, "other")); err == nil {
+		t.Error("ValidatePayload() accepted invalid token")
+	}
+	// Secret key is only used by repositories without own secret.
+	if _, err := m.ValidatePayload(gitlabWebhook(t, "Push Hook", payload, testSecret)); err == nil {
+		t.Error("ValidatePayload() accepted payload with secret key")
+	}
+	other := map[string]any{"project": map[string]any{"id": 9}}
+	if _, err := m.ValidatePayload(gitlabWebhook(t, "Push Hook", other, "repo-secret")); err == nil {
+		t.Error("ValidatePayload() accepted payload of unregistered repo")
+	}
+
+	rotatedAt := time.Now().Add(-time.Hour)
+	m.s = fakeStore{repoServiceID: 7, repoID: 1, secrets: types.WebhookSecrets{Secret: "new", Previous: "old", RotatedAt: &rotatedAt}}
+	for _, secret := range []string{"new", "old"} {
+		if _, err := m.ValidatePayload(gitlabWebhook(t, "Push Hook", payload, secret)); err != nil {
+			t.Errorf("ValidatePayload() with %s secret error = %v", secret, err)
+		}
+	}
+}
+
+func TestGitLabParseEvent(t *testing.T) {
+	m := newTestGitLab(t, "https://gitlab.example.com")
+	project := map[string]any{"id": 7, "git_http_url": "https://gitlab.example.com/owner/repo.git", "web_url": "https://gitlab.example.com/owner/repo"}
+
+	t.Run("push", func(t *testing.T) {
+		r := gitlabWebhook(t, "Push Hook", map[string]any{
+			"ref":           "refs/heads/main",
+			"before":        "aaa",
+			"after":         "abc",
+			"checkout_sha":  "abc",
+			"user_username": "john",
+			"project":       project,
+			"commits": []map[string]any{
+				{"id": "aaa", "message": "Older"},
+				{"id": "abc", "message": "Fix build\n\nDetails.", "author": map[string]any{"name": "John", "email": "john@example.com"}},
+			},
+		}, "repo-secret")
+		pipeline, err := receive(m, r)
+		if err != nil {
+			t.Fatalf("receive() error = %v", err)
+		}
+		if pipeline.CommitSHA != "abc" || pipeline.RepoID != 1 || pipeline.CloneURL != "https://gitlab.example.com/owner/repo.git" {
+			t.Errorf("unexpected pipeline %+v", pipeline)
+		}
+		if pipeline.Event != types.EventPush || deref(pipeline.Branch) != "main" || pipeline.Tag != nil || deref(pipeline.Pusher) != "john" || deref(pipeline.AuthorEmail) != "john@example.com" || pipeline.CommitTitle() != "Fix build" {
+			t.Errorf("unexpected pipeline metadata %+v", pipeline)
+		}
+		if deref(pipeline.CompareURL) != "https://gitlab.example.com/owner/repo/-/compare/aaa...abc" {
+			t.Errorf("CompareURL = %q", deref(pipeline.CompareURL))
+		}
+	})
+
+	t.Run("tag", func(t *testing.T) {
+		r := gitlabWebhook(t, "Tag Push Hook", map[string]any{"ref": "refs/tags/v1.0.0", "before": zeroSHA, "after": "abc", "checkout_sha": "abc", "project": project}, "repo-secret")
+		pipeline, err := receive(m, r)
+		if err != nil {
+			t.Fatalf("receive() error = %v", err)
+		}
+		if pipeline.Event != types.EventTag || deref(pipeline.Tag) != "v1.0.0" || pipeline.Branch != nil || pipeline.CompareURL != nil {
+			t.Errorf("unexpected pipeline metadata %+v", pipeline)
+		}
+	})
+
+	t.Run("deleted branch", func(t *testing.T) {
+		var cleared []string
+		orig := m.s
+		m.s = fakeStore{repoServiceID: 7, repoID: 1, secrets: types.WebhookSecrets{Secret: "repo-secret"}, cleared: &cleared}
+		t.Cleanup(func() { m.s = orig })
+
+		r := gitlabWebhook(t, "Push Hook", map[string]any{"ref": "refs/heads/main", "after": zeroSHA, "checkout_sha": nil, "project": project}, "repo-secret")
+		_, err := receive(m, r)
+		if err != ErrEventNotSupported {
+			t.Errorf("receive() error = %v, want %v", err, ErrEventNotSupported)
+		}
+		if len(cleared) != 1 || cleared[0] != "main" {
+			t.Errorf("cleared schedules of %v, want main", cleared)
+		}
+	})
+
+	t.Run("deleted tag", func(t *testing.T) {
+		var cleared []string
+		orig := m.s
+		m.s = fakeStore{repoServiceID: 7, repoID: 1, secrets: types.WebhookSecrets{Secret: "repo-secret"}, cleared: &cleared}
+		t.Cleanup(func() { m.s = orig })
+
+		r := gitlabWebhook(t, "Tag Push Hook", map[string]any{"ref": "refs/tags/v1.0.0", "after": zeroSHA, "checkout_sha": nil, "project": project}, "repo-secret")
+		_, err := receive(m, r)
+		if err != ErrEventNotSupported || len(cleared) != 0 {
+			t.Errorf("receive() error = %v, cleared schedules of %v", err, cleared)
+		}
+	})
+
+	t.Run("merge request from fork", func(t *testing.T) {
+		r := gitlabWebhook(t, "Merge Request Hook", map[string]any{
+			"project": project,
+			"user":    map[string]any{"username": "jane"},
+			"object_attributes": map[string]any{
+				"iid":               3,
+				"action":            "open",
+				"source_branch":     "feature",
+				"target_branch":     "main",
+				"source_project_id": 8,
+				"target_project_id": 7,
+				"url":               "https://gitlab.example.com/owner/repo/-/merge_requests/3",
+				"last_commit":       map[string]any{"id": "def", "message": "Add feature"},
+			},
+		}, "repo-secret")
+		pipeline, err := receive(m, r)
+		if err != nil {
+			t.Fatalf("receive() error = %v", err)
+		}
+		if pipeline.CommitSHA != "def" || *pipeline.PRNumber != 3 || *pipeline.SourceBranch != "feature" || *pipeline.TargetBranch != "main" || !pipeline.Fork {
+			t.Errorf("unexpected pipeline %+v", pipeline)
+		}
+		if pipeline.Event != types.EventPullRequest || deref(pipeline.Ref) != "refs/merge-requests/3/head" || deref(pipeline.Pusher) != "jane" {
+			t.Errorf("unexpected pipeline metadata %+v", pipeline)
+		}
+	})
+
+	t.Run("merge request update without new commits", func(t *testing.T) {
+		r := gitlabWebhook(t, "Merge Request Hook", map[string]any{
+			"project":           project,
+			"object_attributes": map[string]any{"iid": 3, "action": "update", "last_commit": map[string]any{"id": "def"}},
+		}, "repo-secret")
+		_, err := receive(m, r)
+		if err != ErrEventNotSupported {
+			t.Errorf("receive() error = %v, want %v", err, ErrEventNotSupported)
+		}
+	})
+
+	t.Run("unsupported event", func(t *testing.T) {
+		r := gitlabWebhook(t, "Note Hook", map[string]any{"project": project}, "repo-secret")
+		_, err := receive(m, r)
+		if err != ErrEventNotSupported {
+			t.Errorf("receive() error = %v, want %v", err, ErrEventNotSupported)
+		}
+	})
+
+	t.Run("stored delivery of unregistered repo", func(t *testing.T) {
+		other := map[string]any{"id": 9, "git_http_url": "https://gitlab.example.com/owner/other.git"}
+		for event, payload := range map[string]map[string]any{
+			"Push Hook": {"ref": "refs/heads/main", "checkout_sha": "abc", "project": other},
+			"Merge Request Hook": {"project": other, "object_attributes": map[string]any{
+				"iid": 3, "action": "open", "source_project_id": 9, "target_project_id": 9, "last_commit": map[string]any{"id": "def"},
+			}},
+		} {
+			b, err := json.Marshal(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = m.ParseEvent(context.Background(), event, b)
+			if err != ErrEventNotSupported {
+				t.Errorf("ParseEvent(%s) error = %v, want %v", event, err, ErrEventNotSupported)
+			}
+		}
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		r := gitlabWebhook(t, "Push Hook", map[string]any{"checkout_sha": "abc", "project": project}, "other")
+		_, err := receive(m, r)
+		if err == nil {
+			t.Error("receive() accepted payload with invalid token")
+		}
+	})
+}
+
+// deployTokenStore stores deploy token of single repository.
+type deployTokenStore struct {
+	store.Storer
+	token    *types.DeployToken
+	previous *int64
+	// other is token saved by other server before the next replacement.
+	other *types.DeployToken
+}
+
+func (s *deployTokenStore) GetRepoDeployToken(ctx context.Context, repoID int64) (types.DeployToken, error) {
+	if s.token == nil {
+		return types.DeployToken{}, store.ErrNotFound
+	}
+	return *s.token, nil
+}
+
+func (s *deployTokenStore) ReplaceRepoDeployToken(ctx context.Context, repoID int64, replacedID *int64, token types.DeployToken) (*int64, error) {
+	if s.other != nil {
+		s.token, s.other = s.other, nil
+		return nil, store.ErrConflict
+	}
+	if (replacedID == nil) != (s.token == nil) || replacedID != nil && *replacedID != s.token.ID {
+		return nil, store.ErrConflict
+	}
+	revoke := s.previous
+	if s.token != nil {
+		s.previous = &s.token.ID
+	}
+	s.token = &token
+	return revoke, nil
+}
+
+func TestGitLabDeployToken(t *testing.T) {
+	var created int64
+	var revoked []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/api/v4/projects/7/deploy_tokens/") {
+			revoked = append(revoked, strings.TrimPrefix(r.URL.Path, "/api/v4/projects/7/deploy_tokens/"))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		var body struct {
+			Scopes    []string `json:"scopes"`
+			ExpiresAt string   `json:"expires_at"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v4/projects/7/deploy_tokens" || len(body.Scopes) != 1 || body.Scopes[0] != "read_repository" || body.ExpiresAt == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		created++
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{"id": created, "username": fmt.Sprintf("gitlab+deploy-token-%d", created), "token": "deploy"})
+	}))
+	t.Cleanup(server.Close)
+	m := newTestGitLab(t, server.URL)
+	s := &deployTokenStore{}
+	m.s = s
+
+	for range 2 {
+		credential, err := m.DeployToken(context.Background(), testToken, 1, 7)
+		if err != nil {
+			t.Fatalf("DeployToken() error = %v", err)
+		}
+		if credential.Username != "gitlab+deploy-token-1" || credential.Password != "deploy" || time.Until(credential.ExpiresAt) < time.Hour {
+			t.Errorf("DeployToken() = %+v", credential)
+		}
+	}
+	if created != 1 {
+		t.Errorf("created %d deploy tokens, want valid token reused", created)
+	}
+
+	// Token about to expire is replaced and token it replaced is revoked.
+	s.token.ExpiresAt = time.Now().Add(time.Minute)
+	old := int64(9)
+	s.previous = &old
+	credential, err := m.DeployToken(context.Background(), testToken, 1, 7)
+	if err != nil || credential.Username != "gitlab+deploy-token-2" {
+		t.Fatalf("DeployToken() of expiring token = %+v, %v, want new token", credential, err)
+	}
+	if !slices.Equal(revoked, []string{"9"}) {
+		t.Errorf("revoked tokens %v, want 9 replaced by expiring token", revoked)
+	}
+
+	// Token replaced by other server meanwhile is used and created one revoked.
+	s.token.ExpiresAt = time.Now().Add(time.Minute)
+	s.other = &types.DeployToken{ID: 10, Username: "gitlab+deploy-token-10", Token: "other", ExpiresAt: time.Now().Add(time.Hour)}
+	revoked = nil
+	credential, err = m.DeployToken(context.Background(), testToken, 1, 7)
+	if err != nil || credential.Username != "gitlab+deploy-token-10" || credential.Password != "other" {
+		t.Fatalf("DeployToken() after concurrent replacement = %+v, %v, want token of other server", credential, err)
+	}
+	if !slices.Equal(revoked, []string{"3"}) {
+		t.Errorf("revoked tokens %v, want created token 3", revoked)
+	}
+}
+
+func TestGitLabResolveRef(t *testing.T) {
+	commit := func(sha string) map[string]any {
+		return map[string]any{"id": sha, "message": "Fix build", "author_email": "john@example.com", "committer_name": "Jane"}
+	}
+	refs := map[string]map[string]any{
+		"/api/v4/projects/owner%2Frepo/repository/branches/main":  {"name": "main", "commit": commit("branch-sha")},
+		"/api/v4/projects/owner%2Frepo/repository/branches/v1":    {"name": "v1", "commit": commit("branch-v1-sha")},
+		"/api/v4/projects/owner%2Frepo/repository/tags/v1":        {"name": "v1", "target": "tag-v1-sha", "commit": commit("tag-v1-sha")},
+		"/api/v4/projects/owner%2Frepo/repository/tags/light":     {"name": "light", "target": "light-sha", "commit": commit("light-sha")},
+		"/api/v4/projects/owner%2Frepo/repository/tags/annotated": {"name": "annotated", "target": "tag-object-sha", "commit": commit("annotated-sha")},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ref, ok := refs[r.URL.EscapedPath()]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "404 Not Found"})
+			return
+		}
+		json.NewEncoder(w).Encode(ref)
+	}))
+	t.Cleanup(server.Close)
+	m := newTestGitLab(t, server.URL)
+
+	tests := []struct {
+		name    string
+		wantSHA string
+		wantRef string
+	}{
+		{"main", "branch-sha", "refs/heads/main"},
+		// Branch wins over tag with the same name.
+		{"v1", "branch-v1-sha", "refs/heads/v1"},
+		{"light", "light-sha", "refs/tags/light"},
+		// Annotated tag is resolved to its commit, not the tag object.
+		{"annotated", "annotated-sha", "refs/tags/annotated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commit, err := m.ResolveRef(context.Background(), testToken, "owner", "repo", tt.name)
+			if err != nil {
+				t.Fatalf("ResolveRef() error = %v", err)
+			}
+			if commit.SHA != tt.wantSHA || commit.Ref != tt.wantRef || commit.Message != "Fix build" || commit.AuthorEmail != "john@example.com" || commit.CommitterName != "Jane" {
+				t.Errorf("ResolveRef() = %+v, want %s at %s", commit, tt.wantSHA, tt.wantRef)
+			}
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := m.ResolveRef(context.Background(), testToken, "owner", "repo", "missing")
+		if !errors.Is(err, ErrRefNotFound) {
+			t.Errorf("ResolveRef() error = %v, want %v", err, ErrRefNotFound)
+		}
+	})
+}
+
+func TestGitLabGetFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/api/v4/projects/owner%2Frepo/repository/files/.shark-ci%2Fworkflow.yaml" || r.URL.Query().Get("ref") != "abc" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "404 File Not Found"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"encoding": "base64",
+			"content":  base64.StdEncoding.EncodeToString([]byte("image: golang\n")),
+		})
+	}))
+	t.Cleanup(server.Close)
+	m := newTestGitLab(t, server.URL)
+
+	file, err := m.GetFile(context.Background(), testToken, "owner", "repo", types.WorkflowPath, "abc")
+	if err != nil || string(file) != "image: golang\n" {
+		t.Errorf("GetFile() = %q, %v", file, err)
+	}
+	_, err = m.GetFile(context.Background(), testToken, "owner", "repo", types.WorkflowPath, "def")
+	if !IsNotFound(err) {
+		t.Errorf("GetFile() of missing file error = %v, want not found", err)
+	}
+}
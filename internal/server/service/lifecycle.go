@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/shark-ci/shark-ci/internal/server/store"
+)
+
+// RepoArchived records repository archived or unarchived on the service.
+// Archived repository stays registered with its webhook, so it works again
+// once it is unarchived, but its running pipelines are cancelled. Their
+// statuses are not reported, archived repository is read-only.
+func RepoArchived(ctx context.Context, s store.Storer, repoID int64, archived bool, actor string) error {
+	if archived {
+		_, err := s.CancelRepoPipelines(ctx, repoID, actor, "Repository was archived")
+		if err != nil {
+			return err
+		}
+	}
+	return s.SetRepoForgeArchived(ctx, repoID, archived)
+}
+
+// RepoDeleted unregisters repository deleted on the service, its pipelines are
+// kept. Its webhook was deleted with it.
+func RepoDeleted(ctx context.Context, s store.Storer, repoID int64, actor string) error {
+	_, err := s.CancelRepoPipelines(ctx, repoID, actor, "Repository was deleted")
+	if err != nil {
+		return err
+	}
+	return s.ArchiveRepo(ctx, repoID)
+}
+
+// BranchDeleted removes schedules declared in the workflow of the branch
+// deleted by the push of ref. Deleted tags have no schedules.
+func BranchDeleted(ctx context.Context, s store.Storer, repoID int64, ref string) error {
+	_, branch, _ := pushRef(ref)
+	if branch == nil {
+		return nil
+	}
+	return s.SyncWorkflowSchedules(ctx, repoID, *branch, time.Now(), nil)
+}
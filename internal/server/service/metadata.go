@@ -0,0 +1,50 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+// zeroSHA is sent as commit of created or deleted branch.
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+// pushRef returns event of push to the ref and name of pushed branch or tag.
+func pushRef(ref string) (event types.PipelineEvent, branch *string, tag *string) {
+	if name, ok := strings.CutPrefix(ref, "refs/tags/"); ok {
+		return types.EventTag, nil, &name
+	}
+	if name, ok := strings.CutPrefix(ref, "refs/heads/"); ok {
+		return types.EventPush, &name, nil
+	}
+	return types.EventPush, nil, nil
+}
+
+// optional returns nil for empty strings, services send them for missing
+// values.
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// Pipeline returns queued pipeline of the commit triggered by the event.
+func (c Commit) Pipeline(repoID int64, cloneURL string, event types.PipelineEvent) *types.Pipeline {
+	_, branch, tag := pushRef(c.Ref)
+	return &types.Pipeline{
+		Status:         types.Queued,
+		CloneURL:       cloneURL,
+		CommitSHA:      c.SHA,
+		RepoID:         repoID,
+		Event:          event,
+		Ref:            optional(c.Ref),
+		Branch:         branch,
+		Tag:            tag,
+		CommitMessage:  optional(c.Message),
+		AuthorName:     optional(c.AuthorName),
+		AuthorEmail:    optional(c.AuthorEmail),
+		CommitterName:  optional(c.CommitterName),
+		CommitterEmail: optional(c.CommitterEmail),
+	}
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+func TestPushRef(t *testing.T) {
+	tests := []struct {
+		ref    string
+		event  types.PipelineEvent
+		branch string
+		tag    string
+	}{
+		{"refs/heads/main", types.EventPush, "main", ""},
+		{"refs/heads/feature/x", types.EventPush, "feature/x", ""},
+		{"refs/tags/v1.0.0", types.EventTag, "", "v1.0.0"},
+		{"refs/notes/commits", types.EventPush, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.ref, func(t *testing.T) {
+			event, branch, tag := pushRef(test.ref)
+			if event != test.event {
+				t.Errorf("got event %s, want %s", event, test.event)
+			}
+			if got := deref(branch); got != test.branch {
+				t.Errorf("got branch %q, want %q", got, test.branch)
+			}
+			if got := deref(tag); got != test.tag {
+				t.Errorf("got tag %q, want %q", got, test.tag)
+			}
+		})
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func TestCommitPipeline(t *testing.T) {
+	commit := Commit{SHA: "abc", Ref: "refs/tags/v1.0.0", Message: "Release", AuthorName: "Author"}
+	pipeline := commit.Pipeline(1, "https://example.com/owner/repo.git", types.EventManual)
+
+	if pipeline.Status != types.Queued || pipeline.CommitSHA != "abc" || pipeline.RepoID != 1 || pipeline.Event != types.EventManual {
+		t.Errorf("unexpected pipeline %+v", pipeline)
+	}
+	if deref(pipeline.Tag) != "v1.0.0" || pipeline.Branch != nil || deref(pipeline.CommitMessage) != "Release" || pipeline.AuthorEmail != nil {
+		t.Errorf("unexpected pipeline metadata %+v", pipeline)
+	}
+}
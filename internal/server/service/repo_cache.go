@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+// repoCacheTTL is how long are repositories of user cached. Listing all of
+// them takes many requests, but searching and paging is done on every key
+// press.
+const repoCacheTTL = 2 * time.Minute
+
+// RepoCache caches repositories accessible by service users.
+type RepoCache struct {
+	mu      sync.Mutex
+	entries map[repoCacheKey]repoCacheEntry
+}
+
+type repoCacheKey struct {
+	service       types.Service
+	serviceUserID int64
+}
+
+type repoCacheEntry struct {
+	repos   []types.Repo
+	expires time.Time
+}
+
+func NewRepoCache() *RepoCache {
+	return &RepoCache{
+		entries: map[repoCacheKey]repoCacheEntry{},
+	}
+}
+
+// UserRepos returns repositories of the service user, they are fetched from
+// the service when they are not cached.
+func (c *RepoCache) UserRepos(ctx context.Context, srv ServiceManager, token *oauth2.Token, serviceUserID int64) ([]types.Repo, error) {
+	key := repoCacheKey{service: srv.Name(), serviceUserID: serviceUserID}
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.repos, nil
+	}
+
+	repos, err := srv.GetUserRepos(ctx, token, serviceUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = repoCacheEntry{repos: repos, expires: now.Add(repoCacheTTL)}
+	return repos, nil
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"golang.org/x/oauth2"
+
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+// countingManager counts how many times were repositories fetched.
+type countingManager struct {
+	ServiceManager
+	calls int
+}
+
+func (m *countingManager) Name() types.Service {
+	return types.ServiceGitHub
+}
+
+func (m *countingManager) GetUserRepos(ctx context.Context, token *oauth2.Token, serviceUserID int64) ([]types.Repo, error) {
+	m.calls++
+	return []types.Repo{{RepoServiceID: serviceUserID}}, nil
+}
+
+func TestRepoCache(t *testing.T) {
+	cache := NewRepoCache()
+	srv := &countingManager{}
+
+	for range 3 {
+		repos, err := cache.UserRepos(context.Background(), srv, &oauth2.Token{}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(repos) != 1 || repos[0].RepoServiceID != 1 {
+			t.Fatalf("got repos %v", repos)
+		}
+	}
+	if srv.calls != 1 {
+		t.Errorf("repos fetched %d times, want 1", srv.calls)
+	}
+
+	_, err := cache.UserRepos(context.Background(), srv, &oauth2.Token{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.calls != 2 {
+		t.Errorf("repos of other user fetched %d times, want 2", srv.calls)
+	}
+}
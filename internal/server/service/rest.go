@@ -0,0 +1,78 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultRetryAfter is used when service rejects request because of rate limit
+// but doesn't say when it can be retried.
+const defaultRetryAfter = time.Minute
+
+// APIError is returned when service API responds with unsuccessful status.
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API responded with status %d: %s", e.StatusCode, e.Body)
+}
+
+// restClient calls JSON REST API of services without Go client library.
+type restClient struct {
+	client  *http.Client
+	baseURL string
+}
+
+// do sends body encoded as JSON to the endpoint and decodes response into out
+// if it is not nil.
+func (c restClient) do(ctx context.Context, method string, endpoint string, body any, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+endpoint, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		apiErr := &APIError{StatusCode: resp.StatusCode, Body: string(b)}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			retryAfter := defaultRetryAfter
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				retryAfter = time.Duration(seconds) * time.Second
+			}
+			return &RateLimitError{RetryAfter: retryAfter, Err: apiErr}
+		}
+		return apiErr
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
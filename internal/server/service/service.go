@@ -3,8 +3,13 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/google/go-github/v62/github"
 	"golang.org/x/oauth2"
 
 	"github.com/shark-ci/shark-ci/internal/config"
@@ -14,6 +19,25 @@ import (
 
 var ErrEventNotSupported = errors.New("event is not supported")
 
+// ErrRefNotFound is returned when repository has no branch or tag with the
+// name.
+var ErrRefNotFound = errors.New("branch or tag not found")
+
+// RateLimitError is returned when request was rejected because service API
+// rate limit was exceeded. Request should not be retried before RetryAfter.
+type RateLimitError struct {
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry after %s: %v", e.RetryAfter, e.Err)
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return e.Err
+}
+
 type Status struct {
 	State       types.PipelineStatus
 	TargetURL   string
@@ -21,16 +45,82 @@ type Status struct {
 	Description string
 }
 
+// CheckRun is detailed report of single pipeline job for services which
+// support it.
+type CheckRun struct {
+	Name        string
+	ExternalID  string
+	State       types.PipelineStatus
+	DetailsURL  string
+	Title       string
+	Summary     string
+	Text        string
+	Annotations []Annotation
+	StartedAt   *time.Time
+	CompletedAt *time.Time
+}
+
+type AnnotationLevel string
+
+const (
+	AnnotationNotice  AnnotationLevel = "notice"
+	AnnotationWarning AnnotationLevel = "warning"
+	AnnotationFailure AnnotationLevel = "failure"
+)
+
+// Annotation points to the line of the file mentioned in the pipeline output.
+type Annotation struct {
+	Path    string
+	Line    int
+	Column  int
+	Level   AnnotationLevel
+	Message string
+}
+
+// Commit is commit branch or tag of repository points to.
+type Commit struct {
+	SHA string
+	// Ref is full name of the branch or tag, e.g. refs/heads/main.
+	Ref            string
+	Message        string
+	AuthorName     string
+	AuthorEmail    string
+	CommitterName  string
+	CommitterEmail string
+}
+
+// DeliveryInfo identifies single webhook delivery.
+type DeliveryInfo struct {
+	DeliveryID    string
+	Event         string
+	RepoServiceID int64
+}
+
 type Services map[types.Service]ServiceManager
 
-func InitServices(s store.Storer) Services {
+func InitServices(s store.Storer) (Services, error) {
 	services := Services{}
 	if config.ServerConf.GitHub.ClientID != "" && config.ServerConf.GitHub.ClientSecret != "" {
-		ghm := NewGitHubManager(config.ServerConf.GitHub.ClientID, config.ServerConf.GitHub.ClientSecret, s)
+		var app *GitHubApp
+		if appConf := config.ServerConf.GitHubApp; appConf.ID != 0 {
+			var err error
+			app, err = NewGitHubAppFromFile(int64(appConf.ID), appConf.PrivateKeyFile, appConf.WebhookSecret)
+			if err != nil {
+				return nil, err
+			}
+		}
+		ghm := NewGitHubManager(config.ServerConf.GitHub.ClientID, config.ServerConf.GitHub.ClientSecret, config.ServerConf.GitHub.Checks, app, s)
 		services[ghm.Name()] = ghm
 	}
-	// TODO: Add GitLab.
-	return services
+	if config.ServerConf.GitLab.ClientID != "" && config.ServerConf.GitLab.ClientSecret != "" {
+		glm := NewGitLabManager(config.ServerConf.GitLab.BaseURL, config.ServerConf.GitLab.ClientID, config.ServerConf.GitLab.ClientSecret, s)
+		services[glm.Name()] = glm
+	}
+	if config.ServerConf.Gitea.ClientID != "" && config.ServerConf.Gitea.ClientSecret != "" {
+		gtm := NewGiteaManager(config.ServerConf.Gitea.BaseURL, config.ServerConf.Gitea.ClientID, config.ServerConf.Gitea.ClientSecret, s)
+		services[gtm.Name()] = gtm
+	}
+	return services, nil
 }
 
 type ServiceManager interface {
@@ -39,8 +129,162 @@ type ServiceManager interface {
 	OAuth2Config() *oauth2.Config
 	GetServiceUser(ctx context.Context, token *oauth2.Token) (types.ServiceUser, error)
 	GetUserRepos(ctx context.Context, token *oauth2.Token, serviceUserID int64) ([]types.Repo, error)
-	CreateWebhook(ctx context.Context, token *oauth2.Token, owner string, repoName string) (int64, error)
+	// GetRepo returns current owner, name and clone URL of the repository.
+	GetRepo(ctx context.Context, token *oauth2.Token, repoServiceID int64) (types.Repo, error)
+	CreateWebhook(ctx context.Context, token *oauth2.Token, owner string, repoName string, secret string) (int64, error)
+	UpdateWebhookSecret(ctx context.Context, token *oauth2.Token, owner string, repoName string, webhookID int64, secret string) error
+	// ExpectedWebhook returns configuration webhooks created by the manager
+	// have.
+	ExpectedWebhook() types.Webhook
+	GetWebhook(ctx context.Context, token *oauth2.Token, owner string, repoName string, webhookID int64) (types.Webhook, error)
+	// RepairWebhook restores expected configuration of the webhook.
+	RepairWebhook(ctx context.Context, token *oauth2.Token, owner string, repoName string, webhookID int64, secret string) error
 	DeleteWebhook(ctx context.Context, token *oauth2.Token, owner string, repoName string, webhookID int64) error
-	HandleEvent(ctx context.Context, w http.ResponseWriter, r *http.Request) (*types.Pipeline, error)
+	ValidatePayload(r *http.Request) ([]byte, error)
+	DeliveryInfo(r *http.Request, payload []byte) DeliveryInfo
+	// ParseEvent handles payload of the event of delivery which was validated
+	// when it was received, so stored deliveries can be processed again after
+	// webhook secret rotation.
+	ParseEvent(ctx context.Context, event string, payload []byte) (*types.Pipeline, error)
 	CreateStatus(ctx context.Context, token *oauth2.Token, owner string, repoName string, commit string, status Status) error
+	// ResolveRef returns commit the branch points to, or the tag if there is
+	// no such branch. It returns ErrRefNotFound if there is neither.
+	ResolveRef(ctx context.Context, token *oauth2.Token, owner string, repoName string, name string) (Commit, error)
+	// GetFile returns content of the file at the commit. IsNotFound reports
+	// whether the file does not exist.
+	GetFile(ctx context.Context, token *oauth2.Token, owner string, repoName string, path string, commit string) ([]byte, error)
+}
+
+// CheckRunManager is implemented by services which can show check runs.
+type CheckRunManager interface {
+	ChecksEnabled() bool
+	CreateCheckRun(ctx context.Context, token *oauth2.Token, owner string, repoName string, commit string, run CheckRun) (int64, error)
+	UpdateCheckRun(ctx context.Context, token *oauth2.Token, owner string, repoName string, checkRunID int64, run CheckRun) error
+}
+
+// InstallationManager is implemented by services which can access
+// repositories through app installations instead of user tokens and webhooks.
+type InstallationManager interface {
+	UsesInstallations() bool
+	InstallationToken(ctx context.Context, installationID int64, repoServiceID int64) (*oauth2.Token, error)
+	// CloneToken returns installation token which can only read the
+	// repository.
+	CloneToken(ctx context.Context, installationID int64, repoServiceID int64) (*oauth2.Token, error)
+}
+
+// DeployTokenManager is implemented by services which can issue tokens only
+// allowing to read the repository without app.
+type DeployTokenManager interface {
+	DeployToken(ctx context.Context, token *oauth2.Token, repoID int64, repoServiceID int64) (Credential, error)
+}
+
+// registeredRepoID returns ID of registered repository. Events of repositories
+// which are not registered, e.g. stored deliveries of repositories
+// unregistered before they were processed, are not supported.
+func registeredRepoID(ctx context.Context, s store.Storer, service types.Service, repoServiceID int64) (int64, error) {
+	repoID, err := s.GetRepoIDByServiceRepoID(ctx, service, repoServiceID)
+	if errors.Is(err, store.ErrNotFound) {
+		return 0, ErrEventNotSupported
+	}
+	return repoID, err
+}
+
+// ErrNoInstallation is returned for repositories without app installation of
+// services which access repositories through the app.
+var ErrNoInstallation = errors.New("repository is not accessible through app installation")
+
+// ErrPrivateRepo is returned for private repositories of services which cannot
+// issue credentials scoped to the repository.
+var ErrPrivateRepo = errors.New("private repository cannot be cloned, service cannot issue credential scoped to the repository")
+
+// RepoToken returns token for cloning the repository and reporting its
+// statuses. Repositories accessed through app installation get short-lived
+// token scoped to the repository, others use token of the user who registered
+// the repository.
+func RepoToken(ctx context.Context, s store.Storer, srv ServiceManager, serviceUserID int64, userToken oauth2.Token, installationID *int64, repoServiceID int64) (oauth2.Token, error) {
+	im, ok := srv.(InstallationManager)
+	if !ok || !im.UsesInstallations() {
+		return UserToken(ctx, s, srv, serviceUserID, userToken)
+	}
+	// User token can access much more than the repository, so it is never
+	// used when the service has the app.
+	if installationID == nil {
+		return oauth2.Token{}, ErrNoInstallation
+	}
+
+	token, err := im.InstallationToken(ctx, *installationID, repoServiceID)
+	if err != nil {
+		return oauth2.Token{}, fmt.Errorf("cannot get installation token: %w", err)
+	}
+	return *token, nil
+}
+
+// IsNotFound reports whether service API responded the requested object does
+// not exist, e.g. webhook deleted by the user on the service.
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusNotFound
+	}
+	var respErr *github.ErrorResponse
+	if errors.As(err, &respErr) && respErr.Response != nil {
+		return respErr.Response.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// escapePath escapes segments of path, e.g. branch name, separately so
+// slashes are kept.
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// Credential is username and password for cloning repository over HTTPS.
+// Repository is cloned anonymously with zero credential.
+type Credential struct {
+	Username  string
+	Password  string
+	ExpiresAt time.Time
+}
+
+// CloneCredential returns credential which only allows workers to clone the
+// repository. Services which cannot issue such credential give none for public
+// repositories and ErrPrivateRepo for private ones. User token is never given
+// out.
+func CloneCredential(ctx context.Context, s store.Storer, srv ServiceManager, serviceUserID int64, userToken oauth2.Token, installationID *int64, repoID int64, repoServiceID int64) (Credential, error) {
+	if im, ok := srv.(InstallationManager); ok && im.UsesInstallations() {
+		if installationID == nil {
+			return Credential{}, ErrNoInstallation
+		}
+		token, err := im.CloneToken(ctx, *installationID, repoServiceID)
+		if err != nil {
+			return Credential{}, fmt.Errorf("cannot get installation token: %w", err)
+		}
+		return Credential{Username: "x-access-token", Password: token.AccessToken, ExpiresAt: token.Expiry}, nil
+	}
+
+	token, err := UserToken(ctx, s, srv, serviceUserID, userToken)
+	if err != nil {
+		return Credential{}, err
+	}
+	dm, ok := srv.(DeployTokenManager)
+	if !ok {
+		repo, err := srv.GetRepo(ctx, &token, repoServiceID)
+		if err != nil {
+			return Credential{}, fmt.Errorf("cannot get repository: %w", err)
+		}
+		if repo.Private {
+			return Credential{}, ErrPrivateRepo
+		}
+		return Credential{}, nil
+	}
+	credential, err := dm.DeployToken(ctx, &token, repoID, repoServiceID)
+	if err != nil {
+		return Credential{}, fmt.Errorf("cannot get deploy token: %w", err)
+	}
+	return credential, nil
 }
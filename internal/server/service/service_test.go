@@ -0,0 +1,54 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+// receive validates webhook request like the event handler and parses its
+// payload like the processor does later.
+func receive(m ServiceManager, r *http.Request) (*types.Pipeline, error) {
+	payload, err := m.ValidatePayload(r)
+	if err != nil {
+		return nil, err
+	}
+	return m.ParseEvent(r.Context(), m.DeliveryInfo(r, payload).Event, payload)
+}
+
+func TestStatusName(t *testing.T) {
+	managers := map[string]interface {
+		StatusName(types.PipelineStatus) string
+	}{
+		"GitHub": &GitHubManager{},
+		"GitLab": &GitLabManager{},
+		"Gitea":  &GiteaManager{},
+	}
+	for name, m := range managers {
+		for _, status := range types.PipelineStatuses {
+			if m.StatusName(status) == "" {
+				t.Errorf("%s: StatusName(%s) is empty", name, status)
+			}
+		}
+	}
+
+	tests := []struct {
+		got, want string
+	}{
+		{managers["GitHub"].StatusName(types.Failure), "failure"},
+		{managers["GitHub"].StatusName(types.Error), "error"},
+		{managers["GitHub"].StatusName(types.Skipped), "success"},
+		{managers["GitLab"].StatusName(types.TimedOut), "failed"},
+		{managers["GitLab"].StatusName(types.Skipped), "skipped"},
+		{managers["Gitea"].StatusName(types.Failure), "failure"},
+		{managers["GitHub"].StatusName("unknown"), "error"},
+		{managers["GitLab"].StatusName("unknown"), "failed"},
+		{managers["Gitea"].StatusName("unknown"), "error"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("StatusName() = %q, want %q", tt.got, tt.want)
+		}
+	}
+}
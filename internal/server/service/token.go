@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/shark-ci/shark-ci/internal/server/store"
+)
+
+// tokenExpiryDelta is how long before its expiry is token refreshed, so it
+// does not expire while it is used, e.g. by worker cloning the repository.
+const tokenExpiryDelta = 5 * time.Minute
+
+// ErrReloginRequired is returned when token of the service user cannot be
+// refreshed and the user has to log in again.
+var ErrReloginRequired = errors.New("token cannot be refreshed, user has to log in again")
+
+// UserToken returns valid token of the service user. Expired token is
+// refreshed and the new access and refresh tokens are saved, because services
+// like GitLab invalidate the old refresh token. When the refresh is rejected
+// the service user is flagged to log in again.
+func UserToken(ctx context.Context, s store.Storer, srv ServiceManager, serviceUserID int64, token oauth2.Token) (oauth2.Token, error) {
+	if !tokenExpired(token) {
+		return token, nil
+	}
+
+	token, err := s.RefreshServiceUserToken(ctx, serviceUserID, func(current oauth2.Token) (oauth2.Token, error) {
+		// Token may have been refreshed by another request meanwhile.
+		if !tokenExpired(current) {
+			return current, nil
+		}
+		if current.RefreshToken == "" {
+			return oauth2.Token{}, ErrReloginRequired
+		}
+
+		// Token source refreshes token without access token right away.
+		refreshed, err := srv.OAuth2Config().TokenSource(ctx, &oauth2.Token{RefreshToken: current.RefreshToken}).Token()
+		if err != nil {
+			return oauth2.Token{}, err
+		}
+		if refreshed.RefreshToken == "" {
+			refreshed.RefreshToken = current.RefreshToken
+		}
+		return *refreshed, nil
+	})
+	if err == nil {
+		return token, nil
+	}
+
+	var retrieveErr *oauth2.RetrieveError
+	rejected := errors.As(err, &retrieveErr) && retrieveErr.Response != nil &&
+		(retrieveErr.Response.StatusCode == http.StatusBadRequest || retrieveErr.Response.StatusCode == http.StatusUnauthorized)
+	if !rejected && !errors.Is(err, ErrReloginRequired) {
+		return oauth2.Token{}, fmt.Errorf("cannot refresh token of service user: %w", err)
+	}
+
+	flagErr := s.SetServiceUserReloginRequired(ctx, serviceUserID)
+	if flagErr != nil {
+		slog.Error("store: cannot flag service user to log in again", "serviceUserID", serviceUserID, "err", flagErr)
+	}
+	if errors.Is(err, ErrReloginRequired) {
+		return oauth2.Token{}, err
+	}
+	return oauth2.Token{}, fmt.Errorf("%w: %w", ErrReloginRequired, err)
+}
+
+func tokenExpired(token oauth2.Token) bool {
+	return !token.Expiry.IsZero() && time.Until(token.Expiry) < tokenExpiryDelta
+}
+
+// httpClient returns client authenticated with the token. The token is never
+// refreshed by the client, because the new token would not be saved. Tokens
+// are refreshed by UserToken instead.
+func httpClient(ctx context.Context, token *oauth2.Token) *http.Client {
+	return oauth2.NewClient(ctx, oauth2.StaticTokenSource(token))
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/shark-ci/shark-ci/internal/server/store"
+)
+
+// tokenStore holds token of single service user.
+type tokenStore struct {
+	store.Storer
+	token           oauth2.Token
+	reloginRequired bool
+}
+
+func (s *tokenStore) RefreshServiceUserToken(ctx context.Context, serviceUserID int64, refresh func(current oauth2.Token) (oauth2.Token, error)) (oauth2.Token, error) {
+	token, err := refresh(s.token)
+	if err != nil {
+		return oauth2.Token{}, err
+	}
+	s.token = token
+	return token, nil
+}
+
+func (s *tokenStore) SetServiceUserReloginRequired(ctx context.Context, serviceUserID int64) error {
+	s.reloginRequired = true
+	return nil
+}
+
+func newTokenServer(t *testing.T) *GitLabManager {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.URL.Path != "/oauth/token" || r.Form.Get("refresh_token") != "refresh" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  "new",
+			"refresh_token": "new-refresh",
+			"token_type":    "Bearer",
+			"expires_in":    7200,
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	return NewGitLabManager(server.URL, "id", "secret", nil)
+}
+
+func TestUserToken(t *testing.T) {
+	m := newTokenServer(t)
+	expired := time.Now().Add(time.Minute)
+
+	t.Run("valid", func(t *testing.T) {
+		token := oauth2.Token{AccessToken: "old", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)}
+		s := &tokenStore{token: token}
+		got, err := UserToken(context.Background(), s, m, 1, token)
+		if err != nil || got.AccessToken != "old" {
+			t.Errorf("UserToken() = %v, %v, want old token", got.AccessToken, err)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		token := oauth2.Token{AccessToken: "old", RefreshToken: "refresh", Expiry: expired}
+		s := &tokenStore{token: token}
+		got, err := UserToken(context.Background(), s, m, 1, token)
+		if err != nil {
+			t.Fatalf("UserToken() error = %v", err)
+		}
+		if got.AccessToken != "new" || s.token.RefreshToken != "new-refresh" {
+			t.Errorf("UserToken() = %+v, saved %+v, want refreshed token", got, s.token)
+		}
+	})
+
+	t.Run("refreshed meanwhile", func(t *testing.T) {
+		token := oauth2.Token{AccessToken: "old", RefreshToken: "used", Expiry: expired}
+		s := &tokenStore{token: oauth2.Token{AccessToken: "other", Expiry: time.Now().Add(time.Hour)}}
+		got, err := UserToken(context.Background(), s, m, 1, token)
+		if err != nil || got.AccessToken != "other" {
+			t.Errorf("UserToken() = %v, %v, want token saved by another refresh", got.AccessToken, err)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		token := oauth2.Token{AccessToken: "old", RefreshToken: "revoked", Expiry: expired}
+		s := &tokenStore{token: token}
+		_, err := UserToken(context.Background(), s, m, 1, token)
+		if !errors.Is(err, ErrReloginRequired) || !s.reloginRequired {
+			t.Errorf("UserToken() error = %v, flagged = %v, want %v", err, s.reloginRequired, ErrReloginRequired)
+		}
+	})
+}
+
+func TestRepoToken(t *testing.T) {
+	token := oauth2.Token{AccessToken: "user", Expiry: time.Now().Add(time.Hour)}
+
+	got, err := RepoToken(context.Background(), nil, &GitHubManager{}, 1, token, nil, 7)
+	if err != nil || got.AccessToken != "user" {
+		t.Errorf("RepoToken() without app = %v, %v, want user token", got.AccessToken, err)
+	}
+
+	// User token is never used for repositories of the app.
+	app := &GitHubManager{app: &GitHubApp{}}
+	_, err = RepoToken(context.Background(), nil, app, 1, token, nil, 7)
+	if !errors.Is(err, ErrNoInstallation) {
+		t.Errorf("RepoToken() without installation error = %v, want %v", err, ErrNoInstallation)
+	}
+}
+
+func TestCloneCredential(t *testing.T) {
+	token := oauth2.Token{AccessToken: "user", Expiry: time.Now().Add(time.Hour)}
+
+	private := map[int64]bool{7: false, 8: true}
+	ctx := githubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer user" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var id int64
+		fmt.Sscanf(r.URL.Path, "/repositories/%d", &id)
+		json.NewEncoder(w).Encode(map[string]any{"id": id, "private": private[id]})
+	})
+	m := newTestGitHub(t, nil)
+
+	// User token is never given to workers.
+	credential, err := CloneCredential(ctx, nil, m, 1, token, nil, 1, 7)
+	if err != nil || credential != (Credential{}) {
+		t.Errorf("CloneCredential() of public repo without app = %+v, %v, want anonymous", credential, err)
+	}
+	credential, err = CloneCredential(ctx, nil, m, 1, token, nil, 2, 8)
+	if !errors.Is(err, ErrPrivateRepo) || credential != (Credential{}) {
+		t.Errorf("CloneCredential() of private repo without app = %+v, %v, want %v", credential, err, ErrPrivateRepo)
+	}
+
+	_, err = CloneCredential(context.Background(), nil, &GitHubManager{app: &GitHubApp{}}, 1, token, nil, 1, 7)
+	if !errors.Is(err, ErrNoInstallation) {
+		t.Errorf("CloneCredential() without installation error = %v, want %v", err, ErrNoInstallation)
+	}
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/shark-ci/shark-ci/internal/config"
+	"github.com/shark-ci/shark-ci/internal/server/store"
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+// WebhookProblems returns what differs between the expected and the actual
+// webhook configuration. Extra events are not a problem, they are ignored.
+func WebhookProblems(expected types.Webhook, actual types.Webhook) []string {
+	var problems []string
+	if actual.URL != expected.URL {
+		problems = append(problems, fmt.Sprintf("URL is %q instead of %q", actual.URL, expected.URL))
+	}
+	if expected.ContentType != "" && actual.ContentType != expected.ContentType {
+		problems = append(problems, fmt.Sprintf("content type is %q instead of %q", actual.ContentType, expected.ContentType))
+	}
+	if expected.Active && !actual.Active {
+		problems = append(problems, "webhook is not active")
+	}
+	for _, event := range expected.Events {
+		if !slices.Contains(actual.Events, event) {
+			problems = append(problems, fmt.Sprintf("event %s is not sent", event))
+		}
+	}
+	return problems
+}
+
+// CurrentWebhookSecret returns secret the webhook of the repository should be
+// signed with.
+func CurrentWebhookSecret(ctx context.Context, s store.Storer, service types.Service, repoServiceID int64) (string, error) {
+	secrets, err := s.GetRepoWebhookSecrets(ctx, service, repoServiceID)
+	if errors.Is(err, store.ErrNotFound) {
+		return "", fmt.Errorf("repository %d is not registered", repoServiceID)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if secrets.Secret == "" {
+		return config.ServerConf.SecretKey, nil
+	}
+	return secrets.Secret, nil
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+func TestWebhookProblems(t *testing.T) {
+	expected := types.Webhook{
+		URL:         "https://ci.example.com/event_handler/GitHub",
+		ContentType: "json",
+		Events:      []string{"push", "pull_request"},
+		Active:      true,
+	}
+
+	tests := []struct {
+		name     string
+		expected types.Webhook
+		actual   types.Webhook
+		problems int
+	}{
+		{"healthy", expected, expected, 0},
+		{"extra events", expected, types.Webhook{
+			URL:         expected.URL,
+			ContentType: "json",
+			Events:      []string{"push", "pull_request", "issues"},
+			Active:      true,
+		}, 0},
+		{"misconfigured", expected, types.Webhook{
+			URL:         "https://old.example.com/event_handler/GitHub",
+			ContentType: "form",
+			Events:      []string{"push"},
+			Active:      false,
+		}, 4},
+		{"no content type", types.Webhook{URL: expected.URL, Active: true}, types.Webhook{URL: expected.URL, ContentType: "form", Active: true}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			problems := WebhookProblems(test.expected, test.actual)
+			if len(problems) != test.problems {
+				t.Errorf("got problems %q, want %d", problems, test.problems)
+			}
+		})
+	}
+}
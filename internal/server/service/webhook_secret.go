@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/shark-ci/shark-ci/internal/config"
+	"github.com/shark-ci/shark-ci/internal/server/store"
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+// webhookSecretGracePeriod is how long is the previous webhook secret of
+// repository accepted after rotation, so deliveries sent before the forge
+// hook was updated can still be processed and retried.
+const webhookSecretGracePeriod = 24 * time.Hour
+
+// NewWebhookSecret generates random webhook secret of repository.
+func NewWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// webhookSecrets returns secrets payload of the repository may be signed
+// with. Repositories registered before they had own secrets use the secret
+// key until the webhook checker rotates their secret, the secret key is their
+// previous secret then.
+func webhookSecrets(ctx context.Context, s store.Storer, service types.Service, repoServiceID int64) ([]string, error) {
+	if repoServiceID == 0 {
+		return nil, errors.New("payload is not related to repository")
+	}
+
+	secrets, err := s.GetRepoWebhookSecrets(ctx, service, repoServiceID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fmt.Errorf("repository %d is not registered", repoServiceID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if secrets.Secret == "" {
+		return []string{config.ServerConf.SecretKey}, nil
+	}
+	candidates := []string{secrets.Secret}
+	if secrets.RotatedAt != nil && time.Since(*secrets.RotatedAt) < webhookSecretGracePeriod {
+		previous := secrets.Previous
+		if previous == "" {
+			previous = config.ServerConf.SecretKey
+		}
+		candidates = append(candidates, previous)
+	}
+	return candidates, nil
+}
+
+// RotateWebhookSecret replaces webhook secret of the repository by new random
+// secret. The store is updated before the forge hook, the previous secret is
+// accepted for the grace period, so payloads signed with either secret are
+// valid whether the hook was updated yet or not. The rotation is reverted when
+// the hook cannot be updated.
+func RotateWebhookSecret(ctx context.Context, s store.Storer, srv ServiceManager, token *oauth2.Token, info *types.RepoWebhookChangeInfo) error {
+	if info.WebhookID == nil {
+		return errors.New("repo is accessed through app installation and has no webhook")
+	}
+
+	secret, err := NewWebhookSecret()
+	if err != nil {
+		return fmt.Errorf("cannot generate webhook secret: %w", err)
+	}
+	err = s.RotateRepoWebhookSecret(ctx, info.RepoID, secret)
+	if err != nil {
+		return err
+	}
+
+	err = srv.UpdateWebhookSecret(ctx, token, info.RepoOwner, info.RepoName, *info.WebhookID, secret)
+	if err != nil {
+		revertErr := s.RevertRepoWebhookSecret(ctx, info.RepoID, secret)
+		if revertErr != nil {
+			return fmt.Errorf("cannot update webhook: %w, and cannot revert secret: %w", err, revertErr)
+		}
+		return fmt.Errorf("cannot update webhook: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"golang.org/x/oauth2"
+
+	"github.com/shark-ci/shark-ci/internal/server/store"
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+// secretStore holds webhook secrets of single repository.
+type secretStore struct {
+	store.Storer
+	secret   string
+	previous string
+}
+
+func (s *secretStore) RotateRepoWebhookSecret(ctx context.Context, repoID int64, secret string) error {
+	s.previous, s.secret = s.secret, secret
+	return nil
+}
+
+func (s *secretStore) RevertRepoWebhookSecret(ctx context.Context, repoID int64, secret string) error {
+	if s.secret == secret {
+		s.secret, s.previous = s.previous, ""
+	}
+	return nil
+}
+
+// hookService records secrets the webhook was updated with.
+type hookService struct {
+	ServiceManager
+	s       *secretStore
+	secrets []string
+	err     error
+}
+
+func (h *hookService) UpdateWebhookSecret(ctx context.Context, token *oauth2.Token, owner string, repoName string, webhookID int64, secret string) error {
+	if h.s.secret != secret {
+		return errors.New("hook updated before the store")
+	}
+	if h.err != nil {
+		return h.err
+	}
+	h.secrets = append(h.secrets, secret)
+	return nil
+}
+
+func TestRotateWebhookSecret(t *testing.T) {
+	webhookID := int64(3)
+	info := &types.RepoWebhookChangeInfo{RepoID: 1, WebhookID: &webhookID}
+
+	s := &secretStore{secret: "old"}
+	srv := &hookService{s: s}
+	err := RotateWebhookSecret(context.Background(), s, srv, &oauth2.Token{}, info)
+	if err != nil {
+		t.Fatalf("RotateWebhookSecret() error = %v", err)
+	}
+	if s.previous != "old" || s.secret == "old" || len(srv.secrets) != 1 || srv.secrets[0] != s.secret {
+		t.Errorf("store has secret=%q previous=%q, hook has %v", s.secret, s.previous, srv.secrets)
+	}
+
+	s = &secretStore{secret: "old"}
+	srv = &hookService{s: s, err: errors.New("forbidden")}
+	err = RotateWebhookSecret(context.Background(), s, srv, &oauth2.Token{}, info)
+	if err == nil {
+		t.Fatal("RotateWebhookSecret() succeeded although hook was not updated")
+	}
+	if s.secret != "old" {
+		t.Errorf("secret = %q, want rotation reverted", s.secret)
+	}
+
+	app := &types.RepoWebhookChangeInfo{RepoID: 1}
+	if err := RotateWebhookSecret(context.Background(), s, srv, &oauth2.Token{}, app); err == nil {
+		t.Error("RotateWebhookSecret() succeeded for repo without webhook")
+	}
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/shark-ci/shark-ci/internal/server/db"
+	"github.com/shark-ci/shark-ci/internal/server/encryption"
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+// deployTokenField is encrypted deploy token of the repository.
+func deployTokenField(repoID int64) encryption.Field {
+	return encryption.Field{Table: "repo_deploy_token", Column: "token", Row: repoID}
+}
+
+// GetRepoDeployToken returns the current deploy token of the repository.
+// Deploy tokens are short-lived, so they are not re-encrypted after key
+// rotation. Token encrypted with key removed from the keyring is returned
+// expired without its secret, so it is replaced.
+func (s *PostgresStore) GetRepoDeployToken(ctx context.Context, repoID int64) (types.DeployToken, error) {
+	row, err := s.queries.GetRepoDeployToken(ctx, repoID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return types.DeployToken{}, ErrNotFound
+	}
+	if err != nil {
+		return types.DeployToken{}, fmt.Errorf("cannot get deploy token of repo with id=%d: %w", repoID, err)
+	}
+
+	token, err := s.keyring.Decrypt(row.TokenKeyID, row.Token, deployTokenField(repoID))
+	if errors.Is(err, encryption.ErrUnknownKey) {
+		return types.DeployToken{ID: row.TokenID, Username: row.Username}, nil
+	}
+	if err != nil {
+		return types.DeployToken{}, fmt.Errorf("cannot decrypt deploy token of repo with id=%d: %w", repoID, err)
+	}
+
+	return types.DeployToken{
+		ID:        row.TokenID,
+		Username:  row.Username,
+		Token:     token,
+		ExpiresAt: row.ExpiresAt.Time,
+	}, nil
+}
+
+// ReplaceRepoDeployToken replaces deploy token replacedID of the repository,
+// which is nil when the repository has none, by new token. Replaced token is
+// kept as the previous one, so workers which got it can still clone, and ID
+// of the token it replaced is returned to be revoked. ErrConflict is returned
+// when the current token is not the replaced one, e.g. because other server
+// replaced it meanwhile.
+func (s *PostgresStore) ReplaceRepoDeployToken(ctx context.Context, repoID int64, replacedID *int64, token types.DeployToken) (*int64, error) {
+	encrypted, err := s.keyring.Encrypt(token.Token, deployTokenField(repoID))
+	if err != nil {
+		return nil, fmt.Errorf("cannot encrypt deploy token: %w", err)
+	}
+	keyID := s.keyring.CurrentKeyID()
+	expiresAt := pgtype.Timestamp{Time: token.ExpiresAt, Valid: true}
+
+	if replacedID == nil {
+		rows, err := s.queries.CreateRepoDeployToken(ctx, db.CreateRepoDeployTokenParams{
+			RepoID:     repoID,
+			TokenID:    token.ID,
+			Username:   token.Username,
+			Token:      encrypted,
+			TokenKeyID: keyID,
+			ExpiresAt:  expiresAt,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot create deploy token of repo with id=%d: %w", repoID, err)
+		}
+		if rows == 0 {
+			return nil, ErrConflict
+		}
+		return nil, nil
+	}
+
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.queries.WithTx(tx)
+
+	current, err := qtx.LockRepoDeployToken(ctx, repoID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot get deploy token of repo with id=%d: %w", repoID, err)
+	}
+	if current.TokenID != *replacedID {
+		return nil, ErrConflict
+	}
+
+	err = qtx.ReplaceRepoDeployToken(ctx, db.ReplaceRepoDeployTokenParams{
+		RepoID:     repoID,
+		TokenID:    token.ID,
+		Username:   token.Username,
+		Token:      encrypted,
+		TokenKeyID: keyID,
+		ExpiresAt:  expiresAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot replace deploy token of repo with id=%d: %w", repoID, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot commit transaction: %w", err)
+	}
+	return ValueInt8(current.PreviousTokenID), nil
+}
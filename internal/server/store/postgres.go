@@ -2,47 +2,79 @@ package store
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"golang.org/x/oauth2"
 
 	"github.com/shark-ci/shark-ci/internal/server/db"
+	"github.com/shark-ci/shark-ci/internal/server/encryption"
+	"github.com/shark-ci/shark-ci/internal/server/jobtoken"
 	"github.com/shark-ci/shark-ci/internal/types"
 )
 
+// webhookDeliveryRetention is how long are webhook deliveries kept for
+// browsing and replay.
+const webhookDeliveryRetention = 30 * 24 * time.Hour
+
+// schedulerLockKey is key of advisory lock held while due schedules are
+// claimed, so only one server replica claims them at a time.
+const schedulerLockKey int64 = 0x536368656475 // "Schedu"
+
 type PostgresStore struct {
-	conn    *pgx.Conn
+	pool    *pgxpool.Pool
 	queries *db.Queries
+	// keyring encrypts tokens of service users and webhook secrets.
+	keyring *encryption.Keyring
 }
 
 var _ Storer = &PostgresStore{}
 
-func NewPostgresStore(ctx context.Context, postgresURI string) (*PostgresStore, error) {
-	conn, err := pgx.Connect(ctx, postgresURI)
+func NewPostgresStore(ctx context.Context, postgresURI string, keyring *encryption.Keyring) (*PostgresStore, error) {
+	if keyring == nil {
+		return nil, errors.New("token encryption keyring is required")
+	}
+
+	pool, err := pgxpool.New(ctx, postgresURI)
 	if err != nil {
 		return nil, err
 	}
 
 	return &PostgresStore{
-		conn:    conn,
-		queries: db.New(conn),
+		pool:    pool,
+		queries: db.New(pool),
+		keyring: keyring,
 	}, nil
 }
 
 func (s *PostgresStore) Ping(ctx context.Context) error {
-	return s.conn.Ping(ctx)
+	return s.pool.Ping(ctx)
 }
 
 func (s *PostgresStore) Close(ctx context.Context) error {
-	return s.conn.Close(ctx)
+	s.pool.Close()
+	return nil
 }
 
 func (s *PostgresStore) Clean(ctx context.Context) error {
-	return s.queries.CleanOAuth2State(ctx)
+	err := s.queries.CleanOAuth2State(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = s.queries.CleanJobTokens(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.queries.CleanWebhookDeliveries(ctx, pgtype.Timestamp{Time: time.Now().Add(-webhookDeliveryRetention), Valid: true})
 }
 
 func (s *PostgresStore) GetAndDeleteOAuth2State(ctx context.Context, state uuid.UUID) (types.OAuth2State, error) {
@@ -83,13 +115,22 @@ func (s *PostgresStore) GetUserIDByServiceUser(ctx context.Context, service type
 }
 
 func (s *PostgresStore) CreateUserAndServiceUser(ctx context.Context, serviceUser types.ServiceUser) (int64, int64, error) {
-	tx, err := s.conn.BeginTx(ctx, pgx.TxOptions{})
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
 		return 0, 0, fmt.Errorf("cannot begin transaction: %w", err)
 	}
 	defer tx.Rollback(ctx)
 	qtx := s.queries.WithTx(tx)
 
+	serviceUserID, err := qtx.NextServiceUserID(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot get service user ID: %w", err)
+	}
+	sealed, err := s.sealToken(serviceUserID, serviceUser.AccessToken, NullableText(serviceUser.RefreshToken))
+	if err != nil {
+		return 0, 0, err
+	}
+
 	userID, err := qtx.CreateUser(ctx, db.CreateUserParams{
 		Username: serviceUser.Username,
 		Email:    serviceUser.Email,
@@ -98,15 +139,17 @@ func (s *PostgresStore) CreateUserAndServiceUser(ctx context.Context, serviceUse
 		return 0, 0, fmt.Errorf("cannot create user: %w", err)
 	}
 
-	serviceUserID, err := qtx.CreateServiceUser(ctx, db.CreateServiceUserParams{
+	err = qtx.CreateServiceUser(ctx, db.CreateServiceUserParams{
+		ID:           serviceUserID,
 		Service:      db.Service(serviceUser.Service),
 		Username:     serviceUser.Username,
 		Email:        serviceUser.Email,
-		AccessToken:  serviceUser.AccessToken,
-		RefreshToken: NullableText(serviceUser.RefreshToken),
+		AccessToken:  sealed.AccessToken,
+		RefreshToken: sealed.RefreshToken,
 		TokenType:    serviceUser.TokenType,
 		TokenExpire:  NullableTimestamp(serviceUser.TokenExpire),
 		UserID:       userID,
+		TokenKeyID:   sealed.KeyID,
 	})
 	if err != nil {
 		return 0, 0, fmt.Errorf("cannot create service user: %w", err)
@@ -129,24 +172,151 @@ func (s *PostgresStore) GetServiceUserByUserID(ctx context.Context, service type
 		return types.ServiceUser{}, fmt.Errorf("cannot get service user with service=%s and userID=%d: %w", service, userID, err)
 	}
 
+	accessToken, refreshToken, err := s.openToken(storedToken{
+		ServiceUserID: serviceUser.ID,
+		AccessToken:   serviceUser.AccessToken,
+		RefreshToken:  serviceUser.RefreshToken,
+		KeyID:         serviceUser.TokenKeyID,
+	})
+	if err != nil {
+		return types.ServiceUser{}, fmt.Errorf("cannot get token of service user with id=%d: %w", serviceUser.ID, err)
+	}
+
 	return types.ServiceUser{
-		ID:           serviceUser.ID,
-		Service:      service,
-		Username:     serviceUser.Username,
-		Email:        serviceUser.Email,
-		AccessToken:  serviceUser.AccessToken,
-		RefreshToken: ValueText(serviceUser.RefreshToken),
-		TokenType:    serviceUser.TokenType,
-		TokenExpire:  ValueTime(serviceUser.TokenExpire),
-		UserID:       userID,
+		ID:              serviceUser.ID,
+		Service:         service,
+		Username:        serviceUser.Username,
+		Email:           serviceUser.Email,
+		AccessToken:     accessToken,
+		RefreshToken:    ValueText(refreshToken),
+		TokenType:       serviceUser.TokenType,
+		TokenExpire:     ValueTime(serviceUser.TokenExpire),
+		UserID:          userID,
+		ReloginRequired: serviceUser.ReloginRequired,
 	}, nil
 }
 
+// UpdateServiceUserToken saves new token of the service user, e.g. after they
+// logged in again.
+func (s *PostgresStore) UpdateServiceUserToken(ctx context.Context, serviceUserID int64, token oauth2.Token) error {
+	params, err := s.serviceUserTokenParams(serviceUserID, token)
+	if err != nil {
+		return err
+	}
+	err = s.queries.UpdateServiceUserToken(ctx, params)
+	if err != nil {
+		return fmt.Errorf("cannot update token of service user with id=%d: %w", serviceUserID, err)
+	}
+	return nil
+}
+
+// RefreshServiceUserToken calls refresh with the current token of the service
+// user and saves the returned token. The service user is locked meanwhile, so
+// concurrent refreshes don't use the same refresh token, which may be valid
+// only once. Refresh should return the current token if another refresh has
+// already replaced the expired one.
+func (s *PostgresStore) RefreshServiceUserToken(ctx context.Context, serviceUserID int64, refresh func(current oauth2.Token) (oauth2.Token, error)) (oauth2.Token, error) {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return oauth2.Token{}, fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.queries.WithTx(tx)
+
+	res, err := qtx.GetServiceUserTokenForUpdate(ctx, serviceUserID)
+	if err != nil {
+		return oauth2.Token{}, fmt.Errorf("cannot get token of service user with id=%d: %w", serviceUserID, err)
+	}
+	current, err := s.oauth2Token(storedToken{
+		ServiceUserID: serviceUserID,
+		AccessToken:   res.AccessToken,
+		RefreshToken:  res.RefreshToken,
+		KeyID:         res.TokenKeyID,
+	}, res.TokenType, res.TokenExpire)
+	if err != nil {
+		return oauth2.Token{}, fmt.Errorf("cannot get token of service user with id=%d: %w", serviceUserID, err)
+	}
+
+	token, err := refresh(current)
+	if err != nil {
+		return oauth2.Token{}, err
+	}
+	if token == current {
+		return current, nil
+	}
+
+	params, err := s.serviceUserTokenParams(serviceUserID, token)
+	if err != nil {
+		return oauth2.Token{}, err
+	}
+	err = qtx.UpdateServiceUserToken(ctx, params)
+	if err != nil {
+		return oauth2.Token{}, fmt.Errorf("cannot update token of service user with id=%d: %w", serviceUserID, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return oauth2.Token{}, fmt.Errorf("cannot commit transaction: %w", err)
+	}
+
+	return token, nil
+}
+
+func (s *PostgresStore) SetServiceUserReloginRequired(ctx context.Context, serviceUserID int64) error {
+	err := s.queries.SetServiceUserReloginRequired(ctx, serviceUserID)
+	if err != nil {
+		return fmt.Errorf("cannot flag service user with id=%d: %w", serviceUserID, err)
+	}
+	return nil
+}
+
+// GetReloginRequiredServices returns services the user has to log in again to,
+// because their tokens cannot be refreshed.
+func (s *PostgresStore) GetReloginRequiredServices(ctx context.Context, userID int64) ([]types.Service, error) {
+	res, err := s.queries.GetReloginRequiredServices(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get relogin required services of user with id=%d: %w", userID, err)
+	}
+
+	services := make([]types.Service, 0, len(res))
+	for _, srv := range res {
+		services = append(services, types.Service(srv))
+	}
+	return services, nil
+}
+
+func (s *PostgresStore) serviceUserTokenParams(serviceUserID int64, token oauth2.Token) (db.UpdateServiceUserTokenParams, error) {
+	var refreshToken pgtype.Text
+	if token.RefreshToken != "" {
+		refreshToken = pgtype.Text{String: token.RefreshToken, Valid: true}
+	}
+	sealed, err := s.sealToken(serviceUserID, token.AccessToken, refreshToken)
+	if err != nil {
+		return db.UpdateServiceUserTokenParams{}, err
+	}
+
+	params := db.UpdateServiceUserTokenParams{
+		ID:           serviceUserID,
+		AccessToken:  sealed.AccessToken,
+		RefreshToken: sealed.RefreshToken,
+		TokenType:    token.TokenType,
+		TokenKeyID:   sealed.KeyID,
+	}
+	if !token.Expiry.IsZero() {
+		params.TokenExpire = pgtype.Timestamp{Time: token.Expiry, Valid: true}
+	}
+	return params, nil
+}
+
 func (s *PostgresStore) GetRepoIDByServiceRepoID(ctx context.Context, service types.Service, serviceRepoID int64) (int64, error) {
-	return s.queries.GetRepoIDByServiceRepoID(ctx, db.GetRepoIDByServiceRepoIDParams{
+	repoID, err := s.queries.GetRepoIDByServiceRepoID(ctx, db.GetRepoIDByServiceRepoIDParams{
 		Service:       db.Service(service),
 		RepoServiceID: serviceRepoID,
 	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return repoID, err
 }
 
 func (s *PostgresStore) GetUserRepos(ctx context.Context, userID int64) ([]types.Repo, error) {
@@ -158,13 +328,15 @@ func (s *PostgresStore) GetUserRepos(ctx context.Context, userID int64) ([]types
 	var result []types.Repo
 	for _, repo := range repos {
 		result = append(result, types.Repo{
-			ID:            repo.ID,
-			Service:       types.Service(repo.Service),
-			Owner:         repo.Owner,
-			Name:          repo.Name,
-			RepoServiceID: repo.RepoServiceID,
-			WebhookID:     repo.WebhookID,
-			ServiceUserID: repo.ServiceUserID,
+			ID:             repo.ID,
+			Service:        types.Service(repo.Service),
+			Owner:          repo.Owner,
+			Name:           repo.Name,
+			RepoServiceID:  repo.RepoServiceID,
+			WebhookID:      ValueInt8(repo.WebhookID),
+			InstallationID: ValueInt8(repo.InstallationID),
+			ServiceUserID:  repo.ServiceUserID,
+			ArchivedAt:     ValueTime(repo.ArchivedAt),
 		})
 	}
 
@@ -178,62 +350,440 @@ func (s *PostgresStore) UserOwnRepo(ctx context.Context, userID int64, repoID in
 	})
 }
 
+// CreateRepo creates the repository or registers archived one again. Webhook
+// secret is encrypted with ID of the repository, which is known only after it
+// is created, so it is set in the same transaction.
 func (s *PostgresStore) CreateRepo(ctx context.Context, repo types.Repo) (int64, error) {
-	repoID, err := s.queries.CreateRepo(ctx, db.CreateRepoParams{
-		Service:       db.Service(repo.Service),
-		Owner:         repo.Owner,
-		Name:          repo.Name,
-		RepoServiceID: repo.RepoServiceID,
-		WebhookID:     repo.WebhookID,
-		ServiceUserID: repo.ServiceUserID,
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.queries.WithTx(tx)
+
+	repoID, err := qtx.CreateRepo(ctx, db.CreateRepoParams{
+		Service:        db.Service(repo.Service),
+		Owner:          repo.Owner,
+		Name:           repo.Name,
+		RepoServiceID:  repo.RepoServiceID,
+		WebhookID:      NullableInt8(repo.WebhookID),
+		InstallationID: NullableInt8(repo.InstallationID),
+		ServiceUserID:  repo.ServiceUserID,
 	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrAlreadyExists
+	}
 	if err != nil {
 		return 0, fmt.Errorf("cannot create repo: %w", err)
 	}
 
+	if repo.WebhookSecret != nil {
+		secret, keyID, err := s.sealSecret(repoID, NullableText(repo.WebhookSecret))
+		if err != nil {
+			return 0, err
+		}
+		err = qtx.SetRepoWebhookSecretCiphertext(ctx, db.SetRepoWebhookSecretCiphertextParams{
+			ID:                 repoID,
+			WebhookSecret:      secret,
+			WebhookSecretKeyID: keyID,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("cannot set webhook secret of repo with id=%d: %w", repoID, err)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("cannot commit transaction: %w", err)
+	}
 	return repoID, nil
 }
 
+func (s *PostgresStore) GetRepoWebhookChangeInfo(ctx context.Context, repoID int64) (*types.RepoWebhookChangeInfo, error) {
+	res, err := s.queries.GetRepoWebhookChangeInfo(ctx, repoID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot get webhook change info of repo with id=%d: %w", repoID, err)
+	}
+
+	token, err := s.oauth2Token(storedToken{
+		ServiceUserID: res.ServiceUserID,
+		AccessToken:   res.AccessToken,
+		RefreshToken:  res.RefreshToken,
+		KeyID:         res.TokenKeyID,
+	}, res.TokenType, res.TokenExpire)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get token of service user of repo with id=%d: %w", repoID, err)
+	}
+
+	return &types.RepoWebhookChangeInfo{
+		RepoID:        res.ID,
+		Service:       types.Service(res.Service),
+		RepoOwner:     res.Owner,
+		RepoName:      res.Name,
+		RepoServiceID: res.RepoServiceID,
+		WebhookID:     ValueInt8(res.WebhookID),
+		ForgeArchived: res.ForgeArchivedAt.Valid,
+		ServiceUserID: res.ServiceUserID,
+		Token:         token,
+		UserID:        res.UserID,
+	}, nil
+}
+
+// GetRegisteredRepoServiceIDs returns which of the repositories are
+// registered. Archived repositories can be registered again, so they are not
+// returned.
+func (s *PostgresStore) GetRegisteredRepoServiceIDs(ctx context.Context, service types.Service, repoServiceIDs []int64) ([]int64, error) {
+	ids, err := s.queries.GetRegisteredRepoServiceIDs(ctx, db.GetRegisteredRepoServiceIDsParams{
+		Service:        db.Service(service),
+		RepoServiceIds: repoServiceIDs,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot get registered repos of service=%s: %w", service, err)
+	}
+	return ids, nil
+}
+
+// ClaimReposToCheckWebhook returns IDs of repositories with webhook which were
+// not checked for the interval, the least recently checked first. Claimed
+// repositories are marked as checked, so other servers skip them.
+func (s *PostgresStore) ClaimReposToCheckWebhook(ctx context.Context, interval time.Duration, maxRepos int32) ([]int64, error) {
+	repoIDs, err := s.queries.ClaimReposToCheckWebhook(ctx, db.ClaimReposToCheckWebhookParams{
+		Interval: Interval(interval),
+		MaxRepos: maxRepos,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot claim repos to check webhook: %w", err)
+	}
+	return repoIDs, nil
+}
+
+func (s *PostgresStore) GetRepoWebhookHealth(ctx context.Context, repoID int64) (types.WebhookHealth, error) {
+	res, err := s.queries.GetRepoWebhookHealth(ctx, repoID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return types.WebhookHealth{}, ErrNotFound
+	}
+	if err != nil {
+		return types.WebhookHealth{}, fmt.Errorf("cannot get webhook health of repo with id=%d: %w", repoID, err)
+	}
+
+	return types.WebhookHealth{
+		WebhookID:      ValueInt8(res.WebhookID),
+		CheckedAt:      ValueTime(res.WebhookCheckedAt),
+		CheckError:     ValueText(res.WebhookCheckError),
+		RepairedAt:     ValueTime(res.WebhookRepairedAt),
+		LastDeliveryAt: ValueTime(res.LastDeliveryAt),
+	}, nil
+}
+
+// SetRepoWebhookCheck saves result of webhook check. Nil checkError means the
+// webhook is healthy.
+func (s *PostgresStore) SetRepoWebhookCheck(ctx context.Context, repoID int64, checkError *string, repaired bool) error {
+	err := s.queries.SetRepoWebhookCheck(ctx, db.SetRepoWebhookCheckParams{
+		CheckError: NullableText(checkError),
+		Repaired:   repaired,
+		ID:         repoID,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot set webhook check of repo with id=%d: %w", repoID, err)
+	}
+	return nil
+}
+
+// UpdateRepoName changes owner and name of the repository after it was
+// renamed or transferred on the service.
+func (s *PostgresStore) UpdateRepoName(ctx context.Context, repoID int64, owner string, name string) error {
+	err := s.queries.UpdateRepoName(ctx, db.UpdateRepoNameParams{
+		ID:    repoID,
+		Owner: owner,
+		Name:  name,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot update name of repo with id=%d: %w", repoID, err)
+	}
+	return nil
+}
+
+// SetRepoWebhookID replaces webhook of the repository, e.g. when it was
+// recreated.
+func (s *PostgresStore) SetRepoWebhookID(ctx context.Context, repoID int64, webhookID int64) error {
+	err := s.queries.SetRepoWebhookID(ctx, db.SetRepoWebhookIDParams{
+		ID:        repoID,
+		WebhookID: NullableInt8(&webhookID),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot set webhook of repo with id=%d: %w", repoID, err)
+	}
+	return nil
+}
+
+// SetRepoInstallation sets app installation through which is the repository
+// accessed. Nil installationID means app was uninstalled from the repository.
+func (s *PostgresStore) SetRepoInstallation(ctx context.Context, service types.Service, serviceRepoID int64, installationID *int64) error {
+	err := s.queries.SetRepoInstallation(ctx, db.SetRepoInstallationParams{
+		InstallationID: NullableInt8(installationID),
+		Service:        db.Service(service),
+		RepoServiceID:  serviceRepoID,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot set installation of repo with service=%s and serviceRepoID=%d: %w", service, serviceRepoID, err)
+	}
+	return nil
+}
+
+// ClearInstallation removes deleted app installation from all repositories.
+func (s *PostgresStore) ClearInstallation(ctx context.Context, service types.Service, installationID int64) error {
+	err := s.queries.ClearInstallation(ctx, db.ClearInstallationParams{
+		Service:        db.Service(service),
+		InstallationID: NullableInt8(&installationID),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot clear installation with id=%d: %w", installationID, err)
+	}
+	return nil
+}
+
 func (s *PostgresStore) DeleteRepo(ctx context.Context, repoID int64) error {
 	return s.queries.DeleteRepo(ctx, repoID)
 }
 
-func (s *PostgresStore) GetPipelinesByRepo(ctx context.Context, repoID int64) ([]types.Pipeline, error) {
-	res, err := s.queries.GetPipelinesByRepo(ctx, repoID)
+// ArchiveRepo keeps the repository and its pipelines, but it no longer
+// receives events.
+func (s *PostgresStore) ArchiveRepo(ctx context.Context, repoID int64) error {
+	err := s.queries.ArchiveRepo(ctx, repoID)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("cannot archive repo with id=%d: %w", repoID, err)
 	}
+	return nil
+}
 
-	var result []types.Pipeline
-	for _, pipeline := range res {
-		result = append(result, types.Pipeline{
-			ID:         pipeline.ID,
-			URL:        pipeline.Url.String,
-			Status:     types.PipelineStatus(pipeline.Status),
-			CommitSHA:  pipeline.CommitSha,
-			StartedAt:  ValueTime(pipeline.StartedAt),
-			FinishedAt: ValueTime(pipeline.FinishedAt),
+// SetRepoForgeArchived sets whether the repository is archived on the
+// service. Unlike ArchiveRepo it keeps the repository registered.
+func (s *PostgresStore) SetRepoForgeArchived(ctx context.Context, repoID int64, archived bool) error {
+	err := s.queries.SetRepoForgeArchived(ctx, db.SetRepoForgeArchivedParams{
+		ID:       repoID,
+		Archived: archived,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot set forge archived of repo with id=%d: %w", repoID, err)
+	}
+	return nil
+}
+
+// CancelRepoPipelines cancels queued and running pipelines of the repository
+// and revokes their job tokens, so workers cannot clone it anymore.
+func (s *PostgresStore) CancelRepoPipelines(ctx context.Context, repoID int64, actor string, message string) ([]types.Pipeline, error) {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.queries.WithTx(tx)
+
+	ids, err := qtx.GetRepoActivePipelineIDs(ctx, repoID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get active pipelines of repo with id=%d: %w", repoID, err)
+	}
+	pipelines := make([]types.Pipeline, 0, len(ids))
+	for _, id := range ids {
+		_, transitioned, err := transitionPipeline(ctx, qtx, id, types.PipelineTransition{
+			To:      types.Cancelled,
+			Actor:   actor,
+			Message: &message,
+			At:      time.Now(),
 		})
+		if err != nil {
+			return nil, err
+		}
+		if !transitioned {
+			continue
+		}
+		p, err := qtx.GetPipeline(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get pipeline with id=%d: %w", id, err)
+		}
+		pipelines = append(pipelines, pipelineFromDB(p))
+	}
+	err = qtx.RevokeRepoJobTokens(ctx, repoID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot revoke job tokens of repo with id=%d: %w", repoID, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot commit transaction: %w", err)
+	}
+	return pipelines, nil
+}
+
+// GetRepoPipelines returns pipelines of the repository matching the filter,
+// the newest first.
+func (s *PostgresStore) GetRepoPipelines(ctx context.Context, repoID int64, filter types.PipelineFilter) ([]types.Pipeline, error) {
+	params := db.GetRepoPipelinesParams{
+		RepoID:       repoID,
+		Branch:       NullableText(filter.Branch),
+		CreatedFrom:  NullableTimestamp(filter.From),
+		CreatedTo:    NullableTimestamp(filter.To),
+		BeforeID:     NullableInt8(filter.BeforeID),
+		MaxPipelines: filter.Limit,
+	}
+	if filter.Status != nil {
+		params.Status = db.NullPipelineStatus{PipelineStatus: db.PipelineStatus(*filter.Status), Valid: true}
+	}
+	res, err := s.queries.GetRepoPipelines(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get pipelines of repo with id=%d: %w", repoID, err)
+	}
+
+	pipelines := make([]types.Pipeline, 0, len(res))
+	for _, pipeline := range res {
+		pipelines = append(pipelines, pipelineFromDB(pipeline))
+	}
+	return pipelines, nil
+}
+
+func (s *PostgresStore) GetPipeline(ctx context.Context, pipelineID int64) (types.Pipeline, error) {
+	pipeline, err := s.queries.GetPipeline(ctx, pipelineID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return types.Pipeline{}, ErrNotFound
+	}
+	if err != nil {
+		return types.Pipeline{}, fmt.Errorf("cannot get pipeline with id=%d: %w", pipelineID, err)
+	}
+
+	return pipelineFromDB(pipeline), nil
+}
+
+func (s *PostgresStore) GetPipelinesAwaitingApproval(ctx context.Context, repoID int64) ([]types.Pipeline, error) {
+	pipelines, err := s.queries.GetPipelinesAwaitingApproval(ctx, repoID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get pipelines awaiting approval of repo with id=%d: %w", repoID, err)
 	}
 
+	result := make([]types.Pipeline, 0, len(pipelines))
+	for _, pipeline := range pipelines {
+		result = append(result, pipelineFromDB(pipeline))
+	}
 	return result, nil
 }
 
+// ApprovePipeline marks pipeline awaiting approval as approved by the user.
+// It returns false if the pipeline was not awaiting approval.
+func (s *PostgresStore) ApprovePipeline(ctx context.Context, pipelineID int64, userID int64) (bool, error) {
+	rows, err := s.queries.ApprovePipeline(ctx, db.ApprovePipelineParams{
+		ID:         pipelineID,
+		ApprovedBy: NullableInt8(&userID),
+	})
+	if err != nil {
+		return false, fmt.Errorf("cannot approve pipeline with id=%d: %w", pipelineID, err)
+	}
+	return rows > 0, nil
+}
+
+// MarkPipelineSent records that the pipeline was sent to workers.
+func (s *PostgresStore) MarkPipelineSent(ctx context.Context, pipelineID int64) error {
+	err := s.queries.MarkPipelineSent(ctx, pipelineID)
+	if err != nil {
+		return fmt.Errorf("cannot mark pipeline with id=%d as sent: %w", pipelineID, err)
+	}
+	return nil
+}
+
+// RevertPipelineApproval returns approved pipeline which was not sent to
+// workers back to awaiting approval.
+func (s *PostgresStore) RevertPipelineApproval(ctx context.Context, pipelineID int64) error {
+	err := s.queries.RevertPipelineApproval(ctx, pipelineID)
+	if err != nil {
+		return fmt.Errorf("cannot revert approval of pipeline with id=%d: %w", pipelineID, err)
+	}
+	return nil
+}
+
+// RejectPipeline cancels pipeline awaiting approval without running it. It
+// returns false if the pipeline was not awaiting approval.
+func (s *PostgresStore) RejectPipeline(ctx context.Context, pipelineID int64, actor string) (bool, error) {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return false, fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.queries.WithTx(tx)
+
+	_, err = qtx.LockPipelineAwaitingApproval(ctx, pipelineID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("cannot lock pipeline with id=%d: %w", pipelineID, err)
+	}
+	queued := types.Queued
+	message := "Rejected by maintainer"
+	_, transitioned, err := transitionPipeline(ctx, qtx, pipelineID, types.PipelineTransition{
+		From:    &queued,
+		To:      types.Cancelled,
+		Actor:   actor,
+		Message: &message,
+		At:      time.Now(),
+	})
+	if err != nil || !transitioned {
+		return false, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return false, fmt.Errorf("cannot commit transaction: %w", err)
+	}
+	return true, nil
+}
+
+// GetPipelineCheckRuns returns IDs of check runs of the pipeline by their job.
+func (s *PostgresStore) GetPipelineCheckRuns(ctx context.Context, pipelineID int64) (map[string]int64, error) {
+	rows, err := s.queries.GetPipelineCheckRuns(ctx, pipelineID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get check runs of pipeline with id=%d: %w", pipelineID, err)
+	}
+
+	checkRuns := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		checkRuns[row.Job] = row.CheckRunID
+	}
+	return checkRuns, nil
+}
+
+func (s *PostgresStore) SetPipelineCheckRun(ctx context.Context, pipelineID int64, job string, checkRunID int64) error {
+	return s.queries.SetPipelineCheckRun(ctx, db.SetPipelineCheckRunParams{
+		PipelineID: pipelineID,
+		Job:        job,
+		CheckRunID: checkRunID,
+	})
+}
+
 func (s *PostgresStore) GetPipelineCreationInfo(ctx context.Context, repoID int64) (*types.PipelineCreationInfo, error) {
 	res, err := s.queries.GetPipelineCreationInfo(ctx, repoID)
 	if err != nil {
 		return nil, err
 	}
 
+	token, err := s.oauth2Token(storedToken{
+		ServiceUserID: res.ServiceUserID,
+		AccessToken:   res.AccessToken,
+		RefreshToken:  res.RefreshToken,
+		KeyID:         res.TokenKeyID,
+	}, res.TokenType, res.TokenExpire)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get token of service user of repo with id=%d: %w", repoID, err)
+	}
+
 	return &types.PipelineCreationInfo{
-		Username: res.Username,
-		RepoName: res.Name,
-		Token: oauth2.Token{
-			AccessToken:  res.AccessToken,
-			RefreshToken: res.RefreshToken.String,
-			TokenType:    res.TokenType,
-			Expiry:       res.TokenExpire.Time,
-		},
+		ServiceUserID:  res.ServiceUserID,
+		Username:       res.Username,
+		RepoName:       res.Name,
+		Service:        types.Service(res.Service),
+		RepoServiceID:  res.RepoServiceID,
+		InstallationID: ValueInt8(res.InstallationID),
+		Token:          token,
 	}, nil
 }
 
@@ -244,72 +794,975 @@ func (s *PostgresStore) GetPipelineStateChangeInfo(ctx context.Context, pipeline
 		return nil, err
 	}
 
+	token, err := s.oauth2Token(storedToken{
+		ServiceUserID: res.ServiceUserID,
+		AccessToken:   res.AccessToken,
+		RefreshToken:  res.RefreshToken,
+		KeyID:         res.TokenKeyID,
+	}, res.TokenType, res.TokenExpire)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get token of service user of pipeline with id=%d: %w", pipelineID, err)
+	}
+
 	return &types.PipelineStateChangeInfo{
+		RepoID:    res.RepoID,
+		Number:    res.Number,
+		Status:    types.PipelineStatus(res.Status),
 		CommitSHA: res.CommitSha,
 		URL:       res.Url.String,
+		PRNumber:  ValueInt4(res.PrNumber),
 		Service:   types.Service(res.Service),
 		RepoOwner: res.Owner,
 		RepoName:  res.Name,
-		Token: oauth2.Token{
-			AccessToken:  res.AccessToken,
-			RefreshToken: res.RefreshToken.String,
-			TokenType:    res.TokenType,
-			Expiry:       res.TokenExpire.Time,
-		},
+		Token:     token,
 		StartedAt: ValueTime(res.StartedAt),
 	}, nil
 }
 
+// CreatePipeline creates the pipeline and records its initial status.
 func (s *PostgresStore) CreatePipeline(ctx context.Context, pipeline *types.Pipeline) (int64, error) {
-	pipelineID, err := s.queries.CreatePipeline(ctx, db.CreatePipelineParams{
-		Status:    db.PipelineStatus(pipeline.Status),
-		CloneUrl:  pipeline.CloneURL,
-		CommitSha: pipeline.CommitSHA,
-		RepoID:    pipeline.RepoID,
-	})
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
-		return 0, err
+		return 0, fmt.Errorf("cannot begin transaction: %w", err)
 	}
+	defer tx.Rollback(ctx)
+	qtx := s.queries.WithTx(tx)
 
-	pipeline.ID = pipelineID
-	pipeline.CreateURL()
-	err = s.queries.SetPipelineUrl(ctx, db.SetPipelineUrlParams{
-		ID:  pipelineID,
-		Url: NullableText(&pipeline.URL),
-	})
+	actor := types.ActorSystem
+	if pipeline.Pusher != nil {
+		actor = *pipeline.Pusher
+	}
+	pipeline.Attempt = 1
+	err = createPipeline(ctx, qtx, pipeline, actor, nil)
 	if err != nil {
 		return 0, err
 	}
 
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("cannot commit transaction: %w", err)
+	}
 	return pipeline.ID, nil
 }
 
-func (s *PostgresStore) PipelineStarted(ctx context.Context, pipelineID int64, status types.PipelineStatus, startedAt time.Time) error {
-	return s.queries.PipelineStarted(ctx, db.PipelineStartedParams{
-		ID:        pipelineID,
-		Status:    db.PipelineStatus(status),
-		StartedAt: pgtype.Timestamp{Time: startedAt, Valid: true},
-	})
-}
+// CreateDeliveryPipeline creates the pipeline like CreatePipeline, or like
+// CreatePipelineRerun for re-runs, and saves it to the delivery which produced
+// it, so retries of the delivery resume it instead of creating another one.
+func (s *PostgresStore) CreateDeliveryPipeline(ctx context.Context, deliveryID int64, pipeline *types.Pipeline) (int64, error) {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.queries.WithTx(tx)
 
-func (s *PostgresStore) PipelineFinnished(ctx context.Context, pipelineID int64, status types.PipelineStatus, finnisedAt time.Time) error {
-	return s.queries.PipelineFinished(ctx, db.PipelineFinishedParams{
-		ID:         pipelineID,
-		Status:     db.PipelineStatus(status),
-		FinishedAt: pgtype.Timestamp{Time: finnisedAt, Valid: true},
+	actor := types.ActorSystem
+	if pipeline.Pusher != nil {
+		actor = *pipeline.Pusher
+	}
+	if pipeline.OriginalID != nil {
+		err = createPipelineRerun(ctx, qtx, pipeline, actor)
+	} else {
+		pipeline.Attempt = 1
+		err = createPipeline(ctx, qtx, pipeline, actor, nil)
+	}
+	if err != nil {
+		return 0, err
+	}
+	err = qtx.SetWebhookDeliveryPipeline(ctx, db.SetWebhookDeliveryPipelineParams{
+		ID:         deliveryID,
+		PipelineID: NullableInt8(&pipeline.ID),
 	})
+	if err != nil {
+		return 0, fmt.Errorf("cannot set pipeline of webhook delivery with id=%d: %w", deliveryID, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("cannot commit transaction: %w", err)
+	}
+	return pipeline.ID, nil
 }
 
-func (s *PostgresStore) CreatePipelineLog(ctx context.Context, log types.PipelineLog) (int64, error) {
-	return s.queries.CreatePipelineLog(ctx, db.CreatePipelineLogParams{
-		Order:      int32(log.Order),
+// CreatePipelineRerun creates next attempt of the pipeline returned by
+// types.Pipeline.Rerun. It returns ErrConflict if some attempt of the pipeline
+// did not finish yet.
+func (s *PostgresStore) CreatePipelineRerun(ctx context.Context, pipeline *types.Pipeline, actor string) (int64, error) {
+	if pipeline.OriginalID == nil {
+		return 0, errors.New("pipeline is not a re-run")
+	}
+
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.queries.WithTx(tx)
+
+	err = createPipelineRerun(ctx, qtx, pipeline, actor)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("cannot commit transaction: %w", err)
+	}
+	return pipeline.ID, nil
+}
+
+// createPipelineRerun creates next attempt of the pipeline once all its
+// attempts finished.
+func createPipelineRerun(ctx context.Context, q *db.Queries, pipeline *types.Pipeline, actor string) error {
+	originalID := *pipeline.OriginalID
+	attempts, err := q.LockPipelineAttempts(ctx, originalID)
+	if err != nil {
+		return fmt.Errorf("cannot lock attempts of pipeline with id=%d: %w", originalID, err)
+	}
+	if len(attempts) == 0 {
+		return ErrNotFound
+	}
+	for _, a := range attempts {
+		if !types.PipelineStatus(a.Status).Terminal() {
+			return fmt.Errorf("attempt %d of pipeline with id=%d is %s: %w", a.Attempt, originalID, a.Status, ErrConflict)
+		}
+	}
+
+	pipeline.Attempt = attempts[len(attempts)-1].Attempt + 1
+	message := fmt.Sprintf("Attempt %d", pipeline.Attempt)
+	return createPipeline(ctx, q, pipeline, actor, &message)
+}
+
+// createPipeline creates the pipeline with its URL and records its creation
+// by the actor.
+func createPipeline(ctx context.Context, q *db.Queries, pipeline *types.Pipeline, actor string, message *string) error {
+	var inputs []byte
+	if pipeline.Inputs != nil {
+		var err error
+		inputs, err = json.Marshal(pipeline.Inputs)
+		if err != nil {
+			return fmt.Errorf("cannot marshal pipeline inputs: %w", err)
+		}
+	}
+
+	created, err := q.CreatePipeline(ctx, db.CreatePipelineParams{
+		Status:           db.PipelineStatus(pipeline.Status),
+		CloneUrl:         pipeline.CloneURL,
+		CommitSha:        pipeline.CommitSHA,
+		RepoID:           pipeline.RepoID,
+		PrNumber:         NullableInt4(pipeline.PRNumber),
+		SourceBranch:     NullableText(pipeline.SourceBranch),
+		TargetBranch:     NullableText(pipeline.TargetBranch),
+		FetchRef:         NullableText(pipeline.FetchRef),
+		Fork:             pipeline.Fork,
+		AwaitingApproval: pipeline.AwaitingApproval,
+		Event:            string(pipeline.Event),
+		Ref:              NullableText(pipeline.Ref),
+		Branch:           NullableText(pipeline.Branch),
+		Tag:              NullableText(pipeline.Tag),
+		CommitMessage:    NullableText(pipeline.CommitMessage),
+		AuthorName:       NullableText(pipeline.AuthorName),
+		AuthorEmail:      NullableText(pipeline.AuthorEmail),
+		CommitterName:    NullableText(pipeline.CommitterName),
+		CommitterEmail:   NullableText(pipeline.CommitterEmail),
+		Pusher:           NullableText(pipeline.Pusher),
+		CompareUrl:       NullableText(pipeline.CompareURL),
+		Attempt:          pipeline.Attempt,
+		OriginalID:       NullableInt8(pipeline.OriginalID),
+		Workflow:         NullableText(pipeline.Workflow),
+		Inputs:           inputs,
+	})
+	if err != nil {
+		return err
+	}
+
+	pipeline.ID = created.ID
+	pipeline.Number = created.Number
+	pipeline.CreateURL()
+	err = q.SetPipelineUrl(ctx, db.SetPipelineUrlParams{
+		ID:  pipeline.ID,
+		Url: NullableText(&pipeline.URL),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = q.CreatePipelineEvent(ctx, db.CreatePipelineEventParams{
+		PipelineID: pipeline.ID,
+		ToStatus:   db.PipelineStatus(pipeline.Status),
+		Actor:      actor,
+		Message:    NullableText(message),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot create event of pipeline with id=%d: %w", pipeline.ID, err)
+	}
+	return nil
+}
+
+// GetPipelineAttempts returns all attempts of the pipeline, the first one
+// first.
+func (s *PostgresStore) GetPipelineAttempts(ctx context.Context, pipelineID int64) ([]types.Pipeline, error) {
+	pipelines, err := s.queries.GetPipelineAttempts(ctx, pipelineID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get attempts of pipeline with id=%d: %w", pipelineID, err)
+	}
+
+	result := make([]types.Pipeline, 0, len(pipelines))
+	for _, pipeline := range pipelines {
+		result = append(result, pipelineFromDB(pipeline))
+	}
+	return result, nil
+}
+
+// SetPipelineWorkflow saves workflow loaded by the worker of the running
+// pipeline. Workflow of the pipeline is never changed once it is set. It
+// returns false if the pipeline is not running or has the workflow already.
+func (s *PostgresStore) SetPipelineWorkflow(ctx context.Context, pipelineID int64, workflow string) (bool, error) {
+	rows, err := s.queries.SetPipelineWorkflow(ctx, db.SetPipelineWorkflowParams{
+		ID:       pipelineID,
+		Workflow: NullableText(&workflow),
+	})
+	if err != nil {
+		return false, fmt.Errorf("cannot set workflow of pipeline with id=%d: %w", pipelineID, err)
+	}
+	return rows > 0, nil
+}
+
+// TransitionPipeline changes status of the pipeline and records the change. The
+// status is only changed when the pipeline can move to it from its current
+// status. It returns status of the pipeline before the transition and whether
+// the transition was made.
+func (s *PostgresStore) TransitionPipeline(ctx context.Context, pipelineID int64, t types.PipelineTransition) (types.PipelineStatus, bool, error) {
+	return transitionPipeline(ctx, s.queries, pipelineID, t)
+}
+
+func transitionPipeline(ctx context.Context, q *db.Queries, pipelineID int64, t types.PipelineTransition) (types.PipelineStatus, bool, error) {
+	params := db.TransitionPipelineParams{
+		PipelineID: pipelineID,
+		ToStatus:   db.PipelineStatus(t.To),
+		Actor:      t.Actor,
+		Message:    NullableText(t.Message),
+	}
+	if params.Actor == "" {
+		params.Actor = types.ActorSystem
+	}
+	for _, from := range types.TransitionsTo(t.To) {
+		if t.From == nil || *t.From == from {
+			params.FromStatuses = append(params.FromStatuses, string(from))
+		}
+	}
+	at := pgtype.Timestamp{Time: t.At, Valid: true}
+	if t.To == types.Running {
+		params.StartedAt = at
+	} else if t.To.Terminal() {
+		params.FinishedAt = at
+	}
+
+	res, err := q.TransitionPipeline(ctx, params)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", false, ErrNotFound
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("cannot move pipeline with id=%d to %s: %w", pipelineID, t.To, err)
+	}
+	return types.PipelineStatus(res.Status), res.Transitioned, nil
+}
+
+// GetPipelineTransitions returns status changes of the pipeline, the oldest
+// first.
+func (s *PostgresStore) GetPipelineTransitions(ctx context.Context, pipelineID int64) ([]types.PipelineTransition, error) {
+	events, err := s.queries.GetPipelineEvents(ctx, pipelineID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get events of pipeline with id=%d: %w", pipelineID, err)
+	}
+
+	transitions := make([]types.PipelineTransition, 0, len(events))
+	for _, e := range events {
+		t := types.PipelineTransition{
+			To:      types.PipelineStatus(e.ToStatus),
+			Actor:   e.Actor,
+			Message: ValueText(e.Message),
+			At:      e.CreatedAt.Time,
+		}
+		if e.FromStatus.Valid {
+			from := types.PipelineStatus(e.FromStatus.PipelineStatus)
+			t.From = &from
+		}
+		transitions = append(transitions, t)
+	}
+	return transitions, nil
+}
+
+func (s *PostgresStore) CreatePipelineLog(ctx context.Context, log types.PipelineLog) (int64, error) {
+	return s.queries.CreatePipelineLog(ctx, db.CreatePipelineLogParams{
+		Order:      int32(log.Order),
 		Cmd:        log.Cmd,
 		Output:     log.Output,
 		ExitCode:   int32(log.ExitCode),
 		PipelineID: log.PipelineID,
+		StartedAt:  NullableTimestamp(log.StartedAt),
+		FinishedAt: NullableTimestamp(log.FinishedAt),
+	})
+}
+
+func (s *PostgresStore) GetRepoPipelineByNumber(ctx context.Context, repoID int64, number int64) (types.Pipeline, error) {
+	pipeline, err := s.queries.GetRepoPipelineByNumber(ctx, db.GetRepoPipelineByNumberParams{
+		RepoID: repoID,
+		Number: number,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return types.Pipeline{}, ErrNotFound
+	}
+	if err != nil {
+		return types.Pipeline{}, fmt.Errorf("cannot get pipeline with number=%d of repo with id=%d: %w", number, repoID, err)
+	}
+
+	return pipelineFromDB(pipeline), nil
+}
+
+// GetLatestCommitPipeline returns the last created pipeline of the commit.
+func (s *PostgresStore) GetLatestCommitPipeline(ctx context.Context, repoID int64, commitSHA string) (types.Pipeline, error) {
+	pipeline, err := s.queries.GetLatestCommitPipeline(ctx, db.GetLatestCommitPipelineParams{
+		RepoID:    repoID,
+		CommitSha: commitSHA,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return types.Pipeline{}, ErrNotFound
+	}
+	if err != nil {
+		return types.Pipeline{}, fmt.Errorf("cannot get latest pipeline of commit %s of repo with id=%d: %w", commitSHA, repoID, err)
+	}
+
+	return pipelineFromDB(pipeline), nil
+}
+
+func (s *PostgresStore) GetPipelineLogs(ctx context.Context, pipelineID int64) ([]types.PipelineLog, error) {
+	logs, err := s.queries.GetPipelineLogs(ctx, pipelineID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get logs of pipeline with id=%d: %w", pipelineID, err)
+	}
+
+	var result []types.PipelineLog
+	for _, log := range logs {
+		result = append(result, types.PipelineLog{
+			Order:      int(log.Order),
+			Cmd:        log.Cmd,
+			Output:     log.Output,
+			ExitCode:   int(log.ExitCode),
+			PipelineID: pipelineID,
+			StartedAt:  ValueTime(log.StartedAt),
+			FinishedAt: ValueTime(log.FinishedAt),
+		})
+	}
+
+	return result, nil
+}
+
+// CreateWebhookDelivery records incoming delivery. If delivery with the same
+// ID was already recorded, existing record is returned and created is false.
+func (s *PostgresStore) CreateWebhookDelivery(ctx context.Context, delivery types.WebhookDelivery) (d types.WebhookDelivery, created bool, err error) {
+	headers, err := json.Marshal(delivery.Headers)
+	if err != nil {
+		return types.WebhookDelivery{}, false, fmt.Errorf("cannot marshal webhook delivery headers: %w", err)
+	}
+
+	res, err := s.queries.CreateWebhookDelivery(ctx, db.CreateWebhookDeliveryParams{
+		Service:    db.Service(delivery.Service),
+		DeliveryID: delivery.DeliveryID,
+		Event:      delivery.Event,
+		Headers:    headers,
+		Payload:    delivery.Payload,
+		RepoID:     NullableInt8(delivery.RepoID),
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		existing, err := s.queries.GetWebhookDeliveryByDeliveryID(ctx, db.GetWebhookDeliveryByDeliveryIDParams{
+			Service:    db.Service(delivery.Service),
+			DeliveryID: delivery.DeliveryID,
+		})
+		if err != nil {
+			return types.WebhookDelivery{}, false, fmt.Errorf("cannot get webhook delivery with deliveryID=%s: %w", delivery.DeliveryID, err)
+		}
+
+		d, err := webhookDelivery(existing)
+		return d, false, err
+	}
+	if err != nil {
+		return types.WebhookDelivery{}, false, fmt.Errorf("cannot create webhook delivery: %w", err)
+	}
+
+	delivery.ID = res.ID
+	delivery.Status = types.DeliveryPending
+	delivery.ReceivedAt = res.ReceivedAt.Time
+	return delivery, true, nil
+}
+
+func (s *PostgresStore) GetWebhookDelivery(ctx context.Context, deliveryID int64) (types.WebhookDelivery, error) {
+	delivery, err := s.queries.GetWebhookDelivery(ctx, deliveryID)
+	if err != nil {
+		return types.WebhookDelivery{}, fmt.Errorf("cannot get webhook delivery with id=%d: %w", deliveryID, err)
+	}
+
+	return webhookDelivery(delivery)
+}
+
+func (s *PostgresStore) GetRepoWebhookDeliveries(ctx context.Context, repoID int64, limit int32) ([]types.WebhookDelivery, error) {
+	deliveries, err := s.queries.GetRepoWebhookDeliveries(ctx, db.GetRepoWebhookDeliveriesParams{
+		RepoID: pgtype.Int8{Int64: repoID, Valid: true},
+		Limit:  limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot get webhook deliveries for repoID=%d: %w", repoID, err)
+	}
+
+	var result []types.WebhookDelivery
+	for _, delivery := range deliveries {
+		d, err := webhookDelivery(delivery.WebhookDelivery)
+		if err != nil {
+			return nil, err
+		}
+		d.PipelineNumber = ValueInt8(delivery.PipelineNumber)
+		result = append(result, d)
+	}
+
+	return result, nil
+}
+
+func (s *PostgresStore) SetWebhookDeliveryStatus(ctx context.Context, deliveryID int64, status types.DeliveryStatus, errMsg *string, pipelineID *int64) error {
+	return s.queries.SetWebhookDeliveryStatus(ctx, db.SetWebhookDeliveryStatusParams{
+		ID:         deliveryID,
+		Status:     db.DeliveryStatus(status),
+		Error:      NullableText(errMsg),
+		PipelineID: NullableInt8(pipelineID),
 	})
 }
 
+func (s *PostgresStore) ClaimWebhookDeliveries(ctx context.Context, limit int32, lease time.Duration) ([]types.WebhookDelivery, error) {
+	deliveries, err := s.queries.ClaimWebhookDeliveries(ctx, db.ClaimWebhookDeliveriesParams{
+		Lease:         Interval(lease),
+		MaxDeliveries: limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot claim webhook deliveries: %w", err)
+	}
+
+	var result []types.WebhookDelivery
+	for _, delivery := range deliveries {
+		d, err := webhookDelivery(delivery)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, d)
+	}
+
+	return result, nil
+}
+
+func (s *PostgresStore) RetryWebhookDelivery(ctx context.Context, deliveryID int64, errMsg string, delay time.Duration) error {
+	return s.queries.RetryWebhookDelivery(ctx, db.RetryWebhookDeliveryParams{
+		ID:    deliveryID,
+		Error: NullableText(&errMsg),
+		Delay: Interval(delay),
+	})
+}
+
+// RequeueWebhookDelivery processes the delivery again. Replayed delivery
+// creates new pipeline, otherwise pipeline created by the previous processing
+// is resumed.
+func (s *PostgresStore) RequeueWebhookDelivery(ctx context.Context, deliveryID int64, replay bool) error {
+	return s.queries.RequeueWebhookDelivery(ctx, db.RequeueWebhookDeliveryParams{
+		ID:     deliveryID,
+		Replay: replay,
+	})
+}
+
+func (s *PostgresStore) GetWebhookDeliveryQueueStats(ctx context.Context) (int64, time.Duration, error) {
+	stats, err := s.queries.GetWebhookDeliveryQueueStats(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot get webhook delivery queue stats: %w", err)
+	}
+
+	return stats.Pending, time.Duration(stats.OldestPendingSeconds * float64(time.Second)), nil
+}
+
+func (s *PostgresStore) UpsertCommitStatus(ctx context.Context, status types.CommitStatus) error {
+	return s.queries.UpsertCommitStatus(ctx, db.UpsertCommitStatusParams{
+		RepoID:      status.RepoID,
+		CommitSha:   status.CommitSHA,
+		Context:     status.Context,
+		State:       db.PipelineStatus(status.State),
+		TargetUrl:   status.TargetURL,
+		Description: status.Description,
+		PipelineID:  NullableInt8(status.PipelineID),
+	})
+}
+
+func (s *PostgresStore) ClaimCommitStatuses(ctx context.Context, limit int32, lease time.Duration) ([]types.CommitStatus, error) {
+	statuses, err := s.queries.ClaimCommitStatuses(ctx, db.ClaimCommitStatusesParams{
+		Lease:       Interval(lease),
+		MaxStatuses: limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot claim commit statuses: %w", err)
+	}
+
+	var result []types.CommitStatus
+	for _, status := range statuses {
+		result = append(result, types.CommitStatus{
+			ID:          status.ID,
+			RepoID:      status.RepoID,
+			CommitSHA:   status.CommitSha,
+			Context:     status.Context,
+			State:       types.PipelineStatus(status.State),
+			TargetURL:   status.TargetUrl,
+			Description: status.Description,
+			PipelineID:  ValueInt8(status.PipelineID),
+			Version:     int(status.Version),
+			// Status of the version may be already created when
+			// only its check runs failed.
+			Created:  status.StatusSentVersion >= status.Version,
+			Attempts: int(status.Attempts),
+		})
+	}
+
+	return result, nil
+}
+
+func (s *PostgresStore) CommitStatusSent(ctx context.Context, statusID int64, version int, errMsg *string) error {
+	return s.queries.CommitStatusSent(ctx, db.CommitStatusSentParams{
+		ID:      statusID,
+		Version: int32(version),
+		Error:   NullableText(errMsg),
+	})
+}
+
+// CommitStatusCreated records that the status of the version was created on
+// the service, so retries only send its check runs.
+func (s *PostgresStore) CommitStatusCreated(ctx context.Context, statusID int64, version int) error {
+	return s.queries.CommitStatusCreated(ctx, db.CommitStatusCreatedParams{
+		ID:      statusID,
+		Version: int32(version),
+	})
+}
+
+func (s *PostgresStore) RetryCommitStatus(ctx context.Context, statusID int64, errMsg string, delay time.Duration) error {
+	return s.queries.RetryCommitStatus(ctx, db.RetryCommitStatusParams{
+		ID:    statusID,
+		Error: NullableText(&errMsg),
+		Delay: Interval(delay),
+	})
+}
+
+func (s *PostgresStore) GetRepoStatusInfo(ctx context.Context, repoID int64) (*types.RepoStatusInfo, error) {
+	res, err := s.queries.GetRepoStatusInfo(ctx, repoID)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.oauth2Token(storedToken{
+		ServiceUserID: res.ServiceUserID,
+		AccessToken:   res.AccessToken,
+		RefreshToken:  res.RefreshToken,
+		KeyID:         res.TokenKeyID,
+	}, res.TokenType, res.TokenExpire)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get token of service user of repo with id=%d: %w", repoID, err)
+	}
+
+	return &types.RepoStatusInfo{
+		Service:        types.Service(res.Service),
+		RepoOwner:      res.Owner,
+		RepoName:       res.Name,
+		RepoServiceID:  res.RepoServiceID,
+		InstallationID: ValueInt8(res.InstallationID),
+		ServiceUserID:  res.ServiceUserID,
+		Token:          token,
+		Archived:       res.ArchivedAt.Valid,
+	}, nil
+}
+
+// CreateJobToken stores hash of the job token of the pipeline. Previous token
+// of the pipeline is replaced.
+func (s *PostgresStore) CreateJobToken(ctx context.Context, pipelineID int64, tokenHash []byte, ttl time.Duration) error {
+	err := s.queries.UpsertJobToken(ctx, db.UpsertJobTokenParams{
+		PipelineID: pipelineID,
+		TokenHash:  tokenHash,
+		Ttl:        Interval(ttl),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot create job token of pipeline with id=%d: %w", pipelineID, err)
+	}
+	return nil
+}
+
+// GetJobTokenState returns state of the token with the hash. Token which does
+// not belong to the pipeline is invalid.
+func (s *PostgresStore) GetJobTokenState(ctx context.Context, pipelineID int64, tokenHash []byte) (jobtoken.State, error) {
+	res, err := s.queries.GetJobTokenState(ctx, db.GetJobTokenStateParams{
+		PipelineID: pipelineID,
+		TokenHash:  tokenHash,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return jobtoken.Invalid, nil
+	}
+	if err != nil {
+		return jobtoken.Invalid, fmt.Errorf("cannot validate job token of pipeline with id=%d: %w", pipelineID, err)
+	}
+	switch {
+	case res.Revoked:
+		return jobtoken.Revoked, nil
+	case res.Expired:
+		return jobtoken.Expired, nil
+	}
+	return jobtoken.Valid, nil
+}
+
+// ExtendJobToken makes valid token of the pipeline valid for ttl from now.
+// Expired and revoked tokens stay so.
+func (s *PostgresStore) ExtendJobToken(ctx context.Context, pipelineID int64, ttl time.Duration) error {
+	err := s.queries.ExtendJobToken(ctx, db.ExtendJobTokenParams{
+		PipelineID: pipelineID,
+		Ttl:        Interval(ttl),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot extend job token of pipeline with id=%d: %w", pipelineID, err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) RevokeJobToken(ctx context.Context, pipelineID int64) error {
+	err := s.queries.RevokeJobToken(ctx, pipelineID)
+	if err != nil {
+		return fmt.Errorf("cannot revoke job token of pipeline with id=%d: %w", pipelineID, err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetRepoSchedules(ctx context.Context, repoID int64) ([]types.Schedule, error) {
+	res, err := s.queries.GetRepoSchedules(ctx, repoID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get schedules of repo with id=%d: %w", repoID, err)
+	}
+
+	schedules := make([]types.Schedule, 0, len(res))
+	for _, r := range res {
+		schedule := scheduleFromDB(r.Schedule)
+		schedule.LastPipelineNumber = ValueInt8(r.LastPipelineNumber)
+		schedules = append(schedules, schedule)
+	}
+	return schedules, nil
+}
+
+// CreateSchedule returns ErrAlreadyExists when the repository has schedule of
+// the branch with the same cron expression and timezone.
+func (s *PostgresStore) CreateSchedule(ctx context.Context, schedule types.Schedule) (int64, error) {
+	id, err := createSchedule(ctx, s.queries, schedule)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrAlreadyExists
+	}
+	if err != nil {
+		return 0, fmt.Errorf("cannot create schedule: %w", err)
+	}
+	return id, nil
+}
+
+func createSchedule(ctx context.Context, q *db.Queries, schedule types.Schedule) (int64, error) {
+	return q.CreateSchedule(ctx, db.CreateScheduleParams{
+		RepoID:       schedule.RepoID,
+		Branch:       schedule.Branch,
+		Cron:         schedule.Cron,
+		Timezone:     schedule.Timezone,
+		FromWorkflow: schedule.FromWorkflow,
+		NextRunAt:    pgtype.Timestamp{Time: schedule.NextRunAt, Valid: true},
+	})
+}
+
+func (s *PostgresStore) DeleteSchedule(ctx context.Context, repoID int64, scheduleID int64) error {
+	rows, err := s.queries.DeleteSchedule(ctx, db.DeleteScheduleParams{
+		ID:     scheduleID,
+		RepoID: repoID,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot delete schedule with id=%d: %w", scheduleID, err)
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SyncWorkflowSchedules replaces schedules declared in the workflow of the
+// branch. Schedules which did not change keep their runs. At is when the
+// workflow was pushed, workflow pushed before the last synced one is ignored.
+func (s *PostgresStore) SyncWorkflowSchedules(ctx context.Context, repoID int64, branch string, at time.Time, schedules []types.Schedule) error {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.queries.WithTx(tx)
+
+	rows, err := qtx.MarkWorkflowSchedulesSynced(ctx, db.MarkWorkflowSchedulesSyncedParams{
+		RepoID:   repoID,
+		Branch:   branch,
+		SyncedAt: pgtype.Timestamp{Time: at.UTC(), Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("cannot mark workflow schedules of repo with id=%d synced: %w", repoID, err)
+	}
+	if rows == 0 {
+		return nil
+	}
+
+	keep := make([]string, 0, len(schedules))
+	for _, schedule := range schedules {
+		keep = append(keep, schedule.Cron+" "+schedule.Timezone)
+	}
+	err = qtx.DeleteWorkflowSchedules(ctx, db.DeleteWorkflowSchedulesParams{
+		RepoID: repoID,
+		Branch: branch,
+		Keep:   keep,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot delete workflow schedules of repo with id=%d: %w", repoID, err)
+	}
+	for _, schedule := range schedules {
+		_, err = createSchedule(ctx, qtx, schedule)
+		// Schedule already exists.
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cannot create workflow schedule of repo with id=%d: %w", repoID, err)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ClaimDueSchedules returns schedules which are due and moves their next run
+// to the time returned by next. Claimed run is in progress until its result is
+// set, run which was not finished within claimTimeout is claimed again. Only
+// one caller claims schedules at a time, the others get no schedules, so each
+// run is claimed once even with more server replicas.
+func (s *PostgresStore) ClaimDueSchedules(ctx context.Context, limit int32, claimTimeout time.Duration, next func(schedule types.Schedule) time.Time) ([]types.Schedule, error) {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.queries.WithTx(tx)
+
+	locked, err := qtx.TryLockScheduler(ctx, schedulerLockKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot lock scheduler: %w", err)
+	}
+	if !locked {
+		return nil, nil
+	}
+
+	now := time.Now().UTC()
+	due, err := qtx.GetDueSchedules(ctx, db.GetDueSchedulesParams{
+		Now:          pgtype.Timestamp{Time: now, Valid: true},
+		StaleBefore:  pgtype.Timestamp{Time: now.Add(-claimTimeout), Valid: true},
+		MaxSchedules: limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot get due schedules: %w", err)
+	}
+
+	claimedAt := pgtype.Timestamp{Time: now, Valid: true}
+	schedules := make([]types.Schedule, 0, len(due))
+	for _, d := range due {
+		schedule := scheduleFromDB(d)
+		// Run of the stale claim keeps its time and the next run.
+		if d.ClaimedAt.Valid {
+			err = qtx.ReclaimScheduleRun(ctx, db.ReclaimScheduleRunParams{
+				ID:        schedule.ID,
+				ClaimedAt: claimedAt,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("cannot reclaim run of schedule with id=%d: %w", schedule.ID, err)
+			}
+			schedules = append(schedules, schedule)
+			continue
+		}
+
+		runAt := schedule.NextRunAt
+		schedule.NextRunAt = next(schedule)
+		err = qtx.SetScheduleNextRun(ctx, db.SetScheduleNextRunParams{
+			ID:        schedule.ID,
+			NextRunAt: pgtype.Timestamp{Time: schedule.NextRunAt, Valid: true},
+			ClaimedAt: claimedAt,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot set next run of schedule with id=%d: %w", schedule.ID, err)
+		}
+		schedule.LastRunAt = &runAt
+		schedule.LastPipelineNumber = nil
+		schedule.LastError = nil
+		schedules = append(schedules, schedule)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot commit transaction: %w", err)
+	}
+	return schedules, nil
+}
+
+// SetScheduleResult saves pipeline created by the claimed run of the schedule
+// or error which prevented it and finishes the run.
+func (s *PostgresStore) SetScheduleResult(ctx context.Context, scheduleID int64, pipelineID *int64, errMsg *string) error {
+	err := s.queries.SetScheduleResult(ctx, db.SetScheduleResultParams{
+		ID:             scheduleID,
+		LastPipelineID: NullableInt8(pipelineID),
+		LastError:      NullableText(errMsg),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot set result of schedule with id=%d: %w", scheduleID, err)
+	}
+	return nil
+}
+
+// CreateSchedulePipeline creates the pipeline like CreatePipeline for the run
+// of the schedule at runAt. It returns ErrAlreadyExists when the run already
+// created its pipeline.
+func (s *PostgresStore) CreateSchedulePipeline(ctx context.Context, scheduleID int64, runAt time.Time, pipeline *types.Pipeline) (int64, error) {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.queries.WithTx(tx)
+
+	pipeline.Attempt = 1
+	err = createPipeline(ctx, qtx, pipeline, types.ActorSystem, nil)
+	if err != nil {
+		return 0, err
+	}
+	_, err = qtx.CreateScheduleRun(ctx, db.CreateScheduleRunParams{
+		ScheduleID: scheduleID,
+		RunAt:      pgtype.Timestamp{Time: runAt, Valid: true},
+		PipelineID: pipeline.ID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrAlreadyExists
+	}
+	if err != nil {
+		return 0, fmt.Errorf("cannot create run of schedule with id=%d: %w", scheduleID, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("cannot commit transaction: %w", err)
+	}
+	return pipeline.ID, nil
+}
+
+// GetSchedulePipeline returns pipeline created by the run of the schedule at
+// runAt.
+func (s *PostgresStore) GetSchedulePipeline(ctx context.Context, scheduleID int64, runAt time.Time) (types.Pipeline, error) {
+	res, err := s.queries.GetScheduleRunPipeline(ctx, db.GetScheduleRunPipelineParams{
+		ScheduleID: scheduleID,
+		RunAt:      pgtype.Timestamp{Time: runAt, Valid: true},
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return types.Pipeline{}, ErrNotFound
+	}
+	if err != nil {
+		return types.Pipeline{}, fmt.Errorf("cannot get pipeline of run of schedule with id=%d: %w", scheduleID, err)
+	}
+	return pipelineFromDB(res.Pipeline), nil
+}
+
+func scheduleFromDB(schedule db.Schedule) types.Schedule {
+	return types.Schedule{
+		ID:           schedule.ID,
+		RepoID:       schedule.RepoID,
+		Branch:       schedule.Branch,
+		Cron:         schedule.Cron,
+		Timezone:     schedule.Timezone,
+		FromWorkflow: schedule.FromWorkflow,
+		NextRunAt:    schedule.NextRunAt.Time,
+		LastRunAt:    ValueTime(schedule.LastRunAt),
+		LastError:    ValueText(schedule.LastError),
+		CreatedAt:    schedule.CreatedAt.Time,
+	}
+}
+
+func webhookDelivery(d db.WebhookDelivery) (types.WebhookDelivery, error) {
+	var headers http.Header
+	err := json.Unmarshal(d.Headers, &headers)
+	if err != nil {
+		return types.WebhookDelivery{}, fmt.Errorf("cannot unmarshal headers of webhook delivery with id=%d: %w", d.ID, err)
+	}
+
+	return types.WebhookDelivery{
+		ID:          d.ID,
+		Service:     types.Service(d.Service),
+		DeliveryID:  d.DeliveryID,
+		Event:       d.Event,
+		Headers:     headers,
+		Payload:     d.Payload,
+		Status:      types.DeliveryStatus(d.Status),
+		Error:       ValueText(d.Error),
+		ReceivedAt:  d.ReceivedAt.Time,
+		ProcessedAt: ValueTime(d.ProcessedAt),
+		RepoID:      ValueInt8(d.RepoID),
+		PipelineID:  ValueInt8(d.PipelineID),
+		Attempts:    int(d.Attempts),
+	}, nil
+}
+
+func pipelineFromDB(pipeline db.Pipeline) types.Pipeline {
+	var inputs map[string]string
+	if pipeline.Inputs != nil {
+		// Inputs are only written by createPipeline, so they are always
+		// object of strings.
+		_ = json.Unmarshal(pipeline.Inputs, &inputs)
+	}
+
+	return types.Pipeline{
+		ID:               pipeline.ID,
+		Number:           pipeline.Number,
+		URL:              pipeline.Url.String,
+		Status:           types.PipelineStatus(pipeline.Status),
+		CloneURL:         pipeline.CloneUrl,
+		CommitSHA:        pipeline.CommitSha,
+		CreatedAt:        pipeline.CreatedAt.Time,
+		StartedAt:        ValueTime(pipeline.StartedAt),
+		FinishedAt:       ValueTime(pipeline.FinishedAt),
+		SentAt:           ValueTime(pipeline.SentAt),
+		RepoID:           pipeline.RepoID,
+		PRNumber:         ValueInt4(pipeline.PrNumber),
+		SourceBranch:     ValueText(pipeline.SourceBranch),
+		TargetBranch:     ValueText(pipeline.TargetBranch),
+		FetchRef:         ValueText(pipeline.FetchRef),
+		Fork:             pipeline.Fork,
+		AwaitingApproval: pipeline.AwaitingApproval,
+		ApprovedBy:       ValueInt8(pipeline.ApprovedBy),
+		Event:            types.PipelineEvent(pipeline.Event),
+		Ref:              ValueText(pipeline.Ref),
+		Branch:           ValueText(pipeline.Branch),
+		Tag:              ValueText(pipeline.Tag),
+		CommitMessage:    ValueText(pipeline.CommitMessage),
+		AuthorName:       ValueText(pipeline.AuthorName),
+		AuthorEmail:      ValueText(pipeline.AuthorEmail),
+		CommitterName:    ValueText(pipeline.CommitterName),
+		CommitterEmail:   ValueText(pipeline.CommitterEmail),
+		Pusher:           ValueText(pipeline.Pusher),
+		CompareURL:       ValueText(pipeline.CompareUrl),
+		Attempt:          pipeline.Attempt,
+		OriginalID:       ValueInt8(pipeline.OriginalID),
+		Workflow:         ValueText(pipeline.Workflow),
+		Inputs:           inputs,
+	}
+}
+
 func NullableText(ptr *string) pgtype.Text {
 	if ptr == nil {
 		return pgtype.Text{Valid: false}
@@ -324,6 +1777,24 @@ func NullableTimestamp(ptr *time.Time) pgtype.Timestamp {
 	return pgtype.Timestamp{Time: *ptr, Valid: true}
 }
 
+func NullableInt8(ptr *int64) pgtype.Int8 {
+	if ptr == nil {
+		return pgtype.Int8{Valid: false}
+	}
+	return pgtype.Int8{Int64: *ptr, Valid: true}
+}
+
+func NullableInt4(ptr *int32) pgtype.Int4 {
+	if ptr == nil {
+		return pgtype.Int4{Valid: false}
+	}
+	return pgtype.Int4{Int32: *ptr, Valid: true}
+}
+
+func Interval(d time.Duration) pgtype.Interval {
+	return pgtype.Interval{Microseconds: d.Microseconds(), Valid: true}
+}
+
 func ValueText(value pgtype.Text) *string {
 	if !value.Valid {
 		return nil
@@ -337,3 +1808,17 @@ func ValueTime(value pgtype.Timestamp) *time.Time {
 	}
 	return &value.Time
 }
+
+func ValueInt8(value pgtype.Int8) *int64 {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Int64
+}
+
+func ValueInt4(value pgtype.Int4) *int32 {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Int32
+}
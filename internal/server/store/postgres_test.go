@@ -0,0 +1,360 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shark-ci/shark-ci/internal/server/encryption"
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+// newTestStore connects to migrated database from TEST_DB_URI, tests using it
+// are skipped without it.
+func newTestStore(t *testing.T) *PostgresStore {
+	t.Helper()
+	uri := os.Getenv("TEST_DB_URI")
+	if uri == "" {
+		t.Skip("TEST_DB_URI is not set")
+	}
+
+	keyring, err := encryption.NewKeyring([]encryption.Key{{ID: "test", Secret: bytes.Repeat([]byte{1}, 32)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewPostgresStore(context.Background(), uri, keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close(context.Background()) })
+	return s
+}
+
+// newTestRepo creates repo of new user, names are unique so tests can share
+// the database.
+func newTestRepo(t *testing.T, s *PostgresStore) int64 {
+	t.Helper()
+	ctx := context.Background()
+	name := fmt.Sprintf("test-%d", time.Now().UnixNano())
+
+	_, serviceUserID, err := s.CreateUserAndServiceUser(ctx, types.ServiceUser{
+		Service:     types.ServiceGitHub,
+		Username:    name,
+		Email:       name + "@example.com",
+		AccessToken: "token",
+		TokenType:   "bearer",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := "secret"
+	repoID, err := s.CreateRepo(ctx, types.Repo{
+		Service:       types.ServiceGitHub,
+		Owner:         name,
+		Name:          name,
+		RepoServiceID: time.Now().UnixNano(),
+		ServiceUserID: serviceUserID,
+		WebhookSecret: &secret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repoID
+}
+
+func TestCreatePipelineConcurrentNumbers(t *testing.T) {
+	s := newTestStore(t)
+	repoID := newTestRepo(t, s)
+
+	const n = 20
+	numbers := make([]int64, n)
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pipeline := &types.Pipeline{
+				RepoID:    repoID,
+				Status:    types.Queued,
+				CloneURL:  "https://github.com/owner/repo.git",
+				CommitSHA: fmt.Sprintf("%040d", i),
+				Event:     types.EventManual,
+			}
+			if _, err := s.CreatePipeline(context.Background(), pipeline); err != nil {
+				errs <- err
+				return
+			}
+			numbers[i] = pipeline.Number
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("CreatePipeline() error = %v", err)
+	}
+
+	slices.Sort(numbers)
+	for i, number := range numbers {
+		if number != int64(i+1) {
+			t.Fatalf("pipeline numbers = %v, want 1..%d", numbers, n)
+		}
+	}
+}
+
+func TestRejectPipeline(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+	pipeline := &types.Pipeline{
+		RepoID:           newTestRepo(t, s),
+		Status:           types.Queued,
+		CloneURL:         "https://github.com/fork/repo.git",
+		CommitSHA:        fmt.Sprintf("%040d", 1),
+		Event:            types.EventPullRequest,
+		Fork:             true,
+		AwaitingApproval: true,
+	}
+	if _, err := s.CreatePipeline(ctx, pipeline); err != nil {
+		t.Fatal(err)
+	}
+
+	rejected, err := s.RejectPipeline(ctx, pipeline.ID, "maintainer")
+	if err != nil || !rejected {
+		t.Fatalf("RejectPipeline() = %v, %v, want rejected", rejected, err)
+	}
+	got, err := s.GetPipeline(ctx, pipeline.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != types.Cancelled || got.AwaitingApproval || got.FinishedAt == nil {
+		t.Errorf("rejected pipeline status=%s awaiting=%v finished=%v", got.Status, got.AwaitingApproval, got.FinishedAt)
+	}
+	transitions, err := s.GetPipelineTransitions(ctx, pipeline.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := transitions[len(transitions)-1]; last.To != types.Cancelled || last.Actor != "maintainer" {
+		t.Errorf("last transition = %+v, want cancelled by maintainer", last)
+	}
+
+	rejected, err = s.RejectPipeline(ctx, pipeline.ID, "maintainer")
+	if err != nil || rejected {
+		t.Errorf("RejectPipeline() of finished pipeline = %v, %v", rejected, err)
+	}
+}
+
+// claimed returns the schedule if it was claimed.
+func claimed(t *testing.T, s *PostgresStore, scheduleID int64, nextRun time.Time) (types.Schedule, bool) {
+	t.Helper()
+	schedules, err := s.ClaimDueSchedules(context.Background(), 1000, 10*time.Minute, func(types.Schedule) time.Time { return nextRun })
+	if err != nil {
+		t.Fatalf("ClaimDueSchedules() error = %v", err)
+	}
+	for _, schedule := range schedules {
+		if schedule.ID == scheduleID {
+			return schedule, true
+		}
+	}
+	return types.Schedule{}, false
+}
+
+func TestClaimDueSchedules(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+	runAt := time.Now().UTC().Add(-time.Minute).Truncate(time.Microsecond)
+	scheduleID, err := s.CreateSchedule(ctx, types.Schedule{RepoID: newTestRepo(t, s), Branch: "main", Cron: "@hourly", Timezone: "UTC", NextRunAt: runAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nextRun := time.Now().UTC().Add(time.Hour).Truncate(time.Microsecond)
+
+	schedule, ok := claimed(t, s, scheduleID, nextRun)
+	if !ok || !schedule.NextRunAt.Equal(nextRun) || schedule.LastRunAt == nil || !schedule.LastRunAt.Equal(runAt) {
+		t.Fatalf("claimed = %v, schedule %+v, want run at %v claimed", ok, schedule, runAt)
+	}
+	if _, ok := claimed(t, s, scheduleID, nextRun); ok {
+		t.Error("run in progress was claimed again")
+	}
+
+	// Run of crashed server is claimed again without moving its next run.
+	_, err = s.pool.Exec(ctx, `UPDATE "schedule" SET claimed_at = claimed_at - interval '1 hour' WHERE id = $1`, scheduleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule, ok = claimed(t, s, scheduleID, nextRun.Add(time.Hour))
+	if !ok || !schedule.NextRunAt.Equal(nextRun) || schedule.LastRunAt == nil || !schedule.LastRunAt.Equal(runAt) {
+		t.Fatalf("claimed = %v, schedule %+v, want stale run at %v reclaimed", ok, schedule, runAt)
+	}
+
+	err = s.SetScheduleResult(ctx, scheduleID, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.pool.Exec(ctx, `UPDATE "schedule" SET claimed_at = now() - interval '1 hour' WHERE id = $1 AND claimed_at IS NOT NULL`, scheduleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := claimed(t, s, scheduleID, nextRun); ok {
+		t.Error("finished run was claimed again")
+	}
+}
+
+func TestCreateSchedulePipelineOnce(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+	repoID := newTestRepo(t, s)
+	runAt := time.Now().UTC().Truncate(time.Microsecond)
+	scheduleID, err := s.CreateSchedule(ctx, types.Schedule{RepoID: repoID, Branch: "main", Cron: "@hourly", Timezone: "UTC", NextRunAt: runAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPipeline := func() *types.Pipeline {
+		return &types.Pipeline{
+			RepoID:    repoID,
+			Status:    types.Queued,
+			CloneURL:  "https://github.com/owner/repo.git",
+			CommitSHA: fmt.Sprintf("%040d", 1),
+			Event:     types.EventSchedule,
+		}
+	}
+
+	if _, err := s.GetSchedulePipeline(ctx, scheduleID, runAt); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetSchedulePipeline() before run error = %v, want ErrNotFound", err)
+	}
+	pipelineID, err := s.CreateSchedulePipeline(ctx, scheduleID, runAt, newPipeline())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateSchedulePipeline(ctx, scheduleID, runAt, newPipeline()); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("second CreateSchedulePipeline() error = %v, want ErrAlreadyExists", err)
+	}
+	pipeline, err := s.GetSchedulePipeline(ctx, scheduleID, runAt)
+	if err != nil || pipeline.ID != pipelineID {
+		t.Fatalf("GetSchedulePipeline() = %d, %v, want pipeline %d", pipeline.ID, err, pipelineID)
+	}
+}
+
+func TestReplaceRepoDeployToken(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+	repoID := newTestRepo(t, s)
+	expiresAt := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+	token := func(id int64) types.DeployToken {
+		return types.DeployToken{ID: id, Username: fmt.Sprintf("token-%d", id), Token: "secret", ExpiresAt: expiresAt}
+	}
+	first, second := int64(1), int64(2)
+
+	if _, err := s.GetRepoDeployToken(ctx, repoID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetRepoDeployToken() of new repo error = %v, want ErrNotFound", err)
+	}
+	for i, replaced := range []*int64{nil, &first, &second} {
+		id := int64(i + 1)
+		revoke, err := s.ReplaceRepoDeployToken(ctx, repoID, replaced, token(id))
+		if err != nil {
+			t.Fatalf("ReplaceRepoDeployToken(%d) error = %v", id, err)
+		}
+		if id == 3 && (revoke == nil || *revoke != 1) || id < 3 && revoke != nil {
+			t.Errorf("ReplaceRepoDeployToken(%d) returned %v to revoke", id, revoke)
+		}
+	}
+	if _, err := s.ReplaceRepoDeployToken(ctx, repoID, &second, token(4)); !errors.Is(err, ErrConflict) {
+		t.Errorf("ReplaceRepoDeployToken() of replaced token error = %v, want ErrConflict", err)
+	}
+
+	got, err := s.GetRepoDeployToken(ctx, repoID)
+	if err != nil || got != token(3) {
+		t.Errorf("GetRepoDeployToken() = %+v, %v, want %+v", got, err, token(3))
+	}
+}
+
+func TestSyncWorkflowSchedulesOrder(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+	repoID := newTestRepo(t, s)
+	workflowSchedule := func(cron string) []types.Schedule {
+		return []types.Schedule{{RepoID: repoID, Branch: "main", Cron: cron, Timezone: "UTC", FromWorkflow: true, NextRunAt: time.Now().Add(time.Hour)}}
+	}
+	crons := func() []string {
+		schedules, err := s.GetRepoSchedules(ctx, repoID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var crons []string
+		for _, schedule := range schedules {
+			crons = append(crons, schedule.Cron)
+		}
+		return crons
+	}
+	pushedAt := time.Now()
+
+	if err := s.SyncWorkflowSchedules(ctx, repoID, "main", pushedAt, workflowSchedule("@daily")); err != nil {
+		t.Fatal(err)
+	}
+	// Workflow of older push reported late is ignored.
+	if err := s.SyncWorkflowSchedules(ctx, repoID, "main", pushedAt.Add(-time.Minute), workflowSchedule("@weekly")); err != nil {
+		t.Fatal(err)
+	}
+	if got := crons(); !slices.Equal(got, []string{"@daily"}) {
+		t.Errorf("schedules %v, want @daily", got)
+	}
+
+	if err := s.SyncWorkflowSchedules(ctx, repoID, "main", pushedAt.Add(time.Minute), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := crons(); len(got) != 0 {
+		t.Errorf("schedules %v, want none", got)
+	}
+}
+
+func TestUpsertCommitStatusOrder(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+	repoID := newTestRepo(t, s)
+	sha := fmt.Sprintf("%040d", time.Now().UnixNano())
+	newPipeline := func() int64 {
+		t.Helper()
+		pipeline := &types.Pipeline{RepoID: repoID, Status: types.Queued, CloneURL: "https://github.com/owner/repo.git", CommitSHA: sha, Event: types.EventPush}
+		if _, err := s.CreatePipeline(ctx, pipeline); err != nil {
+			t.Fatal(err)
+		}
+		return pipeline.ID
+	}
+	first, rerun := newPipeline(), newPipeline()
+	report := func(pipelineID int64, state types.PipelineStatus) {
+		t.Helper()
+		err := s.UpsertCommitStatus(ctx, types.CommitStatus{RepoID: repoID, CommitSHA: sha, Context: "shark-ci", State: state, PipelineID: &pipelineID})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	current := func() (int64, types.PipelineStatus) {
+		t.Helper()
+		var pipelineID int64
+		var state string
+		err := s.pool.QueryRow(ctx, `SELECT pipeline_id, state FROM "commit_status" WHERE repo_id = $1 AND commit_sha = $2`, repoID, sha).Scan(&pipelineID, &state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pipelineID, types.PipelineStatus(state)
+	}
+
+	report(first, types.Running)
+	report(first, types.Queued)
+	if id, state := current(); id != first || state != types.Running {
+		t.Errorf("status of pipeline %d is %s, want running status of pipeline %d kept", id, state, first)
+	}
+
+	// Re-run replaces status of the original attempt, which cannot replace it
+	// back.
+	report(rerun, types.Queued)
+	report(first, types.Success)
+	if id, state := current(); id != rerun || state != types.Queued {
+		t.Errorf("status of pipeline %d is %s, want queued status of pipeline %d", id, state, rerun)
+	}
+}
@@ -2,14 +2,27 @@ package store
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"time"
 
 	"github.com/google/uuid"
+	"golang.org/x/oauth2"
 
+	"github.com/shark-ci/shark-ci/internal/server/jobtoken"
 	"github.com/shark-ci/shark-ci/internal/types"
 )
 
+// ErrNotFound is returned when requested object does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrAlreadyExists is returned when created object already exists.
+var ErrAlreadyExists = errors.New("already exists")
+
+// ErrConflict is returned when object is in state which does not allow the
+// change.
+var ErrConflict = errors.New("conflict")
+
 type Storer interface {
 	Ping(ctx context.Context) error
 	Close(ctx context.Context) error
@@ -22,21 +35,87 @@ type Storer interface {
 	GetUserIDByServiceUser(ctx context.Context, service types.Service, username string) (int64, error)
 	CreateUserAndServiceUser(ctx context.Context, serviceUser types.ServiceUser) (int64, int64, error)
 	GetServiceUserByUserID(ctx context.Context, service types.Service, userID int64) (types.ServiceUser, error)
+	UpdateServiceUserToken(ctx context.Context, serviceUserID int64, token oauth2.Token) error
+	RefreshServiceUserToken(ctx context.Context, serviceUserID int64, refresh func(current oauth2.Token) (oauth2.Token, error)) (oauth2.Token, error)
+	SetServiceUserReloginRequired(ctx context.Context, serviceUserID int64) error
+	GetReloginRequiredServices(ctx context.Context, userID int64) ([]types.Service, error)
 
 	GetRepoIDByServiceRepoID(ctx context.Context, service types.Service, serviceRepoID int64) (int64, error)
 	GetUserRepos(ctx context.Context, userID int64) ([]types.Repo, error)
+	GetRegisteredRepoServiceIDs(ctx context.Context, service types.Service, repoServiceIDs []int64) ([]int64, error)
 	UserOwnRepo(ctx context.Context, userID int64, repoID int64) (bool, error)
 	CreateRepo(ctx context.Context, repo types.Repo) (int64, error)
+	SetRepoInstallation(ctx context.Context, service types.Service, serviceRepoID int64, installationID *int64) error
+	ClearInstallation(ctx context.Context, service types.Service, installationID int64) error
 	DeleteRepo(ctx context.Context, repoID int64) error
+	ArchiveRepo(ctx context.Context, repoID int64) error
+	SetRepoForgeArchived(ctx context.Context, repoID int64, archived bool) error
+	CancelRepoPipelines(ctx context.Context, repoID int64, actor string, message string) ([]types.Pipeline, error)
+	ClaimReposToCheckWebhook(ctx context.Context, interval time.Duration, maxRepos int32) ([]int64, error)
+	GetRepoWebhookHealth(ctx context.Context, repoID int64) (types.WebhookHealth, error)
+	SetRepoWebhookCheck(ctx context.Context, repoID int64, checkError *string, repaired bool) error
+	SetRepoWebhookID(ctx context.Context, repoID int64, webhookID int64) error
+	UpdateRepoName(ctx context.Context, repoID int64, owner string, name string) error
+	GetRepoWebhookChangeInfo(ctx context.Context, repoID int64) (*types.RepoWebhookChangeInfo, error)
+	GetRepoWebhookSecrets(ctx context.Context, service types.Service, serviceRepoID int64) (types.WebhookSecrets, error)
+	GetRepoDeployToken(ctx context.Context, repoID int64) (types.DeployToken, error)
+	ReplaceRepoDeployToken(ctx context.Context, repoID int64, replacedID *int64, token types.DeployToken) (*int64, error)
+	RotateRepoWebhookSecret(ctx context.Context, repoID int64, secret string) error
+	RevertRepoWebhookSecret(ctx context.Context, repoID int64, secret string) error
 
-	GetPipelinesByRepo(ctx context.Context, repoID int64) ([]types.Pipeline, error)
+	GetPipeline(ctx context.Context, pipelineID int64) (types.Pipeline, error)
+	GetRepoPipelineByNumber(ctx context.Context, repoID int64, number int64) (types.Pipeline, error)
+	GetLatestCommitPipeline(ctx context.Context, repoID int64, commitSHA string) (types.Pipeline, error)
+	GetRepoPipelines(ctx context.Context, repoID int64, filter types.PipelineFilter) ([]types.Pipeline, error)
 	GetPipelineCreationInfo(ctx context.Context, repoID int64) (*types.PipelineCreationInfo, error)
 	GetPipelineStateChangeInfo(ctx context.Context, pipelineID int64) (*types.PipelineStateChangeInfo, error)
 	CreatePipeline(ctx context.Context, pipeline *types.Pipeline) (int64, error)
-	PipelineStarted(ctx context.Context, pipelineID int64, status types.PipelineStatus, startedAt time.Time) error
-	PipelineFinnished(ctx context.Context, pipelineID int64, status types.PipelineStatus, finnisedAt time.Time) error
+	CreateDeliveryPipeline(ctx context.Context, deliveryID int64, pipeline *types.Pipeline) (int64, error)
+	CreatePipelineRerun(ctx context.Context, pipeline *types.Pipeline, actor string) (int64, error)
+	GetPipelineAttempts(ctx context.Context, pipelineID int64) ([]types.Pipeline, error)
+	SetPipelineWorkflow(ctx context.Context, pipelineID int64, workflow string) (bool, error)
+	TransitionPipeline(ctx context.Context, pipelineID int64, t types.PipelineTransition) (types.PipelineStatus, bool, error)
+	GetPipelineTransitions(ctx context.Context, pipelineID int64) ([]types.PipelineTransition, error)
+	GetPipelineCheckRuns(ctx context.Context, pipelineID int64) (map[string]int64, error)
+	SetPipelineCheckRun(ctx context.Context, pipelineID int64, job string, checkRunID int64) error
+	GetPipelinesAwaitingApproval(ctx context.Context, repoID int64) ([]types.Pipeline, error)
+	ApprovePipeline(ctx context.Context, pipelineID int64, userID int64) (bool, error)
+	MarkPipelineSent(ctx context.Context, pipelineID int64) error
+	RevertPipelineApproval(ctx context.Context, pipelineID int64) error
+	RejectPipeline(ctx context.Context, pipelineID int64, actor string) (bool, error)
 
 	CreatePipelineLog(ctx context.Context, log types.PipelineLog) (int64, error)
+	GetPipelineLogs(ctx context.Context, pipelineID int64) ([]types.PipelineLog, error)
+
+	CreateWebhookDelivery(ctx context.Context, delivery types.WebhookDelivery) (types.WebhookDelivery, bool, error)
+	GetWebhookDelivery(ctx context.Context, deliveryID int64) (types.WebhookDelivery, error)
+	GetRepoWebhookDeliveries(ctx context.Context, repoID int64, limit int32) ([]types.WebhookDelivery, error)
+	SetWebhookDeliveryStatus(ctx context.Context, deliveryID int64, status types.DeliveryStatus, errMsg *string, pipelineID *int64) error
+	ClaimWebhookDeliveries(ctx context.Context, limit int32, lease time.Duration) ([]types.WebhookDelivery, error)
+	RetryWebhookDelivery(ctx context.Context, deliveryID int64, errMsg string, delay time.Duration) error
+	RequeueWebhookDelivery(ctx context.Context, deliveryID int64, replay bool) error
+	GetWebhookDeliveryQueueStats(ctx context.Context) (int64, time.Duration, error)
+
+	UpsertCommitStatus(ctx context.Context, status types.CommitStatus) error
+	ClaimCommitStatuses(ctx context.Context, limit int32, lease time.Duration) ([]types.CommitStatus, error)
+	CommitStatusSent(ctx context.Context, statusID int64, version int, errMsg *string) error
+	CommitStatusCreated(ctx context.Context, statusID int64, version int) error
+	RetryCommitStatus(ctx context.Context, statusID int64, errMsg string, delay time.Duration) error
+	GetRepoStatusInfo(ctx context.Context, repoID int64) (*types.RepoStatusInfo, error)
+
+	GetRepoSchedules(ctx context.Context, repoID int64) ([]types.Schedule, error)
+	CreateSchedule(ctx context.Context, schedule types.Schedule) (int64, error)
+	DeleteSchedule(ctx context.Context, repoID int64, scheduleID int64) error
+	SyncWorkflowSchedules(ctx context.Context, repoID int64, branch string, at time.Time, schedules []types.Schedule) error
+	ClaimDueSchedules(ctx context.Context, limit int32, claimTimeout time.Duration, next func(schedule types.Schedule) time.Time) ([]types.Schedule, error)
+	SetScheduleResult(ctx context.Context, scheduleID int64, pipelineID *int64, errMsg *string) error
+	CreateSchedulePipeline(ctx context.Context, scheduleID int64, runAt time.Time, pipeline *types.Pipeline) (int64, error)
+	GetSchedulePipeline(ctx context.Context, scheduleID int64, runAt time.Time) (types.Pipeline, error)
+
+	CreateJobToken(ctx context.Context, pipelineID int64, tokenHash []byte, ttl time.Duration) error
+	GetJobTokenState(ctx context.Context, pipelineID int64, tokenHash []byte) (jobtoken.State, error)
+	ExtendJobToken(ctx context.Context, pipelineID int64, ttl time.Duration) error
+	RevokeJobToken(ctx context.Context, pipelineID int64) error
 }
 
 func Cleaner(s Storer, d time.Duration) {
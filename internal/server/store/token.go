@@ -0,0 +1,163 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"golang.org/x/oauth2"
+
+	"github.com/shark-ci/shark-ci/internal/server/db"
+	"github.com/shark-ci/shark-ci/internal/server/encryption"
+)
+
+// accessTokenField and refreshTokenField are encrypted tokens of the service
+// user, so access and refresh tokens cannot be swapped with each other or with
+// tokens of other users.
+func accessTokenField(serviceUserID int64) encryption.Field {
+	return encryption.Field{Table: "service_user", Column: "access_token", Row: serviceUserID}
+}
+
+func refreshTokenField(serviceUserID int64) encryption.Field {
+	return encryption.Field{Table: "service_user", Column: "refresh_token", Row: serviceUserID}
+}
+
+// encryptionBatchSize is how many rows are encrypted in single transaction.
+const encryptionBatchSize = 100
+
+// storedToken is token of service user as stored in the database.
+type storedToken struct {
+	ServiceUserID int64
+	AccessToken   string
+	RefreshToken  pgtype.Text
+	KeyID         pgtype.Text
+}
+
+// sealToken encrypts tokens of the service user with the current key.
+func (s *PostgresStore) sealToken(serviceUserID int64, accessToken string, refreshToken pgtype.Text) (storedToken, error) {
+	var err error
+	sealed := storedToken{ServiceUserID: serviceUserID, KeyID: pgtype.Text{String: s.keyring.CurrentKeyID(), Valid: true}}
+	sealed.AccessToken, err = s.keyring.Encrypt(accessToken, accessTokenField(serviceUserID))
+	if err != nil {
+		return storedToken{}, fmt.Errorf("cannot encrypt access token: %w", err)
+	}
+	if refreshToken.Valid {
+		sealed.RefreshToken.Valid = true
+		sealed.RefreshToken.String, err = s.keyring.Encrypt(refreshToken.String, refreshTokenField(serviceUserID))
+		if err != nil {
+			return storedToken{}, fmt.Errorf("cannot encrypt refresh token: %w", err)
+		}
+	}
+	return sealed, nil
+}
+
+// openToken decrypts tokens encrypted with any key of the keyring. Tokens
+// without key ID are plaintext tokens stored before the encryption and are
+// read only by EncryptServiceUserTokens.
+func (s *PostgresStore) openToken(stored storedToken) (accessToken string, refreshToken pgtype.Text, err error) {
+	if !stored.KeyID.Valid {
+		return stored.AccessToken, stored.RefreshToken, nil
+	}
+
+	accessToken, err = s.keyring.Decrypt(stored.KeyID.String, stored.AccessToken, accessTokenField(stored.ServiceUserID))
+	if err != nil {
+		return "", pgtype.Text{}, fmt.Errorf("cannot decrypt access token: %w", err)
+	}
+	if stored.RefreshToken.Valid {
+		refreshToken.Valid = true
+		refreshToken.String, err = s.keyring.Decrypt(stored.KeyID.String, stored.RefreshToken.String, refreshTokenField(stored.ServiceUserID))
+		if err != nil {
+			return "", pgtype.Text{}, fmt.Errorf("cannot decrypt refresh token: %w", err)
+		}
+	}
+	return accessToken, refreshToken, nil
+}
+
+// oauth2Token decrypts stored token of service user.
+func (s *PostgresStore) oauth2Token(stored storedToken, tokenType string, expire pgtype.Timestamp) (oauth2.Token, error) {
+	accessToken, refreshToken, err := s.openToken(stored)
+	if err != nil {
+		return oauth2.Token{}, err
+	}
+
+	return oauth2.Token{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken.String,
+		TokenType:    tokenType,
+		Expiry:       expire.Time,
+	}, nil
+}
+
+// EncryptServiceUserTokens encrypts plaintext tokens and re-encrypts tokens
+// encrypted with other than the current key after rotation or before they were
+// bound to their row. It is run by the
+// encrypt-secrets command after migrations. It returns number of updated
+// service users.
+func (s *PostgresStore) EncryptServiceUserTokens(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		n, err := s.encryptServiceUserTokensBatch(ctx)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < encryptionBatchSize {
+			return total, nil
+		}
+	}
+}
+
+func (s *PostgresStore) encryptServiceUserTokensBatch(ctx context.Context) (int, error) {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.queries.WithTx(tx)
+
+	rows, err := qtx.GetServiceUserTokensToEncrypt(ctx, db.GetServiceUserTokensToEncryptParams{
+		KeyID:     s.keyring.CurrentKeyID(),
+		BatchSize: encryptionBatchSize,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("cannot get service user tokens to encrypt: %w", err)
+	}
+
+	for _, row := range rows {
+		accessToken, refreshToken, err := s.openToken(storedToken{
+			ServiceUserID: row.ID,
+			AccessToken:   row.AccessToken,
+			RefreshToken:  row.RefreshToken,
+			KeyID:         row.TokenKeyID,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("cannot decrypt token of service user with id=%d: %w", row.ID, err)
+		}
+		sealed, err := s.sealToken(row.ID, accessToken, refreshToken)
+		if err != nil {
+			return 0, err
+		}
+
+		err = qtx.SetServiceUserTokenCiphertext(ctx, db.SetServiceUserTokenCiphertextParams{
+			ID:           row.ID,
+			AccessToken:  sealed.AccessToken,
+			RefreshToken: sealed.RefreshToken,
+			TokenKeyID:   sealed.KeyID,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("cannot update token of service user with id=%d: %w", row.ID, err)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("cannot commit transaction: %w", err)
+	}
+	if len(rows) > 0 {
+		slog.Info("Service user tokens encrypted.", "count", len(rows), "keyID", s.keyring.CurrentKeyID())
+	}
+
+	return len(rows), nil
+}
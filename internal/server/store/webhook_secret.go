@@ -0,0 +1,233 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/shark-ci/shark-ci/internal/server/db"
+	"github.com/shark-ci/shark-ci/internal/server/encryption"
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+// webhookSecretField is encrypted webhook secret of the repository. The
+// previous secret uses it too, so it can be moved on rotation.
+func webhookSecretField(repoID int64) encryption.Field {
+	return encryption.Field{Table: "repo", Column: "webhook_secret", Row: repoID}
+}
+
+// sealSecret encrypts webhook secret of the repository with the current key.
+// It returns ID of the key, which is invalid when there is no secret.
+func (s *PostgresStore) sealSecret(repoID int64, secret pgtype.Text) (pgtype.Text, pgtype.Text, error) {
+	if !secret.Valid {
+		return secret, pgtype.Text{}, nil
+	}
+
+	encrypted, err := s.keyring.Encrypt(secret.String, webhookSecretField(repoID))
+	if err != nil {
+		return pgtype.Text{}, pgtype.Text{}, fmt.Errorf("cannot encrypt webhook secret: %w", err)
+	}
+	return pgtype.Text{String: encrypted, Valid: true}, pgtype.Text{String: s.keyring.CurrentKeyID(), Valid: true}, nil
+}
+
+// openSecret decrypts webhook secret of the repository. Secret without key ID
+// is plaintext secret stored before the encryption.
+func (s *PostgresStore) openSecret(repoID int64, keyID pgtype.Text, secret pgtype.Text) (pgtype.Text, error) {
+	if !keyID.Valid || !secret.Valid {
+		return secret, nil
+	}
+
+	decrypted, err := s.keyring.Decrypt(keyID.String, secret.String, webhookSecretField(repoID))
+	if err != nil {
+		return pgtype.Text{}, fmt.Errorf("cannot decrypt webhook secret: %w", err)
+	}
+	return pgtype.Text{String: decrypted, Valid: true}, nil
+}
+
+// GetRepoWebhookSecrets returns webhook secrets of the repository.
+func (s *PostgresStore) GetRepoWebhookSecrets(ctx context.Context, service types.Service, serviceRepoID int64) (types.WebhookSecrets, error) {
+	res, err := s.queries.GetRepoWebhookSecrets(ctx, db.GetRepoWebhookSecretsParams{
+		Service:       db.Service(service),
+		RepoServiceID: serviceRepoID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return types.WebhookSecrets{}, ErrNotFound
+	}
+	if err != nil {
+		return types.WebhookSecrets{}, fmt.Errorf("cannot get webhook secrets of repo with service=%s and serviceRepoID=%d: %w", service, serviceRepoID, err)
+	}
+
+	secret, err := s.openSecret(res.ID, res.WebhookSecretKeyID, res.WebhookSecret)
+	if err != nil {
+		return types.WebhookSecrets{}, err
+	}
+	previous, err := s.openSecret(res.ID, res.WebhookSecretKeyID, res.PreviousWebhookSecret)
+	if err != nil {
+		return types.WebhookSecrets{}, err
+	}
+
+	return types.WebhookSecrets{
+		Secret:    secret.String,
+		Previous:  previous.String,
+		RotatedAt: ValueTime(res.WebhookSecretRotatedAt),
+	}, nil
+}
+
+// RotateRepoWebhookSecret replaces webhook secret of the repository. The
+// current secret becomes the previous one.
+func (s *PostgresStore) RotateRepoWebhookSecret(ctx context.Context, repoID int64, secret string) error {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.queries.WithTx(tx)
+
+	res, err := qtx.GetRepoWebhookSecretForUpdate(ctx, repoID)
+	if err != nil {
+		return fmt.Errorf("cannot get webhook secret of repo with id=%d: %w", repoID, err)
+	}
+	previous, err := s.openSecret(repoID, res.WebhookSecretKeyID, res.WebhookSecret)
+	if err != nil {
+		return err
+	}
+
+	sealedPrevious, _, err := s.sealSecret(repoID, previous)
+	if err != nil {
+		return err
+	}
+	sealed, keyID, err := s.sealSecret(repoID, pgtype.Text{String: secret, Valid: true})
+	if err != nil {
+		return err
+	}
+
+	err = qtx.RotateRepoWebhookSecret(ctx, db.RotateRepoWebhookSecretParams{
+		ID:                    repoID,
+		WebhookSecret:         sealed,
+		PreviousWebhookSecret: sealedPrevious,
+		WebhookSecretKeyID:    keyID,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot rotate webhook secret of repo with id=%d: %w", repoID, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot commit transaction: %w", err)
+	}
+	return nil
+}
+
+// RevertRepoWebhookSecret restores the previous webhook secret of the
+// repository when its current secret is still secret, e.g. because the forge
+// hook could not be updated after the rotation.
+func (s *PostgresStore) RevertRepoWebhookSecret(ctx context.Context, repoID int64, secret string) error {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.queries.WithTx(tx)
+
+	res, err := qtx.GetRepoWebhookSecretForUpdate(ctx, repoID)
+	if err != nil {
+		return fmt.Errorf("cannot get webhook secret of repo with id=%d: %w", repoID, err)
+	}
+	current, err := s.openSecret(repoID, res.WebhookSecretKeyID, res.WebhookSecret)
+	if err != nil {
+		return err
+	}
+	if current.String != secret {
+		return nil
+	}
+
+	err = qtx.RevertRepoWebhookSecret(ctx, repoID)
+	if err != nil {
+		return fmt.Errorf("cannot revert webhook secret of repo with id=%d: %w", repoID, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot commit transaction: %w", err)
+	}
+	return nil
+}
+
+// EncryptRepoWebhookSecrets encrypts plaintext webhook secrets and
+// re-encrypts secrets encrypted with other than the current key after
+// rotation or before they were bound to their row. It is run by the encrypt-secrets command after migrations. It
+// returns number of updated repositories.
+func (s *PostgresStore) EncryptRepoWebhookSecrets(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		n, err := s.encryptRepoWebhookSecretsBatch(ctx)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < encryptionBatchSize {
+			return total, nil
+		}
+	}
+}
+
+func (s *PostgresStore) encryptRepoWebhookSecretsBatch(ctx context.Context) (int, error) {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.queries.WithTx(tx)
+
+	rows, err := qtx.GetRepoWebhookSecretsToEncrypt(ctx, db.GetRepoWebhookSecretsToEncryptParams{
+		KeyID:     s.keyring.CurrentKeyID(),
+		BatchSize: encryptionBatchSize,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("cannot get webhook secrets to encrypt: %w", err)
+	}
+
+	for _, row := range rows {
+		secret, err := s.openSecret(row.ID, row.WebhookSecretKeyID, row.WebhookSecret)
+		if err != nil {
+			return 0, fmt.Errorf("cannot decrypt webhook secret of repo with id=%d: %w", row.ID, err)
+		}
+		previous, err := s.openSecret(row.ID, row.WebhookSecretKeyID, row.PreviousWebhookSecret)
+		if err != nil {
+			return 0, fmt.Errorf("cannot decrypt webhook secret of repo with id=%d: %w", row.ID, err)
+		}
+
+		sealed, keyID, err := s.sealSecret(row.ID, secret)
+		if err != nil {
+			return 0, err
+		}
+		sealedPrevious, _, err := s.sealSecret(row.ID, previous)
+		if err != nil {
+			return 0, err
+		}
+
+		err = qtx.SetRepoWebhookSecretCiphertext(ctx, db.SetRepoWebhookSecretCiphertextParams{
+			ID:                    row.ID,
+			WebhookSecret:         sealed,
+			PreviousWebhookSecret: sealedPrevious,
+			WebhookSecretKeyID:    keyID,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("cannot update webhook secret of repo with id=%d: %w", row.ID, err)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("cannot commit transaction: %w", err)
+	}
+	if len(rows) > 0 {
+		slog.Info("Repository webhook secrets encrypted.", "count", len(rows), "keyID", s.keyring.CurrentKeyID())
+	}
+
+	return len(rows), nil
+}
@@ -0,0 +1,234 @@
+// Package webhookcheck verifies webhooks of registered repositories still exist
+// on the services with expected configuration and repairs them.
+package webhookcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/shark-ci/shark-ci/internal/server/service"
+	"github.com/shark-ci/shark-ci/internal/server/store"
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+const (
+	// batchSize is how many repositories are checked each poll interval.
+	batchSize    = 10
+	pollInterval = time.Minute
+)
+
+// Checker periodically checks webhooks of repositories which were not
+// checked for the interval.
+type Checker struct {
+	s        store.Storer
+	services service.Services
+	interval time.Duration
+}
+
+func NewChecker(s store.Storer, services service.Services, interval time.Duration) *Checker {
+	return &Checker{
+		s:        s,
+		services: services,
+		interval: interval,
+	}
+}
+
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		c.checkDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Checker) checkDue(ctx context.Context) {
+	repoIDs, err := c.s.ClaimReposToCheckWebhook(ctx, c.interval, batchSize)
+	if err != nil {
+		slog.Error("Cannot claim repos to check webhook.", "err", err)
+		return
+	}
+
+	for _, repoID := range repoIDs {
+		err = c.Check(ctx, repoID)
+		if err != nil {
+			slog.Warn("Webhook check failed.", "repoID", repoID, "err", err)
+		}
+	}
+}
+
+// Check verifies webhook of the repository. Missing webhook is recreated and
+// misconfigured one is repaired. Result of the check is saved to the store.
+func (c *Checker) Check(ctx context.Context, repoID int64) error {
+	repaired, checkErr := c.check(ctx, repoID)
+
+	var checkError *string
+	if checkErr != nil {
+		msg := checkErr.Error()
+		checkError = &msg
+	}
+	err := c.s.SetRepoWebhookCheck(ctx, repoID, checkError, repaired)
+	if err != nil {
+		return err
+	}
+	return checkErr
+}
+
+func (c *Checker) check(ctx context.Context, repoID int64) (bool, error) {
+	info, err := c.s.GetRepoWebhookChangeInfo(ctx, repoID)
+	if err != nil {
+		return false, err
+	}
+	if info.WebhookID == nil {
+		return false, errors.New("repo is accessed through app installation and has no webhook")
+	}
+	srv, ok := c.services[info.Service]
+	if !ok {
+		return false, fmt.Errorf("unknown service %s", info.Service)
+	}
+
+	token, err := service.UserToken(ctx, c.s, srv, info.ServiceUserID, info.Token)
+	if err != nil {
+		return false, fmt.Errorf("cannot get token: %w", err)
+	}
+	logger := slog.With("repoID", repoID, "service", info.Service, "webhookID", *info.WebhookID)
+
+	// GitLab and Gitea send no events when repository is renamed, archived
+	// or deleted, so its state is synced here.
+	active, err := c.syncRepo(ctx, logger, srv, &token, info)
+	if err != nil || !active {
+		return false, err
+	}
+
+	// Repositories registered before they had own secrets are signed with
+	// the secret key, they get own secret.
+	secrets, err := c.s.GetRepoWebhookSecrets(ctx, info.Service, info.RepoServiceID)
+	if err != nil {
+		return false, err
+	}
+	legacy := secrets.Secret == ""
+
+	hook, err := srv.GetWebhook(ctx, &token, info.RepoOwner, info.RepoName, *info.WebhookID)
+	if service.IsNotFound(err) {
+		hookID, err := c.recreate(ctx, srv, &token, info, legacy)
+		if err != nil {
+			return false, fmt.Errorf("webhook is missing and cannot be recreated: %w", err)
+		}
+		logger.Info("Missing webhook was recreated.", "newWebhookID", hookID)
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("cannot get webhook: %w", err)
+	}
+
+	repaired := false
+	if legacy {
+		err = service.RotateWebhookSecret(ctx, c.s, srv, &token, info)
+		if err != nil {
+			return false, fmt.Errorf("webhook is signed with the secret key and cannot get own secret: %w", err)
+		}
+		logger.Info("Webhook secret key was replaced by own secret of the repository.")
+		repaired = true
+	}
+
+	problems := service.WebhookProblems(srv.ExpectedWebhook(), hook)
+	if len(problems) == 0 {
+		return repaired, nil
+	}
+	secret, err := service.CurrentWebhookSecret(ctx, c.s, info.Service, info.RepoServiceID)
+	if err != nil {
+		return repaired, err
+	}
+	err = srv.RepairWebhook(ctx, &token, info.RepoOwner, info.RepoName, *info.WebhookID, secret)
+	if err != nil {
+		return repaired, fmt.Errorf("webhook is misconfigured (%v) and cannot be repaired: %w", problems, err)
+	}
+	logger.Info("Misconfigured webhook was repaired.", "problems", problems)
+	return true, nil
+}
+
+// syncRepo updates owner, name and archived state of the repository from the
+// service. Repository which is not found is unregistered. It reports whether
+// the repository is active and its webhook should be checked.
+func (c *Checker) syncRepo(ctx context.Context, logger *slog.Logger, srv service.ServiceManager, token *oauth2.Token, info *types.RepoWebhookChangeInfo) (bool, error) {
+	repo, err := srv.GetRepo(ctx, token, info.RepoServiceID)
+	if service.IsNotFound(err) {
+		err = service.RepoDeleted(ctx, c.s, info.RepoID, types.ActorSystem)
+		if err != nil {
+			return false, err
+		}
+		logger.Info("Repository was not found, it was unregistered.")
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("cannot get repository: %w", err)
+	}
+
+	if repo.Owner != info.RepoOwner || repo.Name != info.RepoName {
+		err = c.s.UpdateRepoName(ctx, info.RepoID, repo.Owner, repo.Name)
+		if err != nil {
+			return false, err
+		}
+		logger.Info("Repository was renamed.", "from", info.RepoOwner+"/"+info.RepoName, "to", repo.Owner+"/"+repo.Name)
+		info.RepoOwner = repo.Owner
+		info.RepoName = repo.Name
+	}
+	if repo.ForgeArchived != info.ForgeArchived {
+		err = service.RepoArchived(ctx, c.s, info.RepoID, repo.ForgeArchived, types.ActorSystem)
+		if err != nil {
+			return false, err
+		}
+		logger.Info("Repository archived state changed.", "archived", repo.ForgeArchived)
+	}
+	// Webhooks of archived repositories cannot be changed.
+	return !repo.ForgeArchived, nil
+}
+
+// recreate creates missing webhook of the repository. Repository without own
+// secret gets new one instead of the secret key.
+func (c *Checker) recreate(ctx context.Context, srv service.ServiceManager, token *oauth2.Token, info *types.RepoWebhookChangeInfo, legacy bool) (int64, error) {
+	var secret string
+	var err error
+	if legacy {
+		secret, err = service.NewWebhookSecret()
+		if err != nil {
+			return 0, fmt.Errorf("cannot generate webhook secret: %w", err)
+		}
+		err = c.s.RotateRepoWebhookSecret(ctx, info.RepoID, secret)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		secret, err = service.CurrentWebhookSecret(ctx, c.s, info.Service, info.RepoServiceID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	hookID, err := srv.CreateWebhook(ctx, token, info.RepoOwner, info.RepoName, secret)
+	if err != nil {
+		if legacy {
+			revertErr := c.s.RevertRepoWebhookSecret(ctx, info.RepoID, secret)
+			if revertErr != nil {
+				slog.Error("Cannot revert webhook secret.", "repoID", info.RepoID, "err", revertErr)
+			}
+		}
+		return 0, err
+	}
+	err = c.s.SetRepoWebhookID(ctx, info.RepoID, hookID)
+	if err != nil {
+		return 0, err
+	}
+	return hookID, nil
+}
@@ -0,0 +1,305 @@
+package webhookcheck
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/shark-ci/shark-ci/internal/server/service"
+	"github.com/shark-ci/shark-ci/internal/server/store"
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+var expected = types.Webhook{URL: "https://ci.example.com/event_handler/GitHub", ContentType: "json", Active: true, Events: []string{"push"}}
+
+// fakeStore holds single repository with webhook.
+type fakeStore struct {
+	store.Storer
+	webhookID int64
+	secret    string
+	previous  string
+	claimed   int
+	checkErr  *string
+	repaired  bool
+
+	owner         string
+	forgeArchived bool
+	cancelled     bool
+	archived      bool
+}
+
+func (s *fakeStore) ClaimReposToCheckWebhook(ctx context.Context, interval time.Duration, maxRepos int32) ([]int64, error) {
+	s.claimed++
+	if s.claimed > 1 {
+		return nil, nil
+	}
+	return []int64{1}, nil
+}
+
+func (s *fakeStore) GetRepoWebhookChangeInfo(ctx context.Context, repoID int64) (*types.RepoWebhookChangeInfo, error) {
+	webhookID := s.webhookID
+	return &types.RepoWebhookChangeInfo{
+		RepoID:        repoID,
+		Service:       types.ServiceGitHub,
+		RepoOwner:     s.owner,
+		RepoName:      "repo",
+		RepoServiceID: 7,
+		WebhookID:     &webhookID,
+		ForgeArchived: s.forgeArchived,
+		Token:         oauth2.Token{AccessToken: "token"},
+	}, nil
+}
+
+func (s *fakeStore) GetRepoWebhookSecrets(ctx context.Context, srv types.Service, serviceRepoID int64) (types.WebhookSecrets, error) {
+	return types.WebhookSecrets{Secret: s.secret, Previous: s.previous}, nil
+}
+
+func (s *fakeStore) RotateRepoWebhookSecret(ctx context.Context, repoID int64, secret string) error {
+	s.previous, s.secret = s.secret, secret
+	return nil
+}
+
+func (s *fakeStore) RevertRepoWebhookSecret(ctx context.Context, repoID int64, secret string) error {
+	if s.secret == secret {
+		s.secret, s.previous = s.previous, ""
+	}
+	return nil
+}
+
+func (s *fakeStore) SetRepoWebhookID(ctx context.Context, repoID int64, webhookID int64) error {
+	s.webhookID = webhookID
+	return nil
+}
+
+func (s *fakeStore) UpdateRepoName(ctx context.Context, repoID int64, owner string, name string) error {
+	s.owner = owner
+	return nil
+}
+
+func (s *fakeStore) SetRepoForgeArchived(ctx context.Context, repoID int64, archived bool) error {
+	s.forgeArchived = archived
+	return nil
+}
+
+func (s *fakeStore) CancelRepoPipelines(ctx context.Context, repoID int64, actor string, message string) ([]types.Pipeline, error) {
+	s.cancelled = true
+	return nil, nil
+}
+
+func (s *fakeStore) ArchiveRepo(ctx context.Context, repoID int64) error {
+	s.archived = true
+	return nil
+}
+
+func (s *fakeStore) SetRepoWebhookCheck(ctx context.Context, repoID int64, checkError *string, repaired bool) error {
+	s.checkErr = checkError
+	s.repaired = repaired
+	return nil
+}
+
+// fakeService holds the repository and its webhooks by ID.
+type fakeService struct {
+	service.ServiceManager
+	hooks     map[int64]types.Webhook
+	secrets   map[int64]string
+	createErr error
+
+	repo    *types.Repo
+	updated bool
+}
+
+func (f *fakeService) GetRepo(ctx context.Context, token *oauth2.Token, repoServiceID int64) (types.Repo, error) {
+	if f.repo == nil {
+		return types.Repo{Owner: "owner", Name: "repo", RepoServiceID: repoServiceID}, nil
+	}
+	if f.repo.RepoServiceID == 0 {
+		return types.Repo{}, &service.APIError{StatusCode: http.StatusNotFound}
+	}
+	return *f.repo, nil
+}
+
+func (f *fakeService) ExpectedWebhook() types.Webhook {
+	return expected
+}
+
+func (f *fakeService) GetWebhook(ctx context.Context, token *oauth2.Token, owner string, repoName string, webhookID int64) (types.Webhook, error) {
+	hook, ok := f.hooks[webhookID]
+	if !ok {
+		return types.Webhook{}, &service.APIError{StatusCode: http.StatusNotFound}
+	}
+	return hook, nil
+}
+
+func (f *fakeService) CreateWebhook(ctx context.Context, token *oauth2.Token, owner string, repoName string, secret string) (int64, error) {
+	if f.createErr != nil {
+		return 0, f.createErr
+	}
+	id := int64(len(f.hooks) + 100)
+	f.hooks[id] = expected
+	f.secrets[id] = secret
+	return id, nil
+}
+
+func (f *fakeService) RepairWebhook(ctx context.Context, token *oauth2.Token, owner string, repoName string, webhookID int64, secret string) error {
+	f.updated = true
+	f.hooks[webhookID] = expected
+	f.secrets[webhookID] = secret
+	return nil
+}
+
+func (f *fakeService) UpdateWebhookSecret(ctx context.Context, token *oauth2.Token, owner string, repoName string, webhookID int64, secret string) error {
+	f.secrets[webhookID] = secret
+	return nil
+}
+
+func newChecker(s *fakeStore, srv *fakeService) *Checker {
+	return NewChecker(s, service.Services{types.ServiceGitHub: srv}, time.Hour)
+}
+
+func TestCheckHealthyWebhook(t *testing.T) {
+	s := &fakeStore{webhookID: 1, secret: "own", owner: "owner"}
+	srv := &fakeService{hooks: map[int64]types.Webhook{1: expected}, secrets: map[int64]string{1: "own"}}
+
+	err := newChecker(s, srv).Check(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if s.repaired || s.checkErr != nil || s.secret != "own" {
+		t.Errorf("healthy webhook was changed, repaired=%v checkErr=%v secret=%q", s.repaired, s.checkErr, s.secret)
+	}
+}
+
+func TestCheckRepairsWebhook(t *testing.T) {
+	s := &fakeStore{webhookID: 1, secret: "own", owner: "owner"}
+	srv := &fakeService{hooks: map[int64]types.Webhook{1: {URL: "https://old.example.com", Active: false}}, secrets: map[int64]string{}}
+
+	err := newChecker(s, srv).Check(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if !s.repaired || srv.hooks[1].URL != expected.URL || srv.secrets[1] != "own" {
+		t.Errorf("webhook was not repaired, repaired=%v hook=%+v secret=%q", s.repaired, srv.hooks[1], srv.secrets[1])
+	}
+}
+
+func TestCheckRecreatesWebhook(t *testing.T) {
+	s := &fakeStore{webhookID: 1, secret: "own", owner: "owner"}
+	srv := &fakeService{hooks: map[int64]types.Webhook{}, secrets: map[int64]string{}}
+
+	err := newChecker(s, srv).Check(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if !s.repaired || s.webhookID == 1 || srv.secrets[s.webhookID] != "own" {
+		t.Errorf("webhook was not recreated with own secret, webhookID=%d secrets=%v", s.webhookID, srv.secrets)
+	}
+}
+
+func TestCheckLegacySecret(t *testing.T) {
+	t.Run("existing webhook", func(t *testing.T) {
+		s := &fakeStore{webhookID: 1, owner: "owner"}
+		srv := &fakeService{hooks: map[int64]types.Webhook{1: expected}, secrets: map[int64]string{1: "secret-key"}}
+
+		err := newChecker(s, srv).Check(context.Background(), 1)
+		if err != nil {
+			t.Fatalf("Check() error = %v", err)
+		}
+		if s.secret == "" || srv.secrets[1] != s.secret || !s.repaired {
+			t.Errorf("repository did not get own secret, store=%q hook=%q", s.secret, srv.secrets[1])
+		}
+	})
+
+	t.Run("recreated webhook", func(t *testing.T) {
+		s := &fakeStore{webhookID: 1, owner: "owner"}
+		srv := &fakeService{hooks: map[int64]types.Webhook{}, secrets: map[int64]string{}}
+
+		err := newChecker(s, srv).Check(context.Background(), 1)
+		if err != nil {
+			t.Fatalf("Check() error = %v", err)
+		}
+		if s.secret == "" || srv.secrets[s.webhookID] != s.secret {
+			t.Errorf("webhook was not recreated with own secret, store=%q hook=%q", s.secret, srv.secrets[s.webhookID])
+		}
+	})
+
+	t.Run("recreate fails", func(t *testing.T) {
+		s := &fakeStore{webhookID: 1, owner: "owner"}
+		srv := &fakeService{hooks: map[int64]types.Webhook{}, secrets: map[int64]string{}, createErr: errors.New("forbidden")}
+
+		err := newChecker(s, srv).Check(context.Background(), 1)
+		if err == nil {
+			t.Fatal("Check() succeeded although webhook was not recreated")
+		}
+		if s.secret != "" || s.checkErr == nil {
+			t.Errorf("secret = %q, checkErr = %v, want secret reverted and error saved", s.secret, s.checkErr)
+		}
+	})
+}
+
+func TestCheckDue(t *testing.T) {
+	s := &fakeStore{webhookID: 1, secret: "own", owner: "owner"}
+	srv := &fakeService{hooks: map[int64]types.Webhook{}, secrets: map[int64]string{}}
+
+	newChecker(s, srv).checkDue(context.Background())
+	if s.claimed != 1 || s.webhookID == 1 {
+		t.Errorf("claimed %d times, webhookID = %d, want claimed repo checked", s.claimed, s.webhookID)
+	}
+}
+
+func TestCheckSyncsRepo(t *testing.T) {
+	t.Run("renamed", func(t *testing.T) {
+		s := &fakeStore{webhookID: 1, secret: "own", owner: "owner"}
+		srv := &fakeService{hooks: map[int64]types.Webhook{1: expected}, secrets: map[int64]string{}, repo: &types.Repo{Owner: "new-owner", Name: "repo", RepoServiceID: 7}}
+
+		err := newChecker(s, srv).Check(context.Background(), 1)
+		if err != nil {
+			t.Fatalf("Check() error = %v", err)
+		}
+		if s.owner != "new-owner" {
+			t.Errorf("owner = %q, want new-owner", s.owner)
+		}
+	})
+
+	t.Run("archived", func(t *testing.T) {
+		s := &fakeStore{webhookID: 1, secret: "own", owner: "owner"}
+		srv := &fakeService{hooks: map[int64]types.Webhook{1: {URL: "https://old.example.com"}}, secrets: map[int64]string{}, repo: &types.Repo{Owner: "owner", Name: "repo", RepoServiceID: 7, ForgeArchived: true}}
+
+		err := newChecker(s, srv).Check(context.Background(), 1)
+		if err != nil {
+			t.Fatalf("Check() error = %v", err)
+		}
+		if !s.forgeArchived || !s.cancelled || s.archived || srv.updated || s.webhookID != 1 {
+			t.Errorf("forgeArchived=%v cancelled=%v archived=%v hookUpdated=%v webhookID=%d, want archived on forge with webhook kept", s.forgeArchived, s.cancelled, s.archived, srv.updated, s.webhookID)
+		}
+	})
+
+	t.Run("unarchived", func(t *testing.T) {
+		s := &fakeStore{webhookID: 1, secret: "own", owner: "owner", forgeArchived: true}
+		srv := &fakeService{hooks: map[int64]types.Webhook{1: expected}, secrets: map[int64]string{}, repo: &types.Repo{Owner: "owner", Name: "repo", RepoServiceID: 7}}
+
+		err := newChecker(s, srv).Check(context.Background(), 1)
+		if err != nil {
+			t.Fatalf("Check() error = %v", err)
+		}
+		if s.forgeArchived || s.cancelled {
+			t.Errorf("forgeArchived=%v cancelled=%v, want unarchived", s.forgeArchived, s.cancelled)
+		}
+	})
+
+	t.Run("deleted", func(t *testing.T) {
+		s := &fakeStore{webhookID: 1, secret: "own", owner: "owner"}
+		srv := &fakeService{hooks: map[int64]types.Webhook{}, secrets: map[int64]string{}, repo: &types.Repo{}}
+
+		err := newChecker(s, srv).Check(context.Background(), 1)
+		if err != nil {
+			t.Fatalf("Check() error = %v", err)
+		}
+		if !s.archived || !s.cancelled || s.webhookID != 1 {
+			t.Errorf("archived=%v cancelled=%v webhookID=%d, want deleted repo unregistered without recreating webhook", s.archived, s.cancelled, s.webhookID)
+		}
+	})
+}
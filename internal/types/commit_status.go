@@ -0,0 +1,18 @@
+package types
+
+// CommitStatus is state of the commit reported to the service.
+type CommitStatus struct {
+	ID          int64
+	RepoID      int64
+	CommitSHA   string
+	Context     string
+	State       PipelineStatus
+	TargetURL   string
+	Description string
+	PipelineID  *int64
+	Version     int
+	// Created is set when the status of the version was created on the
+	// service and only its check runs are not sent yet.
+	Created  bool
+	Attempts int
+}
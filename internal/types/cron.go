@@ -0,0 +1,186 @@
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is parsed cron expression with fields minute, hour, day of month,
+// month and day of week.
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar are set when the field starts with *. Day matches
+	// when both days match, or either of them when both are restricted.
+	domStar, dowStar bool
+}
+
+type cronField struct {
+	min, max int
+	names    []string
+}
+
+var (
+	cronMinute = cronField{min: 0, max: 59}
+	cronHour   = cronField{min: 0, max: 23}
+	cronDom    = cronField{min: 1, max: 31}
+	cronMonth  = cronField{min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	// Day of week 7 is Sunday too.
+	cronDow = cronField{min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses standard five field cron expression, e.g. "30 2 * * 1-5",
+// or one of macros like @daily.
+func ParseCron(expr string) (Cron, error) {
+	spec := strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return Cron{}, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+
+	var c Cron
+	var err error
+	for i, f := range []struct {
+		dst   *uint64
+		field cronField
+	}{
+		{&c.minute, cronMinute},
+		{&c.hour, cronHour},
+		{&c.dom, cronDom},
+		{&c.month, cronMonth},
+		{&c.dow, cronDow},
+	} {
+		*f.dst, err = f.field.parse(fields[i])
+		if err != nil {
+			return Cron{}, fmt.Errorf("cron expression %q: %w", expr, err)
+		}
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domStar = strings.HasPrefix(fields[2], "*")
+	c.dowStar = strings.HasPrefix(fields[4], "*")
+	return c, nil
+}
+
+// parse returns bit set of values of the field, it is comma separated list of
+// values, ranges and *, each optionally with step.
+func (f cronField) parse(s string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		rng, step, hasStep := strings.Cut(part, "/")
+		start, end := f.min, f.max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			lo, hi, _ := strings.Cut(rng, "-")
+			var err error
+			if start, err = f.value(lo); err != nil {
+				return 0, err
+			}
+			if end, err = f.value(hi); err != nil {
+				return 0, err
+			}
+			if start > end {
+				return 0, fmt.Errorf("invalid range %s", rng)
+			}
+		default:
+			var err error
+			if start, err = f.value(rng); err != nil {
+				return 0, err
+			}
+			// Value with step runs from the value to the maximum.
+			if !hasStep {
+				end = start
+			}
+		}
+
+		n := 1
+		if hasStep {
+			var err error
+			n, err = strconv.Atoi(step)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", step)
+			}
+		}
+		for v := start; v <= end; v += n {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid value %q, must be between %d and %d", s, f.min, f.max)
+	}
+	return v, nil
+}
+
+func (c Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<t.Weekday()) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first time after t matching the expression in location of
+// t. Times skipped by daylight saving change are not matched. Zero time is
+// returned when nothing matches, e.g. for 30th February.
+func (c Cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	yearLimit := t.Year() + 5
+
+wrap:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+	for c.month&(1<<t.Month()) == 0 {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+	for !c.dayMatches(t) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+	for c.hour&(1<<t.Hour()) == 0 {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+	for c.minute&(1<<t.Minute()) == 0 {
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+	return t
+}
@@ -0,0 +1,51 @@
+package types
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	prague, err := time.LoadLocation("Europe/Prague")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"@daily", time.Date(2025, 3, 6, 10, 30, 0, 0, time.UTC), time.Date(2025, 3, 7, 0, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2025, 3, 6, 10, 30, 0, 0, time.UTC), time.Date(2025, 3, 6, 10, 45, 0, 0, time.UTC)},
+		{"30 2 * * mon-fri", time.Date(2025, 3, 7, 3, 0, 0, 0, time.UTC), time.Date(2025, 3, 10, 2, 30, 0, 0, time.UTC)},
+		{"0 0 1 jan,jul *", time.Date(2025, 3, 6, 0, 0, 0, 0, time.UTC), time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2025, 3, 6, 0, 0, 0, 0, time.UTC), time.Date(2025, 3, 9, 12, 0, 0, 0, time.UTC)},
+		// Either day of month or day of week matches when both are set.
+		{"0 0 13 * 5", time.Date(2025, 3, 6, 0, 0, 0, 0, time.UTC), time.Date(2025, 3, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2025, 3, 6, 0, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Date(2025, 3, 6, 0, 0, 0, 0, time.UTC), time.Time{}},
+		{"0 3 * * *", time.Date(2025, 3, 6, 12, 0, 0, 0, prague), time.Date(2025, 3, 7, 3, 0, 0, 0, prague)},
+		// 2:30 does not exist on the day daylight saving time starts.
+		{"30 2 * * *", time.Date(2025, 3, 29, 12, 0, 0, 0, prague), time.Date(2025, 3, 31, 2, 30, 0, 0, prague)},
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := c.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("ParseCron(%q).Next(%v) = %v, want %v", tt.expr, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	exprs := []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "* * * foo *"}
+	for _, expr := range exprs {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) succeeded", expr)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package types
 
 import (
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/shark-ci/shark-ci/internal/config"
@@ -12,35 +14,241 @@ type PipelineStatus string
 
 const (
 	Success PipelineStatus = "success" // GitHub -> Success, GitLab -> Success
-	Pending PipelineStatus = "pending" // GitHub -> Pendign, GitLab -> Pending
+	Queued  PipelineStatus = "queued"  // GitHub -> Pendign, GitLab -> Pending
 	Running PipelineStatus = "running" // GitHub -> Pending, GitLab -> Running
-	Error   PipelineStatus = "error"   // GitHub -> Error, GitLab -> Failed
+	// Failure pipelines have failing step.
+	Failure PipelineStatus = "failure" // GitHub -> Failure, GitLab -> Failed
+	// Error pipelines could not run, e.g. repository could not be cloned
+	// or image pulled.
+	Error PipelineStatus = "error" // GitHub -> Error, GitLab -> Failed
+	// Cancelled pipelines are not finished by workers, e.g. when their
+	// repository is unregistered.
+	Cancelled PipelineStatus = "cancelled" // GitHub -> Error, GitLab -> Canceled
+	TimedOut  PipelineStatus = "timed_out" // GitHub -> Failure, GitLab -> Failed
+	// Skipped pipelines have nothing to run, e.g. repository has no
+	// workflow.
+	Skipped PipelineStatus = "skipped" // GitHub -> Success, GitLab -> Skipped
 )
 
+// pipelineTransitions are statuses pipeline can move to from its status.
+// Statuses without transitions are terminal.
+var pipelineTransitions = map[PipelineStatus][]PipelineStatus{
+	// Worker may fail to report start of the pipeline, so it can be
+	// finished right away.
+	Queued:  {Running, Success, Failure, Error, Cancelled, TimedOut, Skipped},
+	Running: {Success, Failure, Error, Cancelled, TimedOut, Skipped},
+}
+
+// Terminal reports whether pipeline with the status is finished.
+func (s PipelineStatus) Terminal() bool {
+	return len(pipelineTransitions[s]) == 0
+}
+
+// CanTransition reports whether pipeline can move from the status to status to.
+func (s PipelineStatus) CanTransition(to PipelineStatus) bool {
+	return slices.Contains(pipelineTransitions[s], to)
+}
+
+// Failed reports whether pipeline with the status finished without success, so
+// its job can be re-run as failed.
+func (s PipelineStatus) Failed() bool {
+	switch s {
+	case Failure, Error, Cancelled, TimedOut:
+		return true
+	default:
+		return false
+	}
+}
+
+// TransitionsTo returns statuses from which pipeline can move to the status.
+func TransitionsTo(to PipelineStatus) []PipelineStatus {
+	var from []PipelineStatus
+	for _, s := range PipelineStatuses {
+		if s.CanTransition(to) {
+			from = append(from, s)
+		}
+	}
+	return from
+}
+
+// PipelineEvent is what triggered the pipeline.
+type PipelineEvent string
+
+const (
+	EventPush        PipelineEvent = "push"
+	EventTag         PipelineEvent = "tag"
+	EventPullRequest PipelineEvent = "pull_request"
+	// EventRerun pipelines were requested again on the service before re-runs
+	// became attempts of the pipeline, which keep its event.
+	EventRerun PipelineEvent = "rerun"
+	// EventManual pipelines were triggered by user of Shark CI.
+	EventManual PipelineEvent = "manual"
+	// EventSchedule pipelines were run by schedule of the repository.
+	EventSchedule PipelineEvent = "schedule"
+)
+
+// PipelineStatuses are all statuses of pipelines.
+var PipelineStatuses = []PipelineStatus{Queued, Running, Success, Failure, Error, Cancelled, TimedOut, Skipped}
+
 type Pipeline struct {
-	ID         int64
+	ID int64
+	// Number is sequential number of the pipeline in its repository, it is
+	// shown to users instead of the ID.
+	Number     int64
 	URL        string
 	Status     PipelineStatus
 	CloneURL   string
 	CommitSHA  string
+	CreatedAt  time.Time
 	StartedAt  *time.Time
 	FinishedAt *time.Time
-	RepoID     int64
+	// SentAt is nil until the pipeline is sent to workers.
+	SentAt *time.Time
+	RepoID int64
+
+	// Pull request pipelines only.
+	PRNumber     *int32
+	SourceBranch *string
+	TargetBranch *string
+	// FetchRef is fetched instead of the commit, e.g. GitHub merge ref of
+	// the pull request.
+	FetchRef *string
+	// Fork pipelines run code from other repository so they never get
+	// secrets and may need maintainer approval before they run.
+	Fork             bool
+	AwaitingApproval bool
+	ApprovedBy       *int64
+
+	// Metadata from the event which triggered the pipeline, fields not
+	// sent by the service are nil.
+	Event PipelineEvent
+	Ref   *string
+	// Branch is pushed branch or source branch of pull request.
+	Branch         *string
+	Tag            *string
+	CommitMessage  *string
+	AuthorName     *string
+	AuthorEmail    *string
+	CommitterName  *string
+	CommitterEmail *string
+	// Pusher is username of the user who pushed, opened pull request or
+	// triggered the pipeline manually.
+	Pusher     *string
+	CompareURL *string
+
+	// Attempt is 1 for pipelines created by events, re-runs of the pipeline
+	// are next attempts linked to the first one by OriginalID.
+	Attempt    int32
+	OriginalID *int64
+	// Workflow is the workflow file loaded by the worker, re-runs use it
+	// instead of the file in the repository.
+	Workflow *string
+	// Inputs are values of workflow inputs of manually triggered pipeline.
+	Inputs map[string]string
+}
+
+// FirstAttemptID returns ID of the first attempt of the pipeline.
+func (p Pipeline) FirstAttemptID() int64 {
+	if p.OriginalID != nil {
+		return *p.OriginalID
+	}
+	return p.ID
+}
+
+// Rerun returns new attempt of the pipeline with the same commit, ref, event
+// metadata and workflow. Its attempt and number are set when it is created.
+func (p Pipeline) Rerun() Pipeline {
+	originalID := p.FirstAttemptID()
+	rerun := p
+	rerun.ID = 0
+	rerun.Number = 0
+	rerun.URL = ""
+	rerun.Status = Queued
+	rerun.CreatedAt = time.Time{}
+	rerun.StartedAt = nil
+	rerun.FinishedAt = nil
+	rerun.AwaitingApproval = false
+	rerun.ApprovedBy = nil
+	rerun.Attempt = 0
+	rerun.OriginalID = &originalID
+	return rerun
+}
+
+// Duration returns how long the pipeline runs or ran. It is zero for
+// pipelines which did not start.
+func (p Pipeline) Duration() time.Duration {
+	if p.StartedAt == nil {
+		return 0
+	}
+	if p.FinishedAt == nil {
+		return time.Since(*p.StartedAt).Truncate(time.Second)
+	}
+	return p.FinishedAt.Sub(*p.StartedAt).Truncate(time.Second)
+}
+
+// CommitTitle returns the first line of the commit message.
+func (p Pipeline) CommitTitle() string {
+	if p.CommitMessage == nil {
+		return ""
+	}
+	title, _, _ := strings.Cut(*p.CommitMessage, "\n")
+	return title
 }
 
 func (p *Pipeline) CreateURL() {
-	p.URL = fmt.Sprintf("%s/repos/%d/pipelines/%d", config.ServerConf.Host, p.RepoID, p.ID)
+	p.URL = fmt.Sprintf("%s/repos/%d/builds/%d", config.ServerConf.Host, p.RepoID, p.Number)
+}
+
+// PipelineFilter selects pipelines of repository. Nil fields match all
+// pipelines. Pipelines are paginated by BeforeID, ID of the last pipeline of
+// the previous page.
+type PipelineFilter struct {
+	Status   *PipelineStatus
+	Branch   *string
+	From     *time.Time
+	To       *time.Time
+	BeforeID *int64
+	Limit    int32
 }
 
 type PipelineCreationInfo struct {
-	RepoName string
-	Username string
-	Token    oauth2.Token
+	ServiceUserID  int64
+	RepoName       string
+	Username       string
+	Service        Service
+	RepoServiceID  int64
+	InstallationID *int64
+	Token          oauth2.Token
+}
+
+const (
+	// ActorWorker makes transitions reported by workers.
+	ActorWorker = "worker"
+	// ActorSystem makes transitions server does on its own.
+	ActorSystem = "system"
+)
+
+// PipelineTransition is change of pipeline status.
+type PipelineTransition struct {
+	// From is nil when the pipeline was created. When it is set for status
+	// change, the pipeline only moves from this status.
+	From *PipelineStatus
+	To   PipelineStatus
+	// Actor is who made the change, username of the user, ActorWorker or
+	// ActorSystem.
+	Actor   string
+	Message *string
+	// At sets start or finish time of the pipeline, depending on To.
+	At time.Time
 }
 
 type PipelineStateChangeInfo struct {
+	RepoID    int64
+	Number    int64
+	Status    PipelineStatus
 	CommitSHA string
 	URL       string
+	PRNumber  *int32
 	Service   Service
 	RepoOwner string
 	RepoName  string
@@ -54,4 +262,15 @@ type PipelineLog struct {
 	Output     string
 	ExitCode   int
 	PipelineID int64
+	// StartedAt and FinishedAt are nil for logs sent by older workers.
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+}
+
+// Duration returns how long the command ran, zero if it is unknown.
+func (l PipelineLog) Duration() time.Duration {
+	if l.StartedAt == nil || l.FinishedAt == nil {
+		return 0
+	}
+	return l.FinishedAt.Sub(*l.StartedAt).Truncate(time.Millisecond)
 }
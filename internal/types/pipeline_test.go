@@ -0,0 +1,76 @@
+package types
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPipelineTransitions(t *testing.T) {
+	tests := []struct {
+		from, to PipelineStatus
+		want     bool
+	}{
+		{Queued, Running, true},
+		{Queued, Error, true},
+		{Running, Success, true},
+		{Running, Cancelled, true},
+		{Running, Queued, false},
+		{Running, Running, false},
+		{Success, Running, false},
+		{Cancelled, Success, false},
+		{Error, Success, false},
+	}
+	for _, tt := range tests {
+		if got := tt.from.CanTransition(tt.to); got != tt.want {
+			t.Errorf("%s.CanTransition(%s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+
+	if from := TransitionsTo(Running); !slices.Equal(from, []PipelineStatus{Queued}) {
+		t.Errorf("TransitionsTo(Running) = %v, want [queued]", from)
+	}
+	for _, s := range PipelineStatuses {
+		terminal := s != Queued && s != Running
+		if s.Terminal() != terminal {
+			t.Errorf("%s.Terminal() = %v, want %v", s, s.Terminal(), terminal)
+		}
+	}
+}
+
+func TestPipelineRerun(t *testing.T) {
+	now := time.Now()
+	workflow := "image: golang\n"
+	first := Pipeline{
+		ID:         3,
+		Number:     5,
+		URL:        "https://ci.example.com/repos/1/builds/5",
+		Status:     Failure,
+		CommitSHA:  "abc",
+		RepoID:     1,
+		StartedAt:  &now,
+		FinishedAt: &now,
+		Event:      EventPush,
+		Attempt:    1,
+		Workflow:   &workflow,
+	}
+
+	second := first.Rerun()
+	if second.ID != 0 || second.Number != 0 || second.URL != "" || second.Attempt != 0 {
+		t.Errorf("rerun keeps identity of the original: %+v", second)
+	}
+	if second.Status != Queued || second.StartedAt != nil || second.FinishedAt != nil {
+		t.Errorf("rerun keeps state of the original: %+v", second)
+	}
+	if second.CommitSHA != first.CommitSHA || second.Event != first.Event || second.Workflow != first.Workflow {
+		t.Errorf("rerun does not keep commit, event and workflow of the original: %+v", second)
+	}
+	if second.FirstAttemptID() != first.ID {
+		t.Errorf("rerun FirstAttemptID() = %d, want %d", second.FirstAttemptID(), first.ID)
+	}
+
+	second.ID = 9
+	if third := second.Rerun(); third.FirstAttemptID() != first.ID {
+		t.Errorf("rerun of rerun FirstAttemptID() = %d, want %d", third.FirstAttemptID(), first.ID)
+	}
+}
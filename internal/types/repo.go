@@ -1,6 +1,10 @@
 package types
 
-import "golang.org/x/oauth2"
+import (
+	"time"
+
+	"golang.org/x/oauth2"
+)
 
 type Repo struct {
 	ID            int64
@@ -8,16 +12,84 @@ type Repo struct {
 	Owner         string
 	Name          string
 	RepoServiceID int64
-	WebhookID     int64
-	ServiceUserID int64
+	// WebhookID is nil for repositories accessed through app installation.
+	WebhookID      *int64
+	InstallationID *int64
+	ServiceUserID  int64
+	// WebhookSecret is only set when the repository is registered.
+	WebhookSecret *string
+	// ArchivedAt is set when the repository was unregistered, but its
+	// pipelines were kept.
+	ArchivedAt *time.Time
+	// CloneURL, ForgeArchived and Private are only set by
+	// ServiceManager.GetRepo.
+	CloneURL string
+	// ForgeArchived is set when the repository is archived on the service.
+	ForgeArchived bool
+	// Private is set when the repository cannot be cloned anonymously.
+	Private bool
 }
 
 type RepoWebhookChangeInfo struct {
-	RepoID    int64
-	Service   string
-	RepoOwner string
-	RepoName  string
+	RepoID        int64
+	Service       Service
+	RepoOwner     string
+	RepoName      string
+	RepoServiceID int64
+	WebhookID     *int64
+	// ForgeArchived is set when the repository was archived on the service.
+	ForgeArchived bool
+	ServiceUserID int64
+	Token         oauth2.Token
+	UserID        int64
+}
+
+// Webhook is configuration of repository webhook on the service.
+type Webhook struct {
+	ID  int64
+	URL string
+	// ContentType is empty for services which always send JSON.
+	ContentType string
+	Events      []string
+	Active      bool
+}
+
+// WebhookHealth is result of the last check of repository webhook.
+type WebhookHealth struct {
 	WebhookID *int64
-	Token     oauth2.Token
-	UserID    int64
+	CheckedAt *time.Time
+	// CheckError is nil when the webhook was healthy or it was repaired.
+	CheckError     *string
+	RepairedAt     *time.Time
+	LastDeliveryAt *time.Time
+}
+
+// WebhookSecrets are secrets payloads of repository webhook are signed with.
+type WebhookSecrets struct {
+	// Secret is empty for repositories registered before they had own
+	// secrets, their payloads are signed with the secret key.
+	Secret string
+	// Previous secret is accepted for a while after rotation.
+	Previous  string
+	RotatedAt *time.Time
+}
+
+type RepoStatusInfo struct {
+	Service        Service
+	RepoOwner      string
+	RepoName       string
+	RepoServiceID  int64
+	InstallationID *int64
+	ServiceUserID  int64
+	Token          oauth2.Token
+	Archived       bool
+}
+
+// DeployToken is token of the service which can only read the repository.
+type DeployToken struct {
+	// ID is ID of the token on the service, it is used to revoke it.
+	ID        int64
+	Username  string
+	Token     string
+	ExpiresAt time.Time
 }
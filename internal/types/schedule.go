@@ -0,0 +1,54 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Schedule periodically runs pipeline of the branch.
+type Schedule struct {
+	ID     int64
+	RepoID int64
+	Branch string
+	Cron   string
+	// Timezone is name of location the cron expression is evaluated in, e.g.
+	// Europe/Prague.
+	Timezone string
+	// FromWorkflow is set for schedules declared in the workflow of the
+	// branch. They are replaced when the workflow changes.
+	FromWorkflow bool
+	NextRunAt    time.Time
+	LastRunAt    *time.Time
+	// LastPipelineNumber is nil when the last run did not create pipeline.
+	LastPipelineNumber *int64
+	LastError          *string
+	CreatedAt          time.Time
+}
+
+// Next returns the first run of the schedule after t.
+func (s Schedule) Next(t time.Time) (time.Time, error) {
+	c, err := ParseCron(s.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unknown timezone %q", s.Timezone)
+	}
+
+	next := c.Next(t.In(loc))
+	if next.IsZero() {
+		return time.Time{}, errors.New("schedule never runs")
+	}
+	return next.UTC(), nil
+}
+
+// Local returns t in timezone of the schedule.
+func (s Schedule) Local(t time.Time) time.Time {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return t
+	}
+	return t.In(loc)
+}
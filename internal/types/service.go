@@ -5,4 +5,7 @@ type Service string
 const (
 	ServiceGitHub Service = "GitHub"
 	ServiceGitLab Service = "GitLab"
+	// ServiceGitea is used for Gitea and Gitea compatible forges like
+	// Forgejo.
+	ServiceGitea Service = "Gitea"
 )
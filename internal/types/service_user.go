@@ -16,6 +16,8 @@ type ServiceUser struct {
 	TokenType    string
 	TokenExpire  *time.Time
 	UserID       int64
+	// ReloginRequired is set when the token cannot be refreshed anymore.
+	ReloginRequired bool
 }
 
 func (su ServiceUser) Token() *oauth2.Token {
@@ -0,0 +1,40 @@
+package types
+
+import (
+	"net/http"
+	"time"
+)
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryProcessed DeliveryStatus = "processed"
+	DeliveryIgnored   DeliveryStatus = "ignored"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+type WebhookDelivery struct {
+	ID          int64
+	Service     Service
+	DeliveryID  string
+	Event       string
+	Headers     http.Header
+	Payload     []byte
+	Status      DeliveryStatus
+	Error       *string
+	ReceivedAt  time.Time
+	ProcessedAt *time.Time
+	RepoID      *int64
+	PipelineID  *int64
+	// PipelineNumber is only set for deliveries listed for a repository.
+	PipelineNumber *int64
+	Attempts       int
+}
+
+// Done reports if delivery was already handled and should not be processed
+// again when forge retries it. Pending deliveries are not done, they are
+// processed again when their lease expires.
+func (d WebhookDelivery) Done() bool {
+	return d.Status == DeliveryProcessed || d.Status == DeliveryIgnored
+}
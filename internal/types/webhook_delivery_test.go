@@ -0,0 +1,21 @@
+package types
+
+import "testing"
+
+func TestWebhookDeliveryDone(t *testing.T) {
+	tests := []struct {
+		status DeliveryStatus
+		want   bool
+	}{
+		{DeliveryPending, false},
+		{DeliveryProcessed, true},
+		{DeliveryIgnored, true},
+		{DeliveryFailed, false},
+	}
+	for _, tt := range tests {
+		d := WebhookDelivery{Status: tt.status}
+		if got := d.Done(); got != tt.want {
+			t.Errorf("Done() with status %s = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
@@ -1,10 +1,9 @@
 package types
 
-import (
-	"golang.org/x/oauth2"
-)
-
 type Work struct {
-	Pipeline Pipeline     `json:"pipeline"`
-	Token    oauth2.Token `json:"token"`
+	Pipeline Pipeline `json:"pipeline"`
+	// JobToken authenticates worker requests of the pipeline, e.g. for
+	// credentials to clone the repository. It is revoked when the pipeline
+	// finishes.
+	JobToken string `json:"job_token"`
 }
@@ -0,0 +1,259 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// WorkflowPath is path of the workflow file in the repository.
+const WorkflowPath = ".shark-ci/workflow.yaml"
+
+// WorkflowInputType is type of value of workflow input.
+type WorkflowInputType string
+
+const (
+	InputString  WorkflowInputType = "string"
+	InputBoolean WorkflowInputType = "boolean"
+	// InputChoice value is one of the input options.
+	InputChoice WorkflowInputType = "choice"
+)
+
+// WorkflowInput is parameter of manually triggered pipeline declared in
+// inputs of the workflow.
+type WorkflowInput struct {
+	Name        string
+	Type        WorkflowInputType
+	Description string
+	Required    bool
+	// Default is used when no value is given, it is empty for inputs
+	// without default.
+	Default string
+	Options []string
+}
+
+// EnvName returns name of environment variable with value of the input.
+func (i WorkflowInput) EnvName() string {
+	return "INPUT_" + strings.ToUpper(strings.ReplaceAll(i.Name, "-", "_"))
+}
+
+// validate checks the value is allowed for the input.
+func (i WorkflowInput) validate(value string) error {
+	switch i.Type {
+	case InputBoolean:
+		if value != "true" && value != "false" {
+			return fmt.Errorf("input %s must be true or false", i.Name)
+		}
+	case InputChoice:
+		if !slices.Contains(i.Options, value) {
+			return fmt.Errorf("input %s must be one of %s", i.Name, strings.Join(i.Options, ", "))
+		}
+	}
+	return nil
+}
+
+var inputNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// WorkflowInputs are inputs in the order they are declared in the workflow.
+type WorkflowInputs []WorkflowInput
+
+func (inputs *WorkflowInputs) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return errors.New("inputs must be a mapping")
+	}
+
+	*inputs = nil
+	for i := 0; i < len(node.Content); i += 2 {
+		var input struct {
+			Type        WorkflowInputType `yaml:"type"`
+			Description string            `yaml:"description"`
+			Required    bool              `yaml:"required"`
+			Default     *string           `yaml:"default"`
+			Options     []string          `yaml:"options"`
+		}
+		err := node.Content[i+1].Decode(&input)
+		if err != nil {
+			return err
+		}
+
+		in := WorkflowInput{
+			Name:        node.Content[i].Value,
+			Type:        input.Type,
+			Description: input.Description,
+			Required:    input.Required,
+			Options:     input.Options,
+		}
+		if in.Type == "" {
+			in.Type = InputString
+		}
+		// Booleans are decoded as written, e.g. true.
+		if input.Default != nil {
+			in.Default = *input.Default
+		}
+
+		err = in.check()
+		if err != nil {
+			return err
+		}
+		*inputs = append(*inputs, in)
+	}
+	return nil
+}
+
+// check reports invalid declaration of the input.
+func (i WorkflowInput) check() error {
+	if !inputNameRegexp.MatchString(i.Name) {
+		return fmt.Errorf("invalid input name %q", i.Name)
+	}
+	switch i.Type {
+	case InputString, InputBoolean:
+	case InputChoice:
+		if len(i.Options) == 0 {
+			return fmt.Errorf("choice input %s has no options", i.Name)
+		}
+	default:
+		return fmt.Errorf("input %s has unknown type %q", i.Name, i.Type)
+	}
+	if i.Default != "" {
+		if err := i.validate(i.Default); err != nil {
+			return fmt.Errorf("invalid default: %w", err)
+		}
+	}
+	return nil
+}
+
+// ErrInvalidWorkflow is returned when workflow file cannot be parsed.
+var ErrInvalidWorkflow = errors.New("invalid workflow")
+
+// ParseWorkflowInputs returns inputs declared in the workflow file.
+func ParseWorkflowInputs(workflow []byte) (WorkflowInputs, error) {
+	var w struct {
+		Inputs WorkflowInputs `yaml:"inputs"`
+	}
+	err := yaml.Unmarshal(workflow, &w)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidWorkflow, err)
+	}
+	return w.Inputs, nil
+}
+
+// DefaultJobName is name of the job of workflow without name.
+const DefaultJobName = "build"
+
+// ParseWorkflowJobs returns names of jobs of the workflow file. Workflow runs
+// a single job named by its name.
+func ParseWorkflowJobs(workflow []byte) ([]string, error) {
+	var w struct {
+		Name string `yaml:"name"`
+	}
+	err := yaml.Unmarshal(workflow, &w)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidWorkflow, err)
+	}
+	if w.Name == "" {
+		return []string{DefaultJobName}, nil
+	}
+	return []string{w.Name}, nil
+}
+
+// WorkflowSchedule is schedule declared in on.schedule of the workflow.
+type WorkflowSchedule struct {
+	Cron string `yaml:"cron"`
+	// Timezone is UTC when it is not set.
+	Timezone string `yaml:"timezone"`
+}
+
+// ParseWorkflowSchedules returns schedules declared in the workflow file.
+func ParseWorkflowSchedules(workflow []byte) ([]WorkflowSchedule, error) {
+	var w struct {
+		On struct {
+			Schedule []WorkflowSchedule `yaml:"schedule"`
+		} `yaml:"on"`
+	}
+	err := yaml.Unmarshal(workflow, &w)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidWorkflow, err)
+	}
+
+	for i := range w.On.Schedule {
+		if w.On.Schedule[i].Timezone == "" {
+			w.On.Schedule[i].Timezone = "UTC"
+		}
+		schedule := Schedule{Cron: w.On.Schedule[i].Cron, Timezone: w.On.Schedule[i].Timezone}
+		if _, err := schedule.Next(time.Now()); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidWorkflow, err)
+		}
+	}
+	return w.On.Schedule, nil
+}
+
+// InputsError is returned when values of inputs are not valid.
+type InputsError struct {
+	Errs []error
+}
+
+func (e *InputsError) Error() string {
+	return errors.Join(e.Errs...).Error()
+}
+
+// Values returns values of all inputs with defaults for inputs without
+// value. All errors of the values are returned as InputsError.
+func (inputs WorkflowInputs) Values(values map[string]string) (map[string]string, error) {
+	var errs []error
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !slices.ContainsFunc(inputs, func(i WorkflowInput) bool { return i.Name == name }) {
+			errs = append(errs, fmt.Errorf("unknown input %s", name))
+		}
+	}
+
+	result := make(map[string]string, len(inputs))
+	for _, input := range inputs {
+		value, ok := values[input.Name]
+		if !ok || value == "" {
+			value = input.Default
+		}
+		if value == "" {
+			if input.Required {
+				errs = append(errs, fmt.Errorf("input %s is required", input.Name))
+			}
+			continue
+		}
+		if err := input.validate(value); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		result[input.Name] = value
+	}
+
+	if len(errs) > 0 {
+		return nil, &InputsError{Errs: errs}
+	}
+	return result, nil
+}
+
+// Env returns environment variables with values of the inputs. Inputs without
+// value get their default, inputs without both are left out.
+func (inputs WorkflowInputs) Env(values map[string]string) []string {
+	var env []string
+	for _, input := range inputs {
+		value, ok := values[input.Name]
+		if !ok {
+			value = input.Default
+		}
+		if value != "" {
+			env = append(env, input.EnvName()+"="+value)
+		}
+	}
+	return env
+}
@@ -0,0 +1,146 @@
+package types
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+const inputsWorkflow = `
+image: golang
+inputs:
+  environment:
+    type: choice
+    options: [staging, production]
+    default: staging
+  dry-run:
+    type: boolean
+    default: true
+  version:
+    required: true
+`
+
+func TestParseWorkflowInputs(t *testing.T) {
+	inputs, err := ParseWorkflowInputs([]byte(inputsWorkflow))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := WorkflowInputs{
+		{Name: "environment", Type: InputChoice, Default: "staging", Options: []string{"staging", "production"}},
+		{Name: "dry-run", Type: InputBoolean, Default: "true"},
+		{Name: "version", Type: InputString, Required: true},
+	}
+	if len(inputs) != len(want) {
+		t.Fatalf("got %d inputs, want %d", len(inputs), len(want))
+	}
+	for i := range want {
+		got := inputs[i]
+		if got.Name != want[i].Name || got.Type != want[i].Type || got.Default != want[i].Default || got.Required != want[i].Required || !slices.Equal(got.Options, want[i].Options) {
+			t.Errorf("input %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+	if name := inputs[1].EnvName(); name != "INPUT_DRY_RUN" {
+		t.Errorf("EnvName() = %s, want INPUT_DRY_RUN", name)
+	}
+}
+
+func TestParseWorkflowInputsInvalid(t *testing.T) {
+	workflows := []string{
+		"inputs: [a]",
+		"inputs:\n  a:\n    type: number",
+		"inputs:\n  a:\n    type: choice",
+		"inputs:\n  a:\n    type: choice\n    options: [x]\n    default: y",
+		"inputs:\n  a:\n    type: boolean\n    default: yes please",
+		"inputs:\n  a b: {}",
+	}
+	for _, w := range workflows {
+		if _, err := ParseWorkflowInputs([]byte(w)); err == nil {
+			t.Errorf("ParseWorkflowInputs(%q) succeeded", w)
+		}
+	}
+}
+
+func TestWorkflowInputsValues(t *testing.T) {
+	inputs, err := ParseWorkflowInputs([]byte(inputsWorkflow))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := inputs.Values(map[string]string{"version": "1.2.0", "dry-run": "false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["environment"] != "staging" || values["dry-run"] != "false" || values["version"] != "1.2.0" {
+		t.Errorf("Values() = %v", values)
+	}
+
+	_, err = inputs.Values(map[string]string{"environment": "prod", "dry-run": "maybe", "other": "x"})
+	var inputsErr *InputsError
+	if !errors.As(err, &inputsErr) {
+		t.Fatalf("Values() error = %v, want InputsError", err)
+	}
+	// Unknown input, invalid choice and boolean and missing required input.
+	if len(inputsErr.Errs) != 4 {
+		t.Errorf("Values() returned %d errors, want 4: %v", len(inputsErr.Errs), err)
+	}
+}
+
+func TestWorkflowInputsEnv(t *testing.T) {
+	inputs, err := ParseWorkflowInputs([]byte(inputsWorkflow))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := inputs.Env(map[string]string{"environment": "production"})
+	want := []string{"INPUT_ENVIRONMENT=production", "INPUT_DRY_RUN=true"}
+	if !slices.Equal(env, want) {
+		t.Errorf("Env() = %v, want %v", env, want)
+	}
+}
+
+func TestParseWorkflowJobs(t *testing.T) {
+	tests := map[string][]string{
+		"name: test\nimage: golang\n": {"test"},
+		"image: golang\n":             {DefaultJobName},
+	}
+	for workflow, want := range tests {
+		jobs, err := ParseWorkflowJobs([]byte(workflow))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(jobs, want) {
+			t.Errorf("ParseWorkflowJobs(%q) = %v, want %v", workflow, jobs, want)
+		}
+	}
+
+	_, err := ParseWorkflowJobs([]byte("name: [test"))
+	if !errors.Is(err, ErrInvalidWorkflow) {
+		t.Errorf("got error %v, want ErrInvalidWorkflow", err)
+	}
+}
+
+func TestParseWorkflowSchedules(t *testing.T) {
+	workflow := `
+image: golang
+on:
+  schedule:
+    - cron: "0 3 * * *"
+    - cron: "@weekly"
+      timezone: Europe/Prague
+`
+	schedules, err := ParseWorkflowSchedules([]byte(workflow))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []WorkflowSchedule{{Cron: "0 3 * * *", Timezone: "UTC"}, {Cron: "@weekly", Timezone: "Europe/Prague"}}
+	if !slices.Equal(schedules, want) {
+		t.Errorf("ParseWorkflowSchedules() = %+v, want %+v", schedules, want)
+	}
+
+	for _, w := range []string{"on:\n  schedule:\n    - cron: 0 3 * *", "on:\n  schedule:\n    - cron: '@daily'\n      timezone: Mars/Olympus"} {
+		if _, err := ParseWorkflowSchedules([]byte(w)); !errors.Is(err, ErrInvalidWorkflow) {
+			t.Errorf("ParseWorkflowSchedules(%q) = %v, want ErrInvalidWorkflow", w, err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package worker
+
+import (
+	"strconv"
+
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+// containerEnv returns environment of commands of the pipeline. Fork pipelines
+// run code from other repository, so they get only variables describing the
+// pipeline and never values given by maintainers.
+func containerEnv(p types.Pipeline, inputs types.WorkflowInputs) []string {
+	env := pipelineEnv(p)
+	if p.Fork {
+		return env
+	}
+	return append(env, inputs.Env(p.Inputs)...)
+}
+
+// pipelineEnv returns environment variables describing the pipeline to its
+// commands. Variables without value are left out.
+func pipelineEnv(p types.Pipeline) []string {
+	env := []string{
+		"CI=true",
+		"SHARK_CI=true",
+		"CI_PIPELINE_ID=" + strconv.FormatInt(p.ID, 10),
+		"CI_PIPELINE_NUMBER=" + strconv.FormatInt(p.Number, 10),
+		"CI_PIPELINE_ATTEMPT=" + strconv.FormatInt(int64(max(p.Attempt, 1)), 10),
+		"CI_COMMIT_SHA=" + p.CommitSHA,
+	}
+	if p.Event != "" {
+		env = append(env, "CI_EVENT="+string(p.Event))
+	}
+	if p.PRNumber != nil {
+		env = append(env, "CI_PR_NUMBER="+strconv.FormatInt(int64(*p.PRNumber), 10))
+	}
+
+	optional := []struct {
+		name  string
+		value *string
+	}{
+		{"CI_REF", p.Ref},
+		{"CI_BRANCH", p.Branch},
+		{"CI_TAG", p.Tag},
+		{"CI_SOURCE_BRANCH", p.SourceBranch},
+		{"CI_TARGET_BRANCH", p.TargetBranch},
+		{"CI_COMMIT_MESSAGE", p.CommitMessage},
+		{"CI_COMMIT_AUTHOR_NAME", p.AuthorName},
+		{"CI_COMMIT_AUTHOR_EMAIL", p.AuthorEmail},
+		{"CI_COMMIT_COMMITTER_NAME", p.CommitterName},
+		{"CI_COMMIT_COMMITTER_EMAIL", p.CommitterEmail},
+		{"CI_PUSHER", p.Pusher},
+		{"CI_COMPARE_URL", p.CompareURL},
+	}
+	for _, v := range optional {
+		if v.value != nil {
+			env = append(env, v.name+"="+*v.value)
+		}
+	}
+	return env
+}
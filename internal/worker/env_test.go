@@ -0,0 +1,50 @@
+package worker
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/shark-ci/shark-ci/internal/types"
+)
+
+func TestPipelineEnv(t *testing.T) {
+	branch := "main"
+	message := "Fix build\n\nDetails."
+	env := pipelineEnv(types.Pipeline{
+		ID:            42,
+		Number:        3,
+		CommitSHA:     "abc",
+		Event:         types.EventPush,
+		Branch:        &branch,
+		CommitMessage: &message,
+	})
+
+	want := []string{
+		"CI=true",
+		"SHARK_CI=true",
+		"CI_PIPELINE_ID=42",
+		"CI_PIPELINE_NUMBER=3",
+		"CI_PIPELINE_ATTEMPT=1",
+		"CI_COMMIT_SHA=abc",
+		"CI_EVENT=push",
+		"CI_BRANCH=main",
+		"CI_COMMIT_MESSAGE=Fix build\n\nDetails.",
+	}
+	if !slices.Equal(env, want) {
+		t.Errorf("pipelineEnv() = %q, want %q", env, want)
+	}
+}
+
+func TestContainerEnv(t *testing.T) {
+	inputs := types.WorkflowInputs{{Name: "target", Type: types.InputString}}
+	p := types.Pipeline{ID: 1, Number: 1, CommitSHA: "abc", Inputs: map[string]string{"target": "prod"}}
+
+	if env := containerEnv(p, inputs); !slices.Contains(env, "INPUT_TARGET=prod") {
+		t.Errorf("containerEnv() = %q, want input", env)
+	}
+
+	p.Fork = true
+	if env := containerEnv(p, inputs); !slices.Equal(env, pipelineEnv(p)) {
+		t.Errorf("containerEnv() of fork pipeline = %q, want only pipeline variables", env)
+	}
+}
@@ -1,7 +1,10 @@
 package worker
 
+import "github.com/shark-ci/shark-ci/internal/types"
+
 type Pipeline struct {
-	Name  string   `yaml:"name"`
-	Image string   `yaml:"image"`
-	Cmds  []string `yaml:"cmds"`
+	Name   string               `yaml:"name"`
+	Image  string               `yaml:"image"`
+	Cmds   []string             `yaml:"cmds"`
+	Inputs types.WorkflowInputs `yaml:"inputs"`
 }
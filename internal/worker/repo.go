@@ -9,11 +9,15 @@ import (
 	"github.com/go-git/go-git/v5"
 	git_config "github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	git_http "github.com/go-git/go-git/v5/plumbing/transport/http"
-	"golang.org/x/oauth2"
+
+	pb "github.com/shark-ci/shark-ci/internal/proto"
 )
 
-func cloneRepo(ctx context.Context, cloneURL string, sha string, token oauth2.Token) (dir string, err error) {
+// cloneRepo fetches commit sha, or fetchRef if it is not empty, of the
+// repository into a new temporary directory.
+func cloneRepo(ctx context.Context, cloneURL string, sha string, fetchRef string, credential *pb.CloneCredential) (dir string, err error) {
 	dir, err = os.MkdirTemp("/tmp", "shark-ci-*")
 	if err != nil {
 		return "", err
@@ -32,16 +36,26 @@ func cloneRepo(ctx context.Context, cloneURL string, sha string, token oauth2.To
 		return "", err
 	}
 
+	source := sha
+	if fetchRef != "" {
+		source = fetchRef
+	}
+	// Repository is cloned anonymously when the server gives no credential,
+	// e.g. for fork pipelines.
+	var auth transport.AuthMethod
+	if credential.GetPassword() != "" {
+		auth = &git_http.BasicAuth{
+			Username: credential.GetUsername(),
+			Password: credential.GetPassword(),
+		}
+	}
 	err = repo.FetchContext(ctx, &git.FetchOptions{
 		RemoteName: "origin",
 		Depth:      1,
 		RefSpecs: []git_config.RefSpec{
-			git_config.RefSpec(fmt.Sprintf("%s:refs/heads/test", sha)),
-		},
-		Auth: &git_http.BasicAuth{
-			Username: "abc",
-			Password: token.AccessToken,
+			git_config.RefSpec(fmt.Sprintf("%s:refs/heads/test", source)),
 		},
+		Auth:     auth,
 		Progress: log.Writer(),
 	})
 	if err != nil {
@@ -53,7 +67,7 @@ func cloneRepo(ctx context.Context, cloneURL string, sha string, token oauth2.To
 		return "", err
 	}
 	err = tree.Checkout(&git.CheckoutOptions{
-		Hash: plumbing.NewHash(sha),
+		Branch: plumbing.NewBranchReferenceName("test"),
 	})
 	if err != nil {
 		return "", err
@@ -3,7 +3,10 @@ package worker
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path"
@@ -15,9 +18,12 @@ import (
 	imagetypes "github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"gopkg.in/yaml.v3"
 
+	"github.com/shark-ci/shark-ci/internal/config"
 	"github.com/shark-ci/shark-ci/internal/messagequeue"
 	pb "github.com/shark-ci/shark-ci/internal/proto"
 	"github.com/shark-ci/shark-ci/internal/types"
@@ -45,56 +51,174 @@ func runWorker(work types.Work, gRPCCLient pb.PipelineReporterClient) {
 	_, err := gRPCCLient.PipelineStarted(context.TODO(), &pb.PipelineStartedRequest{
 		PipelineId: work.Pipeline.ID,
 		StartedAt:  timestamppb.New(*work.Pipeline.StartedAt),
+		JobToken:   work.JobToken,
 	})
 	if err != nil {
 		logger.Warn("Sending pipeline start message failed.", "err", err)
 	}
 
-	err = processWork(context.TODO(), gRPCCLient, work)
+	ctx := context.Background()
+	if timeout := config.WorkerConf.PipelineTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Minute)
+		defer cancel()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go watchCancellation(ctx, gRPCCLient, work, heartbeatInterval, cancel)
+
+	err = processWork(ctx, gRPCCLient, work)
+	if err != nil && ctx.Err() != nil {
+		// Whatever failed, it was because the pipeline ran out of time.
+		err = fmt.Errorf("%w: %w", ctx.Err(), err)
+	}
 	tEnd := time.Now()
 	work.Pipeline.FinishedAt = &tEnd
+
+	req := &pb.PipelineFinnishedRequest{
+		PipelineId: work.Pipeline.ID,
+		FinishedAt: timestamppb.New(*work.Pipeline.FinishedAt),
+		Status:     finishedStatus(err),
+		JobToken:   work.JobToken,
+	}
 	if err != nil {
 		e := err.Error()
-		_, err = gRPCCLient.PipelineFinnished(context.TODO(), &pb.PipelineFinnishedRequest{
+		req.Error = &e
+		logger.Info("Processing pipeline failed.", "status", req.Status, "time", tEnd.Sub(tStart), "err", e)
+	} else {
+		logger.Info("Finished processing pipeline successfully.", "time", tEnd.Sub(tStart))
+	}
+	_, err = gRPCCLient.PipelineFinnished(context.TODO(), req)
+	if err != nil {
+		logger.Warn("Sending pipeline end message failed.", "err", err)
+	}
+}
+
+// heartbeatInterval is how often the worker checks whether the pipeline was
+// cancelled.
+const heartbeatInterval = 15 * time.Second
+
+// watchCancellation sends heartbeats of the pipeline until ctx is done and
+// calls cancel when the server rejects the job token as revoked, i.e. the
+// pipeline was cancelled. Other errors are ignored, so the pipeline does not
+// stop when the server is briefly unavailable.
+func watchCancellation(ctx context.Context, gRPCCLient pb.PipelineReporterClient, work types.Work, interval time.Duration, cancel context.CancelFunc) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		_, err := gRPCCLient.PipelineHeartbeat(ctx, &pb.PipelineHeartbeatRequest{
 			PipelineId: work.Pipeline.ID,
-			FinishedAt: timestamppb.New(*work.Pipeline.FinishedAt),
-			Status:     pb.PipelineFinnishedStatus_FAILURE,
-			Error:      &e,
+			JobToken:   work.JobToken,
+		})
+		if status.Code(err) == codes.PermissionDenied {
+			slog.Info("Pipeline was cancelled.", "PipelineID", work.Pipeline.ID)
+			cancel()
+			return
+		}
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("Sending pipeline heartbeat failed.", "PipelineID", work.Pipeline.ID, "err", err)
+		}
+	}
+}
+
+// finishedStatus classifies result of processing pipeline, so failing steps
+// are told apart from problems with running the pipeline.
+func finishedStatus(err error) pb.PipelineFinnishedStatus {
+	var stepErr *stepFailedError
+	switch {
+	case err == nil:
+		return pb.PipelineFinnishedStatus_SUCCESS
+	case errors.As(err, &stepErr):
+		return pb.PipelineFinnishedStatus_FAILURE
+	case errors.Is(err, errNoWorkflow):
+		return pb.PipelineFinnishedStatus_SKIPPED
+	case errors.Is(err, context.DeadlineExceeded):
+		return pb.PipelineFinnishedStatus_TIMED_OUT
+	case errors.Is(err, context.Canceled):
+		return pb.PipelineFinnishedStatus_CANCELLED
+	default:
+		return pb.PipelineFinnishedStatus_ERROR
+	}
+}
+
+// errNoWorkflow is returned for commits without workflow file.
+var errNoWorkflow = errors.New("workflow file " + types.WorkflowPath + " does not exist")
+
+// stepFailedError is returned when command of the pipeline exits with non-zero
+// code.
+type stepFailedError struct {
+	Cmd      string
+	ExitCode int
+}
+
+func (e *stepFailedError) Error() string {
+	return fmt.Sprintf("command %q exited with code %d", e.Cmd, e.ExitCode)
+}
+
+// loadWorkflow parses workflow of the pipeline. Re-runs use the workflow of
+// the first attempt, otherwise the workflow file is read from the repository
+// and sent to the server.
+func loadWorkflow(ctx context.Context, gRPCCLient pb.PipelineReporterClient, p types.Pipeline, jobToken string, dir string) (Pipeline, error) {
+	var workflow []byte
+	if p.Workflow != nil {
+		workflow = []byte(*p.Workflow)
+	} else {
+		var err error
+		workflow, err = os.ReadFile(path.Join(dir, types.WorkflowPath))
+		if errors.Is(err, fs.ErrNotExist) {
+			return Pipeline{}, errNoWorkflow
+		}
+		if err != nil {
+			return Pipeline{}, err
+		}
+
+		// Pipeline can run without the saved workflow, only its re-runs
+		// would read the file again.
+		_, err = gRPCCLient.WorkflowLoaded(ctx, &pb.WorkflowLoadedRequest{
+			PipelineId: p.ID,
+			Workflow:   string(workflow),
+			JobToken:   jobToken,
 		})
 		if err != nil {
-			slog.Warn("Sending pipeline end message failed.", "time", tEnd.Sub(tStart), "err", err)
+			slog.Warn("Sending pipeline workflow failed.", "PipelineID", p.ID, "err", err)
 		}
-		logger.Info("Processing pipeline failed.", "err", e)
-		return
 	}
 
-	logger.Info("Finished processing pipeline successfully.", "time", tEnd.Sub(tStart))
-	_, err = gRPCCLient.PipelineFinnished(context.TODO(), &pb.PipelineFinnishedRequest{
-		PipelineId: work.Pipeline.ID,
-		FinishedAt: timestamppb.New(*work.Pipeline.FinishedAt),
-		Status:     pb.PipelineFinnishedStatus_SUCCESS,
-	})
+	var pipeline Pipeline
+	err := yaml.Unmarshal(workflow, &pipeline)
 	if err != nil {
-		logger.Warn("Sending pipeline end message failed.", "err", err)
+		return Pipeline{}, err
 	}
+	return pipeline, nil
 }
 
 func processWork(ctx context.Context, gRPCCLient pb.PipelineReporterClient, work types.Work) error {
-	dir, err := cloneRepo(ctx, work.Pipeline.CloneURL, work.Pipeline.CommitSHA, work.Token)
-	defer os.RemoveAll(dir)
+	credential, err := gRPCCLient.GetCloneCredential(ctx, &pb.CloneCredentialRequest{
+		PipelineId: work.Pipeline.ID,
+		JobToken:   work.JobToken,
+	})
 	if err != nil {
-		return err
+		return fmt.Errorf("cannot get clone credential: %w", err)
 	}
 
-	// Parse pipeline.
-	file, err := os.Open(path.Join(dir, ".shark-ci/workflow.yaml"))
+	var fetchRef string
+	if work.Pipeline.FetchRef != nil {
+		fetchRef = *work.Pipeline.FetchRef
+	}
+	dir, err := cloneRepo(ctx, work.Pipeline.CloneURL, work.Pipeline.CommitSHA, fetchRef, credential)
+	defer os.RemoveAll(dir)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	var pipeline Pipeline
-	err = yaml.NewDecoder(file).Decode(&pipeline)
+	pipeline, err := loadWorkflow(ctx, gRPCCLient, work.Pipeline, work.JobToken, dir)
 	if err != nil {
 		return err
 	}
@@ -120,6 +244,7 @@ func processWork(ctx context.Context, gRPCCLient pb.PipelineReporterClient, work
 			Image:      pipeline.Image,
 			Tty:        true,
 			WorkingDir: "/app",
+			Env:        containerEnv(work.Pipeline, pipeline.Inputs),
 		},
 		&containertypes.HostConfig{
 			Binds: []string{dir + ":/app"},
@@ -127,6 +252,13 @@ func processWork(ctx context.Context, gRPCCLient pb.PipelineReporterClient, work
 	if err != nil {
 		return err
 	}
+	// Container is removed even when the pipeline failed or timed out.
+	defer func() {
+		err := cli.ContainerRemove(context.Background(), container.ID, containertypes.RemoveOptions{Force: true})
+		if err != nil {
+			slog.Warn("Removing container failed.", "containerID", container.ID, "err", err)
+		}
+	}()
 
 	// Start container.
 	err = cli.ContainerStart(ctx, container.ID, containertypes.StartOptions{})
@@ -135,6 +267,7 @@ func processWork(ctx context.Context, gRPCCLient pb.PipelineReporterClient, work
 	}
 
 	for i, cmd := range pipeline.Cmds {
+		cmdStart := time.Now()
 		exec, err := cli.ContainerExecCreate(ctx, container.ID, dockertypes.ExecConfig{
 			AttachStdout: true,
 			AttachStderr: true,
@@ -149,13 +282,16 @@ func processWork(ctx context.Context, gRPCCLient pb.PipelineReporterClient, work
 			return err
 		}
 
+		// Closing the connection stops reading output of the command
+		// when the pipeline runs out of time.
+		stop := context.AfterFunc(ctx, hijacked.Close)
 		logsBuff := &bytes.Buffer{}
 		_, err = stdcopy.StdCopy(logsBuff, logsBuff, hijacked.Reader)
+		stop()
+		hijacked.Close()
 		if err != nil {
-			hijacked.Close()
 			return err
 		}
-		hijacked.Close()
 
 		execInspect, err := cli.ContainerExecInspect(ctx, exec.ID) // TODO: Will containen status code.
 		if err != nil {
@@ -168,22 +304,16 @@ func processWork(ctx context.Context, gRPCCLient pb.PipelineReporterClient, work
 			Cmd:        cmd,
 			Output:     logsBuff.String(),
 			ExitCode:   int32(execInspect.ExitCode),
+			StartedAt:  timestamppb.New(cmdStart),
+			FinishedAt: timestamppb.Now(),
+			JobToken:   work.JobToken,
 		})
 		if err != nil {
 			return err
 		}
-	}
-
-	// Stop container.
-	err = cli.ContainerKill(ctx, container.ID, "SIGKILL")
-	if err != nil {
-		return err
-	}
-
-	// Delete container.
-	err = cli.ContainerRemove(ctx, container.ID, containertypes.RemoveOptions{Force: true})
-	if err != nil {
-		return err
+		if execInspect.ExitCode != 0 {
+			return &stepFailedError{Cmd: cmd, ExitCode: execInspect.ExitCode}
+		}
 	}
 
 	return nil
@@ -3,15 +3,87 @@ package worker
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	dockertypes "github.com/docker/docker/api/types"
 	containertypes "github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/shark-ci/shark-ci/internal/proto"
+	"github.com/shark-ci/shark-ci/internal/types"
 )
 
+func TestFinishedStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want pb.PipelineFinnishedStatus
+	}{
+		{nil, pb.PipelineFinnishedStatus_SUCCESS},
+		{fmt.Errorf("step: %w", &stepFailedError{Cmd: "go test", ExitCode: 1}), pb.PipelineFinnishedStatus_FAILURE},
+		{errNoWorkflow, pb.PipelineFinnishedStatus_SKIPPED},
+		{fmt.Errorf("%w: %w", context.DeadlineExceeded, errors.New("use of closed connection")), pb.PipelineFinnishedStatus_TIMED_OUT},
+		{context.Canceled, pb.PipelineFinnishedStatus_CANCELLED},
+		{errors.New("cannot pull image"), pb.PipelineFinnishedStatus_ERROR},
+	}
+	for _, tt := range tests {
+		if got := finishedStatus(tt.err); got != tt.want {
+			t.Errorf("finishedStatus(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+// heartbeatClient rejects heartbeats after the given number of them, first
+// with the given number of other errors and then as revoked.
+type heartbeatClient struct {
+	pb.PipelineReporterClient
+	accepted int
+	failed   int
+	sent     int
+}
+
+func (c *heartbeatClient) PipelineHeartbeat(ctx context.Context, in *pb.PipelineHeartbeatRequest, opts ...grpc.CallOption) (*pb.Empty, error) {
+	c.sent++
+	if c.sent <= c.accepted {
+		return &pb.Empty{}, nil
+	}
+	if in.JobToken != "token" {
+		return nil, status.Error(codes.InvalidArgument, "unexpected token")
+	}
+	if c.sent <= c.accepted+c.failed {
+		return nil, status.Error(codes.Unauthenticated, "job token expired")
+	}
+	return nil, status.Error(codes.PermissionDenied, "job token was revoked")
+}
+
+func TestWatchCancellation(t *testing.T) {
+	client := &heartbeatClient{accepted: 2, failed: 2}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		watchCancellation(ctx, client, types.Work{Pipeline: types.Pipeline{ID: 1}, JobToken: "token"}, time.Millisecond, cancel)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("pipeline was not cancelled after job token was revoked")
+	}
+	// Pipeline is not cancelled by other rejections.
+	if ctx.Err() != context.Canceled || client.sent != 5 {
+		t.Errorf("ctx.Err() = %v after %d heartbeats, want cancelled after 5", ctx.Err(), client.sent)
+	}
+}
+
 //func TestProcessWork(t *testing.T) {
 //	objStore := objectstore.NewMocObjectStore()
 //	processWork(context.TODO(), objStore, types.Work{})
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS "webhook_delivery";
+
+DROP TYPE IF EXISTS "delivery_status";
@@ -0,0 +1,21 @@
+CREATE TYPE delivery_status AS ENUM ('pending', 'processed', 'ignored', 'failed');
+
+CREATE TABLE "webhook_delivery" (
+    "id" bigserial PRIMARY KEY,
+    "service" service NOT NULL,
+    "delivery_id" text NOT NULL,
+    "event" text NOT NULL,
+    "headers" jsonb NOT NULL,
+    "payload" bytea NOT NULL,
+    "status" delivery_status NOT NULL DEFAULT 'pending',
+    "error" text,
+    "received_at" timestamp NOT NULL DEFAULT now(),
+    "processed_at" timestamp,
+    "repo_id" bigint,
+    "pipeline_id" bigint,
+    UNIQUE ("service", "delivery_id"),
+    FOREIGN KEY ("repo_id") REFERENCES "repo" ("id") ON DELETE CASCADE,
+    FOREIGN KEY ("pipeline_id") REFERENCES "pipeline" ("id") ON DELETE SET NULL
+);
+
+CREATE INDEX ON "webhook_delivery" ("repo_id", "received_at" DESC);
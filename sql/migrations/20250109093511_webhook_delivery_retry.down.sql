@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS "webhook_delivery_pending_idx";
+
+ALTER TABLE "webhook_delivery"
+    DROP COLUMN IF EXISTS "attempts",
+    DROP COLUMN IF EXISTS "next_attempt_at";
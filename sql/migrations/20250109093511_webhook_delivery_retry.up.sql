@@ -0,0 +1,5 @@
+ALTER TABLE "webhook_delivery"
+    ADD COLUMN "attempts" int NOT NULL DEFAULT 0,
+    ADD COLUMN "next_attempt_at" timestamp NOT NULL DEFAULT now();
+
+CREATE INDEX "webhook_delivery_pending_idx" ON "webhook_delivery" ("next_attempt_at") WHERE "status" = 'pending';
@@ -0,0 +1,21 @@
+CREATE TABLE "commit_status" (
+    "id" bigserial PRIMARY KEY,
+    "repo_id" bigint NOT NULL,
+    "commit_sha" text NOT NULL,
+    "context" text NOT NULL,
+    "state" pipeline_status NOT NULL,
+    "target_url" text NOT NULL,
+    "description" text NOT NULL,
+    "pipeline_id" bigint,
+    "version" int NOT NULL DEFAULT 1,
+    "sent_version" int NOT NULL DEFAULT 0,
+    "attempts" int NOT NULL DEFAULT 0,
+    "next_attempt_at" timestamp NOT NULL DEFAULT now(),
+    "error" text,
+    "updated_at" timestamp NOT NULL DEFAULT now(),
+    UNIQUE ("repo_id", "commit_sha", "context"),
+    FOREIGN KEY ("repo_id") REFERENCES "repo" ("id") ON DELETE CASCADE,
+    FOREIGN KEY ("pipeline_id") REFERENCES "pipeline" ("id") ON DELETE SET NULL
+);
+
+CREATE INDEX "commit_status_unsent_idx" ON "commit_status" ("next_attempt_at") WHERE "version" > "sent_version";
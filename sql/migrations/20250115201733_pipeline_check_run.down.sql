@@ -0,0 +1 @@
+ALTER TABLE "pipeline" DROP COLUMN IF EXISTS "check_run_id";
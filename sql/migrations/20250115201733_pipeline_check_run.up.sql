@@ -0,0 +1 @@
+ALTER TABLE "pipeline" ADD COLUMN "check_run_id" bigint;
@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS "pipeline_awaiting_approval_idx";
+
+ALTER TABLE "pipeline"
+    DROP COLUMN IF EXISTS "approved_by",
+    DROP COLUMN IF EXISTS "awaiting_approval",
+    DROP COLUMN IF EXISTS "fork",
+    DROP COLUMN IF EXISTS "fetch_ref",
+    DROP COLUMN IF EXISTS "target_branch",
+    DROP COLUMN IF EXISTS "source_branch",
+    DROP COLUMN IF EXISTS "pr_number";
@@ -0,0 +1,11 @@
+ALTER TABLE "pipeline"
+    ADD COLUMN "pr_number" int,
+    ADD COLUMN "source_branch" text,
+    ADD COLUMN "target_branch" text,
+    ADD COLUMN "fetch_ref" text,
+    ADD COLUMN "fork" boolean NOT NULL DEFAULT false,
+    ADD COLUMN "awaiting_approval" boolean NOT NULL DEFAULT false,
+    ADD COLUMN "approved_by" bigint,
+    ADD FOREIGN KEY ("approved_by") REFERENCES "user" ("id") ON DELETE SET NULL;
+
+CREATE INDEX "pipeline_awaiting_approval_idx" ON "pipeline" ("repo_id") WHERE "awaiting_approval";
@@ -0,0 +1,10 @@
+DELETE FROM "webhook_delivery" WHERE "service" = 'Gitea';
+DELETE FROM "repo" WHERE "service" = 'Gitea';
+DELETE FROM "service_user" WHERE "service" = 'Gitea';
+
+ALTER TYPE service RENAME TO service_old;
+CREATE TYPE service AS ENUM ('GitHub', 'GitLab');
+ALTER TABLE "service_user" ALTER COLUMN "service" TYPE service USING "service"::text::service;
+ALTER TABLE "repo" ALTER COLUMN "service" TYPE service USING "service"::text::service;
+ALTER TABLE "webhook_delivery" ALTER COLUMN "service" TYPE service USING "service"::text::service;
+DROP TYPE service_old;
@@ -0,0 +1 @@
+ALTER TYPE service ADD VALUE IF NOT EXISTS 'Gitea';
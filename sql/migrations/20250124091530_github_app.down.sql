@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS "repo_installation_idx";
+
+ALTER TABLE "repo" DROP COLUMN IF EXISTS "installation_id";
+DELETE FROM "repo" WHERE "webhook_id" IS NULL;
+ALTER TABLE "repo" ALTER COLUMN "webhook_id" SET NOT NULL;
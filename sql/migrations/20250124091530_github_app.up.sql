@@ -0,0 +1,5 @@
+-- Repositories accessed through GitHub App installation have no webhook.
+ALTER TABLE "repo" ALTER COLUMN "webhook_id" DROP NOT NULL;
+ALTER TABLE "repo" ADD COLUMN "installation_id" bigint;
+
+CREATE INDEX "repo_installation_idx" ON "repo" ("service", "installation_id") WHERE "installation_id" IS NOT NULL;
@@ -0,0 +1,7 @@
+CREATE TABLE "job_token" (
+    "pipeline_id" bigint PRIMARY KEY,
+    "token_hash" bytea NOT NULL UNIQUE,
+    "expires_at" timestamp NOT NULL,
+    "revoked_at" timestamp,
+    FOREIGN KEY ("pipeline_id") REFERENCES "pipeline" ("id") ON DELETE CASCADE
+);
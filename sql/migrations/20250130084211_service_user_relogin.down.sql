@@ -0,0 +1 @@
+ALTER TABLE "service_user" DROP COLUMN IF EXISTS "relogin_required";
@@ -0,0 +1 @@
+ALTER TABLE "service_user" ADD COLUMN "relogin_required" boolean NOT NULL DEFAULT false;
@@ -0,0 +1,3 @@
+-- Tokens without key ID are plaintext. They are encrypted by "server
+-- encrypt-secrets" run after migrations, the server does not start until then.
+ALTER TABLE "service_user" ADD COLUMN "token_key_id" text;
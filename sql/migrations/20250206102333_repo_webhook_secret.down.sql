@@ -0,0 +1,4 @@
+ALTER TABLE "repo" DROP COLUMN IF EXISTS "webhook_secret_rotated_at";
+ALTER TABLE "repo" DROP COLUMN IF EXISTS "webhook_secret_key_id";
+ALTER TABLE "repo" DROP COLUMN IF EXISTS "previous_webhook_secret";
+ALTER TABLE "repo" DROP COLUMN IF EXISTS "webhook_secret";
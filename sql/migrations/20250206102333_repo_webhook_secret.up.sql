@@ -0,0 +1,5 @@
+-- Repositories without webhook secret were registered with the secret key.
+ALTER TABLE "repo" ADD COLUMN "webhook_secret" text;
+ALTER TABLE "repo" ADD COLUMN "previous_webhook_secret" text;
+ALTER TABLE "repo" ADD COLUMN "webhook_secret_key_id" text;
+ALTER TABLE "repo" ADD COLUMN "webhook_secret_rotated_at" timestamp;
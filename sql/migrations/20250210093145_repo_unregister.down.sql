@@ -0,0 +1,10 @@
+ALTER TABLE "repo" DROP COLUMN IF EXISTS "archived_at";
+
+UPDATE "pipeline" SET "status" = 'error' WHERE "status" = 'cancelled';
+UPDATE "commit_status" SET "state" = 'error' WHERE "state" = 'cancelled';
+
+ALTER TYPE pipeline_status RENAME TO pipeline_status_old;
+CREATE TYPE pipeline_status AS ENUM ('success', 'pending', 'running', 'error');
+ALTER TABLE "pipeline" ALTER COLUMN "status" TYPE pipeline_status USING "status"::text::pipeline_status;
+ALTER TABLE "commit_status" ALTER COLUMN "state" TYPE pipeline_status USING "state"::text::pipeline_status;
+DROP TYPE pipeline_status_old;
@@ -0,0 +1,4 @@
+ALTER TYPE pipeline_status ADD VALUE IF NOT EXISTS 'cancelled';
+
+-- Archived repositories keep their pipeline history, but receive no events.
+ALTER TABLE "repo" ADD COLUMN "archived_at" timestamp;
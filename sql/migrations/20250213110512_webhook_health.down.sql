@@ -0,0 +1,4 @@
+ALTER TABLE "repo"
+    DROP COLUMN "webhook_repaired_at",
+    DROP COLUMN "webhook_check_error",
+    DROP COLUMN "webhook_checked_at";
@@ -0,0 +1,4 @@
+ALTER TABLE "repo"
+    ADD COLUMN "webhook_checked_at" timestamp,
+    ADD COLUMN "webhook_check_error" text,
+    ADD COLUMN "webhook_repaired_at" timestamp;
@@ -0,0 +1,6 @@
+DROP INDEX "pipeline_repo_created_at_idx";
+DROP INDEX "pipeline_repo_branch_idx";
+DROP INDEX "pipeline_repo_status_idx";
+DROP INDEX "pipeline_repo_idx";
+
+ALTER TABLE "pipeline" DROP COLUMN "created_at";
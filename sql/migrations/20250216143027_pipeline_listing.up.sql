@@ -0,0 +1,10 @@
+ALTER TABLE "pipeline" ADD COLUMN "created_at" timestamp;
+UPDATE "pipeline" SET "created_at" = COALESCE("started_at", now());
+ALTER TABLE "pipeline"
+    ALTER COLUMN "created_at" SET NOT NULL,
+    ALTER COLUMN "created_at" SET DEFAULT now();
+
+CREATE INDEX "pipeline_repo_idx" ON "pipeline" ("repo_id", "id" DESC);
+CREATE INDEX "pipeline_repo_status_idx" ON "pipeline" ("repo_id", "status", "id" DESC);
+CREATE INDEX "pipeline_repo_branch_idx" ON "pipeline" ("repo_id", "source_branch", "id" DESC);
+CREATE INDEX "pipeline_repo_created_at_idx" ON "pipeline" ("repo_id", "created_at");
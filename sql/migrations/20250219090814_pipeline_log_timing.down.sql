@@ -0,0 +1,3 @@
+ALTER TABLE "pipeline_log"
+    DROP COLUMN "finished_at",
+    DROP COLUMN "started_at";
@@ -0,0 +1,3 @@
+ALTER TABLE "pipeline_log"
+    ADD COLUMN "started_at" timestamp,
+    ADD COLUMN "finished_at" timestamp;
@@ -0,0 +1,15 @@
+DROP INDEX "pipeline_repo_branch_idx";
+CREATE INDEX "pipeline_repo_branch_idx" ON "pipeline" ("repo_id", "source_branch", "id" DESC);
+
+ALTER TABLE "pipeline"
+    DROP COLUMN "compare_url",
+    DROP COLUMN "pusher",
+    DROP COLUMN "committer_email",
+    DROP COLUMN "committer_name",
+    DROP COLUMN "author_email",
+    DROP COLUMN "author_name",
+    DROP COLUMN "commit_message",
+    DROP COLUMN "tag",
+    DROP COLUMN "branch",
+    DROP COLUMN "ref",
+    DROP COLUMN "event";
@@ -0,0 +1,19 @@
+ALTER TABLE "pipeline"
+    ADD COLUMN "event" text NOT NULL DEFAULT 'push',
+    ADD COLUMN "ref" text,
+    ADD COLUMN "branch" text,
+    ADD COLUMN "tag" text,
+    ADD COLUMN "commit_message" text,
+    ADD COLUMN "author_name" text,
+    ADD COLUMN "author_email" text,
+    ADD COLUMN "committer_name" text,
+    ADD COLUMN "committer_email" text,
+    ADD COLUMN "pusher" text,
+    ADD COLUMN "compare_url" text;
+
+UPDATE "pipeline"
+SET "event" = 'pull_request', "branch" = "source_branch"
+WHERE "pr_number" IS NOT NULL;
+
+DROP INDEX "pipeline_repo_branch_idx";
+CREATE INDEX "pipeline_repo_branch_idx" ON "pipeline" ("repo_id", "branch", "id" DESC);
@@ -0,0 +1,8 @@
+DROP INDEX "pipeline_repo_number_idx";
+
+UPDATE "pipeline"
+SET "url" = regexp_replace("url", '/pipelines/[0-9]+$', '/pipelines/' || "id")
+WHERE "url" IS NOT NULL;
+
+ALTER TABLE "pipeline" DROP COLUMN "number";
+ALTER TABLE "repo" DROP COLUMN "last_pipeline_number";
@@ -0,0 +1,26 @@
+ALTER TABLE "repo" ADD COLUMN "last_pipeline_number" bigint NOT NULL DEFAULT 0;
+ALTER TABLE "pipeline" ADD COLUMN "number" bigint;
+
+UPDATE "pipeline" p
+SET "number" = n."number"
+FROM (
+    SELECT "id", row_number() OVER (PARTITION BY "repo_id" ORDER BY "id") AS "number"
+    FROM "pipeline"
+) n
+WHERE p."id" = n."id";
+
+UPDATE "repo" r
+SET "last_pipeline_number" = p."number"
+FROM (
+    SELECT "repo_id", max("number") AS "number"
+    FROM "pipeline"
+    GROUP BY "repo_id"
+) p
+WHERE r."id" = p."repo_id";
+
+UPDATE "pipeline"
+SET "url" = regexp_replace("url", '/pipelines/[0-9]+$', '/pipelines/' || "number")
+WHERE "url" IS NOT NULL;
+
+ALTER TABLE "pipeline" ALTER COLUMN "number" SET NOT NULL;
+CREATE UNIQUE INDEX "pipeline_repo_number_idx" ON "pipeline" ("repo_id", "number");
@@ -0,0 +1,3 @@
+DROP TABLE "pipeline_event";
+
+ALTER TYPE pipeline_status RENAME VALUE 'queued' TO 'pending';
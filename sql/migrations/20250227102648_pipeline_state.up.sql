@@ -0,0 +1,29 @@
+ALTER TYPE pipeline_status RENAME VALUE 'pending' TO 'queued';
+
+-- Every status change of pipeline, from_status is NULL when it was created.
+CREATE TABLE "pipeline_event" (
+    "id" bigserial PRIMARY KEY,
+    "pipeline_id" bigint NOT NULL,
+    "from_status" pipeline_status,
+    "to_status" pipeline_status NOT NULL,
+    "actor" text NOT NULL,
+    "message" text,
+    "created_at" timestamp NOT NULL DEFAULT now(),
+    FOREIGN KEY ("pipeline_id") REFERENCES "pipeline" ("id") ON DELETE CASCADE
+);
+CREATE INDEX "pipeline_event_pipeline_idx" ON "pipeline_event" ("pipeline_id", "id");
+
+-- History of existing pipelines is reconstructed from their timestamps.
+INSERT INTO "pipeline_event" ("pipeline_id", "from_status", "to_status", "actor", "created_at")
+SELECT "id", NULL, 'queued', 'system', "created_at"
+FROM "pipeline";
+
+INSERT INTO "pipeline_event" ("pipeline_id", "from_status", "to_status", "actor", "created_at")
+SELECT "id", 'queued', 'running', 'worker', "started_at"
+FROM "pipeline"
+WHERE "started_at" IS NOT NULL AND "status" <> 'queued';
+
+INSERT INTO "pipeline_event" ("pipeline_id", "from_status", "to_status", "actor", "created_at")
+SELECT "id", CASE WHEN "started_at" IS NULL THEN 'queued' ELSE 'running' END::pipeline_status, "status", 'system', COALESCE("finished_at", "created_at")
+FROM "pipeline"
+WHERE "status" NOT IN ('queued', 'running');
@@ -0,0 +1,17 @@
+UPDATE "pipeline" SET "status" = 'error' WHERE "status" IN ('failure', 'timed_out');
+UPDATE "pipeline" SET "status" = 'cancelled' WHERE "status" = 'skipped';
+UPDATE "commit_status" SET "state" = 'error' WHERE "state" IN ('failure', 'timed_out');
+UPDATE "commit_status" SET "state" = 'cancelled' WHERE "state" = 'skipped';
+UPDATE "pipeline_event" SET "from_status" = 'error' WHERE "from_status" IN ('failure', 'timed_out');
+UPDATE "pipeline_event" SET "from_status" = 'cancelled' WHERE "from_status" = 'skipped';
+UPDATE "pipeline_event" SET "to_status" = 'error' WHERE "to_status" IN ('failure', 'timed_out');
+UPDATE "pipeline_event" SET "to_status" = 'cancelled' WHERE "to_status" = 'skipped';
+
+ALTER TYPE pipeline_status RENAME TO pipeline_status_old;
+CREATE TYPE pipeline_status AS ENUM ('success', 'queued', 'running', 'error', 'cancelled');
+ALTER TABLE "pipeline" ALTER COLUMN "status" TYPE pipeline_status USING "status"::text::pipeline_status;
+ALTER TABLE "commit_status" ALTER COLUMN "state" TYPE pipeline_status USING "state"::text::pipeline_status;
+ALTER TABLE "pipeline_event"
+    ALTER COLUMN "from_status" TYPE pipeline_status USING "from_status"::text::pipeline_status,
+    ALTER COLUMN "to_status" TYPE pipeline_status USING "to_status"::text::pipeline_status;
+DROP TYPE pipeline_status_old;
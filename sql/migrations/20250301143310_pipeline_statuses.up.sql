@@ -0,0 +1,4 @@
+-- Error is kept for infrastructure problems, failing steps are failure.
+ALTER TYPE pipeline_status ADD VALUE IF NOT EXISTS 'failure';
+ALTER TYPE pipeline_status ADD VALUE IF NOT EXISTS 'timed_out';
+ALTER TYPE pipeline_status ADD VALUE IF NOT EXISTS 'skipped';
@@ -0,0 +1,4 @@
+ALTER TABLE "pipeline" DROP COLUMN "workflow";
+DROP INDEX "pipeline_original_attempt_idx";
+ALTER TABLE "pipeline" DROP COLUMN "original_id";
+ALTER TABLE "pipeline" DROP COLUMN "attempt";
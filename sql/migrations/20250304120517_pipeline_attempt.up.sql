@@ -0,0 +1,8 @@
+-- Re-runs are new pipelines linked to the first attempt, original_id is NULL
+-- for the first attempt itself.
+ALTER TABLE "pipeline" ADD COLUMN "attempt" integer NOT NULL DEFAULT 1;
+ALTER TABLE "pipeline" ADD COLUMN "original_id" bigint REFERENCES "pipeline" ("id") ON DELETE CASCADE;
+CREATE UNIQUE INDEX "pipeline_original_attempt_idx" ON "pipeline" ("original_id", "attempt");
+
+-- Workflow file loaded by the worker, re-runs use it instead of the file.
+ALTER TABLE "pipeline" ADD COLUMN "workflow" text;
@@ -0,0 +1 @@
+ALTER TABLE "pipeline" DROP COLUMN "inputs";
@@ -0,0 +1,2 @@
+-- Values of workflow inputs of manually triggered pipelines.
+ALTER TABLE "pipeline" ADD COLUMN "inputs" jsonb;
@@ -0,0 +1,21 @@
+-- Schedules periodically run pipelines of the branch.
+CREATE TABLE "schedule" (
+    "id" bigserial PRIMARY KEY,
+    "repo_id" bigint NOT NULL,
+    "branch" text NOT NULL,
+    "cron" text NOT NULL,
+    "timezone" text NOT NULL DEFAULT 'UTC',
+    -- Schedules declared in the workflow of the branch are replaced when it
+    -- changes.
+    "from_workflow" boolean NOT NULL DEFAULT false,
+    "next_run_at" timestamp NOT NULL,
+    "last_run_at" timestamp,
+    "last_pipeline_id" bigint,
+    "last_error" text,
+    "created_at" timestamp NOT NULL DEFAULT now(),
+    UNIQUE ("repo_id", "branch", "cron", "timezone"),
+    FOREIGN KEY ("repo_id") REFERENCES "repo" ("id") ON DELETE CASCADE,
+    FOREIGN KEY ("last_pipeline_id") REFERENCES "pipeline" ("id") ON DELETE SET NULL
+);
+
+CREATE INDEX "schedule_next_run_at_idx" ON "schedule" ("next_run_at");
@@ -0,0 +1,10 @@
+ALTER TABLE "commit_status" DROP COLUMN IF EXISTS "status_sent_version";
+
+ALTER TABLE "pipeline" ADD COLUMN IF NOT EXISTS "check_run_id" bigint;
+
+UPDATE "pipeline" p
+SET check_run_id = cr.check_run_id
+FROM "pipeline_check_run" cr
+WHERE cr.pipeline_id = p.id AND cr.job = '';
+
+DROP TABLE IF EXISTS "pipeline_check_run";
@@ -0,0 +1,20 @@
+-- Each job of the pipeline has its own check run. Check runs created for the
+-- whole pipeline before are kept under empty job name.
+CREATE TABLE "pipeline_check_run" (
+    "pipeline_id" bigint NOT NULL,
+    "job" text NOT NULL,
+    "check_run_id" bigint NOT NULL,
+    PRIMARY KEY ("pipeline_id", "job"),
+    FOREIGN KEY ("pipeline_id") REFERENCES "pipeline" ("id") ON DELETE CASCADE
+);
+
+INSERT INTO "pipeline_check_run" (pipeline_id, job, check_run_id)
+SELECT id, '', check_run_id
+FROM "pipeline"
+WHERE check_run_id IS NOT NULL;
+
+ALTER TABLE "pipeline" DROP COLUMN "check_run_id";
+
+-- Commit status is sent separately from check runs, so failing check run does
+-- not send the status again.
+ALTER TABLE "commit_status" ADD COLUMN "status_sent_version" int NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+-- Repository archived on the forge stays registered with its webhook, so it
+-- works again once it is unarchived.
+ALTER TABLE "repo" ADD COLUMN "forge_archived_at" timestamp;
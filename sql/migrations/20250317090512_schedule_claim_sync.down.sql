@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS "workflow_schedule_sync";
+ALTER TABLE "schedule" DROP COLUMN IF EXISTS "claimed_at";
@@ -0,0 +1,14 @@
+-- Claimed run of the schedule is in progress until its result is saved. Run
+-- whose claim is too old is claimed again, so runs of crashed servers are not
+-- lost.
+ALTER TABLE "schedule" ADD COLUMN "claimed_at" timestamp;
+
+-- Workflow schedules of the branch are only replaced by workflow of newer
+-- push than the one they were synced from.
+CREATE TABLE "workflow_schedule_sync" (
+    "repo_id" bigint NOT NULL,
+    "branch" text NOT NULL,
+    "synced_at" timestamp NOT NULL,
+    PRIMARY KEY ("repo_id", "branch"),
+    FOREIGN KEY ("repo_id") REFERENCES "repo" ("id") ON DELETE CASCADE
+);
@@ -0,0 +1,10 @@
+-- Pipeline created by run of the schedule. Run creates at most one pipeline,
+-- so run reclaimed from crashed server does not create another one.
+CREATE TABLE "schedule_run" (
+    "schedule_id" bigint NOT NULL,
+    "run_at" timestamp NOT NULL,
+    "pipeline_id" bigint NOT NULL,
+    PRIMARY KEY ("schedule_id", "run_at"),
+    FOREIGN KEY ("schedule_id") REFERENCES "schedule" ("id") ON DELETE CASCADE,
+    FOREIGN KEY ("pipeline_id") REFERENCES "pipeline" ("id") ON DELETE CASCADE
+);
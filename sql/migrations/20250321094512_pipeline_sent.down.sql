@@ -0,0 +1 @@
+ALTER TABLE "pipeline" DROP COLUMN IF EXISTS "sent_at";
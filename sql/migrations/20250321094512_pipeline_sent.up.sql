@@ -0,0 +1,5 @@
+-- Processor re-sends queued pipeline of retried webhook delivery only if it
+-- was not sent to workers yet.
+ALTER TABLE "pipeline" ADD COLUMN "sent_at" timestamp;
+-- Pipelines created before are considered sent, so they are not sent twice.
+UPDATE "pipeline" SET "sent_at" = "created_at" WHERE NOT "awaiting_approval";
@@ -0,0 +1,13 @@
+-- Deploy token of the repository given to workers for cloning, it is shared by
+-- pipelines and servers until it is about to expire. Replaced token stays
+-- valid until it expires, it is revoked when its successor is replaced.
+CREATE TABLE "repo_deploy_token" (
+    "repo_id" bigint PRIMARY KEY,
+    "token_id" bigint NOT NULL,
+    "username" text NOT NULL,
+    "token" text NOT NULL,
+    "token_key_id" text NOT NULL,
+    "expires_at" timestamp NOT NULL,
+    "previous_token_id" bigint,
+    FOREIGN KEY ("repo_id") REFERENCES "repo" ("id") ON DELETE CASCADE
+);
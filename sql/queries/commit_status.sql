@@ -0,0 +1,54 @@
+-- name: UpsertCommitStatus :exec
+INSERT INTO "commit_status" (repo_id, commit_sha, context, state, target_url, description, pipeline_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (repo_id, commit_sha, context) DO UPDATE
+SET state = EXCLUDED.state,
+    target_url = EXCLUDED.target_url,
+    description = EXCLUDED.description,
+    pipeline_id = EXCLUDED.pipeline_id,
+    version = "commit_status".version + 1,
+    attempts = 0,
+    next_attempt_at = now(),
+    error = NULL,
+    updated_at = now()
+-- Re-runs and later pipelines of the commit have greater ID, late status of
+-- older pipeline must not replace them. Late status of the same pipeline must
+-- not downgrade it, e.g. queued reported after the worker already started it.
+WHERE "commit_status".pipeline_id IS NULL
+   OR EXCLUDED.pipeline_id > "commit_status".pipeline_id
+   OR (EXCLUDED.pipeline_id = "commit_status".pipeline_id
+       AND (CASE EXCLUDED.state WHEN 'queued' THEN 0 WHEN 'running' THEN 1 ELSE 2 END)
+       >= (CASE "commit_status".state WHEN 'queued' THEN 0 WHEN 'running' THEN 1 ELSE 2 END));
+
+-- name: ClaimCommitStatuses :many
+UPDATE "commit_status"
+SET attempts = attempts + 1, next_attempt_at = now() + sqlc.arg(lease)::interval
+WHERE id IN (
+    SELECT cs.id
+    FROM "commit_status" cs
+    WHERE cs.version > cs.sent_version AND cs.next_attempt_at <= now()
+    ORDER BY cs.updated_at
+    LIMIT sqlc.arg(max_statuses)
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: CommitStatusSent :exec
+UPDATE "commit_status"
+SET sent_version = sqlc.arg(version), error = sqlc.arg(error)
+WHERE id = sqlc.arg(id) AND sent_version < sqlc.arg(version);
+
+-- name: CommitStatusCreated :exec
+UPDATE "commit_status"
+SET status_sent_version = sqlc.arg(version)
+WHERE id = sqlc.arg(id) AND status_sent_version < sqlc.arg(version);
+
+-- name: RetryCommitStatus :exec
+UPDATE "commit_status"
+SET error = sqlc.arg(error), next_attempt_at = now() + sqlc.arg(delay)::interval
+WHERE id = sqlc.arg(id);
+
+-- name: GetRepoStatusInfo :one
+SELECT r.service, r.owner, r.name, r.repo_service_id, r.installation_id, r.archived_at, su.id AS service_user_id, su.access_token, su.refresh_token, su.token_type, su.token_expire, su.token_key_id
+FROM "repo" r JOIN "service_user" su ON r.service_user_id = su.id
+WHERE r.id = $1;
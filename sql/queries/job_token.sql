@@ -0,0 +1,33 @@
+-- name: UpsertJobToken :exec
+INSERT INTO "job_token" (pipeline_id, token_hash, expires_at)
+VALUES (sqlc.arg(pipeline_id), sqlc.arg(token_hash), now() + sqlc.arg(ttl)::interval)
+ON CONFLICT (pipeline_id) DO UPDATE
+SET token_hash = EXCLUDED.token_hash, expires_at = EXCLUDED.expires_at, revoked_at = NULL;
+
+-- name: GetJobTokenState :one
+SELECT (revoked_at IS NOT NULL)::bool AS revoked, (expires_at <= now())::bool AS expired
+FROM "job_token"
+WHERE pipeline_id = $1 AND token_hash = $2;
+
+-- name: ExtendJobToken :exec
+UPDATE "job_token"
+SET expires_at = now() + sqlc.arg(ttl)::interval
+WHERE pipeline_id = sqlc.arg(pipeline_id) AND revoked_at IS NULL AND expires_at > now();
+
+-- name: RevokeJobToken :exec
+UPDATE "job_token"
+SET revoked_at = now()
+WHERE pipeline_id = $1 AND revoked_at IS NULL;
+
+-- name: CleanJobTokens :exec
+DELETE FROM "job_token"
+WHERE expires_at < now() OR revoked_at IS NOT NULL;
+
+-- name: RevokeRepoJobTokens :exec
+UPDATE "job_token"
+SET revoked_at = now()
+WHERE revoked_at IS NULL AND pipeline_id IN (
+    SELECT id
+    FROM "pipeline"
+    WHERE repo_id = $1
+);
@@ -1,34 +1,164 @@
--- name: GetPipelinesByRepo :many
-SELECT "id", "url", "status", "commit_sha", "started_at", "finished_at"
+-- name: GetRepoPipelines :many
+SELECT *
 FROM "pipeline"
-WHERE "repo_id" = $1;
+WHERE repo_id = sqlc.arg(repo_id)
+    AND (sqlc.narg(status)::pipeline_status IS NULL OR status = sqlc.narg(status))
+    AND (sqlc.narg(branch)::text IS NULL OR branch = sqlc.narg(branch))
+    AND (sqlc.narg(created_from)::timestamp IS NULL OR created_at >= sqlc.narg(created_from))
+    AND (sqlc.narg(created_to)::timestamp IS NULL OR created_at < sqlc.narg(created_to))
+    AND (sqlc.narg(before_id)::bigint IS NULL OR id < sqlc.narg(before_id))
+ORDER BY id DESC
+LIMIT sqlc.arg(max_pipelines);
 
 -- name: GetPipelineCreationInfo :one
-SELECT su.username, su.access_token, su.refresh_token, su.token_type, su.token_expire, r.name
+SELECT su.id AS service_user_id, su.username, su.access_token, su.refresh_token, su.token_type, su.token_expire, su.token_key_id, r.name, r.service, r.repo_service_id, r.installation_id
 FROM "service_user" su JOIN "repo" r ON su.id = r.service_user_id
 WHERE r.id = $1;
 
 -- name: GetPipelineStateChangeInfo :one
-SELECT p.url, p.commit_sha, p.started_at, r.service, r.owner, r.name, su.access_token, su.refresh_token, su.token_type, su.token_expire
+SELECT p.url, p.number, p.status, p.commit_sha, p.started_at, p.repo_id, p.pr_number, r.service, r.owner, r.name, su.id AS service_user_id, su.access_token, su.refresh_token, su.token_type, su.token_expire, su.token_key_id
 FROM public.pipeline p JOIN public.repo r ON p.repo_id = r.id JOIN public.service_user su ON r.service_user_id = su.id
 WHERE p.id = $1;
 
 -- name: CreatePipeline :one
-INSERT INTO "pipeline" (status, clone_url, commit_sha, repo_id)
-VALUES ($1, $2, $3, $4)
-RETURNING id;
+-- Number is taken from the repository row, which stays locked until the end of
+-- the transaction, so concurrent pipelines of the repository get distinct numbers.
+WITH "next" AS (
+    UPDATE "repo"
+    SET last_pipeline_number = last_pipeline_number + 1
+    WHERE id = $4
+    RETURNING last_pipeline_number
+)
+INSERT INTO "pipeline" (
+    status, clone_url, commit_sha, repo_id, pr_number, source_branch, target_branch, fetch_ref, fork, awaiting_approval,
+    event, ref, branch, tag, commit_message, author_name, author_email, committer_name, committer_email, pusher, compare_url,
+    attempt, original_id, workflow, inputs, number
+)
+SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, "next".last_pipeline_number
+FROM "next"
+RETURNING id, number;
 
 -- name: SetPipelineUrl :exec
 UPDATE "pipeline"
 SET url = $1
 WHERE id = $2;
 
--- name: PipelineStarted :exec
+-- name: TransitionPipeline :one
+-- Pipeline row is locked so concurrent transitions are serialized, the status
+-- is only changed when the current one is in from_statuses. Current status is
+-- returned either way.
+WITH "current" AS (
+    SELECT cp.id, cp.status
+    FROM "pipeline" cp
+    WHERE cp.id = sqlc.arg(pipeline_id)
+    FOR UPDATE
+), "updated" AS (
+    UPDATE "pipeline" p
+    SET status = sqlc.arg(to_status)::pipeline_status,
+        started_at = COALESCE(sqlc.narg(started_at)::timestamp, p.started_at),
+        finished_at = COALESCE(sqlc.narg(finished_at)::timestamp, p.finished_at),
+        awaiting_approval = p.awaiting_approval AND sqlc.narg(finished_at)::timestamp IS NULL
+    FROM "current" c
+    WHERE p.id = c.id AND c.status::text = ANY(sqlc.arg(from_statuses)::text[])
+    RETURNING p.id
+), "event" AS (
+    INSERT INTO "pipeline_event" (pipeline_id, from_status, to_status, actor, message)
+    SELECT u.id, c.status, sqlc.arg(to_status)::pipeline_status, sqlc.arg(actor), sqlc.narg(message)
+    FROM "updated" u, "current" c
+)
+SELECT c.status, EXISTS(SELECT 1 FROM "updated") AS transitioned
+FROM "current" c;
+
+-- name: CreatePipelineEvent :exec
+INSERT INTO "pipeline_event" (pipeline_id, from_status, to_status, actor, message)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: GetPipelineEvents :many
+SELECT *
+FROM "pipeline_event"
+WHERE pipeline_id = $1
+ORDER BY id;
+
+-- name: GetPipeline :one
+SELECT *
+FROM "pipeline"
+WHERE id = $1;
+
+-- name: GetRepoPipelineByNumber :one
+SELECT *
+FROM "pipeline"
+WHERE repo_id = $1 AND number = $2;
+
+-- name: GetLatestCommitPipeline :one
+SELECT *
+FROM "pipeline"
+WHERE repo_id = $1 AND commit_sha = $2
+ORDER BY id DESC
+LIMIT 1;
+
+-- name: GetPipelineAttempts :many
+SELECT *
+FROM "pipeline"
+WHERE id = $1 OR original_id = $1
+ORDER BY attempt;
+
+-- name: LockPipelineAttempts :many
+-- All attempts are locked, including the first one, so concurrent re-runs of
+-- the pipeline are serialized.
+SELECT id, attempt, status
+FROM "pipeline"
+WHERE id = $1 OR original_id = $1
+ORDER BY attempt
+FOR UPDATE;
+
+-- name: SetPipelineWorkflow :execrows
 UPDATE "pipeline"
-SET status = $1, started_at = $2
-WHERE id = $3;
+SET workflow = $1
+WHERE id = $2 AND workflow IS NULL AND status = 'running';
 
--- name: PipelineFinished :exec
+-- name: GetPipelineCheckRuns :many
+SELECT job, check_run_id
+FROM "pipeline_check_run"
+WHERE pipeline_id = $1;
+
+-- name: SetPipelineCheckRun :exec
+INSERT INTO "pipeline_check_run" (pipeline_id, job, check_run_id)
+VALUES ($1, $2, $3)
+ON CONFLICT (pipeline_id, job) DO UPDATE
+SET check_run_id = EXCLUDED.check_run_id;
+
+-- name: GetPipelinesAwaitingApproval :many
+SELECT *
+FROM "pipeline"
+WHERE repo_id = $1 AND awaiting_approval
+ORDER BY id DESC;
+
+-- name: ApprovePipeline :execrows
+UPDATE "pipeline"
+SET awaiting_approval = false, approved_by = $1
+WHERE id = $2 AND awaiting_approval;
+
+-- name: MarkPipelineSent :exec
 UPDATE "pipeline"
-SET status = $1, finished_at = $2
-WHERE id = $3;
+SET sent_at = now()
+WHERE id = $1;
+
+-- name: RevertPipelineApproval :exec
+UPDATE "pipeline"
+SET awaiting_approval = true, approved_by = NULL
+WHERE id = $1 AND NOT awaiting_approval AND status = 'queued';
+
+-- name: LockPipelineAwaitingApproval :one
+-- Row stays locked until the end of transaction, so the pipeline cannot be
+-- approved concurrently.
+SELECT id
+FROM "pipeline"
+WHERE id = $1 AND awaiting_approval AND status = 'queued'
+FOR UPDATE;
+
+-- name: GetRepoActivePipelineIDs :many
+SELECT id
+FROM "pipeline"
+WHERE repo_id = $1 AND status IN ('queued', 'running')
+ORDER BY id
+FOR UPDATE;
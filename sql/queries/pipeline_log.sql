@@ -1,10 +1,14 @@
 -- name: GetPipelineLogs :many
-SELECT "order", "cmd", "output", "exit_code"
+SELECT "order", "cmd", "output", "exit_code", "started_at", "finished_at"
 FROM "pipeline_log"
 WHERE "pipeline_id" = $1
 ORDER BY "order";
 
 -- name: CreatePipelineLog :one
-INSERT INTO "pipeline_log" ("order", "cmd", "output", "exit_code", "pipeline_id")
-VALUES ($1, $2, $3, $4, $5)
+INSERT INTO "pipeline_log" ("order", "cmd", "output", "exit_code", "pipeline_id", "started_at", "finished_at")
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+-- Worker may send the same step again when its call timed out.
+ON CONFLICT ("order", "pipeline_id") DO UPDATE
+SET "cmd" = EXCLUDED."cmd", "output" = EXCLUDED."output", "exit_code" = EXCLUDED."exit_code",
+    "started_at" = EXCLUDED."started_at", "finished_at" = EXCLUDED."finished_at"
 RETURNING "id";
@@ -1,12 +1,13 @@
 -- name: GetUserRepos :many
-SELECT r.id, r.service, r.owner, r.name, r.repo_service_id, r.webhook_id, r.service_user_id
+SELECT r.id, r.service, r.owner, r.name, r.repo_service_id, r.webhook_id, r.installation_id, r.service_user_id, r.archived_at
 FROM "repo" r JOIN "service_user" su ON r.service_user_id = su.id
-WHERE su.user_id = $1;
+WHERE su.user_id = $1
+ORDER BY r.archived_at NULLS FIRST, r.id;
 
 -- name: GetRepoIDByServiceRepoID :one
 SELECT id
 FROM "repo"
-WHERE service = $1 AND repo_service_id = $2;
+WHERE service = $1 AND repo_service_id = $2 AND archived_at IS NULL;
 
 -- name: UserOwnRepo :one
 SELECT EXISTS(
@@ -16,10 +17,147 @@ SELECT EXISTS(
 );
 
 -- name: CreateRepo :one
-INSERT INTO "repo" (service, owner, name, repo_service_id, webhook_id, service_user_id)
-VALUES ($1, $2, $3, $4, $5, $6)
+-- Webhook secret is set by SetRepoWebhookSecretCiphertext, it is encrypted with
+-- ID of the repository.
+INSERT INTO "repo" (service, owner, name, repo_service_id, webhook_id, installation_id, service_user_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+-- Archived repository is registered again with its pipeline history.
+ON CONFLICT (service, repo_service_id) DO UPDATE
+SET owner = EXCLUDED.owner, name = EXCLUDED.name, webhook_id = EXCLUDED.webhook_id, installation_id = EXCLUDED.installation_id,
+    service_user_id = EXCLUDED.service_user_id, webhook_secret = NULL, previous_webhook_secret = NULL,
+    webhook_secret_key_id = NULL, webhook_secret_rotated_at = NULL, archived_at = NULL
+WHERE "repo".archived_at IS NOT NULL
 RETURNING id;
 
 -- name: DeleteRepo :exec
 DELETE FROM "repo"
 WHERE id = $1;
+
+-- name: SetRepoInstallation :exec
+UPDATE "repo"
+SET installation_id = $1
+WHERE service = $2 AND repo_service_id = $3;
+
+-- name: ClearInstallation :exec
+UPDATE "repo"
+SET installation_id = NULL
+WHERE service = $1 AND installation_id = $2;
+
+-- name: GetRepoWebhookChangeInfo :one
+SELECT r.id, r.service, r.owner, r.name, r.repo_service_id, r.webhook_id, r.forge_archived_at, su.id AS service_user_id, su.user_id, su.access_token, su.refresh_token, su.token_type, su.token_expire, su.token_key_id
+FROM "repo" r JOIN "service_user" su ON r.service_user_id = su.id
+WHERE r.id = $1;
+
+-- name: GetRepoWebhookSecrets :one
+SELECT id, webhook_secret, previous_webhook_secret, webhook_secret_key_id, webhook_secret_rotated_at
+FROM "repo"
+WHERE service = $1 AND repo_service_id = $2 AND archived_at IS NULL;
+
+-- name: GetRepoWebhookSecretForUpdate :one
+SELECT webhook_secret, webhook_secret_key_id
+FROM "repo"
+WHERE id = $1
+FOR UPDATE;
+
+-- name: RotateRepoWebhookSecret :exec
+UPDATE "repo"
+SET webhook_secret = $2, previous_webhook_secret = $3, webhook_secret_key_id = $4, webhook_secret_rotated_at = now()
+WHERE id = $1;
+
+-- name: RevertRepoWebhookSecret :exec
+UPDATE "repo"
+SET webhook_secret = previous_webhook_secret, previous_webhook_secret = NULL, webhook_secret_rotated_at = NULL
+WHERE id = $1;
+
+-- name: GetRepoWebhookSecretsToEncrypt :many
+SELECT id, webhook_secret, previous_webhook_secret, webhook_secret_key_id
+FROM "repo"
+-- Secrets without v2: prefix were encrypted before they were bound to their row.
+WHERE webhook_secret IS NOT NULL
+    AND (webhook_secret_key_id IS DISTINCT FROM sqlc.arg(key_id)::text OR webhook_secret NOT LIKE 'v2:%')
+ORDER BY id
+LIMIT sqlc.arg(batch_size)
+FOR UPDATE SKIP LOCKED;
+
+-- name: SetRepoWebhookSecretCiphertext :exec
+UPDATE "repo"
+SET webhook_secret = $2, previous_webhook_secret = $3, webhook_secret_key_id = $4
+WHERE id = $1;
+
+-- name: ArchiveRepo :exec
+UPDATE "repo"
+SET archived_at = now(), webhook_id = NULL, installation_id = NULL, webhook_secret = NULL, previous_webhook_secret = NULL,
+    webhook_secret_key_id = NULL, webhook_secret_rotated_at = NULL
+WHERE id = $1;
+
+-- name: SetRepoForgeArchived :exec
+UPDATE "repo"
+SET forge_archived_at = CASE WHEN sqlc.arg(archived)::boolean THEN coalesce(forge_archived_at, now()) END
+WHERE id = sqlc.arg(id);
+
+-- name: ClaimReposToCheckWebhook :many
+UPDATE "repo"
+SET webhook_checked_at = now()
+WHERE id IN (
+    SELECT id
+    FROM "repo"
+    WHERE webhook_id IS NOT NULL AND archived_at IS NULL
+        AND (webhook_checked_at IS NULL OR webhook_checked_at < now() - sqlc.arg(interval)::interval)
+    ORDER BY webhook_checked_at NULLS FIRST
+    LIMIT sqlc.arg(max_repos)
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id;
+
+-- name: GetRepoWebhookHealth :one
+SELECT r.webhook_id, r.webhook_checked_at, r.webhook_check_error, r.webhook_repaired_at, (
+    SELECT max(wd.received_at)
+    FROM "webhook_delivery" wd
+    WHERE wd.repo_id = r.id AND wd.status IN ('processed', 'ignored')
+)::timestamp AS last_delivery_at
+FROM "repo" r
+WHERE r.id = $1;
+
+-- name: SetRepoWebhookCheck :exec
+UPDATE "repo"
+SET webhook_checked_at = now(), webhook_check_error = sqlc.arg(check_error),
+    webhook_repaired_at = CASE WHEN sqlc.arg(repaired)::boolean THEN now() ELSE webhook_repaired_at END
+WHERE id = sqlc.arg(id);
+
+-- name: SetRepoWebhookID :exec
+UPDATE "repo"
+SET webhook_id = $2
+WHERE id = $1;
+
+-- name: UpdateRepoName :exec
+UPDATE "repo"
+SET owner = $2, name = $3
+WHERE id = $1;
+
+-- name: GetRegisteredRepoServiceIDs :many
+SELECT repo_service_id
+FROM "repo"
+WHERE service = sqlc.arg(service) AND repo_service_id = ANY(sqlc.arg(repo_service_ids)::bigint[]) AND archived_at IS NULL;
+
+-- name: GetRepoDeployToken :one
+SELECT token_id, username, token, token_key_id, expires_at
+FROM "repo_deploy_token"
+WHERE repo_id = $1;
+
+-- name: LockRepoDeployToken :one
+-- Row stays locked until the end of transaction, so the token cannot be
+-- replaced concurrently.
+SELECT token_id, previous_token_id
+FROM "repo_deploy_token"
+WHERE repo_id = $1
+FOR UPDATE;
+
+-- name: CreateRepoDeployToken :execrows
+INSERT INTO "repo_deploy_token" (repo_id, token_id, username, token, token_key_id, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (repo_id) DO NOTHING;
+
+-- name: ReplaceRepoDeployToken :exec
+UPDATE "repo_deploy_token"
+SET token_id = $2, username = $3, token = $4, token_key_id = $5, expires_at = $6, previous_token_id = token_id
+WHERE repo_id = $1;
@@ -0,0 +1,70 @@
+-- name: GetRepoSchedules :many
+SELECT sqlc.embed(s), p.number AS last_pipeline_number
+FROM "schedule" s LEFT JOIN "pipeline" p ON s.last_pipeline_id = p.id
+WHERE s.repo_id = $1
+ORDER BY s.branch, s.id;
+
+-- name: CreateSchedule :one
+INSERT INTO "schedule" (repo_id, branch, cron, timezone, from_workflow, next_run_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (repo_id, branch, cron, timezone) DO NOTHING
+RETURNING id;
+
+-- name: DeleteSchedule :execrows
+DELETE FROM "schedule"
+WHERE id = $1 AND repo_id = $2;
+
+-- name: DeleteWorkflowSchedules :exec
+DELETE FROM "schedule"
+WHERE repo_id = $1 AND branch = $2 AND from_workflow
+    AND NOT (cron || ' ' || timezone = ANY (sqlc.arg(keep)::text[]));
+
+-- name: TryLockScheduler :one
+SELECT pg_try_advisory_xact_lock(sqlc.arg(key)::bigint);
+
+-- name: GetDueSchedules :many
+-- Schedules are due when their next run is due and no run is claimed, or
+-- when their claimed run was not finished in time.
+SELECT s.*
+FROM "schedule" s JOIN "repo" r ON s.repo_id = r.id
+WHERE ((s.claimed_at IS NULL AND s.next_run_at <= sqlc.arg(now)::timestamp)
+        OR s.claimed_at <= sqlc.arg(stale_before)::timestamp)
+    AND r.archived_at IS NULL AND r.forge_archived_at IS NULL
+ORDER BY s.next_run_at
+LIMIT sqlc.arg(max_schedules);
+
+-- name: SetScheduleNextRun :exec
+UPDATE "schedule"
+SET last_run_at = next_run_at, next_run_at = sqlc.arg(next_run_at), last_pipeline_id = NULL, last_error = NULL,
+    claimed_at = sqlc.arg(claimed_at)
+WHERE id = $1;
+
+-- name: ReclaimScheduleRun :exec
+UPDATE "schedule"
+SET claimed_at = sqlc.arg(claimed_at)
+WHERE id = $1;
+
+-- name: SetScheduleResult :exec
+UPDATE "schedule"
+SET last_pipeline_id = $2, last_error = $3, claimed_at = NULL
+WHERE id = $1;
+
+-- name: MarkWorkflowSchedulesSynced :execrows
+-- Row is locked until the end of transaction, so syncs of the branch are
+-- serialized. No row is changed when newer workflow was already synced.
+INSERT INTO "workflow_schedule_sync" (repo_id, branch, synced_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (repo_id, branch) DO UPDATE
+SET synced_at = EXCLUDED.synced_at
+WHERE "workflow_schedule_sync".synced_at < EXCLUDED.synced_at;
+
+-- name: CreateScheduleRun :one
+INSERT INTO "schedule_run" (schedule_id, run_at, pipeline_id)
+VALUES ($1, $2, $3)
+ON CONFLICT (schedule_id, run_at) DO NOTHING
+RETURNING pipeline_id;
+
+-- name: GetScheduleRunPipeline :one
+SELECT sqlc.embed(p)
+FROM "schedule_run" sr JOIN "pipeline" p ON sr.pipeline_id = p.id
+WHERE sr.schedule_id = $1 AND sr.run_at = $2;
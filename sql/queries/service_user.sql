@@ -1,10 +1,14 @@
--- name: CreateServiceUser :one
-INSERT INTO "service_user" (service, username, email, access_token, refresh_token, token_type, token_expire, user_id)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-RETURNING id;
+-- name: NextServiceUserID :one
+-- ID is taken before the service user is created, so its tokens can be
+-- encrypted with it.
+SELECT nextval(pg_get_serial_sequence('service_user', 'id'))::bigint;
+
+-- name: CreateServiceUser :exec
+INSERT INTO "service_user" (id, service, username, email, access_token, refresh_token, token_type, token_expire, user_id, token_key_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
 
 -- name: GetServiceUserByUserID :one
-SELECT id, username, username, email, access_token, refresh_token, token_type, token_expire
+SELECT id, username, email, access_token, refresh_token, token_type, token_expire, relogin_required, token_key_id
 FROM "service_user"
 WHERE user_id = $1 AND service = $2;
 
@@ -12,3 +16,39 @@ WHERE user_id = $1 AND service = $2;
 SELECT user_id
 FROM "service_user"
 WHERE service = $1 AND username = $2;
+
+-- name: GetServiceUserTokenForUpdate :one
+SELECT access_token, refresh_token, token_type, token_expire, token_key_id
+FROM "service_user"
+WHERE id = $1
+FOR UPDATE;
+
+-- name: UpdateServiceUserToken :exec
+UPDATE "service_user"
+SET access_token = $2, refresh_token = $3, token_type = $4, token_expire = $5, token_key_id = $6, relogin_required = false
+WHERE id = $1;
+
+-- name: SetServiceUserReloginRequired :exec
+UPDATE "service_user"
+SET relogin_required = true
+WHERE id = $1;
+
+-- name: GetReloginRequiredServices :many
+SELECT service
+FROM "service_user"
+WHERE user_id = $1 AND relogin_required
+ORDER BY service;
+
+-- name: GetServiceUserTokensToEncrypt :many
+SELECT id, access_token, refresh_token, token_key_id
+FROM "service_user"
+-- Tokens without v2: prefix were encrypted before they were bound to their row.
+WHERE token_key_id IS DISTINCT FROM sqlc.arg(key_id)::text OR access_token NOT LIKE 'v2:%'
+ORDER BY id
+LIMIT sqlc.arg(batch_size)
+FOR UPDATE SKIP LOCKED;
+
+-- name: SetServiceUserTokenCiphertext :exec
+UPDATE "service_user"
+SET access_token = $2, refresh_token = $3, token_key_id = $4
+WHERE id = $1;
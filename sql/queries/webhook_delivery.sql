@@ -0,0 +1,65 @@
+-- name: CreateWebhookDelivery :one
+INSERT INTO "webhook_delivery" (service, delivery_id, event, headers, payload, repo_id)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (service, delivery_id) DO NOTHING
+RETURNING id, received_at;
+
+-- name: GetWebhookDelivery :one
+SELECT *
+FROM "webhook_delivery"
+WHERE id = $1;
+
+-- name: GetWebhookDeliveryByDeliveryID :one
+SELECT *
+FROM "webhook_delivery"
+WHERE service = $1 AND delivery_id = $2;
+
+-- name: GetRepoWebhookDeliveries :many
+SELECT sqlc.embed(d), p.number AS pipeline_number
+FROM "webhook_delivery" d LEFT JOIN "pipeline" p ON d.pipeline_id = p.id
+WHERE d.repo_id = $1
+ORDER BY d.received_at DESC
+LIMIT $2;
+
+-- name: SetWebhookDeliveryStatus :exec
+UPDATE "webhook_delivery"
+SET status = $1, error = $2, pipeline_id = $3, processed_at = now()
+WHERE id = $4;
+
+-- name: CleanWebhookDeliveries :exec
+DELETE FROM "webhook_delivery"
+WHERE received_at < $1;
+
+-- name: ClaimWebhookDeliveries :many
+UPDATE "webhook_delivery"
+SET attempts = attempts + 1, next_attempt_at = now() + sqlc.arg(lease)::interval
+WHERE id IN (
+    SELECT wd.id
+    FROM "webhook_delivery" wd
+    WHERE wd.status = 'pending' AND wd.next_attempt_at <= now()
+    ORDER BY wd.received_at
+    LIMIT sqlc.arg(max_deliveries)
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: RetryWebhookDelivery :exec
+UPDATE "webhook_delivery"
+SET error = sqlc.arg(error), next_attempt_at = now() + sqlc.arg(delay)::interval
+WHERE id = sqlc.arg(id);
+
+-- name: RequeueWebhookDelivery :exec
+UPDATE "webhook_delivery"
+SET status = 'pending', attempts = 0, error = NULL, processed_at = NULL, next_attempt_at = now(),
+    pipeline_id = CASE WHEN sqlc.arg(replay)::boolean THEN NULL ELSE pipeline_id END
+WHERE id = sqlc.arg(id);
+
+-- name: SetWebhookDeliveryPipeline :exec
+UPDATE "webhook_delivery"
+SET pipeline_id = $2
+WHERE id = $1;
+
+-- name: GetWebhookDeliveryQueueStats :one
+SELECT count(*) AS pending, COALESCE(EXTRACT(EPOCH FROM now() - min(received_at)), 0)::float8 AS oldest_pending_seconds
+FROM "webhook_delivery"
+WHERE status = 'pending';
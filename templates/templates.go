@@ -3,6 +3,8 @@ package templates
 import (
 	"embed"
 	"html/template"
+
+	"github.com/shark-ci/shark-ci/internal/types"
 )
 
 //go:embed *.html base/*.html partials/*.html errors/*.html
@@ -12,6 +14,13 @@ var (
 	IndexTmpl = template.Must(template.New("base.html").Funcs(FuncMap).ParseFS(templates, "base/base.html", "base/layout.html", "index.html"))
 	LoginTmpl = template.Must(template.New("base.html").Funcs(FuncMap).ParseFS(templates, "base/base.html", "login.html"))
 
+	DeliveriesTmpl = template.Must(template.New("base.html").Funcs(FuncMap).ParseFS(templates, "base/base.html", "base/layout.html", "deliveries.html"))
+	ApprovalsTmpl  = template.Must(template.New("base.html").Funcs(FuncMap).ParseFS(templates, "base/base.html", "base/layout.html", "approvals.html"))
+	PipelinesTmpl  = template.Must(template.New("base.html").Funcs(FuncMap).ParseFS(templates, "base/base.html", "base/layout.html", "pipelines.html"))
+	PipelineTmpl   = template.Must(template.New("base.html").Funcs(FuncMap).ParseFS(templates, "base/base.html", "base/layout.html", "pipeline.html"))
+	TriggerTmpl    = template.Must(template.New("base.html").Funcs(FuncMap).ParseFS(templates, "base/base.html", "base/layout.html", "trigger.html"))
+	SchedulesTmpl  = template.Must(template.New("base.html").Funcs(FuncMap).ParseFS(templates, "base/base.html", "base/layout.html", "schedules.html"))
+
 	ReposRegisterTmpl = template.Must(template.ParseFS(templates, "partials/repos_register.html"))
 
 	Error400Tmpl = template.Must(template.New("base.html").ParseFS(templates, "base/base.html", "errors/400.html"))
@@ -20,9 +29,26 @@ var (
 )
 
 var FuncMap = template.FuncMap{
-	"Modulo": Modulo,
+	"Modulo":      Modulo,
+	"StatusClass": StatusClass,
 }
 
 func Modulo(a int, b int) bool {
 	return a%b == 0
 }
+
+// StatusClass returns badge class of pipeline status.
+func StatusClass(status types.PipelineStatus) string {
+	switch status {
+	case types.Success:
+		return "bg-success"
+	case types.Failure, types.Error:
+		return "bg-danger"
+	case types.TimedOut:
+		return "bg-warning text-dark"
+	case types.Running:
+		return "bg-primary"
+	default:
+		return "bg-secondary"
+	}
+}